@@ -0,0 +1,37 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// apiserver-runtime generates the source for apiservers built with the builder package.
+package main
+
+import (
+	"os"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/cmd/create"
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	cmd := &cobra.Command{
+		Use:   "apiserver-runtime",
+		Short: "Generate the source for apiservers built with the builder package",
+	}
+	cmd.AddCommand(create.NewCommandCreate(os.Stdout))
+	// cobra reports the error
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
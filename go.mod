@@ -5,18 +5,30 @@ module github.com/pwittrock/apiserver-runtime
 go 1.15
 
 require (
+	github.com/evanphx/json-patch v4.9.0+incompatible
 	github.com/go-openapi/spec v0.19.3
+	github.com/go-openapi/validate v0.19.5
+	github.com/google/cel-go v0.6.0
 	github.com/google/gofuzz v1.1.0
+	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/spf13/cobra v1.0.0
-	golang.org/x/tools v0.0.0-20200903185744-af4cc2cd812e // indirect
-	k8s.io/api v0.19.0 // indirect
+	github.com/spf13/pflag v1.0.5
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
+	k8s.io/api v0.19.0
+	k8s.io/apiextensions-apiserver v0.19.0
 	k8s.io/apimachinery v0.19.0
 	k8s.io/apiserver v0.19.0
 	k8s.io/client-go v0.19.0
 	k8s.io/code-generator v0.19.0
 	k8s.io/component-base v0.19.0
 	k8s.io/klog/v2 v2.2.0
+	k8s.io/kube-aggregator v0.19.0
 	k8s.io/kube-openapi v0.0.0-20200805222855-6aeccd4b50c6
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace (
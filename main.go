@@ -56,6 +56,7 @@ func main() {
 	options := server.NewWardleServerOptions(os.Stdout, os.Stderr, v1alpha1.SchemeGroupVersion)
 	cmd := server.NewCommandStartWardleServer(options, stopCh)
 	cmd.Flags().AddGoFlagSet(flag.CommandLine)
+	cmd.AddCommand(server.NewCommandMigrateStorage(options, stopCh))
 	if err := cmd.Execute(); err != nil {
 		klog.Fatal(err)
 	}
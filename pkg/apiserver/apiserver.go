@@ -107,6 +107,10 @@ func (c completedConfig) New() (*WardleServer, error) {
 
 	apiGroupInfo := genericapiserver.NewDefaultAPIGroupInfo(GroupName, Scheme, metav1.ParameterCodec, Codecs)
 
+	// change: apiserver-runtime
+	// the NegotiatedSerializer may serialize types without generated protobuf functions
+	apiGroupInfo.NegotiatedSerializer = NegotiatedSerializer
+
 	// change: apiserver-runtime
 	// v1alpha1storage := map[string]rest.Storage{}
 	// v1alpha1storage["flunders"] = wardleregistry.RESTInPeace(flunderstorage.NewREST(Scheme, c.GenericConfig.RESTOptionsGetter))
@@ -117,11 +121,31 @@ func (c completedConfig) New() (*WardleServer, error) {
 	// v1beta1storage["flunders"] = wardleregistry.RESTInPeace(flunderstorage.NewREST(Scheme, c.GenericConfig.RESTOptionsGetter))
 	// apiGroupInfo.VersionedResourcesStorageMap["v1beta1"] = v1beta1storage
 
+	// change: apiserver-runtime
+	// the legacy core API group is only served by standalone apiservers -- e.g. for namespaces
+	if len(LegacyAPIs) > 0 {
+		legacyAPIGroupInfo := genericapiserver.NewDefaultAPIGroupInfo("", Scheme, metav1.ParameterCodec, Codecs)
+		legacyAPIGroupInfo.VersionedResourcesStorageMap, err = buildStorageMap(
+			LegacyAPIs, Scheme, c.GenericConfig.RESTOptionsGetter, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.GenericAPIServer.InstallLegacyAPIGroup(
+			genericapiserver.DefaultLegacyAPIPrefix, &legacyAPIGroupInfo); err != nil {
+			return nil, err
+		}
+	}
+
 	// Add new APIs through inserting into APIs
-	apiGroupInfo.VersionedResourcesStorageMap, err = BuildStorageMap(Scheme, c.GenericConfig.RESTOptionsGetter)
+	apiGroupInfo.VersionedResourcesStorageMap, err = BuildStorageMap(
+		Scheme, c.GenericConfig.RESTOptionsGetter, c.GenericConfig.MergedResourceConfig)
 	if err != nil {
 		return nil, err
 	}
+	// all versions of the group may have been disabled with --runtime-config
+	if len(apiGroupInfo.VersionedResourcesStorageMap) == 0 {
+		return s, nil
+	}
 	if err := s.GenericAPIServer.InstallAPIGroup(&apiGroupInfo); err != nil {
 		return nil, err
 	}
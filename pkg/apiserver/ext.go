@@ -22,6 +22,7 @@ import (
 	genericregistry "k8s.io/apiserver/pkg/registry/generic"
 	"k8s.io/apiserver/pkg/registry/rest"
 	pkgserver "k8s.io/apiserver/pkg/server"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
 )
 
 type StorageProvider func(s *runtime.Scheme, g genericregistry.RESTOptionsGetter) (rest.Storage, error)
@@ -30,13 +31,27 @@ var (
 	GroupName           = "example.com"
 	APIs                = map[schema.GroupVersionResource]StorageProvider{}
 	GenericAPIServerFns []func(*pkgserver.GenericAPIServer) *pkgserver.GenericAPIServer
+	// NegotiatedSerializer serializes the objects served by the API group.
+	NegotiatedSerializer runtime.NegotiatedSerializer = Codecs
+	// LegacyAPIs are the resources of the legacy core API group served under /api -- e.g. namespaces.
+	LegacyAPIs = map[schema.GroupVersionResource]StorageProvider{}
 )
 
-// buildStorageMap gets all of the registered APIs
-func BuildStorageMap(s *runtime.Scheme, g genericregistry.RESTOptionsGetter) (map[string]map[string]rest.Storage, error) {
+// buildStorageMap gets all of the registered APIs which are enabled by the resource config
+func BuildStorageMap(s *runtime.Scheme, g genericregistry.RESTOptionsGetter,
+	resourceConfig *serverstorage.ResourceConfig) (map[string]map[string]rest.Storage, error) {
+	return buildStorageMap(APIs, s, g, resourceConfig)
+}
+
+func buildStorageMap(providers map[schema.GroupVersionResource]StorageProvider, s *runtime.Scheme,
+	g genericregistry.RESTOptionsGetter,
+	resourceConfig *serverstorage.ResourceConfig) (map[string]map[string]rest.Storage, error) {
 	apis := map[string]map[string]rest.Storage{}
 	var err error
-	for k, v := range APIs {
+	for k, v := range providers {
+		if resourceConfig != nil && !resourceConfig.VersionEnabled(k.GroupVersion()) {
+			continue
+		}
 		if _, found := apis[k.Version]; !found {
 			apis[k.Version] = map[string]rest.Storage{}
 		}
@@ -48,6 +63,13 @@ func BuildStorageMap(s *runtime.Scheme, g genericregistry.RESTOptionsGetter) (ma
 	return apis, nil
 }
 
+// DefaultAPIResourceConfigSource returns a resource config which enables every registered version of the group.
+func DefaultAPIResourceConfigSource() *serverstorage.ResourceConfig {
+	c := serverstorage.NewResourceConfig()
+	c.EnableVersions(Scheme.PrioritizedVersionsForGroup(GroupName)...)
+	return c
+}
+
 func ApplyGenericAPIServerFns(in *pkgserver.GenericAPIServer) *pkgserver.GenericAPIServer {
 	for i := range GenericAPIServerFns {
 		in = GenericAPIServerFns[i](in)
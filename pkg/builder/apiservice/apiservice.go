@@ -0,0 +1,181 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package apiservice registers the APIServices of an aggregated apiserver with the kube-apiserver hosting it.
+package apiservice
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+	apiregistrationv1client "k8s.io/kube-aggregator/pkg/client/clientset_generated/clientset/typed/apiregistration/v1"
+)
+
+// Config configures the registration of the APIServices.
+type Config struct {
+	// ServiceName is the name of the Service of the apiserver.  Defaults to the first label of the API group.
+	ServiceName string
+	// ServiceNamespace is the namespace of the Service of the apiserver.  Defaults to ServiceName.
+	ServiceNamespace string
+	// GroupPriorityMinimum is the priority of the API groups in discovery.  Defaults to 1000.
+	GroupPriorityMinimum int32
+	// CABundleFile is the CA bundle which signed the serving certificate of the apiserver.  Defaults to the
+	// serving certificate chain of the apiserver, which must then include its CA -- e.g. a self-signed certificate.
+	CABundleFile string
+	// RemoveOnShutdown deletes the APIServices when the apiserver is shut down gracefully.  Requests for the
+	// API groups fail while the APIServices are unavailable, so RemoveOnShutdown should only be set for
+	// apiservers which are not expected to be restarted -- e.g. in development.
+	RemoveOnShutdown bool
+	// ResyncPeriod is how often the APIServices are updated even if the CA bundle has not changed.  Defaults to
+	// 10 minutes.
+	ResyncPeriod time.Duration
+}
+
+// Registrar creates or updates the APIServices, keeping their CA bundle up to date.
+type Registrar struct {
+	// Client creates, updates and deletes the APIServices.
+	Client apiregistrationv1client.APIServicesGetter
+	// APIServices are the registered APIServices.  Their CABundle is set from CABundle.
+	APIServices []*apiregistrationv1.APIService
+	// CABundle returns the current CA bundle.
+	CABundle func() ([]byte, error)
+	// ResyncPeriod is how often the APIServices are updated by Run.
+	ResyncPeriod time.Duration
+
+	queue chan struct{}
+	once  sync.Once
+}
+
+// Enqueue requests the APIServices to be updated by Run -- e.g. when the serving certificate has been rotated.
+// Enqueue implements dynamiccertificates.Listener.
+func (r *Registrar) Enqueue() {
+	r.init()
+	select {
+	case r.queue <- struct{}{}:
+	default:
+	}
+}
+
+// init creates the queue.  Enqueue may be called by the notifier of the serving certificate while Run starts.
+func (r *Registrar) init() {
+	r.once.Do(func() {
+		r.queue = make(chan struct{}, 1)
+	})
+}
+
+// Run updates the APIServices when they are enqueued, and every ResyncPeriod, until ctx is done.  Failed updates
+// are retried with a backoff.
+func (r *Registrar) Run(ctx context.Context) {
+	r.init()
+	resync := r.ResyncPeriod
+	if resync == 0 {
+		resync = 10 * time.Minute
+	}
+	ticker := time.NewTicker(resync)
+	defer ticker.Stop()
+	backoff := wait.Backoff{Duration: time.Second, Factor: 2, Steps: 8, Cap: time.Minute}
+	for {
+		err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+			if ctx.Err() != nil {
+				return true, nil
+			}
+			if err := r.Sync(ctx); err != nil {
+				klog.Errorf("unable to register the APIServices: %v", err)
+				return false, nil
+			}
+			return true, nil
+		})
+		if err != nil {
+			klog.Errorf("giving up registering the APIServices until the next resync: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.queue:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync creates the APIServices which do not exist, and updates the APIServices whose spec, or labels, differ.
+func (r *Registrar) Sync(ctx context.Context) error {
+	caBundle, err := r.CABundle()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, desired := range r.APIServices {
+		desired = desired.DeepCopy()
+		desired.Spec.CABundle = caBundle
+		desired.Spec.InsecureSkipTLSVerify = len(caBundle) == 0
+		if err := r.sync(ctx, desired); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (r *Registrar) sync(ctx context.Context, desired *apiregistrationv1.APIService) error {
+	client := r.Client.APIServices()
+	existing, err := client.Get(ctx, desired.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err := client.Create(ctx, desired, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		klog.Infof("registered APIService %s", desired.Name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	updated := existing.DeepCopy()
+	updated.Spec = desired.Spec
+	for k, v := range desired.Labels {
+		if updated.Labels == nil {
+			updated.Labels = map[string]string{}
+		}
+		updated.Labels[k] = v
+	}
+	if equality.Semantic.DeepEqual(existing, updated) {
+		return nil
+	}
+	if _, err := client.Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	klog.Infof("updated APIService %s", desired.Name)
+	return nil
+}
+
+// Remove deletes the APIServices.
+func (r *Registrar) Remove(ctx context.Context) error {
+	var errs []error
+	for _, s := range r.APIServices {
+		err := r.Client.APIServices().Delete(ctx, s.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apiservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+	"k8s.io/kube-aggregator/pkg/client/clientset_generated/clientset/fake"
+)
+
+func apiService(version string) *apiregistrationv1.APIService {
+	return &apiregistrationv1.APIService{
+		ObjectMeta: metav1.ObjectMeta{Name: version + ".test.example.com", Labels: map[string]string{"apiserver": "test"}},
+		Spec: apiregistrationv1.APIServiceSpec{
+			Group:                "test.example.com",
+			Version:              version,
+			Service:              &apiregistrationv1.ServiceReference{Name: "test", Namespace: "test"},
+			GroupPriorityMinimum: 1000,
+			VersionPriority:      15,
+		},
+	}
+}
+
+// writes returns the verbs of the create, update and delete actions of client.
+func writes(client *fake.Clientset) []string {
+	var verbs []string
+	for _, a := range client.Actions() {
+		if a.GetVerb() != "get" {
+			verbs = append(verbs, a.GetVerb())
+		}
+	}
+	client.ClearActions()
+	return verbs
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	existing := apiService("v1alpha1")
+	existing.Spec.VersionPriority = 1
+	client := fake.NewSimpleClientset(existing)
+	caBundle := []byte("first")
+	r := &Registrar{
+		Client:      client.ApiregistrationV1(),
+		APIServices: []*apiregistrationv1.APIService{apiService("v1"), apiService("v1alpha1")},
+		CABundle:    func() ([]byte, error) { return caBundle, nil },
+	}
+
+	if err := r.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if verbs := writes(client); len(verbs) != 2 || verbs[0] != "create" || verbs[1] != "update" {
+		t.Errorf("expected the missing APIService to be created and the existing one updated, got %v", verbs)
+	}
+	for _, name := range []string{"v1.test.example.com", "v1alpha1.test.example.com"} {
+		s, err := client.ApiregistrationV1().APIServices().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(s.Spec.CABundle) != "first" || s.Spec.InsecureSkipTLSVerify || s.Spec.VersionPriority != 15 {
+			t.Errorf("expected %s to be registered with the CA bundle, got %+v", name, s.Spec)
+		}
+	}
+
+	if err := r.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if verbs := writes(client); len(verbs) != 0 {
+		t.Errorf("expected no writes when the APIServices are up to date, got %v", verbs)
+	}
+
+	// the serving certificate is rotated
+	caBundle = []byte("second")
+	if err := r.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if verbs := writes(client); len(verbs) != 2 {
+		t.Errorf("expected the APIServices to be updated with the new CA bundle, got %v", verbs)
+	}
+
+	if err := r.Remove(ctx); err != nil {
+		t.Fatal(err)
+	}
+	list, err := client.ApiregistrationV1().APIServices().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 0 {
+		t.Errorf("expected the APIServices to be removed, got %v", list.Items)
+	}
+	if err := r.Remove(ctx); err != nil {
+		t.Errorf("expected removing missing APIServices to succeed, got %v", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	synced := make(chan struct{}, 10)
+	client.PrependReactor("get", "apiservices", func(k8stesting.Action) (bool, runtime.Object, error) {
+		synced <- struct{}{}
+		return false, nil, nil
+	})
+	r := &Registrar{
+		Client:       client.ApiregistrationV1(),
+		APIServices:  []*apiregistrationv1.APIService{apiService("v1")},
+		CABundle:     func() ([]byte, error) { return nil, nil },
+		ResyncPeriod: time.Hour,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	wait := func(reason string) {
+		select {
+		case <-synced:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("expected the APIServices to be synced %s", reason)
+		}
+	}
+	wait("when started")
+	r.Enqueue()
+	wait("when enqueued")
+
+	s, err := client.ApiregistrationV1().APIServices().Get(ctx, "v1.test.example.com", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Spec.InsecureSkipTLSVerify {
+		t.Errorf("expected the APIService to skip TLS verification without a CA bundle, got %+v", s.Spec)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"reflect"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/dynamic"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/openapi"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcedefault"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcestrategy"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcevalidation"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	openapicommon "k8s.io/kube-openapi/pkg/common"
+)
+
+// allOpenAPIDefinitions returns the OpenAPI definitions registered with WithOpenAPIDefinitions together with
+// definitions derived from the go types of any registered objects which do not have a registered definition.
+//
+// The apiserver builds the server-side apply type converter from these definitions, so every served resource
+// must have one.  The definitions of the apimachinery types, such as ObjectMeta, are always included because the
+// definitions of unstructured resources reference them even when no typed resource is registered.
+func (a *Server) allOpenAPIDefinitions() openapicommon.GetOpenAPIDefinitions {
+	var objs []interface{}
+	for _, r := range a.registrations {
+		if r.dynamic == nil {
+			objs = append(objs, r.obj.New(), r.obj.NewList())
+		}
+	}
+	if a.namespaces {
+		objs = append(objs, &corev1.Namespace{}, &corev1.NamespaceList{})
+	}
+	defs := openapi.Merge(a.openAPIDefinitions, openapi.DefinitionsFor(objs))
+	if len(a.dynamicResources()) > 0 {
+		defs = openapi.Merge(defs, dynamic.Definitions)
+	}
+
+	// publish the defaults of the resources defaulted from their struct tags
+	var defaulted []interface{}
+	for _, r := range a.registrations {
+		if r.dynamic == nil && resource.IsTagDefaulted(r.obj) {
+			defaulted = append(defaulted, r.obj.New())
+		}
+	}
+	if len(defaulted) > 0 {
+		defs = resourcedefault.OpenAPIDefinitions(defs, defaulted...)
+	}
+
+	// publish the validation rules in the struct tags of the resources
+	var validated []interface{}
+	for _, r := range a.registrations {
+		if r.dynamic == nil {
+			validated = append(validated, r.obj.New())
+		}
+	}
+	return resourcevalidation.OpenAPIDefinitions(defs, validated...)
+}
+
+// addHubTypes registers the type of the version which registered the storage of each resource as the internal
+// version of the resource if the scheme does not already have an internal version for it.
+//
+// Requests, including server-side apply requests, are converted to the internal version of the resource
+// before they are stored.  The store creates and updates objects of the type it was registered with, so using
+// that type as the internal version means each served version is converted directly to the objects of the
+// store -- either by conversion functions registered with the scheme or by the resourcestrategy.Converter
+// functions of the versions -- and the objects of the store are converted to the storage version when they
+// are written to etcd.
+func (a *Server) addHubTypes(scheme *runtime.Scheme) error {
+	hubs := a.hubRegistrations()
+	for gr, r := range hubs {
+		obj, list := r.obj.New(), r.obj.NewList()
+		hub := schema.GroupVersion{Group: gr.Group, Version: runtime.APIVersionInternal}
+		if scheme.Recognizes(hub.WithKind(typeName(obj))) {
+			continue
+		}
+		scheme.AddKnownTypes(hub, obj, list)
+
+		for _, v := range a.registrations {
+			if v.isSubResource() || v.dynamic != nil || v.gvr.GroupResource() != gr {
+				continue
+			}
+			if err := addConverterFuncs(scheme, v.obj.New(), obj); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hubRegistrations returns the registration of each resource whose type is used by its store.  Unstructured
+// resources have no hub, as their objects are only served in the version they are registered with.
+func (a *Server) hubRegistrations() map[schema.GroupResource]registration {
+	hubs := map[schema.GroupResource]registration{}
+	for _, r := range a.registrations {
+		if r.isSubResource() || r.dynamic != nil {
+			continue
+		}
+		if h, found := hubs[r.gvr.GroupResource()]; found && (h.newStorage || !r.newStorage) {
+			continue
+		}
+		hubs[r.gvr.GroupResource()] = r
+	}
+	return hubs
+}
+
+// addConverterFuncs registers conversion functions between two versions of a resource which both implement
+// resourcestrategy.Converter.  Objects are converted through the internal object returned by ConvertToInternal.
+func addConverterFuncs(scheme *runtime.Scheme, obj, hub runtime.Object) error {
+	if reflect.TypeOf(obj) == reflect.TypeOf(hub) {
+		return nil
+	}
+	if _, ok := obj.(resourcestrategy.Converter); !ok {
+		return nil
+	}
+	if _, ok := hub.(resourcestrategy.Converter); !ok {
+		return nil
+	}
+	convert := func(in, out interface{}, _ conversion.Scope) error {
+		out.(resourcestrategy.Converter).ConvertFromInternal(in.(resourcestrategy.Converter).ConvertToInternal())
+		return nil
+	}
+	if err := scheme.AddConversionFunc(obj, hub, convert); err != nil {
+		return err
+	}
+	return scheme.AddConversionFunc(hub, obj, convert)
+}
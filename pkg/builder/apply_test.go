@@ -0,0 +1,139 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"testing"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/openapi"
+	"github.com/pwittrock/apiserver-runtime/pkg/example/v1alpha1"
+	"github.com/pwittrock/apiserver-runtime/pkg/example/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/endpoints/handlers/fieldmanager"
+	openapinamer "k8s.io/apiserver/pkg/endpoints/openapi"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	utilopenapi "k8s.io/apiserver/pkg/util/openapi"
+	openapibuilder "k8s.io/kube-openapi/pkg/builder"
+)
+
+// addExampleConversions registers the conversions between the example versions.
+func addExampleConversions(scheme *runtime.Scheme) error {
+	if err := scheme.AddConversionFunc((*v1beta1.ExampleResource)(nil), (*v1alpha1.ExampleResource)(nil),
+		func(in, out interface{}, _ conversion.Scope) error {
+			out.(*v1alpha1.ExampleResource).ObjectMeta = in.(*v1beta1.ExampleResource).ObjectMeta
+			return nil
+		}); err != nil {
+		return err
+	}
+	return scheme.AddConversionFunc((*v1alpha1.ExampleResource)(nil), (*v1beta1.ExampleResource)(nil),
+		func(in, out interface{}, _ conversion.Scope) error {
+			out.(*v1beta1.ExampleResource).ObjectMeta = in.(*v1alpha1.ExampleResource).ObjectMeta
+			return nil
+		})
+}
+
+func TestApply(t *testing.T) {
+	a := newTestServer().
+		WithResource(&v1alpha1.ExampleResource{}).
+		WithResource(&v1beta1.ExampleResource{})
+	scheme := runtime.NewScheme()
+	a.schemeBuilder.Register(a.addHubTypes, addExampleConversions)
+	if err := a.schemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	config := genericapiserver.DefaultOpenAPIConfig(a.allOpenAPIDefinitions(), openapinamer.NewDefinitionNamer(scheme))
+	config.Info.Title, config.Info.Version = "test", "v0"
+	swagger, err := openapibuilder.BuildOpenAPIDefinitionsForResources(config,
+		openapi.DefinitionName(&v1alpha1.ExampleResource{}), openapi.DefinitionName(&v1beta1.ExampleResource{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	models, err := utilopenapi.ToProtoModels(swagger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hub := schema.GroupVersion{Group: "example.com", Version: runtime.APIVersionInternal}
+	for _, version := range []string{"v1alpha1", "v1beta1"} {
+		t.Run(version, func(t *testing.T) {
+			kind := schema.GroupVersionKind{Group: "example.com", Version: version, Kind: "ExampleResource"}
+			f, err := fieldmanager.NewDefaultFieldManager(models, scheme, scheme, scheme, kind, hub)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			live, err := scheme.New(kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			patch := &unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": kind.GroupVersion().String(),
+				"kind":       kind.Kind,
+				"metadata": map[string]interface{}{
+					"name":   "example",
+					"labels": map[string]interface{}{"app": "example"},
+				},
+			}}
+			applied, err := f.Apply(live, patch, "test-manager", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			accessor, err := meta.Accessor(applied)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if accessor.GetLabels()["app"] != "example" {
+				t.Errorf("expected the label to be applied, got %v", accessor.GetLabels())
+			}
+			managed := accessor.GetManagedFields()
+			if len(managed) != 1 || managed[0].Manager != "test-manager" ||
+				managed[0].APIVersion != kind.GroupVersion().String() {
+				t.Errorf("expected fields to be managed by test-manager in %v, got %+v", kind.GroupVersion(), managed)
+			}
+		})
+	}
+}
+
+func TestHubTypes(t *testing.T) {
+	// the store is registered by v1alpha1, so objects are stored as v1beta1 by converting them from v1alpha1
+	a := newTestServer().
+		WithResource(&v1alpha1.ExampleResource{}).
+		WithResource(&v1beta1.ExampleResource{}).
+		WithStorageVersion(schema.GroupVersionResource{Group: "example.com", Version: "v1beta1", Resource: "examples"})
+	scheme := runtime.NewScheme()
+	a.schemeBuilder.Register(a.addHubTypes, addExampleConversions)
+	if err := a.schemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	internal, err := scheme.New(schema.GroupVersionKind{
+		Group: "example.com", Version: runtime.APIVersionInternal, Kind: "ExampleResource"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := internal.(*v1alpha1.ExampleResource); !ok {
+		t.Errorf("expected the type of the store to be the internal version, got %T", internal)
+	}
+	if errs := a.validate(scheme); len(errs) != 0 {
+		t.Errorf("expected the versions to be convertible, got %v", errs)
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit configures auditing of the requests served by an apiserver.
+//
+// The audit policy and backends are configured through the audit options of the apiserver, so the --audit-*
+// flags take precedence over the Config.  Fields of the resources may be redacted from the request and response
+// bodies of the audit events before they reach the backends.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/apiserver/pkg/audit/policy"
+	genericoptions "k8s.io/apiserver/pkg/server/options"
+)
+
+// Config configures the audit policy and backends.
+type Config struct {
+	// Policy is the audit policy.  Either Policy or PolicyFile must be set.  The Policy is written to a
+	// temporary file while the audit options are applied.
+	Policy *auditv1.Policy
+	// PolicyFile is the file the audit policy is read from when the apiserver is started.
+	PolicyFile string
+
+	// LogPath is the file the audit events are written to, or "-" for stdout.  Defaults to stdout unless
+	// WebhookConfigFile is set.
+	LogPath string
+	// LogMaxAge is the maximum number of days to retain rotated log files.  Defaults to 30.
+	LogMaxAge int
+	// LogMaxBackups is the maximum number of rotated log files to retain.  Defaults to 10.
+	LogMaxBackups int
+	// LogMaxSize is the maximum size in megabytes of the log file before it is rotated.  Defaults to 100.
+	LogMaxSize int
+
+	// WebhookConfigFile is the kubeconfig file of the webhook the audit events are sent to.
+	WebhookConfigFile string
+
+	// Redactions are the fields redacted from the audit events.
+	Redactions []Redaction
+}
+
+// Redaction redacts fields of a resource from the request and response bodies of the audit events.
+type Redaction struct {
+	// Resource is the resource whose fields are redacted.  Subresources of the resource are redacted as well.
+	Resource schema.GroupResource
+	// Fields are the dot separated paths of the redacted fields -- e.g. "spec.credentials.password".  Paths
+	// through lists redact the field of every element of the list.
+	Fields []string
+}
+
+// Complete validates the Config.  Complete must be called before ApplyTo.
+func (c *Config) Complete() error {
+	if c.Policy == nil && c.PolicyFile == "" {
+		return fmt.Errorf("either an audit Policy or PolicyFile must be set")
+	}
+	if c.Policy != nil && c.PolicyFile != "" {
+		return fmt.Errorf("only one of an audit Policy or PolicyFile may be set")
+	}
+	for _, r := range c.Redactions {
+		if r.Resource.Resource == "" {
+			return fmt.Errorf("the resource of the redacted fields %v must be set", r.Fields)
+		}
+	}
+	if c.Policy == nil {
+		return nil
+	}
+	b, err := c.policy()
+	if err != nil {
+		return err
+	}
+	if _, err := policy.LoadPolicyFromBytes(b); err != nil {
+		return fmt.Errorf("invalid audit policy: %v", err)
+	}
+	return nil
+}
+
+// WithPolicy calls apply with the PolicyFile of the audit options set to a file containing the Policy, so the
+// Policy is read when the options are applied.  The file is removed once apply returns.  apply is called with
+// the options unchanged if the Config has no Policy, or the options already have a PolicyFile -- e.g. from
+// the --audit-policy-file flag.
+func (c *Config) WithPolicy(o *genericoptions.AuditOptions, apply func() error) error {
+	if o == nil || o.PolicyFile != "" || c.Policy == nil {
+		return apply()
+	}
+	b, err := c.policy()
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile("", "audit-policy-*.json")
+	if err != nil {
+		return fmt.Errorf("unable to write the audit policy: %v", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write the audit policy: %v", err)
+	}
+
+	o.PolicyFile = f.Name()
+	defer func() { o.PolicyFile = "" }()
+	return apply()
+}
+
+// policy returns the Policy encoded as JSON.
+func (c *Config) policy() ([]byte, error) {
+	p := c.Policy.DeepCopy()
+	p.APIVersion, p.Kind = auditv1.SchemeGroupVersion.String(), "Policy"
+	return json.Marshal(p)
+}
+
+// ApplyTo defaults the audit options from the Config.  Options which have been set, e.g. by flags, are not
+// changed.
+func (c *Config) ApplyTo(o *genericoptions.AuditOptions) {
+	if o == nil {
+		return
+	}
+	if o.PolicyFile == "" {
+		o.PolicyFile = c.PolicyFile
+	}
+	if o.WebhookOptions.ConfigFile == "" {
+		o.WebhookOptions.ConfigFile = c.WebhookConfigFile
+	}
+	if o.LogOptions.Path == "" {
+		o.LogOptions.Path = c.LogPath
+		if o.LogOptions.Path == "" && o.WebhookOptions.ConfigFile == "" {
+			o.LogOptions.Path = "-"
+		}
+	}
+	o.LogOptions.MaxAge = defaultInt(o.LogOptions.MaxAge, c.LogMaxAge, 30)
+	o.LogOptions.MaxBackups = defaultInt(o.LogOptions.MaxBackups, c.LogMaxBackups, 10)
+	o.LogOptions.MaxSize = defaultInt(o.LogOptions.MaxSize, c.LogMaxSize, 100)
+}
+
+// defaultInt returns the first value which is not zero.
+func defaultInt(values ...int) int {
+	for _, v := range values {
+		if v != 0 {
+			return v
+		}
+	}
+	return 0
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"os"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	auditinternal "k8s.io/apiserver/pkg/apis/audit"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/apiserver/pkg/audit/policy"
+	genericoptions "k8s.io/apiserver/pkg/server/options"
+)
+
+// recorder records the events it processes.
+type recorder struct {
+	events []*auditinternal.Event
+}
+
+func (r *recorder) ProcessEvents(events ...*auditinternal.Event) bool {
+	r.events = append(r.events, events...)
+	return true
+}
+func (r *recorder) Run(<-chan struct{}) error { return nil }
+func (r *recorder) Shutdown()                 {}
+func (r *recorder) String() string            { return "recorder" }
+
+func TestRedactingBackend(t *testing.T) {
+	secrets := schema.GroupResource{Group: "example.com", Resource: "secrets"}
+	tests := []struct {
+		name     string
+		resource schema.GroupResource
+		body     string
+		expected string
+	}{
+		{
+			name:     "object",
+			resource: secrets,
+			body:     `{"kind":"Secret","spec":{"password":"hunter2","user":"admin","keys":[{"value":"a"},{"value":"b"}]}}`,
+			expected: `{"kind":"Secret","spec":{"keys":[{"value":"REDACTED"},{"value":"REDACTED"}],"password":"REDACTED","user":"admin"}}`,
+		},
+		{
+			name:     "list",
+			resource: secrets,
+			body:     `{"kind":"SecretList","items":[{"spec":{"password":"hunter2"}},{"spec":{}}]}`,
+			expected: `{"items":[{"spec":{"password":"REDACTED"}},{"spec":{}}],"kind":"SecretList"}`,
+		},
+		{
+			name:     "json patch",
+			resource: secrets,
+			body: `[{"op":"replace","path":"/spec/password","value":"hunter2"},` +
+				`{"op":"add","path":"/spec","value":{"password":"hunter2","user":"admin"}},` +
+				`{"op":"add","path":"/spec/keys/0","value":{"value":"a"}},` +
+				`{"op":"replace","path":"/spec/user","value":"admin"},{"op":"remove","path":"/spec/password"}]`,
+			expected: `[{"op":"replace","path":"/spec/password","value":"REDACTED"},` +
+				`{"op":"add","path":"/spec","value":{"password":"REDACTED","user":"admin"}},` +
+				`{"op":"add","path":"/spec/keys/0","value":{"value":"REDACTED"}},` +
+				`{"op":"replace","path":"/spec/user","value":"admin"},{"op":"remove","path":"/spec/password"}]`,
+		},
+		{
+			name:     "other resource",
+			resource: schema.GroupResource{Group: "example.com", Resource: "configs"},
+			body:     `{"spec":{"password":"hunter2"}}`,
+			expected: `{"spec":{"password":"hunter2"}}`,
+		},
+		{
+			name:     "invalid body",
+			resource: secrets,
+			body:     `{"spec":`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{}
+			backend := NewRedactingBackend(r, []Redaction{{Resource: secrets, Fields: []string{"spec.password", "spec.keys.value"}}})
+			e := &auditinternal.Event{
+				ObjectRef:     &auditinternal.ObjectReference{APIGroup: tt.resource.Group, Resource: tt.resource.Resource},
+				RequestObject: &runtime.Unknown{Raw: []byte(tt.body), ContentType: runtime.ContentTypeJSON},
+			}
+			backend.ProcessEvents(e)
+
+			if string(e.RequestObject.Raw) != tt.body {
+				t.Errorf("expected the event not to be mutated, got %s", e.RequestObject.Raw)
+			}
+			if len(r.events) != 1 {
+				t.Fatalf("expected 1 event, got %d", len(r.events))
+			}
+			got := r.events[0].RequestObject
+			if tt.expected == "" {
+				if got != nil {
+					t.Errorf("expected the body to be dropped, got %s", got.Raw)
+				}
+				return
+			}
+			if got == nil || string(got.Raw) != tt.expected {
+				t.Errorf("expected %s, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestConfig(t *testing.T) {
+	c := &Config{Policy: &auditv1.Policy{Rules: []auditv1.PolicyRule{{Level: auditv1.LevelRequestResponse}}}}
+	if err := c.Complete(); err != nil {
+		t.Fatal(err)
+	}
+	o := genericoptions.NewAuditOptions()
+	c.ApplyTo(o)
+	if o.PolicyFile != "" || o.LogOptions.Path != "-" || o.LogOptions.MaxBackups != 10 {
+		t.Errorf("expected the policy to be logged to stdout, got %+v", o)
+	}
+
+	// the policy is written to a file while the options are applied
+	var path string
+	err := c.WithPolicy(o, func() error {
+		path = o.PolicyFile
+		p, err := policy.LoadPolicyFromFile(path)
+		if err != nil {
+			return err
+		}
+		if len(p.Rules) != 1 || p.Rules[0].Level != auditinternal.LevelRequestResponse {
+			t.Errorf("expected the policy to be written, got %v", p.Rules)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); path == "" || !os.IsNotExist(err) {
+		t.Errorf("expected the policy file %q to be removed, got %v", path, err)
+	}
+	if o.PolicyFile != "" {
+		t.Errorf("expected the policy file to be reset, got %q", o.PolicyFile)
+	}
+
+	// the policy file of the flags is not replaced
+	o.PolicyFile = "flag-policy.yaml"
+	if err := c.WithPolicy(o, func() error {
+		if o.PolicyFile != "flag-policy.yaml" {
+			t.Errorf("expected the flag to take precedence, got %q", o.PolicyFile)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	o = genericoptions.NewAuditOptions()
+	o.PolicyFile, o.LogOptions.Path = "flag-policy.yaml", "/var/log/audit.log"
+	(&Config{PolicyFile: "policy.yaml", WebhookConfigFile: "webhook.kubeconfig", LogMaxBackups: 3}).ApplyTo(o)
+	if o.PolicyFile != "flag-policy.yaml" || o.LogOptions.Path != "/var/log/audit.log" {
+		t.Errorf("expected the flags to take precedence, got %+v", o)
+	}
+	if o.WebhookOptions.ConfigFile != "webhook.kubeconfig" || o.LogOptions.MaxBackups != 3 {
+		t.Errorf("expected the options to be defaulted from the config, got %+v", o)
+	}
+
+	for _, invalid := range []*Config{
+		{},
+		{Policy: &auditv1.Policy{}, PolicyFile: "policy.yaml"},
+		{Policy: &auditv1.Policy{Rules: []auditv1.PolicyRule{{Level: "Everything"}}}},
+		{PolicyFile: "policy.yaml", Redactions: []Redaction{{Fields: []string{"spec.password"}}}},
+	} {
+		if err := invalid.Complete(); err == nil {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+}
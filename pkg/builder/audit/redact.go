@@ -0,0 +1,200 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	auditinternal "k8s.io/apiserver/pkg/apis/audit"
+	apiserveraudit "k8s.io/apiserver/pkg/audit"
+	"k8s.io/klog/v2"
+)
+
+// Redacted replaces the values of the redacted fields.
+const Redacted = "REDACTED"
+
+// NewRedactingBackend returns a Backend redacting the fields of the audit events sent to backend.
+//
+// The request and response bodies of the events for the redacted resources are decoded as JSON.  Lists are
+// redacted item by item, and JSON patches have the values of the operations on redacted fields redacted.  Bodies
+// which cannot be decoded are dropped from the event rather than sent unredacted.
+func NewRedactingBackend(backend apiserveraudit.Backend, redactions []Redaction) apiserveraudit.Backend {
+	fields := map[schema.GroupResource][][]string{}
+	for _, r := range redactions {
+		for _, f := range r.Fields {
+			fields[r.Resource] = append(fields[r.Resource], strings.Split(f, "."))
+		}
+	}
+	return &redactingBackend{Backend: backend, fields: fields}
+}
+
+type redactingBackend struct {
+	apiserveraudit.Backend
+	fields map[schema.GroupResource][][]string
+}
+
+// ProcessEvents redacts copies of the events, since the events must not be mutated.
+func (b *redactingBackend) ProcessEvents(events ...*auditinternal.Event) bool {
+	redacted := make([]*auditinternal.Event, 0, len(events))
+	for _, e := range events {
+		if e.ObjectRef == nil || (e.RequestObject == nil && e.ResponseObject == nil) {
+			redacted = append(redacted, e)
+			continue
+		}
+		fields := b.fields[schema.GroupResource{Group: e.ObjectRef.APIGroup, Resource: e.ObjectRef.Resource}]
+		if len(fields) == 0 {
+			redacted = append(redacted, e)
+			continue
+		}
+		e = e.DeepCopy()
+		e.RequestObject = redactObject(e.RequestObject, fields)
+		e.ResponseObject = redactObject(e.ResponseObject, fields)
+		redacted = append(redacted, e)
+	}
+	return b.Backend.ProcessEvents(redacted...)
+}
+
+func (b *redactingBackend) String() string {
+	return fmt.Sprintf("redacting<%s>", b.Backend)
+}
+
+// redactObject redacts the fields of the JSON body.
+func redactObject(u *runtime.Unknown, fields [][]string) *runtime.Unknown {
+	if u == nil {
+		return nil
+	}
+	var body interface{}
+	if err := json.Unmarshal(u.Raw, &body); err != nil {
+		klog.V(2).Infof("dropping the audited body which cannot be redacted: %v", err)
+		return nil
+	}
+	switch b := body.(type) {
+	case map[string]interface{}:
+		items, isList := b["items"].([]interface{})
+		if _, hasKind := b["kind"].(string); isList && hasKind {
+			for i := range items {
+				redactFields(items[i], fields)
+			}
+		} else {
+			redactFields(b, fields)
+		}
+	case []interface{}:
+		redactPatch(b, fields)
+	}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		klog.V(2).Infof("dropping the audited body which cannot be redacted: %v", err)
+		return nil
+	}
+	return &runtime.Unknown{Raw: raw, ContentType: runtime.ContentTypeJSON}
+}
+
+func redactFields(obj interface{}, fields [][]string) {
+	for _, f := range fields {
+		redactField(obj, f)
+	}
+}
+
+// redactField replaces the value of the field at path, redacting the field of each element of the lists
+// along the path.
+func redactField(obj interface{}, path []string) {
+	switch o := obj.(type) {
+	case []interface{}:
+		for i := range o {
+			redactField(o[i], path)
+		}
+	case map[string]interface{}:
+		v, found := o[path[0]]
+		if !found || v == nil {
+			return
+		}
+		if len(path) == 1 {
+			o[path[0]] = Redacted
+			return
+		}
+		redactField(v, path[1:])
+	}
+}
+
+// redactPatch redacts the values of the JSON patch operations which set redacted fields, or their parents.
+func redactPatch(ops []interface{}, fields [][]string) {
+	for i := range ops {
+		op, ok := ops[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		p, ok := op["path"].(string)
+		if !ok {
+			continue
+		}
+		if _, found := op["value"]; !found {
+			continue
+		}
+		path := strings.Split(strings.TrimPrefix(p, "/"), "/")
+		for j := range path {
+			path[j] = strings.NewReplacer("~1", "/", "~0", "~").Replace(path[j])
+		}
+		for _, f := range fields {
+			if within, rest := matchPath(path, f); within {
+				op["value"] = Redacted
+				break
+			} else if rest != nil {
+				redactField(op["value"], rest)
+			}
+		}
+	}
+}
+
+// matchPath returns whether the patched path is the field or within it, or else the rest of the field path
+// within the patched value if the patched path is a parent of the field.  Indexes of lists in the patched path
+// match any element.
+func matchPath(path, field []string) (bool, []string) {
+	j := 0
+	for i := 0; i < len(path); i++ {
+		if j == len(field) {
+			return true, nil
+		}
+		if path[i] == field[j] {
+			j++
+			continue
+		}
+		if isIndex(path[i]) {
+			continue
+		}
+		return false, nil
+	}
+	if j == len(field) {
+		return true, nil
+	}
+	return false, field[j:]
+}
+
+func isIndex(s string) bool {
+	if s == "-" {
+		return true
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -17,24 +17,48 @@ limitations under the License.
 package builder
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/go-openapi/spec"
 	"github.com/pwittrock/apiserver-runtime/pkg/apiserver"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/apiservice"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/audit"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/controller"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/dynamic"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/encryption"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/garbagecollector"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/namespace"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/protobuf"
 	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourceauthz"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcecel"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourceimmutable"
 	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcerest"
-	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcestrategy"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcevalidation"
 	"github.com/pwittrock/apiserver-runtime/pkg/builder/rest"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/tracing"
 	"github.com/pwittrock/apiserver-runtime/pkg/cmd/server"
+	"go.opentelemetry.io/otel"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/admission"
 	"k8s.io/apiserver/pkg/registry/generic"
 	regsitryrest "k8s.io/apiserver/pkg/registry/rest"
 	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/server/dynamiccertificates"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	aggregatorclient "k8s.io/kube-aggregator/pkg/client/clientset_generated/clientset"
 	openapicommon "k8s.io/kube-openapi/pkg/common"
 )
 
@@ -49,15 +73,33 @@ type Server struct {
 	group                string
 	storage              map[schema.GroupResource]*singletonProvider
 	groupVersions        map[schema.GroupVersion]bool
+	deprecatedVersions   map[schema.GroupVersion]apiLifecycle
 	orderedGroupVersions []schema.GroupVersion
+	storageVersions      map[schema.GroupResource]schema.GroupVersion
+	registrations        []registration
+	openAPIDefinitions   openapicommon.GetOpenAPIDefinitions
+	openAPITitle         string
+	openAPIVersion       string
 	schemes              []*runtime.Scheme
 	schemeBuilder        runtime.SchemeBuilder
+	controllers          *controller.Manager
+	namespaces           bool
+	garbageCollector     bool
+	tracing              *tracing.Config
+	audit                *audit.Config
+	encryption           *encryption.Config
+	registerAPIs         *apiservice.Config
 }
 
 // WithOpenAPIDefinitions registers resource OpenAPI definitions generated by openapi-gen
+//
+// Definitions for registered resources which are not generated by openapi-gen are derived from their go types.
+// The definitions are used to publish the OpenAPI spec and to support server-side apply.
 func (a *Server) WithOpenAPIDefinitions(
 	name, version string, openAPI openapicommon.GetOpenAPIDefinitions) *Server {
-	server.SetOpenAPIDefinitions(name, version, openAPI)
+	a.openAPIDefinitions = openAPI
+	a.openAPITitle = name
+	a.openAPIVersion = version
 	return a
 }
 
@@ -96,6 +138,11 @@ func (a *Server) WithAdditionalSchemesToBuild(s ...*runtime.Scheme) *Server {
 // WithResource will automatically register version-specific defaulting for this version of the
 // resource if the object implements the resource.Defaulter interface.
 //
+// WithResource will mark the version as deprecated if the object implements the resource.Deprecated interface.
+// Deprecated versions return deprecation warnings and are the least preferred versions in discovery.  Deprecation
+// applies to a version rather than to a resource, so every resource of a deprecated version must be deprecated
+// in the same releases.  The other versions of the resources are not deprecated unless their objects are.
+//
 // WithResource automatically adds the object and its list type to the SchemeBuilder under its group version
 // as provided by GetGroupVersionResource.  If the obj also declares itself as an internal version, the
 // object and its list type will be added as internal versions to the SchemeBuilder as well.
@@ -105,32 +152,31 @@ func (a *Server) WithResource(obj resource.Object) *Server {
 
 	// reuse the storage if this resource has already been registered
 	if s, found := a.storage[gvr.GroupResource()]; found {
-		_ = a.forGroupVersionResource(gvr, obj, s.Get)
-		return a
+		return a.reuseGroupVersionResource(gvr, obj, s)
 	}
 
 	// If the type implements it's own storage, then use that
 	switch s := obj.(type) {
 	case resourcerest.Creater:
-		return a.forGroupVersionResource(gvr, obj, rest.StaticHandlerProvider{Storage: s.(regsitryrest.Storage)}.Get)
+		return a.forGroupVersionResource(gvr, obj, StaticStorage, rest.StaticHandlerProvider{Storage: s.(regsitryrest.Storage)}.Get)
 	case resourcerest.Updater:
-		return a.forGroupVersionResource(gvr, obj, rest.StaticHandlerProvider{Storage: s.(regsitryrest.Storage)}.Get)
+		return a.forGroupVersionResource(gvr, obj, StaticStorage, rest.StaticHandlerProvider{Storage: s.(regsitryrest.Storage)}.Get)
 	case resourcerest.Getter:
-		return a.forGroupVersionResource(gvr, obj, rest.StaticHandlerProvider{Storage: s.(regsitryrest.Storage)}.Get)
+		return a.forGroupVersionResource(gvr, obj, StaticStorage, rest.StaticHandlerProvider{Storage: s.(regsitryrest.Storage)}.Get)
 	case resourcerest.Lister:
-		return a.forGroupVersionResource(gvr, obj, rest.StaticHandlerProvider{Storage: s.(regsitryrest.Storage)}.Get)
+		return a.forGroupVersionResource(gvr, obj, StaticStorage, rest.StaticHandlerProvider{Storage: s.(regsitryrest.Storage)}.Get)
 	}
 
-	_ = a.forGroupVersionResource(gvr, obj, rest.New(obj))
+	_ = a.forGroupVersionResource(gvr, obj, EtcdStorage, rest.New(obj))
 
 	// automatically create status subresource if the object implements the status interface
 	if sgs, ok := obj.(resource.StatusGetSetter); ok {
 		st := gvr.GroupVersion().WithResource(gvr.Resource + "/status")
 		if s, found := a.storage[st.GroupResource()]; found {
-			_ = a.forGroupVersionResource(st, obj, s.Get)
+			_ = a.reuseGroupVersionResource(st, obj, s)
 		} else {
 			_, _, _, sp := rest.NewStatus(sgs)
-			_ = a.forGroupVersionResource(st, obj, sp)
+			_ = a.forGroupVersionResource(st, obj, EtcdStorage, sp)
 		}
 	}
 	return a
@@ -141,7 +187,7 @@ func (a *Server) WithResource(obj resource.Object) *Server {
 // and implement the interfaces defined in "apiserver-runtime/pkg/builder/rest" to control the Strategy.
 //
 // WithResourceAndStrategy should never be called after the GroupResource has already been registered with another
-// version.
+// version.  Build will return an error if it is.
 //
 // WithResourceAndStrategy will automatically register the "status" subresource for the resource if the object
 // implements the resource.StatusGetSetter interface.
@@ -156,12 +202,12 @@ func (a *Server) WithResourceAndStrategy(obj resource.Object, strategy rest.Stra
 	gvr := obj.GetGroupVersionResource()
 	a.schemeBuilder.Register(resource.AddToScheme(obj))
 
-	_ = a.forGroupVersionResource(gvr, obj, rest.NewWithStrategy(obj, strategy))
+	_ = a.forGroupVersionResource(gvr, obj, EtcdStorage, rest.NewWithStrategy(obj, strategy))
 
 	// automatically create status subresource if the object implements the status interface
 	if _, ok := obj.(resource.StatusGetSetter); ok {
 		st := gvr.GroupVersion().WithResource(gvr.Resource + "/status")
-		_ = a.forGroupVersionResource(st, obj, rest.NewStatusWithStrategy(obj, strategy))
+		_ = a.forGroupVersionResource(st, obj, EtcdStorage, rest.NewStatusWithStrategy(obj, strategy))
 	}
 	return a
 }
@@ -170,7 +216,7 @@ func (a *Server) WithResourceAndStrategy(obj resource.Object, strategy rest.Stra
 // etcd backed storage.
 //
 // WithResourceAndHandler should never be called after the GroupResource has already been registered with
-// another version.
+// another version.  Build will return an error if it is.
 //
 // Note: WithResourceAndHandler will NOT register the "status" subresource for the resource object.
 //
@@ -183,7 +229,7 @@ func (a *Server) WithResourceAndStrategy(obj resource.Object, strategy rest.Stra
 func (a *Server) WithResourceAndHandler(obj resource.Object, sp rest.ResourceHandlerProvider) *Server {
 	gvr := obj.GetGroupVersionResource()
 	a.schemeBuilder.Register(resource.AddToScheme(obj))
-	return a.forGroupVersionResource(gvr, obj, sp)
+	return a.forGroupVersionResource(gvr, obj, HandlerStorage, sp)
 }
 
 // WithResourceAndStorage registers the resource with the apiserver, applying fn to the storage for the resource
@@ -194,35 +240,252 @@ func (a *Server) WithResourceAndStorage(obj resource.Object, fn rest.StoreFn) *S
 	gvr := obj.GetGroupVersionResource()
 	a.schemeBuilder.Register(resource.AddToScheme(obj))
 
-	_ = a.forGroupVersionResource(gvr, obj, rest.NewWithFn(obj, fn))
+	_ = a.forGroupVersionResource(gvr, obj, EtcdStorage, rest.NewWithFn(obj, fn))
 
 	// automatically create status subresource if the object implements the status interface
 	if _, ok := obj.(resource.StatusGetSetter); ok {
 		st := gvr.GroupVersion().WithResource(gvr.Resource + "/status")
-		_ = a.forGroupVersionResource(st, obj, rest.NewStatusWithFn(obj, fn))
+		_ = a.forGroupVersionResource(st, obj, EtcdStorage, rest.NewStatusWithFn(obj, fn))
+	}
+
+	return a
+}
+
+// WithStorageVersion sets the version used to store the resource in etcd.
+//
+// By default all resources in the group are stored using the first version registered for the group.
+// WithStorageVersion overrides the storage version for a single resource and its subresources -- e.g. flunders
+// may be stored as v1beta1 while fischers are stored as v1alpha1.  Objects are converted to the storage version
+// from the type of the version which registered the storage of the resource when they are written to etcd.
+//
+// The GroupVersionResource must be registered with one of the WithResource functions before Build is called.
+func (a *Server) WithStorageVersion(gvr schema.GroupVersionResource) *Server {
+	if a.storageVersions == nil {
+		a.storageVersions = map[schema.GroupResource]schema.GroupVersion{}
+	}
+	a.storageVersions[gvr.GroupResource()] = gvr.GroupVersion()
+	return a
+}
+
+// WithUnstructuredResource registers a resource defined by an OpenAPI schema rather than a go type.  Objects of
+// the resource are unstructured.Unstructured objects stored in etcd.
+//
+// As for the versions of a CustomResourceDefinition, openAPISchema must be a structural schema.  Objects are
+// pruned of fields not specified by the schema and defaulted from the schema when they are read from requests
+// or storage, and are validated against the schema when they are created or updated.  The schema is published
+// in the OpenAPI spec of the apiserver.
+//
+// Unstructured resources are stored in the version they are registered with, and cannot be registered for
+// multiple versions or with subresources.  Server-side apply requests are merged using the schema.
+func (a *Server) WithUnstructuredResource(gvr schema.GroupVersionResource, kind string,
+	openAPISchema *apiextensionsv1.JSONSchemaProps, scope apiextensionsv1.ResourceScope) *Server {
+	if _, found := a.storage[gvr.GroupResource()]; found {
+		a.errs = append(a.errs, fmt.Errorf(
+			"unstructured resource %v is already registered with another version", gvr.GroupResource()))
+		return a
+	}
+	r, err := dynamic.NewResource(gvr, kind, openAPISchema, scope)
+	if err != nil {
+		a.errs = append(a.errs, fmt.Errorf("invalid unstructured resource %v: %v", gvr, err))
+		return a
+	}
+	if len(a.dynamicResources()) == 0 {
+		// registered once so the defaulting function dispatches to every unstructured resource
+		a.schemeBuilder.Register(func(s *runtime.Scheme) error {
+			return dynamic.AddToScheme(a.dynamicResources()...)(s)
+		})
+	}
+	a.registrations = append(a.registrations,
+		registration{gvr: gvr, kind: EtcdStorage, newStorage: true, dynamic: r})
+
+	a.withGroupVersions(gvr.GroupVersion())
+	sp := &singletonProvider{Provider: dynamic.New(r), kind: EtcdStorage}
+	a.storage[gvr.GroupResource()] = sp
+	apiserver.APIs[gvr] = sp.Get
+	return a.WithStorageVersion(gvr)
+}
+
+// WithController registers a controller which reconciles the objects of a resource served by the apiserver.
+//
+// Controllers are run in the apiserver process by the "start-controllers" post-start hook using the loopback
+// client config.  reconcile is called with the namespace and name of each object of the resource which is
+// added, updated or deleted, and requests which fail are retried with a rate limited backoff.  Controllers
+// are stopped when the apiserver is stopped.
+//
+// When the apiserver is replicated, the --leader-elect flag should be set so that only the replica holding a
+// Lease runs the controllers.  The Lease is stored in the cluster the apiserver is running in, or in the cluster
+// of --kubeconfig if it is set.
+//
+// The GroupVersionResource must be registered with one of the WithResource functions before Build is called.
+func (a *Server) WithController(
+	name string, gvr schema.GroupVersionResource, reconcile controller.ReconcileFunc) *Server {
+	a.manager()
+	if reconcile == nil {
+		a.errs = append(a.errs, fmt.Errorf("controller %s must have a reconcile function", name))
+		return a
+	}
+	for _, c := range a.controllers.Controllers {
+		if c.Name == name {
+			a.errs = append(a.errs, fmt.Errorf("controller %s is registered multiple times", name))
+			return a
+		}
+	}
+	a.controllers.Controllers = append(a.controllers.Controllers, &controller.Controller{
+		Name:                 name,
+		GroupVersionResource: gvr,
+		Reconcile:            reconcile,
+	})
+	return a
+}
+
+// WithNamespaces serves the core/v1 namespaces resource for apiservers which are run without a kube-apiserver.
+//
+// Namespaces are served under /api/v1 and stored in etcd, and the default, kube-system and kube-public namespaces
+// are created when the apiserver starts.  The NamespaceLifecycle admission plugin watches the namespaces served
+// by the apiserver rather than those of a kube-apiserver, so objects cannot be created in namespaces which do
+// not exist or are being deleted.  Deleted namespaces are Terminating until the "namespace" controller has
+// deleted the objects of every namespaced resource in them.
+//
+// The apiserver does not connect to a kube-apiserver for its admission plugins when namespaces are served, so
+// --kubeconfig is ignored.
+func (a *Server) WithNamespaces() *Server {
+	if a.namespaces {
+		return a
 	}
+	a.namespaces = true
+	a.schemeBuilder.Register(namespace.AddToScheme)
+	a.manager()
+	return a
+}
 
+// WithGarbageCollector deletes the objects of the registered resources whose owners have been deleted, for
+// apiservers which are run without a kube-controller-manager.
+//
+// Objects are deleted once every owner in their ownerReferences has been deleted, honoring the Background,
+// Foreground and Orphan propagation policies of the deleted owners.  Only ownerReferences to objects of the
+// resources registered with the Server are followed.  The garbage collector is run with the controllers
+// registered with WithController.
+func (a *Server) WithGarbageCollector() *Server {
+	a.garbageCollector = true
+	a.manager()
+	return a
+}
+
+// WithTracing records OpenTelemetry spans for the requests served by the apiserver, exporting them as JSON to the
+// file or stdout as configured by config.
+//
+// Requests are traced by a server span which continues the W3C trace context from the traceparent header of the
+// request.  The admission plugins, the DefaultStrategy hooks and the storage calls of each request are traced by
+// child spans.  Storage calls are traced above the watch cache, and the conversion between the internal and
+// storage versions of the objects is not traced separately.  The service.name of the spans defaults to the name
+// of the API group.
+//
+// The TracerProvider is set, and the trace file created, when the apiserver is started rather than by its other
+// commands.  Spans are written as the JSON of the stdouttrace exporter, which is not the OTLP JSON encoding.
+func (a *Server) WithTracing(config tracing.Config) *Server {
+	a.tracing = &config
+	return a
+}
+
+// WithAuditPolicy audits the requests served by the apiserver with the audit policy of config.
+//
+// The audit events are written to the log file and sent to the webhook of config, or written to stdout if
+// neither is configured.  The --audit-* flags take precedence over config, so the policy and backends may still
+// be changed when the apiserver is started.  The fields of the Redactions in config are redacted from the request
+// and response bodies of the audit events before they reach either backend.
+func (a *Server) WithAuditPolicy(config audit.Config) *Server {
+	a.audit = &config
+	return a
+}
+
+// WithEncryptionAtRest encrypts the objects of the resources in the EncryptionConfiguration of config when they
+// are stored in etcd.
+//
+// Objects are encrypted with the first provider of their resource -- one of aescbc, aesgcm, secretbox, kms or
+// identity -- and decrypted with whichever provider encrypted them, in the same format as the kube-apiserver.
+// The kms providers use the KMS services of config, or else the gRPC KMS plugin listening on their endpoint.
+// Keys are rotated by adding the new key first, running the rotate-encryption-keys command to rewrite the
+// objects of the encrypted resources, and then removing the old key.
+func (a *Server) WithEncryptionAtRest(config encryption.Config) *Server {
+	a.encryption = &config
+	return a
+}
+
+// WithAPIServiceRegistration registers the apiserver with the kube-apiserver hosting it when the apiserver is
+// started, creating or updating an APIService for each served GroupVersion.
+//
+// The APIServices are written using the kubeconfig of the delegated authentication, or the in-cluster config,
+// and reference the Service of config.  Their CA bundle is the CABundleFile of config, or else the serving
+// certificate of the apiserver, and is updated when the serving certificate is rotated.  The APIServices are
+// deleted when the apiserver is shut down if config.RemoveOnShutdown is set.
+func (a *Server) WithAPIServiceRegistration(config apiservice.Config) *Server {
+	a.registerAPIs = &config
 	return a
 }
 
-// forGroupVersionResource manually registers storage for a specific resource or subresource version.
-func (a *Server) forGroupVersionResource(
-	gvr schema.GroupVersionResource, obj resource.Object, sp rest.ResourceHandlerProvider) *Server {
+// manager returns the Manager running the controllers, creating it if necessary.
+func (a *Server) manager() *controller.Manager {
+	if a.controllers == nil {
+		a.controllers = controller.NewManager("")
+	}
+	return a.controllers
+}
+
+// forGroupVersionResource manually registers new storage for a specific resource or subresource version.
+func (a *Server) forGroupVersionResource(gvr schema.GroupVersionResource, obj resource.Object,
+	kind StorageKind, sp rest.ResourceHandlerProvider) *Server {
+	a.registrations = append(a.registrations, registration{gvr: gvr, obj: obj, kind: kind, newStorage: true})
+	return a.registerGroupVersionResource(gvr, obj, kind, sp)
+}
+
+// reuseGroupVersionResource registers a specific resource or subresource version using the storage already
+// registered for another version of the GroupResource.
+func (a *Server) reuseGroupVersionResource(
+	gvr schema.GroupVersionResource, obj resource.Object, s *singletonProvider) *Server {
+	a.registrations = append(a.registrations, registration{gvr: gvr, obj: obj, kind: s.kind})
+	return a.registerGroupVersionResource(gvr, obj, s.kind, s.Get)
+}
+
+func (a *Server) registerGroupVersionResource(gvr schema.GroupVersionResource, obj resource.Object,
+	kind StorageKind, sp rest.ResourceHandlerProvider) *Server {
 	// register the group version
 	a.withGroupVersions(gvr.GroupVersion())
 
-	// TODO: make sure folks don't register multiple storage instance for the same group-resource
+	// registering multiple storage instances for the same group-resource is reported by Build.
 	// don't replace the existing instance otherwise it will chain wrapped singletonProviders when
 	// fetching from the map before calling this function
 	if _, found := a.storage[gvr.GroupResource()]; !found {
-		a.storage[gvr.GroupResource()] = &singletonProvider{Provider: sp}
+		a.storage[gvr.GroupResource()] = &singletonProvider{Provider: sp, kind: kind}
+	}
+
+	// deprecated versions are moved to the end of the version priority when building
+	// the releases are validated to be the same for every resource of the version by Build
+	if l, deprecated := lifecycleOf(obj); deprecated {
+		if a.deprecatedVersions == nil {
+			a.deprecatedVersions = map[schema.GroupVersion]apiLifecycle{}
+		}
+		if _, found := a.deprecatedVersions[gvr.GroupVersion()]; !found {
+			a.deprecatedVersions[gvr.GroupVersion()] = l
+		}
 	}
 
 	// add the defaulting function for this version to the scheme
-	if _, ok := obj.(resourcestrategy.Defaulter); ok {
-		apiserver.Scheme.AddTypeDefaultingFunc(obj, func(obj interface{}) {
-			obj.(resourcestrategy.Defaulter).Default()
-		})
+	if fn, err := resource.DefaultingFunc(obj); err != nil {
+		a.errs = append(a.errs, fmt.Errorf("invalid defaults for %v: %v", gvr, err))
+	} else if fn != nil {
+		apiserver.Scheme.AddTypeDefaultingFunc(obj, fn)
+	}
+	if err := resourcevalidation.ValidateRules(obj); err != nil {
+		a.errs = append(a.errs, fmt.Errorf("invalid validation rules for %v: %v", gvr, err))
+	}
+	if _, err := resourceimmutable.Fields(obj); err != nil {
+		a.errs = append(a.errs, fmt.Errorf("invalid immutable fields for %v: %v", gvr, err))
+	}
+	if err := resourcecel.Compile(obj); err != nil {
+		a.errs = append(a.errs, fmt.Errorf("invalid CEL rules for %v: %v", gvr, err))
+	}
+	if _, err := resourceauthz.Fields(obj); err != nil {
+		a.errs = append(a.errs, fmt.Errorf("invalid authorized fields for %v: %v", gvr, err))
 	}
 
 	// add the API with its storage
@@ -247,7 +510,7 @@ func (a *Server) WithSubResource(
 
 	// reuse the storage if this resource has already been registered
 	if s, found := a.storage[gvr.GroupResource()]; found {
-		_ = a.forGroupVersionResource(gvr, request, s.Get)
+		_ = a.reuseGroupVersionResource(gvr, request, s)
 	} else {
 		a.errs = append(a.errs, fmt.Errorf(
 			"subresources must be registered with a strategy or handler the first time they are registered"))
@@ -275,13 +538,19 @@ func (a *Server) WithSubResourceAndStrategy(
 	parent resource.Object, subResourcePath string, request resource.Object, strategy rest.Strategy) *Server {
 	gvr := parent.GetGroupVersionResource()
 	gvr.Resource = gvr.Resource + "/" + subResourcePath
-	return a.forGroupVersionResource(gvr, request, rest.NewWithStrategy(request, strategy))
+
+	// reuse the storage if this subresource has already been registered
+	if s, found := a.storage[gvr.GroupResource()]; found {
+		return a.reuseGroupVersionResource(gvr, request, s)
+	}
+	return a.forGroupVersionResource(gvr, request, EtcdStorage, rest.NewWithStrategy(request, strategy))
 }
 
 // WithSubResourceAndHandler registers a request handler for the subresource rather than the default
 // etcd backed storage.
 //
 // WithSubResourceAndHandler should never be called after the subresource has been registered with another.
+// Build will return an error if it is.
 //
 // Note: WithResourceAndHandler will NOT register the "status" subresource for the resource object.
 //
@@ -296,7 +565,7 @@ func (a *Server) WithSubResourceAndHandler(
 	gvr := parent.GetGroupVersionResource()
 	// add the subresource path
 	gvr.Resource = gvr.Resource + "/" + subResourcePath
-	return a.forGroupVersionResource(gvr, request, sp)
+	return a.forGroupVersionResource(gvr, request, HandlerStorage, sp)
 }
 
 //WithSchemeInstallers registers functions to install resource types into the Scheme.
@@ -330,29 +599,559 @@ func (a *Server) WithServerFns(fns ...func(server *GenericAPIServer) *GenericAPI
 	return a
 }
 
-// Build returns a Command used to run the apiserver
+// Build returns a Command used to run the apiserver.
+//
+// Build validates the registered resources and returns an error listing every problem found -- e.g. resources
+// registered multiple times, subresources registered without their parent, objects whose New or NewList
+// functions return the wrong types, and versions which cannot be converted to the storage version.
 func (a *Server) Build() (*Command, error) {
+	if err := a.build(); err != nil {
+		return nil, err
+	}
+	o := server.NewWardleServerOptions(os.Stdout, os.Stderr, a.orderedGroupVersions[0])
+	stopCh := genericapiserver.SetupSignalHandler()
+	cmd := server.NewCommandStartServer(o, stopCh)
+	cmd.Flags().AddGoFlagSet(flag.CommandLine)
+	cmd.AddCommand(server.NewCommandMigrateStorage(o, stopCh))
+	cmd.AddCommand(a.newDescribeCommand())
+	cmd.AddCommand(a.newManifestsCommand())
+	if a.encryption != nil {
+		cmd.AddCommand(server.NewCommandRotateEncryptionKeys(o, stopCh, a.encryption.Resources))
+	}
+	if a.namespaces {
+		a.addNamespaces()
+	}
+	if a.garbageCollector {
+		a.controllers.Runnables = append(a.controllers.Runnables,
+			&garbagecollector.GarbageCollector{Resources: a.garbageCollectedResources()})
+	}
+	if a.tracing != nil {
+		a.traceCommand(cmd)
+	}
+	if a.controllers != nil {
+		a.addControllers(cmd, o)
+	}
+	if a.registerAPIs != nil {
+		a.addAPIServiceRegistration(o)
+	}
+	return cmd, nil
+}
+
+// build adds the registered resources to the schemes, validates them and configures the apiserver to serve them.
+func (a *Server) build() error {
 	a.schemes = append(a.schemes, apiserver.Scheme)
-	a.schemeBuilder.Register(
-		func(scheme *runtime.Scheme) error {
-			scheme.SetVersionPriority(a.orderedGroupVersions...)
-			for i := range a.orderedGroupVersions {
-				metav1.AddToGroupVersion(scheme, a.orderedGroupVersions[i])
-			}
-			return nil
-		},
-	)
+	a.schemeBuilder.Register(a.addGroupVersions)
 	for i := range a.schemes {
 		a.schemeBuilder.AddToScheme(a.schemes[i])
 	}
 
+	for gr, gv := range a.storageVersions {
+		if _, found := apiserver.APIs[gv.WithResource(gr.Resource)]; !found {
+			a.errs = append(a.errs, fmt.Errorf(
+				"storage version %v for %v must be registered with WithResource", gv, gr))
+			continue
+		}
+		server.StorageVersions[gr] = gv
+	}
+	a.errs = append(a.errs, a.validate(apiserver.Scheme)...)
+	a.errs = append(a.errs, a.validateControllers()...)
+
 	if len(a.errs) != 0 {
-		return nil, errs{list: a.errs}
+		return errs{list: a.errs}
 	}
-	o := server.NewWardleServerOptions(os.Stdout, os.Stderr, a.orderedGroupVersions[0])
-	cmd := server.NewCommandStartServer(o, genericapiserver.SetupSignalHandler())
-	cmd.Flags().AddGoFlagSet(flag.CommandLine)
-	return cmd, nil
+	if a.tracing != nil {
+		a.addTracing()
+	}
+	if a.audit != nil {
+		if err := a.addAudit(); err != nil {
+			return err
+		}
+	}
+	if a.encryption != nil {
+		if err := a.addEncryption(); err != nil {
+			return err
+		}
+	}
+	server.SetOpenAPIDefinitions(a.openAPITitle, a.openAPIVersion, a.allOpenAPIDefinitions())
+	apiserver.NegotiatedSerializer = protobuf.NegotiatedSerializer(apiserver.Codecs, apiserver.Scheme, apiserver.Scheme)
+	if len(a.dynamicResources()) > 0 {
+		apiserver.NegotiatedSerializer = dynamic.NegotiatedSerializer(apiserver.NegotiatedSerializer)
+		server.RecommendedConfigFns = append(server.RecommendedConfigFns, a.postProcessDynamicSpec, a.manageDynamicFields)
+	}
+	rest.RegisterMetrics()
+	server.RecommendedConfigFns = append(server.RecommendedConfigFns, a.instrumentHandlers)
+	if a.authorizesFields() {
+		server.RecommendedConfigFns = append(server.RecommendedConfigFns, addFieldAuthorizer)
+	}
+	return nil
+}
+
+// validateControllers returns errors for controllers of resources which are not registered.
+func (a *Server) validateControllers() []error {
+	if a.controllers == nil {
+		return nil
+	}
+	var errs []error
+	for _, c := range a.controllers.Controllers {
+		if _, found := apiserver.APIs[c.GroupVersionResource]; !found {
+			errs = append(errs, fmt.Errorf(
+				"controller %s reconciles %v which must be registered with WithResource", c.Name, c.GroupVersionResource))
+		}
+	}
+	return errs
+}
+
+// addControllers adds the leader election flags to the command and runs the controllers from a post-start hook.
+func (a *Server) addControllers(cmd *Command, o *ServerOptions) {
+	if a.controllers.LeaderElection.ResourceName == "" {
+		a.controllers.LeaderElection.ResourceName = a.group + "-controllers"
+	}
+	a.controllers.LeaseConfig = func() (*restclient.Config, error) {
+		// the options are completed from the flags before the server is started
+		if o.RecommendedOptions.CoreAPI != nil && o.RecommendedOptions.CoreAPI.CoreAPIKubeconfigPath != "" {
+			return clientcmd.BuildConfigFromFlags("", o.RecommendedOptions.CoreAPI.CoreAPIKubeconfigPath)
+		}
+		return restclient.InClusterConfig()
+	}
+	a.controllers.AddFlags(cmd.Flags())
+	apiserver.GenericAPIServerFns = append(apiserver.GenericAPIServerFns,
+		func(s *genericapiserver.GenericAPIServer) *genericapiserver.GenericAPIServer {
+			s.AddPostStartHookOrDie("start-controllers", a.controllers.PostStartHook())
+			return s
+		})
+}
+
+// addAPIServiceRegistration registers the APIServices of the apiserver when it is started.
+func (a *Server) addAPIServiceRegistration(o *ServerOptions) {
+	config := *a.registerAPIs
+	mo := ManifestOptions{
+		Name:                 config.ServiceName,
+		Namespace:            config.ServiceNamespace,
+		GroupPriorityMinimum: config.GroupPriorityMinimum,
+	}
+	mo.complete(a.group)
+	services := a.apiServices(mo)
+	apiserver.GenericAPIServerFns = append(apiserver.GenericAPIServerFns,
+		func(s *genericapiserver.GenericAPIServer) *genericapiserver.GenericAPIServer {
+			r := &apiservice.Registrar{
+				APIServices:  services,
+				ResyncPeriod: config.ResyncPeriod,
+				CABundle: func() ([]byte, error) {
+					if config.CABundleFile != "" {
+						return ioutil.ReadFile(config.CABundleFile)
+					}
+					if s.SecureServingInfo == nil || s.SecureServingInfo.Cert == nil {
+						return nil, nil
+					}
+					cert, _ := s.SecureServingInfo.Cert.CurrentCertKeyContent()
+					return cert, nil
+				},
+			}
+			if s.SecureServingInfo != nil {
+				if n, ok := s.SecureServingInfo.Cert.(dynamiccertificates.Notifier); ok {
+					n.AddListener(r)
+				}
+			}
+			// the client is created by the post-start hook, which may still be running when the apiserver is
+			// shut down
+			var clientLock sync.Mutex
+			s.AddPostStartHookOrDie("register-apiservices", func(ctx genericapiserver.PostStartHookContext) error {
+				// the options are completed from the flags before the server is started
+				kubeconfig, err := delegatedAuthenticationConfig(o)
+				if err != nil {
+					return err
+				}
+				client, err := aggregatorclient.NewForConfig(kubeconfig)
+				if err != nil {
+					return err
+				}
+				clientLock.Lock()
+				r.Client = client.ApiregistrationV1()
+				clientLock.Unlock()
+				runCtx, cancel := context.WithCancel(context.Background())
+				go func() {
+					<-ctx.StopCh
+					cancel()
+				}()
+				go r.Run(runCtx)
+				return nil
+			})
+			if config.RemoveOnShutdown {
+				s.AddPreShutdownHookOrDie("unregister-apiservices", func() error {
+					clientLock.Lock()
+					started := r.Client != nil
+					clientLock.Unlock()
+					if !started {
+						return nil
+					}
+					ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+					defer cancel()
+					return r.Remove(ctx)
+				})
+			}
+			return s
+		})
+}
+
+// delegatedAuthenticationConfig returns the config of the kube-apiserver the apiserver delegates authentication to.
+func delegatedAuthenticationConfig(o *ServerOptions) (*restclient.Config, error) {
+	if o.RecommendedOptions.Authentication != nil && o.RecommendedOptions.Authentication.RemoteKubeConfigFile != "" {
+		return clientcmd.BuildConfigFromFlags("", o.RecommendedOptions.Authentication.RemoteKubeConfigFile)
+	}
+	return restclient.InClusterConfig()
+}
+
+// addNamespaces serves namespaces from the legacy API group, and runs the namespace controller and the
+// namespace admission plugins against the apiserver itself.
+func (a *Server) addNamespaces() {
+	gvr := namespace.GroupVersionResource
+	apiserver.LegacyAPIs[gvr] = namespace.New
+	server.StorageVersions[gvr.GroupResource()] = gvr.GroupVersion()
+
+	var namespaced []schema.GroupVersionResource
+	for _, r := range a.garbageCollectedResources() {
+		if r.Namespaced {
+			namespaced = append(namespaced, r.GroupVersionResource)
+		}
+	}
+	a.controllers.Controllers = append(a.controllers.Controllers, &controller.Controller{
+		Name:                 "namespace",
+		GroupVersionResource: gvr,
+		Reconcile:            namespace.Reconcile(namespaced),
+	})
+
+	server.ServerOptionsFns = append(server.ServerOptionsFns, func(o *ServerOptions) *ServerOptions {
+		// the informers of the admission plugins watch the apiserver rather than a kube-apiserver
+		o.RecommendedOptions.CoreAPI = nil
+		next := o.RecommendedOptions.ExtraAdmissionInitializers
+		o.RecommendedOptions.ExtraAdmissionInitializers = func(
+			c *genericapiserver.RecommendedConfig) ([]admission.PluginInitializer, error) {
+			initializers, err := namespace.LoopbackInformers(c)
+			if err != nil || next == nil {
+				return initializers, err
+			}
+			more, err := next(c)
+			return append(initializers, more...), err
+		}
+		return o
+	})
+	apiserver.GenericAPIServerFns = append(apiserver.GenericAPIServerFns,
+		func(s *genericapiserver.GenericAPIServer) *genericapiserver.GenericAPIServer {
+			s.AddPostStartHookOrDie("create-default-namespaces", namespace.CreateDefaultNamespaces())
+			return s
+		})
+}
+
+// garbageCollectedResources returns the registered resources, watched using their storage versions.
+func (a *Server) garbageCollectedResources() []garbagecollector.Resource {
+	var resources []garbagecollector.Resource
+	index := map[schema.GroupResource]int{}
+	for _, r := range a.registrations {
+		if strings.Contains(r.gvr.Resource, "/") {
+			continue
+		}
+		if r.dynamic != nil {
+			resources = append(resources, garbagecollector.Resource{
+				GroupVersionResource: r.gvr,
+				Kinds:                []schema.GroupVersionKind{r.dynamic.GroupVersionKind()},
+				Namespaced:           r.dynamic.NamespaceScoped(),
+			})
+			continue
+		}
+		gr := r.gvr.GroupResource()
+		i, found := index[gr]
+		if !found {
+			i = len(resources)
+			index[gr] = i
+			resources = append(resources, garbagecollector.Resource{
+				GroupVersionResource: a.storageVersion(gr).WithResource(gr.Resource),
+				Namespaced:           r.obj.NamespaceScoped(),
+			})
+		}
+		resources[i].Kinds = append(resources[i].Kinds, r.gvr.GroupVersion().WithKind(typeName(r.obj)))
+	}
+	return resources
+}
+
+// addTracing traces the handler chain, admission plugins and storage.  Spans are not recorded until the
+// TracerProvider is set by traceCommand.
+func (a *Server) addTracing() {
+	server.RecommendedConfigFns = append(server.RecommendedConfigFns,
+		func(config *genericapiserver.RecommendedConfig) *genericapiserver.RecommendedConfig {
+			next := config.BuildHandlerChainFunc
+			config.BuildHandlerChainFunc = func(apiHandler http.Handler, c *genericapiserver.Config) http.Handler {
+				return tracing.WithTracing(next(apiHandler, c))
+			}
+			return config
+		})
+	server.ServerOptionsFns = append(server.ServerOptionsFns, func(o *ServerOptions) *ServerOptions {
+		if o.RecommendedOptions.Admission != nil {
+			o.RecommendedOptions.Admission.Decorators = append(
+				o.RecommendedOptions.Admission.Decorators, tracing.AdmissionDecorator)
+		}
+		return o
+	})
+	server.RESTOptionsGetterFns = append(server.RESTOptionsGetterFns, tracing.RESTOptionsGetter)
+}
+
+// traceCommand sets the global TracerProvider when the server command is run, so the trace file is not created
+// by the other commands, and flushes the spans once the server has shut down.
+func (a *Server) traceCommand(cmd *Command) {
+	config := *a.tracing
+	if config.ServiceName == "" {
+		config.ServiceName = a.group
+	}
+	run := cmd.RunE
+	cmd.RunE = func(c *Command, args []string) error {
+		provider, err := tracing.NewTracerProvider(config)
+		if err != nil {
+			return err
+		}
+		previous := otel.GetTracerProvider()
+		otel.SetTracerProvider(provider)
+		err = run(c, args)
+
+		otel.SetTracerProvider(previous)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if shutdownErr := provider.Shutdown(ctx); err == nil && shutdownErr != nil {
+			return fmt.Errorf("unable to flush the traces: %v", shutdownErr)
+		}
+		return err
+	}
+}
+
+// addAudit defaults the audit options from the audit Config and redacts the audit events.
+func (a *Server) addAudit() error {
+	config := *a.audit
+	for _, r := range config.Redactions {
+		if _, found := a.storage[r.Resource]; !found {
+			return fmt.Errorf("redacted resource %v must be registered with WithResource", r.Resource)
+		}
+	}
+	if err := config.Complete(); err != nil {
+		return err
+	}
+
+	server.ServerOptionsFns = append(server.ServerOptionsFns, func(o *ServerOptions) *ServerOptions {
+		config.ApplyTo(o.RecommendedOptions.Audit)
+		return o
+	})
+	server.OptionsApplierFns = append(server.OptionsApplierFns, func(next server.OptionsApplier) server.OptionsApplier {
+		return func(o *ServerOptions, c *genericapiserver.RecommendedConfig) error {
+			if err := config.WithPolicy(o.RecommendedOptions.Audit, func() error { return next(o, c) }); err != nil {
+				return err
+			}
+			// the backend is created from the audit options when they are applied
+			if len(config.Redactions) > 0 && c.AuditBackend != nil {
+				c.AuditBackend = audit.NewRedactingBackend(c.AuditBackend, config.Redactions)
+			}
+			return nil
+		}
+	})
+	return nil
+}
+
+// addEncryption encrypts the storage of the resources in the encryption Config.
+func (a *Server) addEncryption() error {
+	if err := a.encryption.Validate(); err != nil {
+		return err
+	}
+	if a.encryption.Configuration != nil {
+		resources, err := a.encryption.Resources()
+		if err != nil {
+			return err
+		}
+		for _, gr := range resources {
+			if _, found := a.storage[gr]; !found {
+				return fmt.Errorf("encrypted resource %v must be registered with WithResource", gr)
+			}
+		}
+	}
+	server.RESTOptionsGetterFns = append(server.RESTOptionsGetterFns, a.encryption.RESTOptionsGetter)
+	return nil
+}
+
+// instrumentHandlers records the latency of the requests for the resources which are not stored in etcd.
+func (a *Server) instrumentHandlers(config *genericapiserver.RecommendedConfig) *genericapiserver.RecommendedConfig {
+	handlers := map[schema.GroupResource]bool{}
+	for _, r := range a.registrations {
+		if r.kind != EtcdStorage {
+			handlers[r.gvr.GroupResource()] = true
+		}
+	}
+	if len(handlers) == 0 {
+		return config
+	}
+	next := config.BuildHandlerChainFunc
+	config.BuildHandlerChainFunc = func(apiHandler http.Handler, c *genericapiserver.Config) http.Handler {
+		return next(rest.WithHandlerMetrics(apiHandler, handlers), c)
+	}
+	return config
+}
+
+// authorizesFields returns true if a registered resource has fields which may only be written by authorized users.
+func (a *Server) authorizesFields() bool {
+	for _, r := range a.registrations {
+		if r.dynamic != nil {
+			continue
+		}
+		if fields, _ := resourceauthz.Fields(r.obj); len(fields) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// addFieldAuthorizer adds the authorizer of the apiserver to the context of the requests, so the DefaultStrategy
+// authorizes the users writing the authorized fields of the resources.
+func addFieldAuthorizer(config *genericapiserver.RecommendedConfig) *genericapiserver.RecommendedConfig {
+	next := config.BuildHandlerChainFunc
+	config.BuildHandlerChainFunc = func(apiHandler http.Handler, c *genericapiserver.Config) http.Handler {
+		return next(resourceauthz.WithRequestAuthorizer(apiHandler, c.Authorization.Authorizer), c)
+	}
+	return config
+}
+
+// postProcessDynamicSpec publishes the schemas of the unstructured resources in the OpenAPI spec.
+func (a *Server) postProcessDynamicSpec(config *genericapiserver.RecommendedConfig) *genericapiserver.RecommendedConfig {
+	if config.OpenAPIConfig == nil {
+		return config
+	}
+	next := config.OpenAPIConfig.PostProcessSpec
+	process := dynamic.PostProcessSpec(a.dynamicResources()...)
+	config.OpenAPIConfig.PostProcessSpec = func(s *spec.Swagger) (*spec.Swagger, error) {
+		if next != nil {
+			var err error
+			if s, err = next(s); err != nil {
+				return nil, err
+			}
+		}
+		return process(s)
+	}
+	return config
+}
+
+// manageDynamicFields serves the requests writing the unstructured resources with field managers which support
+// unstructured objects.
+func (a *Server) manageDynamicFields(config *genericapiserver.RecommendedConfig) *genericapiserver.RecommendedConfig {
+	// the storage is created when the apiserver installs the resources, before any request is served
+	storage := func(gvr schema.GroupVersionResource) (regsitryrest.Storage, error) {
+		return a.storage[gvr.GroupResource()].Get(apiserver.Scheme, nil)
+	}
+	next := config.BuildHandlerChainFunc
+	config.BuildHandlerChainFunc = func(apiHandler http.Handler, c *genericapiserver.Config) http.Handler {
+		return next(dynamic.WithFieldManagers(
+			apiHandler, c, apiserver.Scheme, apiserver.NegotiatedSerializer, storage, a.dynamicResources()...), c)
+	}
+	return config
+}
+
+// addGroupVersions adds the registered versions to the scheme.  addGroupVersions must be called after the
+// types have been added to the scheme.
+func (a *Server) addGroupVersions(scheme *runtime.Scheme) error {
+	scheme.SetVersionPriority(a.prioritizedGroupVersions()...)
+	for i := range a.orderedGroupVersions {
+		metav1.AddToGroupVersion(scheme, a.orderedGroupVersions[i])
+	}
+	return a.addHubTypes(scheme)
+}
+
+// NewScheme returns a new Scheme containing the types of the registered resources.  NewScheme may be used to
+// test the serialization and conversion of the resources without building the apiserver.
+func (a *Server) NewScheme() (*runtime.Scheme, error) {
+	scheme := runtime.NewScheme()
+	if err := a.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	return scheme, nil
+}
+
+// AddToScheme adds the types of the registered resources to scheme, as Build does for apiserver.Scheme.
+// AddToScheme may be used to test the resources with the conversions registered directly with apiserver.Scheme.
+func (a *Server) AddToScheme(scheme *runtime.Scheme) error {
+	if err := a.schemeBuilder.AddToScheme(scheme); err != nil {
+		return err
+	}
+	return a.addGroupVersions(scheme)
+}
+
+// Resources returns the objects of the registered resources and subresources.  Unstructured resources are not
+// included.
+func (a *Server) Resources() map[schema.GroupVersionResource]resource.Object {
+	objs := map[schema.GroupVersionResource]resource.Object{}
+	for _, r := range a.registrations {
+		if r.dynamic == nil {
+			objs[r.gvr] = r.obj
+		}
+	}
+	return objs
+}
+
+// dynamicResources returns the unstructured resources registered with WithUnstructuredResource.
+func (a *Server) dynamicResources() []*dynamic.Resource {
+	var resources []*dynamic.Resource
+	for _, r := range a.registrations {
+		if r.dynamic != nil {
+			resources = append(resources, r.dynamic)
+		}
+	}
+	return resources
+}
+
+// StorageVersion returns the version the resource is stored as.
+func (a *Server) StorageVersion(gr schema.GroupResource) schema.GroupVersion {
+	return a.storageVersion(gr)
+}
+
+// prioritizedGroupVersions returns the registered versions ordered by preference.  Versions are preferred in
+// the order they were registered, except for deprecated versions which are preferred last.
+func (a *Server) prioritizedGroupVersions() []schema.GroupVersion {
+	var served, deprecated []schema.GroupVersion
+	for _, gv := range a.orderedGroupVersions {
+		if a.isDeprecated(gv) {
+			deprecated = append(deprecated, gv)
+		} else {
+			served = append(served, gv)
+		}
+	}
+	return append(served, deprecated...)
+}
+
+// isDeprecated returns true if the version is deprecated.
+func (a *Server) isDeprecated(gv schema.GroupVersion) bool {
+	_, found := a.deprecatedVersions[gv]
+	return found
+}
+
+// apiLifecycle is the release a version was deprecated in and the release it will be removed in.
+type apiLifecycle struct {
+	deprecatedMajor, deprecatedMinor int
+	removedMajor, removedMinor       int
+}
+
+func (l apiLifecycle) String() string {
+	if l == (apiLifecycle{}) {
+		return "not deprecated"
+	}
+	s := fmt.Sprintf("deprecated in %d.%d", l.deprecatedMajor, l.deprecatedMinor)
+	if l.removedMajor != 0 || l.removedMinor != 0 {
+		s += fmt.Sprintf(" and removed in %d.%d", l.removedMajor, l.removedMinor)
+	}
+	return s
+}
+
+// lifecycleOf returns the deprecation and removal releases of the object's version, and true if the object
+// is deprecated.
+func lifecycleOf(obj resource.Object) (apiLifecycle, bool) {
+	if !resource.IsDeprecated(obj) {
+		return apiLifecycle{}, false
+	}
+	d := obj.(resource.Deprecated)
+	l := apiLifecycle{}
+	l.deprecatedMajor, l.deprecatedMinor = d.APILifecycleDeprecated()
+	l.removedMajor, l.removedMinor = d.APILifecycleRemoved()
+	return l, true
 }
 
 // Execute builds and executes the apiserver Command.
@@ -368,6 +1167,7 @@ func (a *Server) Execute() error {
 type singletonProvider struct {
 	sync.Once
 	Provider rest.ResourceHandlerProvider
+	kind     StorageKind
 	storage  regsitryrest.Storage
 	err      error
 }
@@ -0,0 +1,350 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/apiserver"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/audit"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/controller"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/garbagecollector"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcestrategy"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/rest"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/tracing"
+	"github.com/pwittrock/apiserver-runtime/pkg/cmd/server"
+	examplev1alpha1 "github.com/pwittrock/apiserver-runtime/pkg/example/v1alpha1"
+	examplev1beta1 "github.com/pwittrock/apiserver-runtime/pkg/example/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/apiserver/pkg/endpoints/deprecation"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+)
+
+func TestPrioritizedGroupVersions(t *testing.T) {
+	v1alpha1 := schema.GroupVersion{Group: "example.com", Version: "v1alpha1"}
+	v1beta1 := schema.GroupVersion{Group: "example.com", Version: "v1beta1"}
+	v1 := schema.GroupVersion{Group: "example.com", Version: "v1"}
+
+	a := &Server{}
+	a.withGroupVersions(v1alpha1, v1beta1, v1)
+	expected := []schema.GroupVersion{v1alpha1, v1beta1, v1}
+	if versions := a.prioritizedGroupVersions(); !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expected %v, got %v", expected, versions)
+	}
+
+	a.deprecatedVersions = map[schema.GroupVersion]apiLifecycle{
+		v1alpha1: {deprecatedMajor: 1, deprecatedMinor: 20},
+	}
+	expected = []schema.GroupVersion{v1beta1, v1, v1alpha1}
+	if versions := a.prioritizedGroupVersions(); !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expected %v, got %v", expected, versions)
+	}
+}
+
+func TestWithStorageVersion(t *testing.T) {
+	gr := schema.GroupResource{Group: "example.com", Resource: "examples"}
+	v1beta1 := schema.GroupVersion{Group: gr.Group, Version: "v1beta1"}
+	a := newTestServer().
+		WithResource(&examplev1alpha1.ExampleResource{}).
+		WithResource(&examplev1beta1.ExampleResource{}).
+		WithStorageVersion(v1beta1.WithResource(gr.Resource))
+	if v := a.storageVersion(gr); v != v1beta1 {
+		t.Fatalf("expected examples to be stored as %v, got %v", v1beta1, v)
+	}
+	scheme := runtime.NewScheme()
+	a.schemeBuilder.Register(a.addHubTypes, addExampleConversions)
+	if err := a.schemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	// the store of examples was registered by v1alpha1, so its objects are converted to v1beta1 when they are
+	// written to etcd and back to v1alpha1 when they are read
+	codec, _, err := serverstorage.NewStorageCodec(serverstorage.StorageCodecConfig{
+		StorageMediaType:  runtime.ContentTypeJSON,
+		StorageSerializer: serializer.NewCodecFactory(scheme),
+		StorageVersion:    a.storageVersion(gr),
+		MemoryVersion:     schema.GroupVersion{Group: gr.Group, Version: runtime.APIVersionInternal},
+		Config:            *storagebackend.NewDefaultConfig("", nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := codec.Encode(&examplev1alpha1.ExampleResource{}, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"apiVersion":"example.com/v1beta1"`) {
+		t.Errorf("expected the example to be stored as v1beta1, got %s", buf.String())
+	}
+	obj, err := runtime.Decode(codec, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := obj.(*examplev1alpha1.ExampleResource); !ok {
+		t.Errorf("expected the example to be read as the type of the store, got %T", obj)
+	}
+}
+
+// DeprecatedWidget is a deprecated version of Widget
+type DeprecatedWidget struct {
+	Widget
+}
+
+func (w *DeprecatedWidget) New() runtime.Object { return &DeprecatedWidget{} }
+func (w *DeprecatedWidget) DeepCopyObject() runtime.Object {
+	return &DeprecatedWidget{Widget: *w.Widget.DeepCopyObject().(*Widget)}
+}
+func (w *DeprecatedWidget) IsInternalVersion() bool                    { return false }
+func (w *DeprecatedWidget) APILifecycleDeprecated() (major, minor int) { return 1, 20 }
+func (w *DeprecatedWidget) APILifecycleRemoved() (major, minor int)    { return 0, 0 }
+func (w *DeprecatedWidget) GetGroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: "test.example.com", Version: "v1alpha1", Resource: "widgets"}
+}
+
+func TestDeprecatedVersionPriority(t *testing.T) {
+	a := newTestServer().WithResource(&DeprecatedWidget{}).WithResource(&Widget{})
+	scheme, err := a.NewScheme()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the deprecated version is registered first, but preferred last
+	expected := []schema.GroupVersion{testGroupVersion, {Group: "test.example.com", Version: "v1alpha1"}}
+	if versions := scheme.PrioritizedVersionsForGroup("test.example.com"); !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expected %v, got %v", expected, versions)
+	}
+
+	// only the deprecated version returns deprecation warnings and is described as deprecated
+	currentMajor, currentMinor := 1, 21
+	for _, obj := range []resource.Object{&Widget{}, &DeprecatedWidget{}} {
+		gv := obj.GetGroupVersionResource().GroupVersion()
+		deprecated := deprecation.IsDeprecated(obj, currentMajor, currentMinor)
+		if deprecated != a.isDeprecated(gv) {
+			t.Errorf("expected %v to be deprecated %t, got %t", gv, a.isDeprecated(gv), deprecated)
+		}
+	}
+	for _, d := range a.Describe() {
+		if d.Deprecated != (d.GroupVersionResource.Version == "v1alpha1") {
+			t.Errorf("expected only v1alpha1 to be deprecated, got %+v", d)
+		}
+	}
+
+	// the resources of a deprecated version must be deprecated in the same releases
+	a.forGroupVersionResource(schema.GroupVersionResource{
+		Group: "test.example.com", Version: "v1alpha1", Resource: "gizmos"}, &Widget{}, EtcdStorage, rest.New(&Widget{}))
+	var found bool
+	for _, err := range a.validate(scheme) {
+		found = found || err.Error() == "test.example.com/v1alpha1, Resource=gizmos is not deprecated, but "+
+			"test.example.com/v1alpha1, Resource=widgets is deprecated in 1.20: every resource of a version must be "+
+			"deprecated in the same releases"
+	}
+	if !found {
+		t.Errorf("expected gizmos to be rejected for not being deprecated, got %v", a.validate(scheme))
+	}
+}
+
+func TestWithController(t *testing.T) {
+	reconcile := func(ctx context.Context, req controller.Request) error { return nil }
+	widgets := testGroupVersion.WithResource("widgets")
+	a := newTestServer().
+		WithResource(&Widget{}).
+		WithController("widgets", widgets, reconcile).
+		WithController("widgets", widgets, reconcile).
+		WithController("sprockets", testGroupVersion.WithResource("sprockets"), reconcile).
+		WithController("nil", widgets, nil)
+
+	if len(a.errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", a.errs)
+	}
+	for i, msg := range []string{"widgets is registered multiple times", "nil must have a reconcile function"} {
+		if !strings.Contains(a.errs[i].Error(), msg) {
+			t.Errorf("expected %q, got %v", msg, a.errs[i])
+		}
+	}
+	errs := a.validateControllers()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "controller sprockets reconciles") {
+		t.Errorf("expected the sprockets controller to be invalid, got %v", errs)
+	}
+}
+
+func TestWithNamespacesAndGarbageCollector(t *testing.T) {
+	a := newTestServer().
+		WithResource(&Widget{}).
+		WithNamespaces().
+		WithNamespaces().
+		WithGarbageCollector()
+	if len(a.errs) != 0 {
+		t.Fatalf("expected no errors, got %v", a.errs)
+	}
+	if a.controllers == nil {
+		t.Fatalf("expected the namespace controller and garbage collector to be run by a manager")
+	}
+
+	expected := []garbagecollector.Resource{{
+		GroupVersionResource: testGroupVersion.WithResource("widgets"),
+		Kinds:                []schema.GroupVersionKind{testGroupVersion.WithKind("Widget")},
+		Namespaced:           true,
+	}}
+	if resources := a.garbageCollectedResources(); !reflect.DeepEqual(resources, expected) {
+		t.Errorf("expected %v, got %v", expected, resources)
+	}
+
+	scheme, err := a.NewScheme()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []string{"Namespace", "NamespaceList"} {
+		if !scheme.Recognizes(corev1.SchemeGroupVersion.WithKind(kind)) {
+			t.Errorf("expected %s to be registered", kind)
+		}
+	}
+}
+
+// Lever has a CEL rule which cannot be compiled.
+type Lever struct {
+	Widget
+}
+
+func (l *Lever) New() runtime.Object { return &Lever{} }
+func (l *Lever) CELRules() []resourcestrategy.CELRule {
+	return []resourcestrategy.CELRule{{Rule: "self.spec =="}}
+}
+func (l *Lever) DeepCopyObject() runtime.Object {
+	c := *l
+	l.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	return &c
+}
+func (l *Lever) GetGroupVersionResource() schema.GroupVersionResource {
+	return testGroupVersion.WithResource("levers")
+}
+
+func TestInvalidCELRules(t *testing.T) {
+	a := newTestServer().WithResource(&Lever{})
+	msg := "invalid CEL rules for test.example.com/v1, Resource=levers"
+	if len(a.errs) != 1 || !strings.Contains(a.errs[0].Error(), msg) {
+		t.Errorf("expected the CEL rules to be invalid, got %v", a.errs)
+	}
+}
+
+// Crank has an authorized field without a subresource.
+type Crank struct {
+	Widget
+}
+
+func (c *Crank) New() runtime.Object { return &Crank{} }
+func (c *Crank) AuthorizedFields() []resourcestrategy.AuthorizedField {
+	return []resourcestrategy.AuthorizedField{{Path: "spec.owner"}}
+}
+func (c *Crank) DeepCopyObject() runtime.Object {
+	o := *c
+	c.ObjectMeta.DeepCopyInto(&o.ObjectMeta)
+	return &o
+}
+func (c *Crank) GetGroupVersionResource() schema.GroupVersionResource {
+	return testGroupVersion.WithResource("cranks")
+}
+
+func TestInvalidAuthorizedFields(t *testing.T) {
+	a := newTestServer().WithResource(&Crank{})
+	msg := "invalid authorized fields for test.example.com/v1, Resource=cranks: field spec.owner must have a subresource"
+	if len(a.errs) != 1 || !strings.Contains(a.errs[0].Error(), msg) {
+		t.Errorf("expected the authorized fields to be invalid, got %v", a.errs)
+	}
+	if a.authorizesFields() {
+		t.Error("expected the invalid fields not to be authorized")
+	}
+}
+
+func TestTraceCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "traces.json")
+
+	a := newTestServer().WithResource(&Widget{}).WithTracing(tracing.Config{Path: path})
+	cmd := &Command{RunE: func(*Command, []string) error {
+		_, span := tracing.Start(context.Background(), "serve")
+		span.End()
+		return nil
+	}}
+	a.traceCommand(cmd)
+
+	// the trace file is only created when the command is run
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the trace file not to be created before running the command, got %v", err)
+	}
+	if err := cmd.RunE(cmd, nil); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"Name":"serve"`) || !strings.Contains(string(b), `"Value":"test.example.com"`) {
+		t.Errorf("expected the span of the test.example.com service to be flushed, got:\n%s", b)
+	}
+}
+
+func TestAddAudit(t *testing.T) {
+	serverOptionsFns, optionsApplierFns := server.ServerOptionsFns, server.OptionsApplierFns
+	defer func() { server.ServerOptionsFns, server.OptionsApplierFns = serverOptionsFns, optionsApplierFns }()
+
+	a := newTestServer().WithResource(&Widget{}).WithAuditPolicy(audit.Config{
+		Policy:     &auditv1.Policy{Rules: []auditv1.PolicyRule{{Level: auditv1.LevelMetadata}}},
+		Redactions: []audit.Redaction{{Resource: testGroupVersion.WithResource("widgets").GroupResource()}},
+	})
+	if err := a.addAudit(); err != nil {
+		t.Fatal(err)
+	}
+
+	o := server.ApplyServerOptionsFns(server.NewWardleServerOptions(nil, nil, testGroupVersion))
+	c := genericapiserver.NewRecommendedConfig(apiserver.Codecs)
+	var policyFile string
+	apply := server.ApplyOptionsApplierFns(func(o *ServerOptions, c *genericapiserver.RecommendedConfig) error {
+		policyFile = o.RecommendedOptions.Audit.PolicyFile
+		return o.RecommendedOptions.Audit.ApplyTo(&c.Config)
+	})
+	if err := apply(o, c); err != nil {
+		t.Fatal(err)
+	}
+
+	// the backend created from the options is redacted, and the policy file is removed once it has been read
+	if c.AuditBackend == nil || !strings.HasPrefix(c.AuditBackend.String(), "redacting<") {
+		t.Errorf("expected the audit backend to be redacted, got %v", c.AuditBackend)
+	}
+	if c.AuditPolicyChecker == nil {
+		t.Error("expected the audit policy to be read")
+	}
+	if _, err := os.Stat(policyFile); policyFile == "" || !os.IsNotExist(err) {
+		t.Errorf("expected the policy file %q to be removed, got %v", policyFile, err)
+	}
+}
@@ -0,0 +1,277 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package client contains clients, informers and listers for the resources served by an apiserver built with
+// the builder package.
+//
+// The clients are built from the resource.Objects registered with the apiserver, rather than generated by
+// client-gen, and read and write the same go types the apiserver serves.  Objects are serialized using
+// apiserver.Scheme, so clients for resources which were not registered with a built apiserver -- e.g. in a
+// controller running in another process -- register the resources with apiserver.Scheme when they are created.
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/apiserver"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+)
+
+// Interface returns the clients for resources.
+type Interface interface {
+	// Resource returns a client for the resource of obj.
+	Resource(obj resource.Object) (NamespaceableResourceInterface, error)
+}
+
+// ResourceInterface reads and writes the objects of a resource.  The objects returned are of the types returned
+// by the New and NewList functions of the resource.
+type ResourceInterface interface {
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (resource.Object, error)
+	List(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error)
+	Create(ctx context.Context, obj resource.Object, opts metav1.CreateOptions) (resource.Object, error)
+	Update(ctx context.Context, obj resource.Object, opts metav1.UpdateOptions) (resource.Object, error)
+	UpdateStatus(ctx context.Context, obj resource.Object, opts metav1.UpdateOptions) (resource.Object, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions,
+		subresources ...string) (resource.Object, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+}
+
+// NamespaceableResourceInterface reads and writes the objects of a resource in every namespace, or in a single
+// namespace.  Objects of namespace scoped resources are created and updated in their own namespace unless a
+// namespace is selected.
+type NamespaceableResourceInterface interface {
+	ResourceInterface
+	// Namespace returns a client for the objects in namespace.
+	Namespace(namespace string) ResourceInterface
+}
+
+// NewForConfig returns a new Interface for the apiserver at config.
+func NewForConfig(config *rest.Config) (Interface, error) {
+	if config == nil {
+		return nil, fmt.Errorf("a config is required")
+	}
+	return &clientset{config: rest.CopyConfig(config), clients: map[schema.GroupVersion]rest.Interface{}}, nil
+}
+
+// schemeLock guards the registration of resources with apiserver.Scheme.
+var schemeLock sync.Mutex
+
+// Register registers obj with apiserver.Scheme if it is not already registered -- e.g. by building an
+// apiserver serving it.  Register is called when a client for obj is created, and must not be called
+// concurrently with the scheme being used to serialize objects.
+func Register(obj resource.Object) error {
+	schemeLock.Lock()
+	defer schemeLock.Unlock()
+	gv := obj.GetGroupVersionResource().GroupVersion()
+	if apiserver.Scheme.Recognizes(gv.WithKind("WatchEvent")) && isRegistered(obj) {
+		return nil
+	}
+	if err := resource.AddToScheme(obj)(apiserver.Scheme); err != nil {
+		return err
+	}
+	metav1.AddToGroupVersion(apiserver.Scheme, gv)
+	return nil
+}
+
+func isRegistered(obj resource.Object) bool {
+	for _, o := range []runtime.Object{obj.New(), obj.NewList()} {
+		if _, _, err := apiserver.Scheme.ObjectKinds(o); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+type clientset struct {
+	config *rest.Config
+
+	lock    sync.Mutex
+	clients map[schema.GroupVersion]rest.Interface
+}
+
+func (c *clientset) Resource(obj resource.Object) (NamespaceableResourceInterface, error) {
+	if err := Register(obj); err != nil {
+		return nil, err
+	}
+	client, err := c.restClient(obj.GetGroupVersionResource().GroupVersion())
+	if err != nil {
+		return nil, err
+	}
+	return NewForRESTClient(client, obj), nil
+}
+
+// restClient returns the client shared by the resources of gv.
+func (c *clientset) restClient(gv schema.GroupVersion) (rest.Interface, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if client, found := c.clients[gv]; found {
+		return client, nil
+	}
+	config := rest.CopyConfig(c.config)
+	config.GroupVersion = &gv
+	config.APIPath = "/apis"
+	if gv.Group == "" {
+		config.APIPath = "/api"
+	}
+	config.NegotiatedSerializer = apiserver.Codecs.WithoutConversion()
+	if config.UserAgent == "" {
+		config.UserAgent = rest.DefaultKubernetesUserAgent()
+	}
+	client, err := rest.RESTClientFor(config)
+	if err != nil {
+		return nil, err
+	}
+	c.clients[gv] = client
+	return client, nil
+}
+
+// NewForRESTClient returns a client for the resource of obj using client, which must be configured for the
+// GroupVersion of the resource.  The resource must be registered with apiserver.Scheme.
+func NewForRESTClient(client rest.Interface, obj resource.Object) NamespaceableResourceInterface {
+	return &resourceClient{client: client, obj: obj, resource: obj.GetGroupVersionResource().Resource}
+}
+
+type resourceClient struct {
+	client    rest.Interface
+	obj       resource.Object
+	resource  string
+	namespace string
+}
+
+func (c *resourceClient) Namespace(namespace string) ResourceInterface {
+	n := *c
+	n.namespace = namespace
+	return &n
+}
+
+// request returns a request for the resource in namespace, if it is namespace scoped.
+func (c *resourceClient) request(r *rest.Request, namespace string) *rest.Request {
+	return r.NamespaceIfScoped(namespace, c.obj.NamespaceScoped()).Resource(c.resource)
+}
+
+// namespaceOf returns the namespace of the client, or else of obj.
+func (c *resourceClient) namespaceOf(obj resource.Object) string {
+	if c.namespace != "" {
+		return c.namespace
+	}
+	return obj.GetObjectMeta().Namespace
+}
+
+// into returns a new object of the resource.
+func (c *resourceClient) into() resource.Object {
+	return c.obj.New().(resource.Object)
+}
+
+func (c *resourceClient) Get(ctx context.Context, name string, opts metav1.GetOptions) (resource.Object, error) {
+	result := c.into()
+	err := c.request(c.client.Get(), c.namespace).
+		Name(name).
+		VersionedParams(&opts, metav1.ParameterCodec).
+		Do(ctx).
+		Into(result)
+	return result, err
+}
+
+func (c *resourceClient) List(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	result := c.obj.NewList()
+	err := c.request(c.client.Get(), c.namespace).
+		VersionedParams(&opts, metav1.ParameterCodec).
+		Timeout(timeout).
+		Do(ctx).
+		Into(result)
+	return result, err
+}
+
+func (c *resourceClient) Create(ctx context.Context, obj resource.Object, opts metav1.CreateOptions) (
+	resource.Object, error) {
+	result := c.into()
+	err := c.request(c.client.Post(), c.namespaceOf(obj)).
+		VersionedParams(&opts, metav1.ParameterCodec).
+		Body(obj).
+		Do(ctx).
+		Into(result)
+	return result, err
+}
+
+func (c *resourceClient) Update(ctx context.Context, obj resource.Object, opts metav1.UpdateOptions) (
+	resource.Object, error) {
+	return c.update(ctx, obj, opts)
+}
+
+func (c *resourceClient) UpdateStatus(ctx context.Context, obj resource.Object, opts metav1.UpdateOptions) (
+	resource.Object, error) {
+	return c.update(ctx, obj, opts, "status")
+}
+
+func (c *resourceClient) update(ctx context.Context, obj resource.Object, opts metav1.UpdateOptions,
+	subresources ...string) (resource.Object, error) {
+	result := c.into()
+	err := c.request(c.client.Put(), c.namespaceOf(obj)).
+		Name(obj.GetObjectMeta().Name).
+		SubResource(subresources...).
+		VersionedParams(&opts, metav1.ParameterCodec).
+		Body(obj).
+		Do(ctx).
+		Into(result)
+	return result, err
+}
+
+func (c *resourceClient) Patch(ctx context.Context, name string, pt types.PatchType, data []byte,
+	opts metav1.PatchOptions, subresources ...string) (resource.Object, error) {
+	result := c.into()
+	err := c.request(c.client.Patch(pt), c.namespace).
+		Name(name).
+		SubResource(subresources...).
+		VersionedParams(&opts, metav1.ParameterCodec).
+		Body(data).
+		Do(ctx).
+		Into(result)
+	return result, err
+}
+
+func (c *resourceClient) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return c.request(c.client.Delete(), c.namespace).
+		Name(name).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+func (c *resourceClient) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	opts.Watch = true
+	return c.request(c.client.Get(), c.namespace).
+		VersionedParams(&opts, metav1.ParameterCodec).
+		Timeout(timeout).
+		Watch(ctx)
+}
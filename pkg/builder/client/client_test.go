@@ -0,0 +1,280 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+)
+
+// Widget is a namespaced resource with a spec and a status.
+type Widget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              WidgetSpec   `json:"spec,omitempty"`
+	Status            WidgetStatus `json:"status,omitempty"`
+}
+
+type WidgetSpec struct {
+	Size int `json:"size,omitempty"`
+}
+
+type WidgetStatus struct {
+	Ready bool `json:"ready,omitempty"`
+}
+
+type WidgetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Widget `json:"items"`
+}
+
+// newWidget returns a Widget in the default namespace.
+func newWidget(name string, size int) *Widget {
+	return &Widget{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}, Spec: WidgetSpec{Size: size}}
+}
+
+func (w *Widget) DeepCopyObject() runtime.Object {
+	c := *w
+	w.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	return &c
+}
+func (w *Widget) GetObjectMeta() *metav1.ObjectMeta { return &w.ObjectMeta }
+func (w *Widget) NamespaceScoped() bool             { return true }
+func (w *Widget) New() runtime.Object               { return &Widget{} }
+func (w *Widget) NewList() runtime.Object           { return &WidgetList{} }
+func (w *Widget) IsInternalVersion() bool           { return true }
+func (w *Widget) GetGroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+}
+
+func (l *WidgetList) DeepCopyObject() runtime.Object {
+	c := *l
+	l.ListMeta.DeepCopyInto(&c.ListMeta)
+	c.Items = nil
+	for i := range l.Items {
+		c.Items = append(c.Items, *l.Items[i].DeepCopyObject().(*Widget))
+	}
+	return &c
+}
+
+// server serves the widgets in the default namespace from memory, recording the requests.
+type server struct {
+	sync.Mutex
+	widgets  map[string]Widget
+	version  int
+	requests []string
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/apis/example.com/v1/namespaces/default/widgets")
+	s.requests = append(s.requests, r.Method+" "+path)
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	name := parts[0]
+
+	write := func(code int, obj interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(obj)
+	}
+	notFound := func() {
+		write(http.StatusNotFound, &metav1.Status{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+			Status:   metav1.StatusFailure, Reason: metav1.StatusReasonNotFound, Code: http.StatusNotFound})
+	}
+	store := func(g Widget) {
+		s.version++
+		g.APIVersion, g.Kind = "example.com/v1", "Widget"
+		g.ResourceVersion = strconv.Itoa(s.version)
+		s.widgets[g.Name] = g
+		write(http.StatusOK, g)
+	}
+
+	switch {
+	case r.Method == http.MethodGet && name == "" && r.URL.Query().Get("watch") == "true":
+		w.Header().Set("Content-Type", "application/json")
+		for _, g := range s.widgets {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"type": "ADDED", "object": g})
+		}
+	case r.Method == http.MethodGet && name == "":
+		list := WidgetList{TypeMeta: metav1.TypeMeta{APIVersion: "example.com/v1", Kind: "WidgetList"}}
+		list.ResourceVersion = strconv.Itoa(s.version)
+		for _, g := range s.widgets {
+			list.Items = append(list.Items, g)
+		}
+		write(http.StatusOK, list)
+	case r.Method == http.MethodPost:
+		g := Widget{}
+		_ = json.NewDecoder(r.Body).Decode(&g)
+		store(g)
+	case r.Method == http.MethodGet || r.Method == http.MethodPut || r.Method == http.MethodPatch:
+		g, found := s.widgets[name]
+		if !found {
+			notFound()
+			return
+		}
+		if r.Method == http.MethodGet {
+			write(http.StatusOK, g)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&g)
+		store(g)
+	case r.Method == http.MethodDelete:
+		if _, found := s.widgets[name]; !found {
+			notFound()
+			return
+		}
+		delete(s.widgets, name)
+		write(http.StatusOK, &metav1.Status{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"}, Status: metav1.StatusSuccess})
+	}
+}
+
+func newTestClient(t *testing.T) (NamespaceableResourceInterface, *server, func()) {
+	s := &server{widgets: map[string]Widget{}}
+	ts := httptest.NewServer(s)
+	client, err := NewForConfig(&rest.Config{Host: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	widgets, err := client.Resource(&Widget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return widgets, s, ts.Close
+}
+
+func TestClient(t *testing.T) {
+	widgets, s, stop := newTestClient(t)
+	defer stop()
+	ctx := context.Background()
+
+	created, err := widgets.Create(ctx, newWidget("one", 1), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	one, ok := created.(*Widget)
+	if !ok || one.Spec.Size != 1 || one.ResourceVersion != "1" {
+		t.Fatalf("expected the created Widget, got %#v", created)
+	}
+
+	one.Spec.Size = 2
+	if _, err := widgets.Update(ctx, one, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	one.Status.Ready = true
+	if _, err := widgets.UpdateStatus(ctx, one, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := widgets.Namespace("default").Patch(
+		ctx, "one", types.MergePatchType, []byte(`{"spec":{"size":3}}`), metav1.PatchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := widgets.Namespace("default").Get(ctx, "one", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := got.(*Widget); w.Spec.Size != 3 || !w.Status.Ready {
+		t.Errorf("expected the updated Widget, got %#v", w)
+	}
+	list, err := widgets.Namespace("default").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := list.(*WidgetList); len(l.Items) != 1 || l.Items[0].Name != "one" {
+		t.Errorf("expected the Widgets to be listed, got %#v", l)
+	}
+
+	if err := widgets.Namespace("default").Delete(ctx, "one", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := widgets.Namespace("default").Get(ctx, "one", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the deleted Widget to be not found, got %v", err)
+	}
+
+	expected := []string{"POST ", "PUT /one", "PUT /one/status", "PATCH /one", "GET /one", "GET ", "DELETE /one",
+		"GET /one"}
+	if fmt.Sprint(s.requests) != fmt.Sprint(expected) {
+		t.Errorf("expected requests %q, got %q", expected, s.requests)
+	}
+}
+
+func TestInformer(t *testing.T) {
+	widgets, _, stop := newTestClient(t)
+	defer stop()
+	if _, err := widgets.Create(
+		context.Background(), newWidget("one", 1), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	factory := NewSharedInformerFactoryForNamespace(&fixedClient{widgets}, 0, "default")
+	informer, err := factory.ForResource(&Widget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shared, _ := factory.ForResource(&Widget{}); shared.Informer() != informer.Informer() {
+		t.Errorf("expected the informer to be shared")
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	factory.Start(stopCh)
+	for gvr, synced := range factory.WaitForCacheSync(stopCh) {
+		if !synced {
+			t.Fatalf("expected %v to be synced", gvr)
+		}
+	}
+
+	obj, err := informer.Lister().Namespace("default").Get("one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, ok := obj.(*Widget); !ok || w.Name != "one" {
+		t.Errorf("expected the Widget to be listed, got %#v", obj)
+	}
+	all, err := informer.Lister().List(labels.Everything())
+	if err != nil || len(all) != 1 {
+		t.Errorf("expected a Widget to be listed, got %v %v", all, err)
+	}
+}
+
+// fixedClient returns the same client for every resource.
+type fixedClient struct {
+	client NamespaceableResourceInterface
+}
+
+func (c *fixedClient) Resource(resource.Object) (NamespaceableResourceInterface, error) {
+	return c.client, nil
+}
@@ -0,0 +1,321 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fake contains a fake client for the resources served by an apiserver built with the builder package.
+//
+// The fake client stores objects in memory using an ObjectTracker, and runs the same strategy as the apiserver
+// when objects are created, updated and patched -- e.g. defaulting, PrepareForCreate, Validate and
+// ValidateUpdate, and only updating the status of objects through the status subresource -- so code using the
+// client may be tested against the validation and defaulting of the resources without starting an apiserver.
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pwittrock/apiserver-runtime/pkg/apiserver"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/client"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource"
+	builderrest "github.com/pwittrock/apiserver-runtime/pkg/builder/rest"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/watch"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Client is a fake client.Interface storing objects in an ObjectTracker.  The actions of the client are
+// recorded, and may be intercepted by prepending reactors.
+type Client struct {
+	k8stesting.Fake
+	tracker k8stesting.ObjectTracker
+
+	lock       sync.Mutex
+	resources  map[schema.GroupVersionResource]resource.Object
+	strategies map[schema.GroupVersionResource]builderrest.Strategy
+
+	// writes serializes the writes so objects are not modified between being read and updated
+	writes  sync.Mutex
+	version int
+}
+
+var _ client.Interface = &Client{}
+
+// NewSimpleClient returns a new fake client storing objects.  The objects are stored as they are, without
+// running the strategy of their resource.  NewSimpleClient panics if the objects cannot be stored.
+func NewSimpleClient(objects ...resource.Object) *Client {
+	c := &Client{
+		tracker:    k8stesting.NewObjectTracker(apiserver.Scheme, apiserver.Codecs.UniversalDecoder()),
+		resources:  map[schema.GroupVersionResource]resource.Object{},
+		strategies: map[schema.GroupVersionResource]builderrest.Strategy{},
+	}
+	for _, obj := range objects {
+		if _, err := c.Resource(obj); err != nil {
+			panic(err)
+		}
+		obj = obj.DeepCopyObject().(resource.Object)
+		if obj.GetObjectMeta().ResourceVersion == "" {
+			obj.GetObjectMeta().ResourceVersion = c.nextResourceVersion()
+		}
+		if err := c.tracker.Create(obj.GetGroupVersionResource(), obj, obj.GetObjectMeta().Namespace); err != nil {
+			panic(err)
+		}
+	}
+	c.AddReactor("*", "*", c.react)
+	c.AddWatchReactor("*", c.watch)
+	return c
+}
+
+// Tracker returns the ObjectTracker storing the objects.
+func (c *Client) Tracker() k8stesting.ObjectTracker {
+	return c.tracker
+}
+
+// SetStrategy sets the strategy of the resource of obj -- e.g. the strategy of a resource registered using
+// rest.NewWithStrategy.  Defaults to a rest.DefaultStrategy.
+func (c *Client) SetStrategy(obj resource.Object, strategy builderrest.Strategy) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.strategies[obj.GetGroupVersionResource()] = strategy
+}
+
+// Resource implements client.Interface
+func (c *Client) Resource(obj resource.Object) (client.NamespaceableResourceInterface, error) {
+	if err := client.Register(obj); err != nil {
+		return nil, err
+	}
+	gvk, err := kind(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	gvr := obj.GetGroupVersionResource()
+	c.resources[gvr] = obj
+	if _, found := c.strategies[gvr]; !found {
+		c.strategies[gvr] = &builderrest.DefaultStrategy{
+			Object:         obj,
+			ObjectTyper:    apiserver.Scheme,
+			TableConvertor: rest.NewDefaultTableConvertor(gvr.GroupResource()),
+		}
+	}
+	return &resourceClient{fake: c, obj: obj, gvr: gvr, gvk: gvk}, nil
+}
+
+// kind returns the kind of obj in the GroupVersion of its resource.
+func kind(obj resource.Object) (schema.GroupVersionKind, error) {
+	gv := obj.GetGroupVersionResource().GroupVersion()
+	gvks, _, err := apiserver.Scheme.ObjectKinds(obj.New())
+	if err != nil {
+		return schema.GroupVersionKind{}, err
+	}
+	for _, gvk := range gvks {
+		if gvk.GroupVersion() == gv {
+			return gvk, nil
+		}
+	}
+	return schema.GroupVersionKind{}, fmt.Errorf("%T is not registered for %v", obj, gv)
+}
+
+func (c *Client) nextResourceVersion() string {
+	c.version++
+	return strconv.Itoa(c.version)
+}
+
+// resource returns the object and strategy of a resource.
+func (c *Client) resource(gvr schema.GroupVersionResource) (resource.Object, builderrest.Strategy) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.resources[gvr], c.strategies[gvr]
+}
+
+// react stores the objects of create, update, patch and delete actions, and reads objects for get and list
+// actions, running the strategy of the resource as the apiserver would.
+func (c *Client) react(action k8stesting.Action) (bool, runtime.Object, error) {
+	obj, strategy := c.resource(action.GetResource())
+	if obj == nil {
+		return false, nil, nil
+	}
+	if action.GetSubresource() == "status" {
+		strategy = &builderrest.StatusSubResourceStrategy{Strategy: strategy}
+	} else if action.GetSubresource() != "" {
+		return true, nil, apierrors.NewNotFound(action.GetResource().GroupResource(), action.GetSubresource())
+	}
+	gvr, ns := action.GetResource(), action.GetNamespace()
+	ctx := genericapirequest.WithNamespace(context.Background(), ns)
+
+	c.writes.Lock()
+	defer c.writes.Unlock()
+	switch action := action.(type) {
+	case k8stesting.GetActionImpl:
+		result, err := c.tracker.Get(gvr, ns, action.GetName())
+		return true, result, err
+	case k8stesting.ListActionImpl:
+		result, err := c.tracker.List(gvr, action.GetKind(), ns)
+		return true, result, err
+	case k8stesting.CreateActionImpl:
+		if action.GetSubresource() != "" {
+			return true, nil, apierrors.NewMethodNotSupported(gvr.GroupResource(), "create")
+		}
+		return c.create(ctx, gvr, strategy, action.GetObject())
+	case k8stesting.UpdateActionImpl:
+		return c.update(ctx, gvr, strategy, action.GetObject())
+	case k8stesting.PatchActionImpl:
+		old, err := c.tracker.Get(gvr, ns, action.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		patched, err := patch(old, obj.New(), action.GetPatchType(), action.GetPatch())
+		if err != nil {
+			return true, nil, err
+		}
+		return c.update(ctx, gvr, strategy, patched)
+	case k8stesting.DeleteActionImpl:
+		return c.delete(gvr, ns, action.GetName())
+	}
+	return false, nil, nil
+}
+
+func (c *Client) create(ctx context.Context, gvr schema.GroupVersionResource, strategy builderrest.Strategy,
+	obj runtime.Object) (bool, runtime.Object, error) {
+	obj = obj.DeepCopyObject()
+	apiserver.Scheme.Default(obj)
+	if err := rest.BeforeCreate(strategy, ctx, obj); err != nil {
+		return true, nil, err
+	}
+	meta := obj.(resource.Object).GetObjectMeta()
+	if meta.Name == "" {
+		return true, nil, apierrors.NewBadRequest("name or generateName is required")
+	}
+	meta.ResourceVersion = c.nextResourceVersion()
+	if err := c.tracker.Create(gvr, obj, meta.Namespace); err != nil {
+		return true, nil, err
+	}
+	result, err := c.tracker.Get(gvr, meta.Namespace, meta.Name)
+	return true, result, err
+}
+
+func (c *Client) update(ctx context.Context, gvr schema.GroupVersionResource, strategy builderrest.Strategy,
+	obj runtime.Object) (bool, runtime.Object, error) {
+	obj = obj.DeepCopyObject()
+	apiserver.Scheme.Default(obj)
+	meta := obj.(resource.Object).GetObjectMeta()
+	namespace := genericapirequest.NamespaceValue(ctx)
+	old, err := c.tracker.Get(gvr, namespace, meta.Name)
+	if apierrors.IsNotFound(err) && strategy.AllowCreateOnUpdate() {
+		return c.create(ctx, gvr, strategy, obj)
+	}
+	if err != nil {
+		return true, nil, err
+	}
+	oldMeta := old.(resource.Object).GetObjectMeta()
+	switch {
+	case meta.ResourceVersion == "" && !strategy.AllowUnconditionalUpdate():
+		return true, nil, apierrors.NewConflict(gvr.GroupResource(), meta.Name,
+			fmt.Errorf("metadata.resourceVersion must be specified for an update"))
+	case meta.ResourceVersion != "" && meta.ResourceVersion != oldMeta.ResourceVersion:
+		return true, nil, apierrors.NewConflict(gvr.GroupResource(), meta.Name,
+			fmt.Errorf("the object has been modified; please apply your changes to the latest version and try again"))
+	}
+
+	if err := rest.BeforeUpdate(strategy, ctx, obj, old); err != nil {
+		return true, nil, err
+	}
+	meta.ResourceVersion = c.nextResourceVersion()
+	// as for the apiserver, deleted objects are removed once their last finalizer is removed
+	if meta.DeletionTimestamp != nil && len(meta.Finalizers) == 0 {
+		return true, obj, c.tracker.Delete(gvr, namespace, meta.Name)
+	}
+	if err := c.tracker.Update(gvr, obj, namespace); err != nil {
+		return true, nil, err
+	}
+	result, err := c.tracker.Get(gvr, namespace, meta.Name)
+	return true, result, err
+}
+
+// delete deletes the object, or marks it as deleted if it has finalizers.
+func (c *Client) delete(gvr schema.GroupVersionResource, namespace, name string) (bool, runtime.Object, error) {
+	obj, err := c.tracker.Get(gvr, namespace, name)
+	if err != nil {
+		return true, nil, err
+	}
+	meta := obj.(resource.Object).GetObjectMeta()
+	if len(meta.Finalizers) == 0 {
+		return true, obj, c.tracker.Delete(gvr, namespace, name)
+	}
+	if meta.DeletionTimestamp == nil {
+		now := metav1.Now()
+		meta.DeletionTimestamp = &now
+		meta.DeletionGracePeriodSeconds = new(int64)
+		meta.ResourceVersion = c.nextResourceVersion()
+		if err := c.tracker.Update(gvr, obj, namespace); err != nil {
+			return true, nil, err
+		}
+	}
+	return true, obj, nil
+}
+
+// patch returns old patched with data, decoded into obj.
+func patch(old, obj runtime.Object, pt types.PatchType, data []byte) (runtime.Object, error) {
+	original, err := json.Marshal(old)
+	if err != nil {
+		return nil, err
+	}
+	var patched []byte
+	switch pt {
+	case types.JSONPatchType:
+		p, err := jsonpatch.DecodePatch(data)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(err.Error())
+		}
+		patched, err = p.Apply(original)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(err.Error())
+		}
+	case types.MergePatchType:
+		patched, err = jsonpatch.MergePatch(original, data)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(err.Error())
+		}
+	case types.StrategicMergePatchType:
+		patched, err = strategicpatch.StrategicMergePatch(original, data, old)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(err.Error())
+		}
+	default:
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("patch type %s is not supported by the fake client", pt))
+	}
+	if err := json.Unmarshal(patched, obj); err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	return obj, nil
+}
+
+// watch watches the objects of the tracker.
+func (c *Client) watch(action k8stesting.Action) (bool, watch.Interface, error) {
+	w, err := c.tracker.Watch(action.GetResource(), action.GetNamespace())
+	return true, w, err
+}
@@ -0,0 +1,276 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/client"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Widget is defaulted, prepared for create and validated by its strategy functions.  spec.color is immutable and
+// status.node is write-once.
+type Widget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              WidgetSpec   `json:"spec,omitempty"`
+	Status            WidgetStatus `json:"status,omitempty"`
+}
+
+type WidgetSpec struct {
+	Size  int    `json:"size,omitempty"`
+	Color string `json:"color,omitempty" immutable:"true"`
+}
+
+type WidgetStatus struct {
+	Ready bool   `json:"ready,omitempty"`
+	Node  string `json:"node,omitempty" immutable:"once"`
+}
+
+type WidgetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Widget `json:"items"`
+}
+
+// newWidget returns a Widget in the default namespace.
+func newWidget(name string, size int) *Widget {
+	return &Widget{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}, Spec: WidgetSpec{Size: size}}
+}
+
+func (w *Widget) DeepCopyObject() runtime.Object {
+	c := *w
+	w.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	return &c
+}
+func (w *Widget) GetObjectMeta() *metav1.ObjectMeta { return &w.ObjectMeta }
+func (w *Widget) NamespaceScoped() bool             { return true }
+func (w *Widget) New() runtime.Object               { return &Widget{} }
+func (w *Widget) NewList() runtime.Object           { return &WidgetList{} }
+func (w *Widget) IsInternalVersion() bool           { return true }
+func (w *Widget) GetGroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+}
+
+func (w *Widget) Default() {
+	if w.Spec.Color == "" {
+		w.Spec.Color = "grey"
+	}
+}
+
+func (w *Widget) PrepareForCreate(context.Context) {
+	w.Status = WidgetStatus{}
+}
+
+func (w *Widget) Validate(context.Context) field.ErrorList {
+	if w.Spec.Size < 0 {
+		return field.ErrorList{field.Invalid(field.NewPath("spec", "size"), w.Spec.Size, "must not be negative")}
+	}
+	return nil
+}
+
+func (w *Widget) ValidateUpdate(ctx context.Context, old runtime.Object) field.ErrorList {
+	return w.Validate(ctx)
+}
+
+func (w *Widget) CopyStatus(_ context.Context, from runtime.Object) {
+	w.Status = from.(*Widget).Status
+}
+
+func (w *Widget) CopySpec(_ context.Context, from runtime.Object) {
+	w.Spec = from.(*Widget).Spec
+}
+
+func (l *WidgetList) DeepCopyObject() runtime.Object {
+	c := *l
+	l.ListMeta.DeepCopyInto(&c.ListMeta)
+	c.Items = nil
+	for i := range l.Items {
+		c.Items = append(c.Items, *l.Items[i].DeepCopyObject().(*Widget))
+	}
+	return &c
+}
+
+func TestStrategy(t *testing.T) {
+	ctx := context.Background()
+	c := NewSimpleClient()
+	widgets, err := c.Resource(&Widget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = widgets.Create(ctx, newWidget("negative", -1), metav1.CreateOptions{})
+	if !apierrors.IsInvalid(err) {
+		t.Errorf("expected Validate to reject the Widget, got %v", err)
+	}
+	s := newWidget("one", 1)
+	s.Status.Ready = true
+	created, err := widgets.Create(ctx, s, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	one := created.(*Widget)
+	if one.Spec.Color != "grey" || one.Status.Ready || one.UID == "" || one.ResourceVersion == "" {
+		t.Errorf("expected the Widget to be defaulted and prepared for create, got %+v", one)
+	}
+
+	one.Spec.Color = "red"
+	if _, err := widgets.Update(ctx, one, metav1.UpdateOptions{}); !apierrors.IsInvalid(err) {
+		t.Errorf("expected ValidateUpdate to reject the Widget, got %v", err)
+	}
+	one.Spec.Color, one.Spec.Size, one.Status.Ready = "grey", 2, true
+	updated, err := widgets.Update(ctx, one, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := updated.(*Widget); u.Spec.Size != 2 || u.Status.Ready {
+		t.Errorf("expected the spec to be updated without the status, got %+v", u)
+	}
+	if _, err := widgets.Update(ctx, one, metav1.UpdateOptions{}); !apierrors.IsConflict(err) {
+		t.Errorf("expected updating a stale Widget to conflict, got %v", err)
+	}
+
+	one = updated.(*Widget)
+	one.Spec.Size, one.Status.Ready = 3, true
+	updated, err = widgets.UpdateStatus(ctx, one, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := updated.(*Widget); u.Spec.Size != 2 || !u.Status.Ready {
+		t.Errorf("expected the status to be updated without the spec, got %+v", u)
+	}
+
+	patched, err := widgets.Namespace("default").Patch(
+		ctx, "one", types.MergePatchType, []byte(`{"spec":{"size":4}}`), metav1.PatchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := patched.(*Widget); p.Spec.Size != 4 || !p.Status.Ready {
+		t.Errorf("expected the Widget to be patched, got %+v", p)
+	}
+	if _, err := widgets.Namespace("default").Patch(
+		ctx, "one", types.MergePatchType, []byte(`{"spec":{"size":-1}}`), metav1.PatchOptions{}); !apierrors.IsInvalid(err) {
+		t.Errorf("expected ValidateUpdate to reject the patch, got %v", err)
+	}
+
+	var verbs []string
+	for _, a := range c.Actions() {
+		verbs = append(verbs, a.GetVerb()+"/"+a.GetSubresource())
+	}
+	expected := []string{"create/", "create/", "update/", "update/", "update/", "update/status", "patch/", "patch/"}
+	if fmt.Sprint(verbs) != fmt.Sprint(expected) {
+		t.Errorf("expected actions %v, got %v", expected, verbs)
+	}
+}
+
+func TestImmutableFields(t *testing.T) {
+	ctx := context.Background()
+	c := NewSimpleClient(newWidget("one", 1))
+	widgets, err := c.Resource(&Widget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := widgets.Namespace("default").Get(ctx, "one", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	one := obj.(*Widget)
+	one.Status.Node = "node-1"
+	updated, err := widgets.UpdateStatus(ctx, one, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("expected the write-once status field to be set, got %v", err)
+	}
+	one = updated.(*Widget)
+	one.Status.Node = "node-2"
+	if _, err := widgets.UpdateStatus(ctx, one, metav1.UpdateOptions{}); !apierrors.IsInvalid(err) {
+		t.Errorf("expected changing the write-once status field to be forbidden, got %v", err)
+	}
+	one.Status.Node, one.Spec.Color = "node-1", "blue"
+	if _, err := widgets.Update(ctx, one, metav1.UpdateOptions{}); !apierrors.IsInvalid(err) ||
+		!strings.Contains(err.Error(), "spec.color: Forbidden: field is immutable") {
+		t.Errorf("expected changing the immutable field to be forbidden, got %v", err)
+	}
+}
+
+func TestReads(t *testing.T) {
+	ctx := context.Background()
+	labelled := newWidget("labelled", 1)
+	labelled.Labels = map[string]string{"app": "one"}
+	finalized := newWidget("finalized", 1)
+	finalized.Finalizers, finalized.Spec.Color = []string{"example.com/finalizer"}, "grey"
+	c := NewSimpleClient(labelled, finalized, newWidget("other", 1))
+	widgets, err := c.Resource(&Widget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := widgets.Namespace("default").List(ctx, metav1.ListOptions{LabelSelector: "app=one"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items := list.(*WidgetList).Items; len(items) != 1 || items[0].Name != "labelled" {
+		t.Errorf("expected the labelled Widget to be listed, got %v", items)
+	}
+
+	if err := widgets.Namespace("default").Delete(ctx, "finalized", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	obj, err := widgets.Namespace("default").Get(ctx, "finalized", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.GetObjectMeta().DeletionTimestamp == nil {
+		t.Errorf("expected the Widget with finalizers to be marked deleted, got %+v", obj)
+	}
+	obj.GetObjectMeta().Finalizers = nil
+	if _, err := widgets.Namespace("default").Update(ctx, obj, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := widgets.Namespace("default").Get(ctx, "finalized", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the Widget to be deleted when its finalizers are removed, got %v", err)
+	}
+	if err := widgets.Namespace("default").Delete(ctx, "other", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := widgets.Namespace("default").Get(ctx, "other", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the Widget to be deleted, got %v", err)
+	}
+
+	// the fake client may be used by informers
+	factory := client.NewSharedInformerFactory(c, 0)
+	informer, err := factory.ForResource(&Widget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	factory.Start(stopCh)
+	factory.WaitForCacheSync(stopCh)
+	if _, err := informer.Lister().Namespace("default").Get("labelled"); err != nil {
+		t.Errorf("expected the Widget to be listed by the informer, got %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/client"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// resourceClient records the actions of a resource with the fake client.
+type resourceClient struct {
+	fake      *Client
+	obj       resource.Object
+	gvr       schema.GroupVersionResource
+	gvk       schema.GroupVersionKind
+	namespace string
+}
+
+func (c *resourceClient) Namespace(namespace string) client.ResourceInterface {
+	n := *c
+	n.namespace = namespace
+	return &n
+}
+
+// namespaceOf returns the namespace of the client, or else of obj.
+func (c *resourceClient) namespaceOf(obj resource.Object) string {
+	if c.namespace != "" {
+		return c.namespace
+	}
+	return obj.GetObjectMeta().Namespace
+}
+
+// scoped returns namespace if the resource is namespace scoped.
+func (c *resourceClient) scoped(namespace string) string {
+	if !c.obj.NamespaceScoped() {
+		return ""
+	}
+	return namespace
+}
+
+// invokes records action and returns the object returned by the reactors.
+func (c *resourceClient) invokes(action k8stesting.Action) (resource.Object, error) {
+	obj, err := c.fake.Invokes(action, nil)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, nil
+	}
+	return obj.(resource.Object), nil
+}
+
+func (c *resourceClient) Get(_ context.Context, name string, _ metav1.GetOptions) (resource.Object, error) {
+	return c.invokes(k8stesting.NewGetAction(c.gvr, c.scoped(c.namespace), name))
+}
+
+func (c *resourceClient) List(_ context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+	obj, err := c.fake.Invokes(k8stesting.NewListAction(c.gvr, c.gvk, c.scoped(c.namespace), opts), nil)
+	if err != nil || obj == nil {
+		return obj, err
+	}
+
+	// filter the objects by the selectors as the apiserver would
+	label, field, _ := k8stesting.ExtractFromListOptions(opts)
+	_, strategy := c.fake.resource(c.gvr)
+	predicate := strategy.Match(label, field)
+	items, err := meta.ExtractList(obj)
+	if err != nil {
+		return nil, err
+	}
+	var filtered []runtime.Object
+	for i := range items {
+		matches, err := predicate.Matches(items[i])
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			filtered = append(filtered, items[i])
+		}
+	}
+	if err := meta.SetList(obj, filtered); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (c *resourceClient) Create(_ context.Context, obj resource.Object, _ metav1.CreateOptions) (
+	resource.Object, error) {
+	return c.invokes(k8stesting.NewCreateAction(c.gvr, c.scoped(c.namespaceOf(obj)), obj))
+}
+
+func (c *resourceClient) Update(_ context.Context, obj resource.Object, _ metav1.UpdateOptions) (
+	resource.Object, error) {
+	return c.invokes(k8stesting.NewUpdateAction(c.gvr, c.scoped(c.namespaceOf(obj)), obj))
+}
+
+func (c *resourceClient) UpdateStatus(_ context.Context, obj resource.Object, _ metav1.UpdateOptions) (
+	resource.Object, error) {
+	return c.invokes(k8stesting.NewUpdateSubresourceAction(c.gvr, "status", c.scoped(c.namespaceOf(obj)), obj))
+}
+
+func (c *resourceClient) Patch(_ context.Context, name string, pt types.PatchType, data []byte,
+	_ metav1.PatchOptions, subresources ...string) (resource.Object, error) {
+	return c.invokes(k8stesting.NewPatchSubresourceAction(
+		c.gvr, c.scoped(c.namespace), name, pt, data, subresources...))
+}
+
+func (c *resourceClient) Delete(_ context.Context, name string, _ metav1.DeleteOptions) error {
+	_, err := c.fake.Invokes(k8stesting.NewDeleteAction(c.gvr, c.scoped(c.namespace), name), nil)
+	return err
+}
+
+func (c *resourceClient) Watch(_ context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	w, err := c.fake.InvokesWatch(k8stesting.NewWatchAction(c.gvr, c.scoped(c.namespace), opts))
+	if err != nil {
+		return nil, err
+	}
+
+	// filter the events by the selectors as the apiserver would
+	label, field, _ := k8stesting.ExtractFromListOptions(opts)
+	_, strategy := c.fake.resource(c.gvr)
+	predicate := strategy.Match(label, field)
+	return watch.Filter(w, func(e watch.Event) (watch.Event, bool) {
+		matches, err := predicate.Matches(e.Object)
+		return e, err == nil && matches
+	}), nil
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Informer provides a shared informer and a lister for the objects of a resource.
+type Informer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() Lister
+}
+
+// Lister lists the objects of a resource from an informer cache.  The objects are shared with the cache, and
+// must be copied before they are modified.
+type Lister interface {
+	// List lists the objects in every namespace matching selector.
+	List(selector labels.Selector) ([]resource.Object, error)
+	// Get returns the object of a non-namespace scoped resource.
+	Get(name string) (resource.Object, error)
+	// Namespace returns a lister for the objects in namespace.
+	Namespace(namespace string) NamespaceLister
+}
+
+// NamespaceLister lists the objects of a resource in a namespace from an informer cache.
+type NamespaceLister interface {
+	// List lists the objects in the namespace matching selector.
+	List(selector labels.Selector) ([]resource.Object, error)
+	// Get returns the object in the namespace.
+	Get(name string) (resource.Object, error)
+}
+
+// SharedInformerFactory creates informers for resources which are shared by every caller requesting the same
+// resource.
+type SharedInformerFactory struct {
+	client    Interface
+	resync    time.Duration
+	namespace string
+
+	lock      sync.Mutex
+	informers map[schema.GroupVersionResource]cache.SharedIndexInformer
+	started   map[schema.GroupVersionResource]bool
+}
+
+// NewSharedInformerFactory returns a new factory for informers watching every namespace using client.
+func NewSharedInformerFactory(client Interface, resync time.Duration) *SharedInformerFactory {
+	return NewSharedInformerFactoryForNamespace(client, resync, metav1.NamespaceAll)
+}
+
+// NewSharedInformerFactoryForNamespace returns a new factory for informers watching namespace using client.
+func NewSharedInformerFactoryForNamespace(
+	client Interface, resync time.Duration, namespace string) *SharedInformerFactory {
+	return &SharedInformerFactory{
+		client:    client,
+		resync:    resync,
+		namespace: namespace,
+		informers: map[schema.GroupVersionResource]cache.SharedIndexInformer{},
+		started:   map[schema.GroupVersionResource]bool{},
+	}
+}
+
+// ForResource returns the informer for the resource of obj, creating it if it has not been requested before.
+// Informers created after the factory is started are started by calling Start again.
+func (f *SharedInformerFactory) ForResource(obj resource.Object) (Informer, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	gvr := obj.GetGroupVersionResource()
+	if informer, found := f.informers[gvr]; found {
+		return &sharedInformer{informer: informer, resource: gvr.GroupResource()}, nil
+	}
+	client, err := f.client.Resource(obj)
+	if err != nil {
+		return nil, err
+	}
+	var c ResourceInterface = client
+	if f.namespace != metav1.NamespaceAll {
+		c = client.Namespace(f.namespace)
+	}
+	informer := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+				return c.List(context.TODO(), opts)
+			},
+			WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+				return c.Watch(context.TODO(), opts)
+			},
+		},
+		obj.New(),
+		f.resync,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+	)
+	f.informers[gvr] = informer
+	return &sharedInformer{informer: informer, resource: gvr.GroupResource()}, nil
+}
+
+// Start starts the informers which have not been started.
+func (f *SharedInformerFactory) Start(stopCh <-chan struct{}) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for gvr, informer := range f.informers {
+		if !f.started[gvr] {
+			go informer.Run(stopCh)
+			f.started[gvr] = true
+		}
+	}
+}
+
+// WaitForCacheSync waits for the caches of the started informers to be synced, returning whether each cache
+// was synced before stopCh was closed.
+func (f *SharedInformerFactory) WaitForCacheSync(stopCh <-chan struct{}) map[schema.GroupVersionResource]bool {
+	informers := func() map[schema.GroupVersionResource]cache.SharedIndexInformer {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		informers := map[schema.GroupVersionResource]cache.SharedIndexInformer{}
+		for gvr, informer := range f.informers {
+			if f.started[gvr] {
+				informers[gvr] = informer
+			}
+		}
+		return informers
+	}()
+
+	result := map[schema.GroupVersionResource]bool{}
+	for gvr, informer := range informers {
+		result[gvr] = cache.WaitForCacheSync(stopCh, informer.HasSynced)
+	}
+	return result
+}
+
+type sharedInformer struct {
+	informer cache.SharedIndexInformer
+	resource schema.GroupResource
+}
+
+func (i *sharedInformer) Informer() cache.SharedIndexInformer {
+	return i.informer
+}
+
+func (i *sharedInformer) Lister() Lister {
+	return &lister{lister: cache.NewGenericLister(i.informer.GetIndexer(), i.resource)}
+}
+
+type lister struct {
+	lister cache.GenericLister
+}
+
+func (l *lister) List(selector labels.Selector) ([]resource.Object, error) {
+	return objects(l.lister.List(selector))
+}
+
+func (l *lister) Get(name string) (resource.Object, error) {
+	return object(l.lister.Get(name))
+}
+
+func (l *lister) Namespace(namespace string) NamespaceLister {
+	return &namespaceLister{lister: l.lister.ByNamespace(namespace)}
+}
+
+type namespaceLister struct {
+	lister cache.GenericNamespaceLister
+}
+
+func (l *namespaceLister) List(selector labels.Selector) ([]resource.Object, error) {
+	return objects(l.lister.List(selector))
+}
+
+func (l *namespaceLister) Get(name string) (resource.Object, error) {
+	return object(l.lister.Get(name))
+}
+
+func object(obj runtime.Object, err error) (resource.Object, error) {
+	if err != nil {
+		return nil, err
+	}
+	return obj.(resource.Object), nil
+}
+
+func objects(objs []runtime.Object, err error) ([]resource.Object, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := make([]resource.Object, 0, len(objs))
+	for i := range objs {
+		result = append(result, objs[i].(resource.Object))
+	}
+	return result, nil
+}
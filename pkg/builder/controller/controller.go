@@ -0,0 +1,148 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package controller runs reconcilers for the resources served by an apiserver in the apiserver process.
+//
+// Each Controller watches a resource through a shared informer and calls its ReconcileFunc with the namespace
+// and name of every object which is added, updated or deleted.  Failed reconciles are retried with a rate
+// limited backoff.  The controllers of a Manager are started by a post-start hook of the apiserver using the
+// loopback client config, optionally after acquiring a Lease so only one replica of the apiserver reconciles.
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// Request is a request to reconcile an object.
+type Request struct {
+	// NamespacedName is the namespace and name of the object.  The object may have been deleted.
+	types.NamespacedName
+	// Client is a client for the apiserver.
+	Client dynamic.Interface
+	// Lister lists the objects of the resource from the informer cache.
+	Lister cache.GenericLister
+}
+
+// ReconcileFunc reconciles the object in the Request.  Requests which return an error are retried with a
+// rate limited backoff.
+type ReconcileFunc func(ctx context.Context, req Request) error
+
+// Controller reconciles the objects of a resource.
+type Controller struct {
+	// Name is the name of the controller, used to name its workqueue and in logs.
+	Name string
+	// GroupVersionResource is the resource reconciled by the controller.
+	GroupVersionResource schema.GroupVersionResource
+	// Reconcile is called for each object of the resource which is added, updated or deleted.
+	Reconcile ReconcileFunc
+	// Workers is the number of requests reconciled concurrently.  Defaults to 1.
+	Workers int
+}
+
+var _ Runnable = &Controller{}
+
+// Start implements Runnable
+func (c *Controller) Start(client dynamic.Interface, informers dynamicinformer.DynamicSharedInformerFactory) func(
+	ctx context.Context) {
+	informer := informers.ForResource(c.GroupVersionResource)
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), c.Name)
+	enqueue := func(obj interface{}) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			utilruntime.HandleError(err)
+			return
+		}
+		queue.Add(key)
+	}
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+		DeleteFunc: enqueue,
+	})
+
+	return func(ctx context.Context) {
+		defer utilruntime.HandleCrash()
+		defer queue.ShutDown()
+
+		klog.Infof("Starting controller %s", c.Name)
+		defer klog.Infof("Shutting down controller %s", c.Name)
+		if !cache.WaitForNamedCacheSync(c.Name, ctx.Done(), informer.Informer().HasSynced) {
+			return
+		}
+
+		workers := c.Workers
+		if workers < 1 {
+			workers = 1
+		}
+		wg := sync.WaitGroup{}
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				wait.UntilWithContext(ctx, func(ctx context.Context) {
+					for c.processNext(ctx, queue, client, informer.Lister()) {
+					}
+				}, time.Second)
+			}()
+		}
+		<-ctx.Done()
+		// stop the workers waiting for requests, then wait for the requests being reconciled
+		queue.ShutDown()
+		wg.Wait()
+	}
+}
+
+// processNext reconciles the next request in the queue.  processNext returns false when the queue is shut down.
+func (c *Controller) processNext(ctx context.Context, queue workqueue.RateLimitingInterface,
+	client dynamic.Interface, lister cache.GenericLister) bool {
+	key, shutdown := queue.Get()
+	if shutdown {
+		return false
+	}
+	defer queue.Done(key)
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key.(string))
+	if err != nil {
+		utilruntime.HandleError(err)
+		queue.Forget(key)
+		return true
+	}
+	req := Request{
+		NamespacedName: types.NamespacedName{Namespace: namespace, Name: name},
+		Client:         client,
+		Lister:         lister,
+	}
+	if err := c.Reconcile(ctx, req); err != nil {
+		utilruntime.HandleError(fmt.Errorf("controller %s failed to reconcile %s: %v", c.Name, key, err))
+		queue.AddRateLimited(key)
+		return true
+	}
+	queue.Forget(key)
+	return true
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+var flunders = schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "flunders"}
+
+func newFlunder(namespace, name string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion("example.com/v1")
+	u.SetKind("Flunder")
+	u.SetNamespace(namespace)
+	u.SetName(name)
+	return u
+}
+
+// recorder records the reconciled requests, failing the first request for each object.
+type recorder struct {
+	sync.Mutex
+	attempts map[string]int
+}
+
+func (r *recorder) reconcile(ctx context.Context, req Request) error {
+	r.Lock()
+	defer r.Unlock()
+	r.attempts[req.String()]++
+	if r.attempts[req.String()] == 1 {
+		return fmt.Errorf("first attempt")
+	}
+	if _, err := req.Lister.ByNamespace(req.Namespace).Get(req.Name); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *recorder) count(key string) int {
+	r.Lock()
+	defer r.Unlock()
+	return r.attempts[key]
+}
+
+func TestManager(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newFlunder("default", "one"))
+	r := &recorder{attempts: map[string]int{}}
+	m := NewManager("example-controllers")
+	m.Controllers = append(m.Controllers, &Controller{
+		Name: "flunders", GroupVersionResource: flunders, Reconcile: r.reconcile, Workers: 2})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		m.run(ctx, client)
+		close(stopped)
+	}()
+
+	// failed requests are retried
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return r.count("default/one") == 2, nil
+	}); err != nil {
+		t.Fatalf("expected default/one to be reconciled twice, got %d", r.count("default/one"))
+	}
+
+	// new objects are reconciled
+	if _, err := client.Resource(flunders).Namespace("default").Create(
+		ctx, newFlunder("default", "two"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return r.count("default/two") == 2, nil
+	}); err != nil {
+		t.Fatalf("expected default/two to be reconciled twice, got %d", r.count("default/two"))
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the controllers to stop")
+	}
+}
+
+// lease is a resourcelock.Interface which can be made unavailable.
+type lease struct {
+	sync.Mutex
+	record      *resourcelock.LeaderElectionRecord
+	unavailable bool
+}
+
+func (l *lease) Get(context.Context) (*resourcelock.LeaderElectionRecord, []byte, error) {
+	l.Lock()
+	defer l.Unlock()
+	if l.unavailable {
+		return nil, nil, fmt.Errorf("unavailable")
+	}
+	if l.record == nil {
+		return nil, nil, apierrors.NewNotFound(schema.GroupResource{Resource: "leases"}, "test")
+	}
+	raw, err := json.Marshal(l.record)
+	r := *l.record
+	return &r, raw, err
+}
+
+func (l *lease) Create(ctx context.Context, r resourcelock.LeaderElectionRecord) error {
+	return l.Update(ctx, r)
+}
+
+func (l *lease) Update(_ context.Context, r resourcelock.LeaderElectionRecord) error {
+	l.Lock()
+	defer l.Unlock()
+	if l.unavailable {
+		return fmt.Errorf("unavailable")
+	}
+	l.record = &r
+	return nil
+}
+
+func (l *lease) RecordEvent(string) {}
+func (l *lease) Identity() string   { return "test" }
+func (l *lease) Describe() string   { return "test" }
+
+func (l *lease) setUnavailable(unavailable bool) {
+	l.Lock()
+	defer l.Unlock()
+	l.unavailable = unavailable
+}
+
+func TestLead(t *testing.T) {
+	m := NewManager("example-controllers")
+	m.LeaderElection.LeaseDuration.Duration = 400 * time.Millisecond
+	m.LeaderElection.RenewDeadline.Duration = 200 * time.Millisecond
+	m.LeaderElection.RetryPeriod.Duration = 50 * time.Millisecond
+	l := &lease{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := make(chan context.Context, 2)
+	stopped := make(chan error)
+	go func() {
+		stopped <- m.lead(ctx, l, func(ctx context.Context) {
+			runs <- ctx
+			<-ctx.Done()
+		})
+	}()
+	next := func() context.Context {
+		select {
+		case ctx := <-runs:
+			return ctx
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the controllers to be run")
+			return nil
+		}
+	}
+
+	// the controllers are stopped when the lease is lost, and run again when it is reacquired
+	first := next()
+	l.setUnavailable(true)
+	select {
+	case <-first.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the controllers to be stopped when the lease is lost")
+	}
+	l.setUnavailable(false)
+	next()
+
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected lead to return")
+	}
+}
+
+func TestAddFlags(t *testing.T) {
+	m := NewManager("example-controllers")
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	m.AddFlags(fs)
+	if err := fs.Parse([]string{"--leader-elect", "--leader-elect-resource-namespace=apiservers"}); err != nil {
+		t.Fatal(err)
+	}
+	if !m.LeaderElection.LeaderElect || m.LeaderElection.ResourceNamespace != "apiservers" {
+		t.Errorf("expected leader election in apiservers, got %+v", m.LeaderElection)
+	}
+	if m.LeaderElection.ResourceName != "example-controllers" || m.LeaderElection.ResourceLock != "leases" {
+		t.Errorf("expected the example-controllers lease, got %+v", m.LeaderElection)
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	componentbaseconfig "k8s.io/component-base/config"
+	"k8s.io/component-base/config/options"
+	"k8s.io/klog/v2"
+)
+
+// Runnable is run by a Manager using the clients and informers shared by its Controllers -- e.g. a garbage
+// collector watching every resource.
+type Runnable interface {
+	// Start registers event handlers with the informers and returns a function to run until ctx is done.  The
+	// informers are started after Start is called.
+	Start(client dynamic.Interface, informers dynamicinformer.DynamicSharedInformerFactory) func(ctx context.Context)
+}
+
+// Manager runs Controllers sharing a set of informers.
+type Manager struct {
+	// Controllers are the controllers run by the Manager.
+	Controllers []*Controller
+	// Runnables are run by the Manager in addition to the Controllers.
+	Runnables []Runnable
+	// LeaderElection configures the election of the replica which runs the controllers.  Leader election is
+	// disabled by default, and should be enabled when the apiserver is replicated.
+	LeaderElection componentbaseconfig.LeaderElectionConfiguration
+	// LeaseConfig returns the config of the client for the apiserver storing the Lease -- e.g. the
+	// kube-apiserver.  The Leases are not served by the apiserver running the controllers, so the loopback
+	// config cannot be used.  Defaults to the in-cluster config.
+	LeaseConfig func() (*rest.Config, error)
+}
+
+// NewManager returns a new Manager with the default leader election configuration.  name is used as the name of
+// the Lease.
+func NewManager(name string) *Manager {
+	return &Manager{
+		LeaderElection: componentbaseconfig.LeaderElectionConfiguration{
+			LeaseDuration:     metav1.Duration{Duration: 15 * time.Second},
+			RenewDeadline:     metav1.Duration{Duration: 10 * time.Second},
+			RetryPeriod:       metav1.Duration{Duration: 2 * time.Second},
+			ResourceLock:      resourcelock.LeasesResourceLock,
+			ResourceName:      name,
+			ResourceNamespace: metav1.NamespaceSystem,
+		},
+		LeaseConfig: rest.InClusterConfig,
+	}
+}
+
+// AddFlags adds the leader election flags -- e.g. --leader-elect.
+func (m *Manager) AddFlags(fs *pflag.FlagSet) {
+	options.BindLeaderElectionFlags(&m.LeaderElection, fs)
+}
+
+// PostStartHook returns an apiserver post-start hook which runs the controllers until the apiserver is stopped.
+func (m *Manager) PostStartHook() genericapiserver.PostStartHookFunc {
+	return func(hookContext genericapiserver.PostStartHookContext) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-hookContext.StopCh
+			cancel()
+		}()
+		go func() {
+			if err := m.Run(ctx, hookContext.LoopbackClientConfig); err != nil {
+				klog.Errorf("unable to run controllers: %v", err)
+			}
+		}()
+		return nil
+	}
+}
+
+// Run runs the controllers using clients created from config until ctx is done.  If leader election is enabled,
+// Run runs the controllers only while the Lease is held.  The controllers are stopped if the Lease is lost, and
+// are run again when it is reacquired.
+func (m *Manager) Run(ctx context.Context, config *rest.Config) error {
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	if !m.LeaderElection.LeaderElect {
+		m.run(ctx, client)
+		return nil
+	}
+
+	lock, err := m.newLock()
+	if err != nil {
+		return err
+	}
+	return m.lead(ctx, lock, func(ctx context.Context) { m.run(ctx, client) })
+}
+
+// lead calls run while lock is held, until ctx is done.  If the Lease is lost, the context of run is cancelled,
+// and the Lease is campaigned for again once run has returned -- e.g. once the controllers have drained their
+// workqueues -- so run is never called concurrently.
+func (m *Manager) lead(ctx context.Context, lock resourcelock.Interface, run func(ctx context.Context)) error {
+	for {
+		stopped := make(chan struct{})
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   m.LeaderElection.LeaseDuration.Duration,
+			RenewDeadline:   m.LeaderElection.RenewDeadline.Duration,
+			RetryPeriod:     m.LeaderElection.RetryPeriod.Duration,
+			ReleaseOnCancel: true,
+			Name:            m.LeaderElection.ResourceName,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					defer close(stopped)
+					run(ctx)
+				},
+				OnStoppedLeading: func() {
+					if ctx.Err() == nil {
+						klog.Warningf("lost the %s lease, stopping the controllers", m.LeaderElection.ResourceName)
+					}
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		elector.Run(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		// the elector only returns before ctx is done after acquiring and then losing the Lease, so run was called
+		<-stopped
+		klog.Infof("stopped the controllers, campaigning for the %s lease", m.LeaderElection.ResourceName)
+	}
+}
+
+// newLock returns the lock acquired by the leader.
+func (m *Manager) newLock() (resourcelock.Interface, error) {
+	config, err := m.LeaseConfig()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the leader election client: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(rest.AddUserAgent(config, "leader-election"))
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return resourcelock.New(
+		m.LeaderElection.ResourceLock,
+		m.LeaderElection.ResourceNamespace,
+		m.LeaderElection.ResourceName,
+		client.CoreV1(),
+		client.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: hostname + "_" + string(uuid.NewUUID())})
+}
+
+// run runs the controllers until ctx is done, and waits for them to stop.
+func (m *Manager) run(ctx context.Context, client dynamic.Interface) {
+	informers := dynamicinformer.NewDynamicSharedInformerFactory(client, 0)
+	var runs []func(context.Context)
+	for _, c := range m.Controllers {
+		runs = append(runs, c.Start(client, informers))
+	}
+	for _, r := range m.Runnables {
+		runs = append(runs, r.Start(client, informers))
+	}
+	informers.Start(ctx.Done())
+
+	wg := sync.WaitGroup{}
+	for i := range runs {
+		wg.Add(1)
+		go func(run func(context.Context)) {
+			defer wg.Done()
+			run(ctx)
+		}(runs[i])
+	}
+	wg.Wait()
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"testing"
+
+	"github.com/go-openapi/spec"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/openapi"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Knob is defaulted from its tags before its Default function is called.
+type Knob struct {
+	Widget
+	Setting string `json:"setting,omitempty" default:"low"`
+}
+
+func (k *Knob) New() runtime.Object   { return &Knob{} }
+func (k *Knob) DefaultFromTags() bool { return true }
+func (k *Knob) Default()              { k.Setting += "-defaulted" }
+func (k *Knob) DeepCopyObject() runtime.Object {
+	c := *k
+	k.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	return &c
+}
+func (k *Knob) GetGroupVersionResource() schema.GroupVersionResource {
+	return testGroupVersion.WithResource("knobs")
+}
+
+func TestTagDefaults(t *testing.T) {
+	a := newTestServer().WithResource(&Knob{})
+	scheme, err := a.NewScheme()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &Knob{}
+	scheme.Default(k)
+	if k.Setting != "low-defaulted" {
+		t.Errorf("expected the tag default to be applied before Default, got %q", k.Setting)
+	}
+
+	defs := a.allOpenAPIDefinitions()(func(path string) spec.Ref { return spec.MustCreateRef(path) })
+	p := defs[openapi.DefinitionName(&Knob{})].Schema.Properties["setting"]
+	if p.Default != "low" {
+		t.Errorf("expected the default to be published, got %v", p.Default)
+	}
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-openapi/spec"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcestrategy"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// StorageKind describes how requests for a resource are handled.
+type StorageKind string
+
+const (
+	// EtcdStorage resources are stored in etcd using a Strategy.
+	EtcdStorage StorageKind = "etcd"
+	// HandlerStorage resources are handled by a ResourceHandlerProvider.
+	HandlerStorage StorageKind = "handler"
+	// StaticStorage resources are handled by the resource object itself.
+	StaticStorage StorageKind = "static"
+)
+
+// ResourceDescription describes a resource or subresource version registered with the Server.
+type ResourceDescription struct {
+	// GroupVersionResource is the registered resource or subresource.
+	GroupVersionResource schema.GroupVersionResource
+	// StorageKind is how requests for the resource are handled.
+	StorageKind StorageKind
+	// StorageVersion is the version the resource is stored as.
+	StorageVersion schema.GroupVersion
+	// Strategy lists the resourcestrategy interfaces implemented by the object.
+	Strategy []string
+	// SubResources lists the subresources registered for the resource in this version.
+	SubResources []string
+	// OpenAPI is true if an OpenAPI definition generated by openapi-gen is registered for the object.  Objects
+	// without one are published using a definition derived from their go type.
+	OpenAPI bool
+	// Deprecated is true if the version is deprecated by implementing resource.Deprecated.
+	Deprecated bool
+}
+
+// strategyInterfaces are the resourcestrategy interfaces reported by Describe.
+var strategyInterfaces = []struct {
+	name string
+	typ  reflect.Type
+}{
+	{"AllowCreateOnUpdater", reflect.TypeOf((*resourcestrategy.AllowCreateOnUpdater)(nil)).Elem()},
+	{"AllowUnconditionalUpdater", reflect.TypeOf((*resourcestrategy.AllowUnconditionalUpdater)(nil)).Elem()},
+	{"CELRulesProvider", reflect.TypeOf((*resourcestrategy.CELRulesProvider)(nil)).Elem()},
+	{"Canonicalizer", reflect.TypeOf((*resourcestrategy.Canonicalizer)(nil)).Elem()},
+	{"Converter", reflect.TypeOf((*resourcestrategy.Converter)(nil)).Elem()},
+	{"Defaulter", reflect.TypeOf((*resourcestrategy.Defaulter)(nil)).Elem()},
+	{"FieldAuthorizer", reflect.TypeOf((*resourcestrategy.FieldAuthorizer)(nil)).Elem()},
+	{"ImmutableFieldsProvider", reflect.TypeOf((*resourcestrategy.ImmutableFieldsProvider)(nil)).Elem()},
+	{"PrepareForCreater", reflect.TypeOf((*resourcestrategy.PrepareForCreater)(nil)).Elem()},
+	{"PrepareForUpdater", reflect.TypeOf((*resourcestrategy.PrepareForUpdater)(nil)).Elem()},
+	{"TableConverter", reflect.TypeOf((*resourcestrategy.TableConverter)(nil)).Elem()},
+	{"TagDefaulter", reflect.TypeOf((*resourcestrategy.TagDefaulter)(nil)).Elem()},
+	{"Validater", reflect.TypeOf((*resourcestrategy.Validater)(nil)).Elem()},
+	{"ValidateUpdater", reflect.TypeOf((*resourcestrategy.ValidateUpdater)(nil)).Elem()},
+}
+
+// Describe returns a description of every resource and subresource version registered with the Server,
+// sorted by GroupVersionResource.
+func (a *Server) Describe() []ResourceDescription {
+	definitions := a.openAPIDefinitionNames()
+
+	subresources := map[schema.GroupVersionResource][]string{}
+	for _, r := range a.registrations {
+		if !r.isSubResource() {
+			continue
+		}
+		parts := strings.SplitN(r.gvr.Resource, "/", 2)
+		parent := r.gvr.GroupVersion().WithResource(parts[0])
+		subresources[parent] = append(subresources[parent], parts[1])
+	}
+
+	var descriptions []ResourceDescription
+	for _, r := range a.registrations {
+		if r.dynamic != nil {
+			// the schemas of unstructured resources are always published
+			descriptions = append(descriptions, ResourceDescription{
+				GroupVersionResource: r.gvr,
+				StorageKind:          r.kind,
+				StorageVersion:       a.storageVersion(r.gvr.GroupResource()),
+				OpenAPI:              true,
+				Deprecated:           a.isDeprecated(r.gvr.GroupVersion()),
+			})
+			continue
+		}
+		d := ResourceDescription{
+			GroupVersionResource: r.gvr,
+			StorageKind:          r.kind,
+			StorageVersion:       a.storageVersion(r.gvr.GroupResource()),
+			SubResources:         subresources[r.gvr],
+			OpenAPI:              definitions[openAPIDefinitionName(r.obj)],
+			Deprecated:           a.isDeprecated(r.gvr.GroupVersion()),
+		}
+		t := reflect.TypeOf(r.obj)
+		for _, i := range strategyInterfaces {
+			if t.Implements(i.typ) {
+				d.Strategy = append(d.Strategy, i.name)
+			}
+		}
+		sort.Strings(d.SubResources)
+		descriptions = append(descriptions, d)
+	}
+	sort.Slice(descriptions, func(i, j int) bool {
+		return descriptions[i].GroupVersionResource.String() < descriptions[j].GroupVersionResource.String()
+	})
+	return descriptions
+}
+
+// WriteDescription writes the description of the registered resources to out as a table.
+func (a *Server) WriteDescription(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "GROUP\tVERSION\tRESOURCE\tSTORAGE\tSTORAGE VERSION\tSTRATEGY\tSUBRESOURCES\tOPENAPI\tDEPRECATED")
+	for _, d := range a.Describe() {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%t\t%t\n",
+			d.GroupVersionResource.Group, d.GroupVersionResource.Version, d.GroupVersionResource.Resource,
+			d.StorageKind, d.StorageVersion.Version, orNone(d.Strategy), orNone(d.SubResources), d.OpenAPI,
+			d.Deprecated)
+	}
+	return w.Flush()
+}
+
+// newDescribeCommand returns the 'describe-apis' command which prints the registered resources.
+func (a *Server) newDescribeCommand() *Command {
+	return &Command{
+		Use:   "describe-apis",
+		Short: "Print the resources served by the apiserver",
+		Long: "Print each resource and subresource version served by the apiserver with its storage, " +
+			"storage version, strategy interfaces, subresources and OpenAPI coverage.",
+		RunE: func(c *Command, args []string) error {
+			return a.WriteDescription(c.OutOrStdout())
+		},
+	}
+}
+
+// openAPIDefinitionNames returns the names of the registered OpenAPI definitions.
+func (a *Server) openAPIDefinitionNames() map[string]bool {
+	names := map[string]bool{}
+	if a.openAPIDefinitions == nil {
+		return names
+	}
+	defs := a.openAPIDefinitions(func(path string) spec.Ref {
+		return spec.MustCreateRef("#/definitions/" + path)
+	})
+	for name := range defs {
+		names[name] = true
+	}
+	return names
+}
+
+// openAPIDefinitionName returns the name of the OpenAPI definition generated by openapi-gen for the object.
+func openAPIDefinitionName(obj interface{}) string {
+	t := reflect.Indirect(reflect.ValueOf(obj)).Type()
+	return t.PkgPath() + "." + t.Name()
+}
+
+func orNone(values []string) string {
+	if len(values) == 0 {
+		return "<none>"
+	}
+	return strings.Join(values, ",")
+}
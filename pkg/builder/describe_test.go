@@ -0,0 +1,92 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-openapi/spec"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcestrategy"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	openapicommon "k8s.io/kube-openapi/pkg/common"
+)
+
+// StatusWidget is a Widget with a status subresource and validation
+type StatusWidget struct {
+	Widget
+}
+
+func (w *StatusWidget) CopyStatus(ctx context.Context, from runtime.Object) {}
+func (w *StatusWidget) CopySpec(ctx context.Context, from runtime.Object)   {}
+func (w *StatusWidget) Validate(ctx context.Context) field.ErrorList        { return nil }
+
+// RuledWidget is a Widget with rules for its fields.
+type RuledWidget struct {
+	Widget
+}
+
+func (w *RuledWidget) DefaultFromTags() bool                                { return true }
+func (w *RuledWidget) ImmutableFields() []resourcestrategy.ImmutableField   { return nil }
+func (w *RuledWidget) CELRules() []resourcestrategy.CELRule                 { return nil }
+func (w *RuledWidget) AuthorizedFields() []resourcestrategy.AuthorizedField { return nil }
+func (w *RuledWidget) New() runtime.Object                                  { return &RuledWidget{} }
+func (w *RuledWidget) GetGroupVersionResource() schema.GroupVersionResource {
+	return testGroupVersion.WithResource("ruledwidgets")
+}
+
+func TestDescribe(t *testing.T) {
+	a := newTestServer().
+		WithOpenAPIDefinitions("test", "v0", func(ref openapicommon.ReferenceCallback) map[string]openapicommon.OpenAPIDefinition {
+			return map[string]openapicommon.OpenAPIDefinition{
+				openAPIDefinitionName(&StatusWidget{}): {Schema: spec.Schema{}},
+			}
+		}).
+		WithResource(&StatusWidget{})
+
+	expected := []ResourceDescription{
+		{
+			GroupVersionResource: testGroupVersion.WithResource("widgets"),
+			StorageKind:          EtcdStorage,
+			StorageVersion:       testGroupVersion,
+			Strategy:             []string{"Validater"},
+			SubResources:         []string{"status"},
+			OpenAPI:              true,
+		},
+		{
+			GroupVersionResource: testGroupVersion.WithResource("widgets/status"),
+			StorageKind:          EtcdStorage,
+			StorageVersion:       testGroupVersion,
+			Strategy:             []string{"Validater"},
+			OpenAPI:              true,
+		},
+	}
+	if d := a.Describe(); !reflect.DeepEqual(d, expected) {
+		t.Errorf("expected %+v, got %+v", expected, d)
+	}
+}
+
+func TestDescribeRules(t *testing.T) {
+	d := newTestServer().WithResource(&RuledWidget{}).Describe()
+	expected := []string{"CELRulesProvider", "FieldAuthorizer", "ImmutableFieldsProvider", "TagDefaulter"}
+	if len(d) != 1 || !reflect.DeepEqual(d[0].Strategy, expected) {
+		t.Errorf("expected the strategy %v, got %+v", expected, d)
+	}
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/go-openapi/spec"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+)
+
+var (
+	widgets = schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+	gizmos  = schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "gizmos"}
+)
+
+func widgetSchema() *apiextensionsv1.JSONSchemaProps {
+	return &apiextensionsv1.JSONSchemaProps{
+		Type: "object",
+		Properties: map[string]apiextensionsv1.JSONSchemaProps{
+			"spec": {
+				Type: "object",
+				Properties: map[string]apiextensionsv1.JSONSchemaProps{
+					"size":  {Type: "integer", Minimum: float64Ptr(1), Default: &apiextensionsv1.JSON{Raw: []byte("3")}},
+					"color": {Type: "string", Enum: []apiextensionsv1.JSON{{Raw: []byte(`"red"`)}, {Raw: []byte(`"blue"`)}}},
+				},
+			},
+		},
+	}
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
+
+func newWidget(t *testing.T) *Resource {
+	r, err := NewResource(widgets, "Widget", widgetSchema(), apiextensionsv1.NamespaceScoped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestNewResourceErrors(t *testing.T) {
+	nonStructural := &apiextensionsv1.JSONSchemaProps{
+		Type:       "object",
+		Properties: map[string]apiextensionsv1.JSONSchemaProps{"spec": {}},
+	}
+	badDefault := widgetSchema()
+	badDefault.Properties["spec"].Properties["size"] = apiextensionsv1.JSONSchemaProps{
+		Type: "integer", Minimum: float64Ptr(1), Default: &apiextensionsv1.JSON{Raw: []byte("0")}}
+
+	for name, test := range map[string]struct {
+		kind   string
+		schema *apiextensionsv1.JSONSchemaProps
+		scope  apiextensionsv1.ResourceScope
+		err    string
+	}{
+		"kind":            {schema: widgetSchema(), scope: apiextensionsv1.NamespaceScoped, err: "kind must be set"},
+		"scope":           {kind: "Widget", schema: widgetSchema(), err: "scope must be"},
+		"schema":          {kind: "Widget", scope: apiextensionsv1.ClusterScoped, err: "openAPISchema must be set"},
+		"structural":      {kind: "Widget", schema: nonStructural, scope: apiextensionsv1.ClusterScoped, err: "type: Required value"},
+		"invalid default": {kind: "Widget", schema: badDefault, scope: apiextensionsv1.ClusterScoped, err: "should be greater than or equal to 1"},
+	} {
+		_, err := NewResource(widgets, test.kind, test.schema, test.scope)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected an error containing %q, got %v", name, test.err, err)
+		}
+	}
+}
+
+func TestDefaultAndValidate(t *testing.T) {
+	r := newWidget(t)
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       "Widget",
+		"metadata":   map[string]interface{}{"name": "w", "unknown": "x"},
+		"spec":       map[string]interface{}{"color": "green", "unknown": "x"},
+		"unknown":    "x",
+	}}
+	r.Default(u)
+
+	expected := map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       "Widget",
+		"metadata":   map[string]interface{}{"name": "w"},
+		"spec":       map[string]interface{}{"color": "green", "size": int64(3)},
+	}
+	if actual, _ := json.Marshal(u.Object); string(actual) != mustMarshal(t, expected) {
+		t.Errorf("expected %s, got %s", mustMarshal(t, expected), actual)
+	}
+
+	errs := r.Validate(u)
+	if len(errs) != 1 || errs[0].Field != "spec.color" {
+		t.Errorf("expected an invalid spec.color, got %v", errs)
+	}
+
+	u.SetKind("Gizmo")
+	if errs := r.Validate(u); len(errs) != 1 || errs[0].Field != "kind" {
+		t.Errorf("expected an invalid kind, got %v", errs)
+	}
+}
+
+func TestStrategy(t *testing.T) {
+	r := newWidget(t)
+	s := NewStrategy(r, runtime.NewScheme())
+	if !s.NamespaceScoped() {
+		t.Errorf("expected the strategy to be namespace scoped")
+	}
+
+	obj := r.New().(*unstructured.Unstructured)
+	obj.SetName("w")
+	obj.Object["spec"] = map[string]interface{}{"size": int64(0)}
+	s.PrepareForCreate(context.Background(), obj)
+	if obj.GetGeneration() != 1 {
+		t.Errorf("expected generation 1, got %d", obj.GetGeneration())
+	}
+	if errs := s.Validate(context.Background(), obj); len(errs) != 1 || errs[0].Field != "spec.size" {
+		t.Errorf("expected an invalid spec.size, got %v", errs)
+	}
+
+	updated := obj.DeepCopy()
+	updated.SetLabels(map[string]string{"a": "b"})
+	s.PrepareForUpdate(context.Background(), updated, obj)
+	if updated.GetGeneration() != 1 {
+		t.Errorf("expected metadata changes to keep generation 1, got %d", updated.GetGeneration())
+	}
+	updated.Object["spec"] = map[string]interface{}{"size": int64(2)}
+	s.PrepareForUpdate(context.Background(), updated, obj)
+	if updated.GetGeneration() != 2 {
+		t.Errorf("expected spec changes to increment the generation, got %d", updated.GetGeneration())
+	}
+
+	labels, fields, err := s.getAttrs(updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels["a"] != "b" || fields["metadata.name"] != "w" {
+		t.Errorf("unexpected attributes %v %v", labels, fields)
+	}
+}
+
+func TestSerialization(t *testing.T) {
+	widget := newWidget(t)
+	gizmo, err := NewResource(gizmos, "Gizmo", &apiextensionsv1.JSONSchemaProps{Type: "object"},
+		apiextensionsv1.ClusterScoped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(widget, gizmo)(scheme); err != nil {
+		t.Fatal(err)
+	}
+	metav1.AddToGroupVersion(scheme, widgets.GroupVersion())
+	codecs := serializer.NewCodecFactory(scheme)
+	ns := NegotiatedSerializer(codecs)
+	info, _ := runtime.SerializerInfoForMediaType(ns.SupportedMediaTypes(), runtime.ContentTypeJSON)
+
+	// requests are pruned and defaulted when decoded
+	decoder := ns.DecoderToVersion(info.Serializer, widgets.GroupVersion())
+	obj, _, err := decoder.Decode(
+		[]byte(`{"apiVersion":"example.com/v1","kind":"Widget","metadata":{"name":"w"},"spec":{"x":1}}`),
+		nil, widget.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec := obj.(*unstructured.Unstructured).Object["spec"]; mustMarshal(t, spec) != `{"size":3}` {
+		t.Errorf("expected the spec to be pruned and defaulted, got %v", spec)
+	}
+
+	// lists are encoded as they are, even though multiple kinds share the unstructured list type
+	list := widget.NewList().(*unstructured.UnstructuredList)
+	list.Items = append(list.Items, *obj.(*unstructured.Unstructured))
+	b := &bytes.Buffer{}
+	if err := ns.EncoderForVersion(info.Serializer, widgets.GroupVersion()).Encode(list, b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"kind":"WidgetList"`) || !strings.Contains(b.String(), `"size":3`) {
+		t.Errorf("unexpected encoding %s", b.String())
+	}
+}
+
+func TestPostProcessSpec(t *testing.T) {
+	r := newWidget(t)
+	path := "/apis/example.com/v1/namespaces/{namespace}/widgets/{name}"
+	ref := spec.MustCreateRef("#/definitions/" + friendlyName(unstructuredName))
+	s := &spec.Swagger{SwaggerProps: spec.SwaggerProps{
+		Paths: &spec.Paths{Paths: map[string]spec.PathItem{
+			path: {PathItemProps: spec.PathItemProps{Get: &spec.Operation{OperationProps: spec.OperationProps{
+				Responses: &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+					200: {ResponseProps: spec.ResponseProps{Schema: &spec.Schema{SchemaProps: spec.SchemaProps{Ref: ref}}}},
+				}}},
+			}}}},
+		}},
+		Definitions: spec.Definitions{
+			friendlyName(unstructuredName): {VendorExtensible: spec.VendorExtensible{Extensions: spec.Extensions{
+				gvkExtension: []interface{}{map[string]interface{}{"kind": "Widget"}}}}},
+		},
+	}}
+
+	s, err := PostProcessSpec(r)(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, found := s.Definitions["com.example.v1.Widget"]
+	if !found {
+		t.Fatalf("expected a definition for the Widget")
+	}
+	if _, found := def.Properties["spec"].Properties["size"]; !found {
+		t.Errorf("expected the definition to contain the schema, got %v", def)
+	}
+	if _, found := s.Definitions["com.example.v1.WidgetList"]; !found {
+		t.Errorf("expected a definition for the WidgetList")
+	}
+	if _, found := s.Definitions[friendlyName(unstructuredName)].Extensions[gvkExtension]; found {
+		t.Errorf("expected the unstructured definition not to claim the Widget kind")
+	}
+	response := s.Paths.Paths[path].Get.Responses.StatusCodeResponses[200]
+	if actual := response.Schema.Ref.String(); actual != "#/definitions/com.example.v1.Widget" {
+		t.Errorf("expected the path to reference the Widget definition, got %s", actual)
+	}
+	if len(s.Paths.Paths) != 1 {
+		t.Errorf("expected only the existing paths to be replaced, got %d paths", len(s.Paths.Paths))
+	}
+}
+
+func mustMarshal(t *testing.T, obj interface{}) string {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
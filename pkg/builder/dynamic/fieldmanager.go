@@ -0,0 +1,228 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamic
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/endpoints/handlers"
+	"k8s.io/apiserver/pkg/endpoints/handlers/fieldmanager"
+	"k8s.io/apiserver/pkg/endpoints/handlers/responsewriters"
+	"k8s.io/apiserver/pkg/endpoints/metrics"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/features"
+	"k8s.io/apiserver/pkg/registry/rest"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+)
+
+// StorageFunc returns the storage serving a resource.
+type StorageFunc func(gvr schema.GroupVersionResource) (rest.Storage, error)
+
+// WithFieldManagers returns a handler which serves the create, update and patch requests of the resources with
+// field managers which track the managed fields of unstructured objects, and serves every other request with
+// handler.  Server-side apply requests are merged using the schemas of the resources.
+//
+// The field managers of the routes installed by the apiserver convert objects to the internal version of their
+// group through the scheme, which cannot convert unstructured objects because every dynamic kind is registered
+// with the same go type.  As for CustomResourceDefinitions, the field managers of the resources only convert
+// objects to the version of their resource.
+func WithFieldManagers(handler http.Handler, c *genericapiserver.Config, scheme *runtime.Scheme,
+	serializer runtime.NegotiatedSerializer, storage StorageFunc, resources ...*Resource) http.Handler {
+	if !utilfeature.DefaultFeatureGate.Enabled(features.ServerSideApply) {
+		return handler
+	}
+	writers := map[schema.GroupVersionResource]*writer{}
+	for _, r := range resources {
+		writers[r.gvr] = &writer{resource: r, config: c, scheme: scheme, serializer: serializer, storage: storage}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		info, ok := request.RequestInfoFrom(req.Context())
+		if !ok || !info.IsResourceRequest || info.Subresource != "" {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		wr, found := writers[schema.GroupVersionResource{
+			Group: info.APIGroup, Version: info.APIVersion, Resource: info.Resource}]
+		if !found {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		serve := wr.handlerFor(info.Verb)
+		if serve == nil {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		if err := wr.init(); err != nil {
+			responsewriters.InternalError(w, req, err)
+			return
+		}
+		metrics.InstrumentHandlerFunc(strings.ToUpper(info.Verb), info.APIGroup, info.APIVersion, info.Resource,
+			"", metrics.CleanScope(info), metrics.APIServerComponent, false, "", serve).ServeHTTP(w, req)
+	})
+}
+
+// writer serves the requests which write the objects of a Resource.  The request scope is created for the first
+// request, after the storage of the resource has been installed.
+type writer struct {
+	resource   *Resource
+	config     *genericapiserver.Config
+	scheme     *runtime.Scheme
+	serializer runtime.NegotiatedSerializer
+	storage    StorageFunc
+
+	once  sync.Once
+	err   error
+	store rest.Storage
+	scope *handlers.RequestScope
+}
+
+// handlerFor returns the handler for the verb, or nil if the request is served by the apiserver.
+func (w *writer) handlerFor(verb string) http.HandlerFunc {
+	switch verb {
+	case "create":
+		return func(rw http.ResponseWriter, req *http.Request) {
+			handlers.CreateResource(w.store.(rest.Creater), w.scope, w.config.AdmissionControl)(rw, req)
+		}
+	case "update":
+		return func(rw http.ResponseWriter, req *http.Request) {
+			handlers.UpdateResource(w.store.(rest.Updater), w.scope, w.config.AdmissionControl)(rw, req)
+		}
+	case "patch":
+		// strategic merge patches are not supported for unstructured objects
+		patchTypes := []string{string(types.JSONPatchType), string(types.MergePatchType), string(types.ApplyPatchType)}
+		return func(rw http.ResponseWriter, req *http.Request) {
+			handlers.PatchResource(w.store.(rest.Patcher), w.scope, w.config.AdmissionControl, patchTypes)(rw, req)
+		}
+	}
+	return nil
+}
+
+// init creates the request scope of the resource.
+func (w *writer) init() error {
+	w.once.Do(func() {
+		w.err = w.newScope()
+	})
+	return w.err
+}
+
+func (w *writer) newScope() error {
+	r := w.resource
+	store, err := w.storage(r.gvr)
+	if err != nil {
+		return err
+	}
+	tableConvertor, ok := store.(rest.TableConvertor)
+	if !ok {
+		return fmt.Errorf("storage of %v is not a rest.TableConvertor", r.gvr)
+	}
+	w.store = store
+
+	prefix := "/" + path.Join("apis", r.gvr.Group, r.gvr.Version) + "/" + r.gvr.Resource + "/"
+	if r.NamespaceScoped() {
+		prefix = "/" + path.Join("apis", r.gvr.Group, r.gvr.Version, "namespaces") + "/"
+	}
+	scope := &handlers.RequestScope{
+		Namer: handlers.ContextBasedNaming{
+			SelfLinker:         meta.NewAccessor(),
+			ClusterScoped:      !r.NamespaceScoped(),
+			SelfLinkPathPrefix: prefix,
+		},
+		Serializer:               w.serializer,
+		ParameterCodec:           metav1.ParameterCodec,
+		Creater:                  creater{},
+		Convertor:                converter{kind: r.GroupVersionKind()},
+		Defaulter:                w.scheme,
+		Typer:                    w.scheme,
+		UnsafeConvertor:          converter{kind: r.GroupVersionKind()},
+		Authorizer:               w.config.Authorization.Authorizer,
+		EquivalentResourceMapper: w.config.EquivalentResourceRegistry,
+		TableConvertor:           tableConvertor,
+		Resource:                 r.gvr,
+		Kind:                     r.GroupVersionKind(),
+		MetaGroupVersion:         metav1.SchemeGroupVersion,
+		// the objects are only served in the version of the resource, which is used as the in-memory version
+		HubGroupVersion:     r.gvr.GroupVersion(),
+		MaxRequestBodyBytes: w.config.MaxRequestBodyBytes,
+	}
+	models, err := r.models()
+	if err != nil {
+		return fmt.Errorf("unable to build the OpenAPI models for %v: %v", r.gvr, err)
+	}
+	scope.FieldManager, err = fieldmanager.NewDefaultCRDFieldManager(
+		models, scope.Convertor, scope.Defaulter, scope.Creater, scope.Kind, scope.HubGroupVersion, false)
+	if err != nil {
+		return err
+	}
+	w.scope = scope
+	return nil
+}
+
+// creater creates unstructured objects of a kind.
+type creater struct{}
+
+// New implements runtime.ObjectCreater
+func (creater) New(kind schema.GroupVersionKind) (runtime.Object, error) {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(kind)
+	return u, nil
+}
+
+// converter converts the unstructured objects of a kind, which are only served in a single version, by checking
+// their kind rather than finding their kind from their go type as the scheme does.
+type converter struct {
+	kind schema.GroupVersionKind
+}
+
+// Convert implements runtime.ObjectConvertor
+func (c converter) Convert(in, out, context interface{}) error {
+	uIn, okIn := in.(runtime.Unstructured)
+	uOut, okOut := out.(runtime.Unstructured)
+	if !okIn || !okOut {
+		return fmt.Errorf("unable to convert %T to %T, only unstructured objects are supported", in, out)
+	}
+	uOut.SetUnstructuredContent(runtime.DeepCopyJSON(uIn.UnstructuredContent()))
+	return nil
+}
+
+// ConvertToVersion implements runtime.ObjectConvertor
+func (c converter) ConvertToVersion(in runtime.Object, target runtime.GroupVersioner) (runtime.Object, error) {
+	kind := in.GetObjectKind().GroupVersionKind()
+	if kind != c.kind {
+		return nil, fmt.Errorf("unable to convert %v, only %v is supported", kind, c.kind)
+	}
+	if gvk, ok := target.KindForGroupVersionKinds([]schema.GroupVersionKind{kind}); !ok || gvk != kind {
+		return nil, fmt.Errorf("unable to convert %v to %v, %v is only served as %v", kind, target, c.kind.GroupKind(),
+			c.kind.GroupVersion())
+	}
+	return in.DeepCopyObject(), nil
+}
+
+// ConvertFieldLabel implements runtime.ObjectConvertor
+func (c converter) ConvertFieldLabel(_ schema.GroupVersionKind, label, value string) (string, string, error) {
+	return runtime.DefaultMetaV1FieldSelectorConversion(label, value)
+}
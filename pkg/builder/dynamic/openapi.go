@@ -0,0 +1,183 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-openapi/spec"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/openapi"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	crdopenapi "k8s.io/apiextensions-apiserver/pkg/controller/openapi/builder"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilopenapi "k8s.io/apiserver/pkg/util/openapi"
+	"k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/util/proto"
+)
+
+// gvkExtension is the OpenAPI extension listing the GroupVersionKinds of a definition.
+const gvkExtension = "x-kubernetes-group-version-kind"
+
+var (
+	unstructuredName     = openapi.DefinitionName(&unstructured.Unstructured{})
+	unstructuredListName = openapi.DefinitionName(&unstructured.UnstructuredList{})
+)
+
+// Definitions returns the OpenAPI definitions of the unstructured.Unstructured and unstructured.UnstructuredList
+// types which the routes of the dynamic resources are registered with.  PostProcessSpec replaces the references
+// to these definitions with the definitions derived from the schemas of the resources.
+//
+// The definitions depend on the ObjectMeta and ListMeta definitions, so that the spec built for the routes
+// defines the metadata referenced by the definitions of the resources even when no other resource is served.
+func Definitions(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+	objectMeta := openapi.DefinitionName(&metav1.ObjectMeta{})
+	listMeta := openapi.DefinitionName(&metav1.ListMeta{})
+	object := spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"object"}}}
+	return map[string]common.OpenAPIDefinition{
+		unstructuredName: {
+			Schema: spec.Schema{SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"metadata": {SchemaProps: spec.SchemaProps{Ref: ref(objectMeta)}},
+				},
+			}},
+			Dependencies: []string{objectMeta},
+		},
+		unstructuredListName: {
+			Schema: spec.Schema{SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"metadata": {SchemaProps: spec.SchemaProps{Ref: ref(listMeta)}},
+					"items": {SchemaProps: spec.SchemaProps{
+						Type:  []string{"array"},
+						Items: &spec.SchemaOrArray{Schema: &object},
+					}},
+				},
+			}},
+			Dependencies: []string{listMeta},
+		},
+	}
+}
+
+// PostProcessSpec returns a function to add the definitions derived from the schemas of the resources to an
+// OpenAPI spec and use them for the paths of the resources.  The function may be used as the PostProcessSpec of
+// the OpenAPI config, where it is applied both to the published spec and to the spec used for server-side apply.
+func PostProcessSpec(resources ...*Resource) func(*spec.Swagger) (*spec.Swagger, error) {
+	return func(s *spec.Swagger) (*spec.Swagger, error) {
+		if s.Definitions == nil {
+			s.Definitions = spec.Definitions{}
+		}
+		for _, r := range resources {
+			crd, err := r.swagger(crdopenapi.Options{V2: true, StripDefaults: true})
+			if err != nil {
+				return nil, fmt.Errorf("unable to build the OpenAPI spec for %v: %v", r.gvr, err)
+			}
+			for name, def := range crd.Definitions {
+				if _, found := s.Definitions[name]; !found {
+					s.Definitions[name] = def
+				}
+			}
+			if s.Paths == nil || crd.Paths == nil {
+				continue
+			}
+			for path, item := range crd.Paths.Paths {
+				if _, found := s.Paths.Paths[path]; found {
+					s.Paths.Paths[path] = item
+				}
+			}
+		}
+
+		// each dynamic kind is registered with the unstructured types, so their definitions would otherwise claim
+		// every dynamic kind
+		for _, name := range []string{friendlyName(unstructuredName), friendlyName(unstructuredListName)} {
+			if def, found := s.Definitions[name]; found {
+				delete(def.Extensions, gvkExtension)
+				s.Definitions[name] = def
+			}
+		}
+		return s, nil
+	}
+}
+
+// models returns the OpenAPI models used to merge server-side apply requests for the resource, built as they are
+// for a CustomResourceDefinition.
+func (r *Resource) models() (proto.Models, error) {
+	s, err := r.swagger(crdopenapi.Options{
+		StripDefaults: true, StripValueValidation: true, StripNullable: true, AllowNonStructural: true})
+	if err != nil {
+		return nil, err
+	}
+	// the spec references the definitions of the object metadata and the definitions they depend on
+	defs := openapi.DefinitionsFor(nil)(func(name string) spec.Ref {
+		return spec.MustCreateRef("#/definitions/" + friendlyName(name))
+	})
+	names := []string{openapi.DefinitionName(&metav1.ObjectMeta{}), openapi.DefinitionName(&metav1.ListMeta{})}
+	for len(names) > 0 {
+		name := names[0]
+		names = names[1:]
+		if _, found := s.Definitions[friendlyName(name)]; found {
+			continue
+		}
+		s.Definitions[friendlyName(name)] = defs[name].Schema
+		names = append(names, defs[name].Dependencies...)
+	}
+	return utilopenapi.ToProtoModels(s)
+}
+
+// swagger returns the OpenAPI spec for the resource built as it is for a CustomResourceDefinition.
+func (r *Resource) swagger(opts crdopenapi.Options) (*spec.Swagger, error) {
+	scope := apiextensionsv1.NamespaceScoped
+	if !r.NamespaceScoped() {
+		scope = apiextensionsv1.ClusterScoped
+	}
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: r.gvr.Resource + "." + r.gvr.Group},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: r.gvr.Group,
+			Names: apiextensionsv1.CustomResourceDefinitionNames{
+				Plural:   r.gvr.Resource,
+				Singular: strings.ToLower(r.kind),
+				Kind:     r.kind,
+				ListKind: r.kind + "List",
+			},
+			Scope: scope,
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{{
+				Name:    r.gvr.Version,
+				Served:  true,
+				Storage: true,
+				Schema:  &apiextensionsv1.CustomResourceValidation{OpenAPIV3Schema: r.schema},
+			}},
+		},
+	}
+	return crdopenapi.BuildSwagger(crd, r.gvr.Version, opts)
+}
+
+// friendlyName returns the name of the definition in the spec -- e.g. io.k8s.apimachinery.pkg.apis.meta.v1.Status
+// for k8s.io/apimachinery/pkg/apis/meta/v1.Status.
+func friendlyName(name string) string {
+	parts := strings.Split(name, "/")
+	if len(parts) > 0 && strings.Contains(parts[0], ".") {
+		host := strings.Split(parts[0], ".")
+		for i, j := 0, len(host)-1; i < j; i, j = i+1, j-1 {
+			host[i], host[j] = host[j], host[i]
+		}
+		parts[0] = strings.Join(host, ".")
+	}
+	return strings.Join(parts, ".")
+}
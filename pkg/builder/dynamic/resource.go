@@ -0,0 +1,188 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dynamic serves resources defined by an OpenAPI schema rather than go types.
+//
+// Objects of dynamic resources are unstructured.Unstructured objects.  As with CustomResourceDefinitions, the
+// schema must be a structural schema -- objects are pruned of fields not specified by the schema and defaulted
+// when they are decoded from requests or storage, and validated against the schema before they are stored.
+package dynamic
+
+import (
+	"fmt"
+
+	"github.com/go-openapi/validate"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	structuraldefaulting "k8s.io/apiextensions-apiserver/pkg/apiserver/schema/defaulting"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/schema/listtype"
+	schemaobjectmeta "k8s.io/apiextensions-apiserver/pkg/apiserver/schema/objectmeta"
+	structuralpruning "k8s.io/apiextensions-apiserver/pkg/apiserver/schema/pruning"
+	apiservervalidation "k8s.io/apiextensions-apiserver/pkg/apiserver/validation"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Resource is a resource defined by a structural OpenAPI schema.
+type Resource struct {
+	gvr        schema.GroupVersionResource
+	kind       string
+	scope      apiextensionsv1.ResourceScope
+	schema     *apiextensionsv1.JSONSchemaProps
+	structural *structuralschema.Structural
+	validator  *validate.SchemaValidator
+}
+
+// NewResource returns a new Resource for the GroupVersionResource.  openAPISchema is the schema of the objects,
+// as for the versions of a CustomResourceDefinition, and must be a structural schema.
+func NewResource(gvr schema.GroupVersionResource, kind string, openAPISchema *apiextensionsv1.JSONSchemaProps,
+	scope apiextensionsv1.ResourceScope) (*Resource, error) {
+	if kind == "" {
+		return nil, fmt.Errorf("kind must be set")
+	}
+	if scope != apiextensionsv1.NamespaceScoped && scope != apiextensionsv1.ClusterScoped {
+		return nil, fmt.Errorf("scope must be %s or %s", apiextensionsv1.NamespaceScoped, apiextensionsv1.ClusterScoped)
+	}
+	if openAPISchema == nil {
+		return nil, fmt.Errorf("openAPISchema must be set")
+	}
+
+	internal := &apiextensions.JSONSchemaProps{}
+	if err := apiextensionsv1.Convert_v1_JSONSchemaProps_To_apiextensions_JSONSchemaProps(
+		openAPISchema, internal, nil); err != nil {
+		return nil, err
+	}
+	structural, err := structuralschema.NewStructural(internal)
+	if err != nil {
+		return nil, err
+	}
+	if errs := structuralschema.ValidateStructural(field.NewPath("openAPISchema"), structural); len(errs) != 0 {
+		return nil, errs.ToAggregate()
+	}
+	if errs, err := structuraldefaulting.ValidateDefaults(
+		field.NewPath("openAPISchema"), structural, true, true); err != nil {
+		return nil, err
+	} else if len(errs) != 0 {
+		return nil, errs.ToAggregate()
+	}
+	validator, _, err := apiservervalidation.NewSchemaValidator(
+		&apiextensions.CustomResourceValidation{OpenAPIV3Schema: internal})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Resource{
+		gvr:        gvr,
+		kind:       kind,
+		scope:      scope,
+		schema:     openAPISchema,
+		structural: structural,
+		validator:  validator,
+	}, nil
+}
+
+// GetGroupVersionResource returns the GroupVersionResource of the resource.
+func (r *Resource) GetGroupVersionResource() schema.GroupVersionResource {
+	return r.gvr
+}
+
+// GroupVersionKind returns the GroupVersionKind of the objects.
+func (r *Resource) GroupVersionKind() schema.GroupVersionKind {
+	return r.gvr.GroupVersion().WithKind(r.kind)
+}
+
+// ListGroupVersionKind returns the GroupVersionKind of the lists of objects.
+func (r *Resource) ListGroupVersionKind() schema.GroupVersionKind {
+	return r.gvr.GroupVersion().WithKind(r.kind + "List")
+}
+
+// NamespaceScoped returns true if the resource is namespace scoped.
+func (r *Resource) NamespaceScoped() bool {
+	return r.scope == apiextensionsv1.NamespaceScoped
+}
+
+// New returns a new object of the resource.
+func (r *Resource) New() runtime.Object {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(r.GroupVersionKind())
+	return u
+}
+
+// NewList returns a new list of objects of the resource.
+func (r *Resource) NewList() runtime.Object {
+	u := &unstructured.UnstructuredList{}
+	u.SetGroupVersionKind(r.ListGroupVersionKind())
+	return u
+}
+
+// Default prunes the fields not specified by the schema from the object and defaults its unset fields.
+func (r *Resource) Default(u *unstructured.Unstructured) {
+	structuralpruning.Prune(u.Object, r.structural, true)
+	// drop malformed metadata fields rather than failing, as is done for objects read from storage
+	_ = schemaobjectmeta.Coerce(nil, u.Object, r.structural, true, true)
+	structuraldefaulting.Default(u.Object, r.structural)
+}
+
+// Validate validates the object against the schema.
+func (r *Resource) Validate(obj runtime.Object) field.ErrorList {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return field.ErrorList{field.Invalid(
+			field.NewPath(""), fmt.Sprintf("%T", obj), "must be a pointer to an Unstructured type")}
+	}
+	var errs field.ErrorList
+	gvk := u.GroupVersionKind()
+	if gvk.GroupVersion() != r.gvr.GroupVersion() {
+		errs = append(errs, field.Invalid(field.NewPath("apiVersion"), u.GetAPIVersion(),
+			fmt.Sprintf("must be %s", r.gvr.GroupVersion())))
+	}
+	if gvk.Kind != r.kind {
+		errs = append(errs, field.Invalid(field.NewPath("kind"), u.GetKind(), fmt.Sprintf("must be %s", r.kind)))
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	errs = append(errs, apiservervalidation.ValidateCustomResource(nil, u.UnstructuredContent(), r.validator)...)
+	errs = append(errs, schemaobjectmeta.Validate(nil, u.Object, r.structural, true)...)
+	errs = append(errs, listtype.ValidateListSetsAndMaps(nil, r.structural, u.Object)...)
+	return errs
+}
+
+// AddToScheme returns a function to add the resources to the scheme.
+//
+// The objects of every resource are registered as unstructured.Unstructured objects, and a defaulting function
+// which calls the Default function of the resource matching the GroupVersionKind of the object is registered
+// for unstructured.Unstructured.
+func AddToScheme(resources ...*Resource) func(s *runtime.Scheme) error {
+	return func(s *runtime.Scheme) error {
+		kinds := map[schema.GroupVersionKind]*Resource{}
+		for _, r := range resources {
+			s.AddKnownTypeWithName(r.GroupVersionKind(), &unstructured.Unstructured{})
+			s.AddKnownTypeWithName(r.ListGroupVersionKind(), &unstructured.UnstructuredList{})
+			kinds[r.GroupVersionKind()] = r
+		}
+		s.AddTypeDefaultingFunc(&unstructured.Unstructured{}, func(obj interface{}) {
+			u := obj.(*unstructured.Unstructured)
+			if r, found := kinds[u.GroupVersionKind()]; found {
+				r.Default(u)
+			}
+		})
+		return nil
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamic
+
+import (
+	"io"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// NegotiatedSerializer returns a NegotiatedSerializer which encodes unstructured objects as they are.
+//
+// Objects of dynamic resources are only served in the version they are defined for, so they never need to be
+// converted.  The versioning encoders of the scheme cannot encode lists of unstructured objects when multiple
+// dynamic kinds are registered for a version, because each kind is registered with the same go type.
+func NegotiatedSerializer(ns runtime.NegotiatedSerializer) runtime.NegotiatedSerializer {
+	return negotiatedSerializer{NegotiatedSerializer: ns}
+}
+
+type negotiatedSerializer struct {
+	runtime.NegotiatedSerializer
+}
+
+// EncoderForVersion implements runtime.NegotiatedSerializer
+func (n negotiatedSerializer) EncoderForVersion(e runtime.Encoder, gv runtime.GroupVersioner) runtime.Encoder {
+	return encoder{Encoder: n.NegotiatedSerializer.EncoderForVersion(e, gv), unstructured: e}
+}
+
+// encoder encodes unstructured objects with the unstructured encoder, and all other objects with the Encoder.
+type encoder struct {
+	runtime.Encoder
+	unstructured runtime.Encoder
+}
+
+// Encode implements runtime.Encoder
+func (e encoder) Encode(obj runtime.Object, w io.Writer) error {
+	o := obj
+	if co, ok := obj.(runtime.CacheableObject); ok {
+		o = co.GetObject()
+	}
+	if _, ok := o.(runtime.Unstructured); ok {
+		return e.unstructured.Encode(o, w)
+	}
+	return e.Encoder.Encode(obj, w)
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamic
+
+import (
+	"context"
+
+	builderrest "github.com/pwittrock/apiserver-runtime/pkg/builder/rest"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apiserver/pkg/registry/generic"
+	genericregistry "k8s.io/apiserver/pkg/registry/generic/registry"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/apiserver/pkg/storage"
+)
+
+// Strategy is the Strategy for the objects of a Resource.  Strategy adds validation against the schema of
+// the Resource to the DefaultStrategy.
+type Strategy struct {
+	builderrest.DefaultStrategy
+	Resource *Resource
+}
+
+var _ builderrest.Strategy = Strategy{}
+
+// NewStrategy returns a new Strategy for the resource.
+func NewStrategy(r *Resource, typer runtime.ObjectTyper) Strategy {
+	return Strategy{
+		DefaultStrategy: builderrest.DefaultStrategy{
+			Object:         r.New(),
+			ObjectTyper:    typer,
+			TableConvertor: rest.NewDefaultTableConvertor(r.gvr.GroupResource()),
+			GroupResource:  r.gvr.GroupResource(),
+		},
+		Resource: r,
+	}
+}
+
+// NamespaceScoped returns the scope of the Resource.
+func (s Strategy) NamespaceScoped() bool {
+	return s.Resource.NamespaceScoped()
+}
+
+// PrepareForCreate sets the generation of the object.
+func (s Strategy) PrepareForCreate(ctx context.Context, obj runtime.Object) {
+	if a, err := meta.Accessor(obj); err == nil {
+		a.SetGeneration(1)
+	}
+	s.DefaultStrategy.PrepareForCreate(ctx, obj)
+}
+
+// PrepareForUpdate increments the generation of the object if anything other than its metadata changed.
+func (s Strategy) PrepareForUpdate(ctx context.Context, obj, old runtime.Object) {
+	s.DefaultStrategy.PrepareForUpdate(ctx, obj, old)
+
+	newContent := obj.(runtime.Unstructured).UnstructuredContent()
+	oldContent := old.(runtime.Unstructured).UnstructuredContent()
+	if !equality.Semantic.DeepEqual(withoutMetadata(newContent), withoutMetadata(oldContent)) {
+		newMeta, _ := meta.Accessor(obj)
+		oldMeta, _ := meta.Accessor(old)
+		newMeta.SetGeneration(oldMeta.GetGeneration() + 1)
+	}
+}
+
+// Validate validates the object against the schema, and then calls the DefaultStrategy.
+func (s Strategy) Validate(ctx context.Context, obj runtime.Object) field.ErrorList {
+	errs := s.Resource.Validate(obj)
+	return append(errs, s.DefaultStrategy.Validate(ctx, obj)...)
+}
+
+// ValidateUpdate validates the object against the schema, and then calls the DefaultStrategy.
+func (s Strategy) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	errs := s.Resource.Validate(obj)
+	return append(errs, s.DefaultStrategy.ValidateUpdate(ctx, obj, old)...)
+}
+
+// Match returns a SelectionPredicate for the labels and fields of the objects.
+func (s Strategy) Match(label labels.Selector, field fields.Selector) storage.SelectionPredicate {
+	return storage.SelectionPredicate{
+		Label:    label,
+		Field:    field,
+		GetAttrs: s.getAttrs,
+	}
+}
+
+func (s Strategy) getAttrs(obj runtime.Object) (labels.Set, fields.Set, error) {
+	if s.Resource.NamespaceScoped() {
+		return storage.DefaultNamespaceScopedAttr(obj)
+	}
+	return storage.DefaultClusterScopedAttr(obj)
+}
+
+// withoutMetadata returns a shallow copy of the object content without the metadata.
+func withoutMetadata(content map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(content))
+	for k, v := range content {
+		if k != "metadata" {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+// New returns a StorageProvider for the resource which stores objects in etcd using a Strategy.
+func New(r *Resource) builderrest.ResourceHandlerProvider {
+	return func(scheme *runtime.Scheme, optsGetter generic.RESTOptionsGetter) (rest.Storage, error) {
+		s := NewStrategy(r, scheme)
+		store := &genericregistry.Store{
+			NewFunc:                  r.New,
+			NewListFunc:              r.NewList,
+			PredicateFunc:            s.Match,
+			DefaultQualifiedResource: r.gvr.GroupResource(),
+			TableConvertor:           s,
+			CreateStrategy:           s,
+			UpdateStrategy:           s,
+			DeleteStrategy:           s,
+		}
+		options := &generic.StoreOptions{RESTOptions: restOptionsGetter{optsGetter, r}, AttrFunc: s.getAttrs}
+		if err := store.CompleteWithOptions(options); err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+}
+
+// restOptionsGetter decodes the stored objects of a Resource into unstructured objects of its kind.
+type restOptionsGetter struct {
+	generic.RESTOptionsGetter
+	resource *Resource
+}
+
+// GetRESTOptions implements generic.RESTOptionsGetter
+func (g restOptionsGetter) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	opts, err := g.RESTOptionsGetter.GetRESTOptions(resource)
+	if err != nil || opts.StorageConfig == nil {
+		return opts, err
+	}
+	config := *opts.StorageConfig
+	config.Codec = storageCodec{Codec: config.Codec, resource: g.resource}
+	opts.StorageConfig = &config
+	return opts, nil
+}
+
+// storageCodec decodes objects into unstructured objects of the kind of a Resource.  The etcd watcher decodes
+// objects without an object to decode into, which the scheme would convert to the internal version of the group,
+// where the dynamic kinds are not registered.
+type storageCodec struct {
+	runtime.Codec
+	resource *Resource
+}
+
+// Decode implements runtime.Decoder
+func (c storageCodec) Decode(
+	data []byte, defaults *schema.GroupVersionKind, into runtime.Object) (runtime.Object, *schema.GroupVersionKind, error) {
+	if into == nil {
+		into = c.resource.New()
+	}
+	return c.Codec.Decode(data, defaults, into)
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-openapi/spec"
+	"github.com/pwittrock/apiserver-runtime/pkg/apiserver"
+	"github.com/pwittrock/apiserver-runtime/pkg/cmd/server"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	etcd3testing "k8s.io/apiserver/pkg/storage/etcd3/testing"
+)
+
+func TestWithUnstructuredResource(t *testing.T) {
+	gizmos := testGroupVersion.WithResource("gizmos")
+	a := newTestServer().
+		WithResource(&Widget{}).
+		WithUnstructuredResource(gizmos, "Gizmo", &apiextensionsv1.JSONSchemaProps{Type: "object"},
+			apiextensionsv1.ClusterScoped)
+	if len(a.errs) != 0 {
+		t.Fatal(a.errs)
+	}
+
+	scheme := runtime.NewScheme()
+	if err := a.schemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if errs := a.validate(scheme); len(errs) != 0 {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+	for _, kind := range []string{"Gizmo", "GizmoList"} {
+		if !scheme.Recognizes(testGroupVersion.WithKind(kind)) {
+			t.Errorf("expected %s to be added to the scheme", kind)
+		}
+	}
+	if a.storageVersion(gizmos.GroupResource()) != testGroupVersion {
+		t.Errorf("expected the gizmos to be stored as %v", testGroupVersion)
+	}
+
+	d := a.Describe()
+	expected := ResourceDescription{
+		GroupVersionResource: gizmos,
+		StorageKind:          EtcdStorage,
+		StorageVersion:       testGroupVersion,
+		OpenAPI:              true,
+	}
+	if len(d) != 2 || !reflect.DeepEqual(d[0], expected) {
+		t.Errorf("expected %+v, got %+v", expected, d)
+	}
+
+	defs := a.allOpenAPIDefinitions()(func(path string) spec.Ref { return spec.MustCreateRef(path) })
+	if _, found := defs["k8s.io/apimachinery/pkg/apis/meta/v1/unstructured.Unstructured"]; !found {
+		t.Errorf("expected a definition for the unstructured objects")
+	}
+}
+
+func TestWithUnstructuredResourceErrors(t *testing.T) {
+	widgets := testGroupVersion.WithResource("widgets")
+	a := newTestServer().
+		WithResource(&Widget{}).
+		WithUnstructuredResource(widgets, "Widget", &apiextensionsv1.JSONSchemaProps{Type: "object"},
+			apiextensionsv1.ClusterScoped).
+		WithUnstructuredResource(schema.GroupVersionResource{Group: "test.example.com", Version: "v1", Resource: "gizmos"},
+			"Gizmo", nil, apiextensionsv1.ClusterScoped)
+
+	if len(a.errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", a.errs)
+	}
+	for i, msg := range []string{"already registered", "openAPISchema must be set"} {
+		if !strings.Contains(a.errs[i].Error(), msg) {
+			t.Errorf("expected %q, got %v", msg, a.errs[i])
+		}
+	}
+
+	// resources registered after an unstructured resource are validated against its registration
+	widgetsV2 := schema.GroupVersion{Group: testGroupVersion.Group, Version: "v2"}.WithResource("widgets")
+	a = newTestServer().
+		WithUnstructuredResource(widgetsV2, "Widget", &apiextensionsv1.JSONSchemaProps{Type: "object"},
+			apiextensionsv1.ClusterScoped).
+		WithResource(&Widget{})
+	scheme := runtime.NewScheme()
+	if err := a.schemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	errs := a.validate(scheme)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "uses the storage of the unstructured resource") {
+		t.Errorf("expected the widgets not to reuse the unstructured storage, got %v", errs)
+	}
+}
+
+// newUnstructuredHandler builds a and returns the handler of an apiserver storing its objects in etcd.  Requests
+// are not authenticated or authorized.
+func newUnstructuredHandler(t *testing.T, a *Server, etcd *etcd3testing.EtcdTestServer) http.Handler {
+	recommendedConfigFns, negotiatedSerializer := server.RecommendedConfigFns, apiserver.NegotiatedSerializer
+	t.Cleanup(func() {
+		server.RecommendedConfigFns, apiserver.NegotiatedSerializer = recommendedConfigFns, negotiatedSerializer
+		for _, r := range a.registrations {
+			delete(apiserver.APIs, r.gvr)
+			delete(server.StorageVersions, r.gvr.GroupResource())
+		}
+	})
+	if err := a.build(); err != nil {
+		t.Fatal(err)
+	}
+
+	o := server.NewWardleServerOptions(ioutil.Discard, ioutil.Discard, a.orderedGroupVersions[0])
+	o.RecommendedOptions.Authentication = nil
+	o.RecommendedOptions.Authorization = nil
+	o.RecommendedOptions.CoreAPI = nil
+	o.RecommendedOptions.Admission = nil
+	o.RecommendedOptions.Etcd.StorageConfig.Transport.ServerList = etcd.V3Client.Endpoints()
+	o.RecommendedOptions.SecureServing.ServerCert.CertDirectory = ""
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	o.RecommendedOptions.SecureServing.Listener = listener
+
+	config, err := o.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := config.Complete().New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.GenericAPIServer.Handler
+}
+
+// serve serves a request for path with the body, and decodes the response into an Unstructured object.
+func serve(t *testing.T, h http.Handler, method, path, contentType, body string) (int, *unstructured.Unstructured) {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	u := &unstructured.Unstructured{}
+	if err := u.UnmarshalJSON(w.Body.Bytes()); err != nil {
+		t.Fatalf("%s %s: unable to decode %q: %v", method, path, w.Body, err)
+	}
+	return w.Code, u
+}
+
+// managers returns the field managers and operations in the managed fields of u.
+func managers(u *unstructured.Unstructured) []string {
+	var m []string
+	for _, f := range u.GetManagedFields() {
+		m = append(m, f.Manager+":"+string(f.Operation))
+	}
+	return m
+}
+
+func TestUnstructuredResourceServer(t *testing.T) {
+	etcd, _ := etcd3testing.NewUnsecuredEtcd3TestClientServer(t)
+	defer etcd.Terminate(t)
+
+	gv := schema.GroupVersion{Group: "dynamic.example.com", Version: "v1"}
+	size := &apiextensionsv1.JSONSchemaProps{
+		Type: "object",
+		Properties: map[string]apiextensionsv1.JSONSchemaProps{
+			"spec": {Type: "object", Properties: map[string]apiextensionsv1.JSONSchemaProps{
+				"size":  {Type: "integer"},
+				"color": {Type: "string"},
+			}},
+		},
+	}
+	// only unstructured resources are served, of multiple kinds in the same version
+	a := newTestServer().
+		WithUnstructuredResource(gv.WithResource("gizmos"), "Gizmo", size, apiextensionsv1.NamespaceScoped).
+		WithUnstructuredResource(gv.WithResource("doodads"), "Doodad", size, apiextensionsv1.ClusterScoped)
+	h := newUnstructuredHandler(t, a, etcd)
+
+	gizmos := "/apis/dynamic.example.com/v1/namespaces/default/gizmos"
+	code, u := serve(t, h, http.MethodPost, gizmos+"?fieldManager=creator", "application/json",
+		`{"apiVersion":"dynamic.example.com/v1","kind":"Gizmo","metadata":{"name":"created"},"spec":{"size":1}}`)
+	if code != http.StatusCreated {
+		t.Fatalf("expected the gizmo to be created, got %d: %v", code, u)
+	}
+	if m := managers(u); !reflect.DeepEqual(m, []string{"creator:Update"}) {
+		t.Errorf("expected the created fields to be managed by the creator, got %v", m)
+	}
+	code, u = serve(t, h, http.MethodPost, "/apis/dynamic.example.com/v1/doodads", "application/json",
+		`{"apiVersion":"dynamic.example.com/v1","kind":"Doodad","metadata":{"name":"created"}}`)
+	if code != http.StatusCreated || u.GetKind() != "Doodad" {
+		t.Fatalf("expected the doodad to be created, got %d: %v", code, u)
+	}
+
+	// server-side apply creates and then updates objects of each kind
+	for _, path := range []string{gizmos + "/applied", "/apis/dynamic.example.com/v1/doodads/applied"} {
+		kind := "Gizmo"
+		if strings.Contains(path, "doodads") {
+			kind = "Doodad"
+		}
+		for i, expected := range []int{http.StatusCreated, http.StatusOK} {
+			code, u := serve(t, h, http.MethodPatch, path+"?fieldManager=applier", "application/apply-patch+yaml",
+				fmt.Sprintf(`{"apiVersion":"dynamic.example.com/v1","kind":%q,"metadata":{"name":"applied"},`+
+					`"spec":{"size":%d}}`, kind, i))
+			if code != expected {
+				t.Fatalf("expected %s to be applied with %d, got %d: %v", path, expected, code, u)
+			}
+			if u.GetKind() != kind || !reflect.DeepEqual(managers(u), []string{"applier:Apply"}) {
+				t.Errorf("expected a %s managed by the applier, got %v", kind, u)
+			}
+			if size, _, _ := unstructured.NestedInt64(u.Object, "spec", "size"); size != int64(i) {
+				t.Errorf("expected the applied size %d, got %d", i, size)
+			}
+		}
+	}
+
+	// a conflicting apply is rejected
+	code, u = serve(t, h, http.MethodPatch, gizmos+"/created?fieldManager=applier", "application/apply-patch+yaml",
+		`{"apiVersion":"dynamic.example.com/v1","kind":"Gizmo","metadata":{"name":"created"},"spec":{"size":2}}`)
+	if code != http.StatusConflict {
+		t.Errorf("expected the apply to conflict with the creator, got %d: %v", code, u)
+	}
+
+	code, u = serve(t, h, http.MethodGet, gizmos, "", "")
+	if code != http.StatusOK {
+		t.Fatalf("expected the gizmos to be listed, got %d: %v", code, u)
+	}
+	list, err := u.ToList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, item := range list.Items {
+		names = append(names, item.GetName())
+	}
+	if expected := []string{"applied", "created"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected the gizmos %v, got %v", expected, names)
+	}
+}
@@ -0,0 +1,298 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package encryption encrypts the objects of resources stored in etcd.
+//
+// Resources are encrypted as configured by an EncryptionConfiguration, using the aescbc, aesgcm, secretbox,
+// identity and kms providers of the kube-apiserver and the same storage format, so objects encrypted by the
+// kube-apiserver may be read and vice versa.  The first provider of a resource encrypts the objects written to
+// etcd, and every provider is tried when reading them.  Keys are rotated by adding the new key as the first key,
+// and rewriting the objects of the resource with the rotate-encryption-keys command.
+package encryption
+
+import (
+	"crypto/aes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	apiserverconfig "k8s.io/apiserver/pkg/apis/config"
+	apiserverconfigv1 "k8s.io/apiserver/pkg/apis/config/v1"
+	"k8s.io/apiserver/pkg/apis/config/validation"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/storage/value"
+	aestransformer "k8s.io/apiserver/pkg/storage/value/encrypt/aes"
+	"k8s.io/apiserver/pkg/storage/value/encrypt/envelope"
+	"k8s.io/apiserver/pkg/storage/value/encrypt/identity"
+	"k8s.io/apiserver/pkg/storage/value/encrypt/secretbox"
+)
+
+// The prefixes of the encrypted values written to etcd by each provider, as written by the kube-apiserver.
+const (
+	aesCBCPrefix    = "k8s:enc:aescbc:v1:"
+	aesGCMPrefix    = "k8s:enc:aesgcm:v1:"
+	secretboxPrefix = "k8s:enc:secretbox:v1:"
+	kmsPrefix       = "k8s:enc:kms:v1:"
+)
+
+var (
+	scheme = runtime.NewScheme()
+	codecs = serializer.NewCodecFactory(scheme)
+)
+
+func init() {
+	_ = apiserverconfig.AddToScheme(scheme)
+	_ = apiserverconfigv1.AddToScheme(scheme)
+}
+
+// Config configures the encryption of resources at rest.
+type Config struct {
+	// Configuration is the EncryptionConfiguration of the resources.  Either Configuration or File must be set.
+	Configuration *apiserverconfigv1.EncryptionConfiguration
+	// File is the EncryptionConfiguration file read when the apiserver is started.
+	File string
+	// KMS are the KMS services of the kms providers, by the name of the provider.  The kms providers which are
+	// not in KMS use the gRPC KMS plugin listening on their endpoint.
+	KMS map[string]KMS
+}
+
+// Resources returns the encrypted resources.
+func (c *Config) Resources() ([]schema.GroupResource, error) {
+	config, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	var resources []schema.GroupResource
+	for _, r := range config.Resources {
+		for _, gr := range r.Resources {
+			resources = append(resources, schema.ParseGroupResource(gr))
+		}
+	}
+	return resources, nil
+}
+
+// Validate validates the Configuration.  Configurations read from a File are validated when they are read.
+func (c *Config) Validate() error {
+	if (c.Configuration == nil) == (c.File == "") {
+		return fmt.Errorf("exactly one of an EncryptionConfiguration or File must be set")
+	}
+	if c.Configuration == nil {
+		return nil
+	}
+	_, err := c.load()
+	return err
+}
+
+// Transformers returns the transformers of the encrypted resources.
+func (c *Config) Transformers() (map[schema.GroupResource]value.Transformer, error) {
+	config, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	prefixTransformers := map[schema.GroupResource][]value.PrefixTransformer{}
+	for i := range config.Resources {
+		transformers, err := c.prefixTransformers(&config.Resources[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range config.Resources[i].Resources {
+			gr := schema.ParseGroupResource(r)
+			prefixTransformers[gr] = append(prefixTransformers[gr], transformers...)
+		}
+	}
+	result := map[schema.GroupResource]value.Transformer{}
+	for gr, transformers := range prefixTransformers {
+		result[gr] = value.NewPrefixTransformers(fmt.Errorf("no matching prefix found"), transformers...)
+	}
+	return result, nil
+}
+
+// load returns the internal EncryptionConfiguration, defaulted and validated.
+func (c *Config) load() (*apiserverconfig.EncryptionConfiguration, error) {
+	var data []byte
+	if c.Configuration != nil {
+		in := c.Configuration.DeepCopy()
+		in.APIVersion, in.Kind = apiserverconfigv1.SchemeGroupVersion.String(), "EncryptionConfiguration"
+		b, err := runtime.Encode(codecs.LegacyCodec(apiserverconfigv1.SchemeGroupVersion), in)
+		if err != nil {
+			return nil, err
+		}
+		data = b
+	} else {
+		b, err := ioutil.ReadFile(c.File)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the encryption configuration: %v", err)
+		}
+		data = b
+	}
+	obj, gvk, err := codecs.UniversalDecoder().Decode(data, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode the encryption configuration: %v", err)
+	}
+	config, ok := obj.(*apiserverconfig.EncryptionConfiguration)
+	if !ok {
+		return nil, fmt.Errorf("expected an EncryptionConfiguration, got %v", gvk)
+	}
+
+	// the KMS services in c.KMS have no endpoint, which is required for the gRPC KMS plugins
+	validated := config.DeepCopy()
+	for i := range validated.Resources {
+		for _, p := range validated.Resources[i].Providers {
+			if p.KMS != nil && c.KMS[p.KMS.Name] != nil && p.KMS.Endpoint == "" {
+				p.KMS.Endpoint = "unix:///" + p.KMS.Name
+			}
+		}
+	}
+	if err := validation.ValidateEncryptionConfiguration(validated).ToAggregate(); err != nil {
+		return nil, fmt.Errorf("invalid encryption configuration: %v", err)
+	}
+	return config, nil
+}
+
+func (c *Config) prefixTransformers(config *apiserverconfig.ResourceConfiguration) ([]value.PrefixTransformer, error) {
+	var result []value.PrefixTransformer
+	for _, p := range config.Providers {
+		var (
+			transformer value.PrefixTransformer
+			err         error
+		)
+		switch {
+		case p.AESGCM != nil:
+			transformer, err = keyTransformer(aesGCMPrefix, p.AESGCM.Keys, func(key []byte) (value.Transformer, error) {
+				block, err := aes.NewCipher(key)
+				if err != nil {
+					return nil, err
+				}
+				return aestransformer.NewGCMTransformer(block), nil
+			})
+		case p.AESCBC != nil:
+			transformer, err = keyTransformer(aesCBCPrefix, p.AESCBC.Keys, func(key []byte) (value.Transformer, error) {
+				block, err := aes.NewCipher(key)
+				if err != nil {
+					return nil, err
+				}
+				return aestransformer.NewCBCTransformer(block), nil
+			})
+		case p.Secretbox != nil:
+			transformer, err = keyTransformer(secretboxPrefix, p.Secretbox.Keys, func(key []byte) (value.Transformer, error) {
+				if len(key) != 32 {
+					return nil, fmt.Errorf("expected key size 32 for secretbox provider, got %v", len(key))
+				}
+				var k [32]byte
+				copy(k[:], key)
+				return secretbox.NewSecretboxTransformer(k), nil
+			})
+		case p.KMS != nil:
+			transformer, err = c.kmsTransformer(p.KMS)
+		case p.Identity != nil:
+			transformer = value.PrefixTransformer{Transformer: identity.NewEncryptCheckTransformer(), Prefix: []byte{}}
+		default:
+			err = fmt.Errorf("provider does not contain any of the expected providers: KMS, AESGCM, AESCBC, Secretbox, Identity")
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, transformer)
+	}
+	return result, nil
+}
+
+// keyTransformer returns a transformer encrypting values with the first key, and decrypting them with the key
+// whose name prefixes the value.
+func keyTransformer(prefix string, keys []apiserverconfig.Key,
+	newTransformer func(key []byte) (value.Transformer, error)) (value.PrefixTransformer, error) {
+	var transformers []value.PrefixTransformer
+	for _, k := range keys {
+		key, err := base64.StdEncoding.DecodeString(k.Secret)
+		if err != nil {
+			return value.PrefixTransformer{}, fmt.Errorf("could not obtain secret for named key %s: %v", k.Name, err)
+		}
+		t, err := newTransformer(key)
+		if err != nil {
+			return value.PrefixTransformer{}, fmt.Errorf("error while creating cipher for named key %s: %v", k.Name, err)
+		}
+		transformers = append(transformers, value.PrefixTransformer{Transformer: t, Prefix: []byte(k.Name + ":")})
+	}
+	return value.PrefixTransformer{
+		Transformer: value.NewPrefixTransformers(
+			fmt.Errorf("no matching key was found for the provided %s transformer", strings.Split(prefix, ":")[2]),
+			transformers...),
+		Prefix: []byte(prefix),
+	}, nil
+}
+
+// kmsTransformer returns an envelope transformer encrypting the data encryption keys with the KMS service.
+func (c *Config) kmsTransformer(config *apiserverconfig.KMSConfiguration) (value.PrefixTransformer, error) {
+	service := c.KMS[config.Name]
+	if service == nil {
+		s, err := envelope.NewGRPCService(config.Endpoint, config.Timeout.Duration)
+		if err != nil {
+			return value.PrefixTransformer{}, fmt.Errorf("could not configure KMS plugin %q, error: %v", config.Name, err)
+		}
+		service = s
+	}
+	t, err := envelope.NewEnvelopeTransformer(service, int(*config.CacheSize), aestransformer.NewCBCTransformer)
+	if err != nil {
+		return value.PrefixTransformer{}, err
+	}
+	return value.PrefixTransformer{Transformer: t, Prefix: []byte(kmsPrefix + config.Name + ":")}, nil
+}
+
+// RESTOptionsGetter encrypts the resources stored with the RESTOptions of getter.  The transformers are created
+// from the Config when the RESTOptions of the first resource are requested.
+func (c *Config) RESTOptionsGetter(getter generic.RESTOptionsGetter) generic.RESTOptionsGetter {
+	return &restOptionsGetter{RESTOptionsGetter: getter, config: c}
+}
+
+type restOptionsGetter struct {
+	generic.RESTOptionsGetter
+	config *Config
+
+	once         sync.Once
+	transformers map[schema.GroupResource]value.Transformer
+	err          error
+}
+
+func (g *restOptionsGetter) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	opts, err := g.RESTOptionsGetter.GetRESTOptions(resource)
+	if err != nil {
+		return opts, err
+	}
+	g.once.Do(func() {
+		g.transformers, g.err = g.config.Transformers()
+	})
+	if g.err != nil {
+		return opts, g.err
+	}
+
+	// subresources are encrypted as their parent resource
+	parent := schema.GroupResource{Group: resource.Group, Resource: strings.Split(resource.Resource, "/")[0]}
+	transformer, found := g.transformers[parent]
+	if !found {
+		return opts, nil
+	}
+
+	// operate on a copy so the storage config shared by other resources is not modified
+	storageConfig := *opts.StorageConfig
+	storageConfig.Transformer = transformer
+	opts.StorageConfig = &storageConfig
+	return opts, nil
+}
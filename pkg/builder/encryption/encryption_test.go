@@ -0,0 +1,180 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apiserverconfigv1 "k8s.io/apiserver/pkg/apis/config/v1"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	"k8s.io/apiserver/pkg/storage/value"
+)
+
+var (
+	secrets = schema.GroupResource{Group: "example.com", Resource: "secrets"}
+	ctx     = value.DefaultContext("/registry/example.com/secrets/default/one")
+)
+
+func key(name string, b byte) apiserverconfigv1.Key {
+	return apiserverconfigv1.Key{Name: name, Secret: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))}
+}
+
+func configuration(providers ...apiserverconfigv1.ProviderConfiguration) *apiserverconfigv1.EncryptionConfiguration {
+	return &apiserverconfigv1.EncryptionConfiguration{Resources: []apiserverconfigv1.ResourceConfiguration{{
+		Resources: []string{secrets.String()},
+		Providers: providers,
+	}}}
+}
+
+func transformer(t *testing.T, c *Config) value.Transformer {
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	transformers, err := c.Transformers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transformers) != 1 || transformers[secrets] == nil {
+		t.Fatalf("expected a transformer for %v, got %v", secrets, transformers)
+	}
+	return transformers[secrets]
+}
+
+func TestRotation(t *testing.T) {
+	plaintext := []byte(`{"spec":{"password":"hunter2"}}`)
+	identity := apiserverconfigv1.ProviderConfiguration{Identity: &apiserverconfigv1.IdentityConfiguration{}}
+	first := transformer(t, &Config{Configuration: configuration(
+		apiserverconfigv1.ProviderConfiguration{AESCBC: &apiserverconfigv1.AESConfiguration{
+			Keys: []apiserverconfigv1.Key{key("first", 1)}}},
+		identity)})
+	rotated := transformer(t, &Config{Configuration: configuration(
+		apiserverconfigv1.ProviderConfiguration{AESCBC: &apiserverconfigv1.AESConfiguration{
+			Keys: []apiserverconfigv1.Key{key("second", 2), key("first", 1)}}},
+		identity)})
+
+	stored, err := first.TransformToStorage(plaintext, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(stored), "k8s:enc:aescbc:v1:first:") || bytes.Contains(stored, []byte("hunter2")) {
+		t.Fatalf("expected the object to be encrypted with the first key, got %q", stored)
+	}
+	if _, stale, err := first.TransformFromStorage(plaintext, ctx); err != nil || !stale {
+		t.Errorf("expected unencrypted objects to be read and stale, got %v %v", stale, err)
+	}
+
+	for _, tt := range []struct {
+		name        string
+		transformer value.Transformer
+		stale       bool
+	}{
+		{name: "first", transformer: first},
+		{name: "rotated", transformer: rotated, stale: true},
+	} {
+		out, stale, err := tt.transformer.TransformFromStorage(stored, ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !bytes.Equal(out, plaintext) || stale != tt.stale {
+			t.Errorf("%s: expected %s and stale %v, got %s and %v", tt.name, plaintext, tt.stale, out, stale)
+		}
+	}
+}
+
+func TestKMS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kek")
+	kms, err := NewLocalKMS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{
+		Configuration: configuration(apiserverconfigv1.ProviderConfiguration{
+			KMS: &apiserverconfigv1.KMSConfiguration{Name: "local"}}),
+		KMS: map[string]KMS{"local": kms},
+	}
+	stored, err := transformer(t, c).TransformToStorage([]byte("hunter2"), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(stored), "k8s:enc:kms:v1:local:") {
+		t.Fatalf("expected the object to be encrypted by the kms provider, got %q", stored)
+	}
+
+	// the key encryption key is read from the file by another apiserver
+	restarted, err := NewLocalKMS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.KMS["local"] = restarted
+	out, _, err := transformer(t, c).TransformFromStorage(stored, ctx)
+	if err != nil || string(out) != "hunter2" {
+		t.Errorf("expected the object to be decrypted, got %q %v", out, err)
+	}
+}
+
+func TestRESTOptionsGetter(t *testing.T) {
+	shared := &storagebackend.Config{Prefix: "/registry"}
+	c := &Config{Configuration: configuration(apiserverconfigv1.ProviderConfiguration{
+		Secretbox: &apiserverconfigv1.SecretboxConfiguration{Keys: []apiserverconfigv1.Key{key("box", 3)}}})}
+	getter := c.RESTOptionsGetter(generic.RESTOptions{StorageConfig: shared})
+
+	for _, r := range []string{"secrets", "secrets/status"} {
+		opts, err := getter.GetRESTOptions(schema.GroupResource{Group: secrets.Group, Resource: r})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.StorageConfig.Transformer == nil || opts.StorageConfig == shared {
+			t.Errorf("expected %s to be encrypted using a copy of the storage config", r)
+		}
+	}
+	opts, err := getter.GetRESTOptions(schema.GroupResource{Group: secrets.Group, Resource: "configs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.StorageConfig.Transformer != nil || shared.Transformer != nil {
+		t.Errorf("expected only the encrypted resources to be transformed")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for name, c := range map[string]*Config{
+		"empty": {},
+		"both":  {Configuration: configuration(), File: "encryption.yaml"},
+		"short key": {Configuration: configuration(apiserverconfigv1.ProviderConfiguration{
+			Secretbox: &apiserverconfigv1.SecretboxConfiguration{
+				Keys: []apiserverconfigv1.Key{{Name: "short", Secret: base64.StdEncoding.EncodeToString([]byte("short"))}}}})},
+		"kms without endpoint": {Configuration: configuration(apiserverconfigv1.ProviderConfiguration{
+			KMS: &apiserverconfigv1.KMSConfiguration{Name: "remote"}})},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// KMS encrypts and decrypts the data encryption keys of a kms provider with a key encryption key held by a Key
+// Management Service.  The objects of the resources are encrypted with the data encryption keys, which are
+// stored with the objects.
+type KMS interface {
+	// Encrypt encrypts a data encryption key.
+	Encrypt(plaintext []byte) ([]byte, error)
+	// Decrypt decrypts a data encryption key encrypted by Encrypt.
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// NewLocalKMS returns a KMS encrypting the data encryption keys using AES-GCM with the base64 encoded 32 byte key
+// in the file at path.  A key is generated and written to the file if it does not exist.
+//
+// The key encryption key is stored on the local filesystem, so NewLocalKMS should only be used for testing.
+func NewLocalKMS(path string) (KMS, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		key := make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+		b = []byte(base64.StdEncoding.EncodeToString(key))
+		if err := ioutil.WriteFile(path, b, 0600); err != nil {
+			return nil, fmt.Errorf("unable to write the key encryption key: %v", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read the key encryption key: %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode the key encryption key in %s: %v", path, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("expected a 32 byte key encryption key in %s, got %d bytes", path, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &localKMS{aead: aead}, nil
+}
+
+type localKMS struct {
+	aead cipher.AEAD
+}
+
+// Encrypt encrypts plaintext with a random nonce, which is prepended to the ciphertext.
+func (k *localKMS) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return k.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (k *localKMS) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < k.aead.NonceSize() {
+		return nil, fmt.Errorf("the data encryption key is too short")
+	}
+	nonce, ciphertext := ciphertext[:k.aead.NonceSize()], ciphertext[k.aead.NonceSize():]
+	return k.aead.Open(nil, nonce, ciphertext, nil)
+}
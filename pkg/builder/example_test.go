@@ -52,7 +52,7 @@ func Example() {
 // The storage version is the first one registered (v1alpha1), and alternate versions (v1beta1) are converted to the
 // storage version before being stored.
 // Requires that conversion functions be registered with the apiserver.Scheme to convert alternate versions
-// to/from the storage version.
+// to/from the storage version, or that each version implements resourcestrategy.Converter.
 func ExampleServer_WithResource() {
 	var _ resource.Object = &v1alpha1.ExampleResource{}
 	var _ resource.Object = &v1beta1.ExampleResource{}
@@ -0,0 +1,451 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package garbagecollector deletes the objects served by an apiserver whose owners have been deleted, for
+// apiservers running without the kube-controller-manager garbage collector.
+//
+// As with the kube-controller-manager garbage collector, objects are deleted once every owner in their
+// ownerReferences has been deleted.  Owners deleted with the Orphan propagation policy have the ownerReferences
+// to them removed from their dependents before they are deleted, and owners deleted with the Foreground
+// propagation policy are deleted after their dependents with blockOwnerDeletion set have been deleted.
+//
+// Only the ownerReferences to objects of the Resources known by the GarbageCollector are followed.  Owners of
+// other resources are assumed to exist.
+package garbagecollector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/controller"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// ownerIndex indexes the objects in the informers by the UIDs of their owners.
+const ownerIndex = "ownerUID"
+
+// Resource is a resource whose objects are garbage collected, and may own other objects.
+type Resource struct {
+	// GroupVersionResource is the version of the resource watched by the GarbageCollector.
+	GroupVersionResource schema.GroupVersionResource
+	// Kinds are the kinds of the resource in every version -- i.e. the apiVersion and kind of ownerReferences to
+	// objects of the resource.
+	Kinds []schema.GroupVersionKind
+	// Namespaced is true if the resource is namespace scoped.
+	Namespaced bool
+}
+
+// GarbageCollector deletes the objects of its Resources whose owners have been deleted.
+type GarbageCollector struct {
+	// Resources are the resources whose objects are garbage collected.
+	Resources []Resource
+	// Workers is the number of objects processed concurrently.  Defaults to 1.
+	Workers int
+}
+
+var _ controller.Runnable = &GarbageCollector{}
+
+// item is an object processed by the GarbageCollector.
+type item struct {
+	// resource is the index of the Resource of the object.
+	resource  int
+	namespace string
+	name      string
+}
+
+// collector is a running GarbageCollector.
+type collector struct {
+	resources []Resource
+	kinds     map[schema.GroupKind]int
+	client    dynamic.Interface
+	informers []cache.SharedIndexInformer
+	queue     workqueue.RateLimitingInterface
+}
+
+// Start implements controller.Runnable
+func (gc *GarbageCollector) Start(client dynamic.Interface, informers dynamicinformer.DynamicSharedInformerFactory) func(
+	ctx context.Context) {
+	c := &collector{
+		resources: gc.Resources,
+		kinds:     map[schema.GroupKind]int{},
+		client:    client,
+		queue:     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "garbagecollector"),
+	}
+	for i, r := range gc.Resources {
+		for _, k := range r.Kinds {
+			c.kinds[k.GroupKind()] = i
+		}
+		informer := informers.ForResource(r.GroupVersionResource).Informer()
+		if err := informer.AddIndexers(cache.Indexers{ownerIndex: indexByOwner}); err != nil {
+			// the informer is shared with a Runnable which added the index
+			utilruntime.HandleError(err)
+		}
+		informer.AddEventHandler(c.handler(i))
+		c.informers = append(c.informers, informer)
+	}
+
+	return func(ctx context.Context) {
+		defer utilruntime.HandleCrash()
+		defer c.queue.ShutDown()
+
+		klog.Infof("Starting garbage collector")
+		defer klog.Infof("Shutting down garbage collector")
+		var synced []cache.InformerSynced
+		for _, informer := range c.informers {
+			synced = append(synced, informer.HasSynced)
+		}
+		if !cache.WaitForNamedCacheSync("garbagecollector", ctx.Done(), synced...) {
+			return
+		}
+
+		workers := gc.Workers
+		if workers < 1 {
+			workers = 1
+		}
+		wg := sync.WaitGroup{}
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				wait.UntilWithContext(ctx, func(ctx context.Context) {
+					for c.processNext(ctx) {
+					}
+				}, time.Second)
+			}()
+		}
+		<-ctx.Done()
+		// stop the workers waiting for items, then wait for the items being processed
+		c.queue.ShutDown()
+		wg.Wait()
+	}
+}
+
+// indexByOwner indexes objects by the UIDs of their owners.
+func indexByOwner(obj interface{}) ([]string, error) {
+	o, ok := obj.(metav1.Object)
+	if !ok {
+		return nil, nil
+	}
+	var uids []string
+	for _, ref := range o.GetOwnerReferences() {
+		uids = append(uids, string(ref.UID))
+	}
+	return uids, nil
+}
+
+// handler enqueues the objects of resource i, their owners and their dependents.
+//
+// Objects are processed when they are added or updated in case their owners have been deleted, and when they
+// are being deleted in case they are waiting on their dependents.  Deleting an object requires its owners to
+// be processed in case they are waiting on it, and its dependents to be processed in case it was their last
+// owner.
+func (c *collector) handler(i int) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.enqueue(i, obj)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			c.enqueue(i, obj)
+			c.enqueueOwners(obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			c.enqueueOwners(obj)
+			c.enqueueDependents(obj)
+		},
+	}
+}
+
+func (c *collector) enqueue(i int, obj interface{}) {
+	if o, ok := obj.(metav1.Object); ok {
+		c.queue.Add(item{resource: i, namespace: o.GetNamespace(), name: o.GetName()})
+	}
+}
+
+func (c *collector) enqueueOwners(obj interface{}) {
+	o, ok := obj.(metav1.Object)
+	if !ok {
+		return
+	}
+	for _, ref := range o.GetOwnerReferences() {
+		if i, found := c.resourceFor(ref); found {
+			c.queue.Add(item{resource: i, namespace: c.ownerNamespace(i, o), name: ref.Name})
+		}
+	}
+}
+
+func (c *collector) enqueueDependents(obj interface{}) {
+	o, ok := obj.(metav1.Object)
+	if !ok {
+		return
+	}
+	for i, d := range c.dependents(o.GetUID()) {
+		c.enqueue(i, d)
+	}
+}
+
+// dependents returns the objects owned by the object with uid, mapped to the indexes of their Resources.
+func (c *collector) dependents(uid types.UID) map[int][]*unstructured.Unstructured {
+	deps := map[int][]*unstructured.Unstructured{}
+	for i, informer := range c.informers {
+		objs, err := informer.GetIndexer().ByIndex(ownerIndex, string(uid))
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+		for _, obj := range objs {
+			deps[i] = append(deps[i], obj.(*unstructured.Unstructured))
+		}
+	}
+	return deps
+}
+
+// resourceFor returns the index of the Resource referred to by ref.
+func (c *collector) resourceFor(ref metav1.OwnerReference) (int, bool) {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return 0, false
+	}
+	i, found := c.kinds[gv.WithKind(ref.Kind).GroupKind()]
+	return i, found
+}
+
+// ownerNamespace returns the namespace of the owners of resource i of the dependent.  Namespaced objects may
+// only be owned by objects in the same namespace.
+func (c *collector) ownerNamespace(i int, dependent metav1.Object) string {
+	if c.resources[i].Namespaced {
+		return dependent.GetNamespace()
+	}
+	return ""
+}
+
+// processNext processes the next item in the queue.  processNext returns false when the queue is shut down.
+func (c *collector) processNext(ctx context.Context) bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.process(ctx, key.(item)); err != nil {
+		utilruntime.HandleError(fmt.Errorf("garbage collector failed to process %v: %v", key, err))
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+func (c *collector) process(ctx context.Context, it item) error {
+	key := it.name
+	if it.namespace != "" {
+		key = it.namespace + "/" + it.name
+	}
+	obj, exists, err := c.informers[it.resource].GetIndexer().GetByKey(key)
+	if err != nil || !exists {
+		// the dependents of deleted objects are enqueued when they are deleted
+		return err
+	}
+	u := obj.(*unstructured.Unstructured)
+	if u.GetDeletionTimestamp() != nil {
+		switch {
+		case hasFinalizer(u, metav1.FinalizerOrphanDependents):
+			return c.orphanDependents(ctx, it.resource, u)
+		case hasFinalizer(u, metav1.FinalizerDeleteDependents):
+			return c.deleteDependents(ctx, it.resource, u)
+		}
+		return nil
+	}
+	return c.collect(ctx, it.resource, u)
+}
+
+// orphanDependents removes the ownerReferences to the owner from its dependents, and then removes the orphan
+// finalizer from the owner.
+func (c *collector) orphanDependents(ctx context.Context, i int, owner *unstructured.Unstructured) error {
+	for j, deps := range c.dependents(owner.GetUID()) {
+		for _, d := range deps {
+			d = d.DeepCopy()
+			d.SetOwnerReferences(removeOwner(d.GetOwnerReferences(), owner.GetUID()))
+			if err := c.update(ctx, j, d); err != nil {
+				return err
+			}
+		}
+	}
+	return c.removeFinalizer(ctx, i, owner, metav1.FinalizerOrphanDependents)
+}
+
+// deleteDependents deletes the dependents of the owner in the foreground, and then removes the foreground
+// finalizer from the owner once none of its dependents block its deletion.
+func (c *collector) deleteDependents(ctx context.Context, i int, owner *unstructured.Unstructured) error {
+	foreground := metav1.DeletePropagationForeground
+	blocked := false
+	for j, deps := range c.dependents(owner.GetUID()) {
+		for _, d := range deps {
+			if d.GetDeletionTimestamp() == nil {
+				uid := d.GetUID()
+				err := c.resourceClient(j, d.GetNamespace()).Delete(ctx, d.GetName(), metav1.DeleteOptions{
+					PropagationPolicy: &foreground,
+					Preconditions:     &metav1.Preconditions{UID: &uid},
+				})
+				if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+					return err
+				}
+			}
+			for _, ref := range d.GetOwnerReferences() {
+				if ref.UID == owner.GetUID() && ref.BlockOwnerDeletion != nil && *ref.BlockOwnerDeletion {
+					blocked = true
+				}
+			}
+		}
+	}
+	if blocked {
+		// the owner is enqueued again as its dependents are deleted
+		return nil
+	}
+	return c.removeFinalizer(ctx, i, owner, metav1.FinalizerDeleteDependents)
+}
+
+// collect deletes the object if all of its owners have been deleted, and removes the ownerReferences to deleted
+// owners if some of its owners still exist.
+func (c *collector) collect(ctx context.Context, i int, obj *unstructured.Unstructured) error {
+	refs := obj.GetOwnerReferences()
+	if len(refs) == 0 {
+		return nil
+	}
+	var remaining []metav1.OwnerReference
+	for _, ref := range refs {
+		exists, err := c.ownerExists(ctx, obj, ref)
+		if err != nil {
+			return err
+		}
+		if exists {
+			remaining = append(remaining, ref)
+		}
+	}
+	if len(remaining) == len(refs) {
+		return nil
+	}
+	if len(remaining) > 0 {
+		obj = obj.DeepCopy()
+		obj.SetOwnerReferences(remaining)
+		return c.update(ctx, i, obj)
+	}
+
+	background := metav1.DeletePropagationBackground
+	uid := obj.GetUID()
+	err := c.resourceClient(i, obj.GetNamespace()).Delete(ctx, obj.GetName(), metav1.DeleteOptions{
+		PropagationPolicy: &background,
+		Preconditions:     &metav1.Preconditions{UID: &uid},
+	})
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		// the object was deleted, or replaced by a new object with the same name
+		return nil
+	}
+	return err
+}
+
+// ownerExists returns false if the owner referred to by ref has been deleted.  Owners of unknown resources are
+// assumed to exist.  The apiserver is checked for owners missing from the informer caches, which may not have
+// observed recently created owners.
+func (c *collector) ownerExists(ctx context.Context, dependent metav1.Object, ref metav1.OwnerReference) (
+	bool, error) {
+	i, found := c.resourceFor(ref)
+	if !found {
+		return true, nil
+	}
+	namespace := c.ownerNamespace(i, dependent)
+	key := ref.Name
+	if namespace != "" {
+		key = namespace + "/" + ref.Name
+	}
+	if obj, exists, err := c.informers[i].GetIndexer().GetByKey(key); err == nil && exists {
+		if obj.(metav1.Object).GetUID() == ref.UID {
+			return true, nil
+		}
+	}
+	owner, err := c.resourceClient(i, namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return owner.GetUID() == ref.UID, nil
+}
+
+func (c *collector) removeFinalizer(ctx context.Context, i int, obj *unstructured.Unstructured,
+	finalizer string) error {
+	obj = obj.DeepCopy()
+	var finalizers []string
+	for _, f := range obj.GetFinalizers() {
+		if f != finalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	obj.SetFinalizers(finalizers)
+	return c.update(ctx, i, obj)
+}
+
+// update updates the object.  Conflicts are returned so the object is processed again with its latest state.
+func (c *collector) update(ctx context.Context, i int, obj *unstructured.Unstructured) error {
+	_, err := c.resourceClient(i, obj.GetNamespace()).Update(ctx, obj, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (c *collector) resourceClient(i int, namespace string) dynamic.ResourceInterface {
+	r := c.client.Resource(c.resources[i].GroupVersionResource)
+	if c.resources[i].Namespaced {
+		return r.Namespace(namespace)
+	}
+	return r
+}
+
+func hasFinalizer(obj metav1.Object, finalizer string) bool {
+	for _, f := range obj.GetFinalizers() {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func removeOwner(refs []metav1.OwnerReference, uid types.UID) []metav1.OwnerReference {
+	var remaining []metav1.OwnerReference
+	for _, ref := range refs {
+		if ref.UID != uid {
+			remaining = append(remaining, ref)
+		}
+	}
+	return remaining
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package garbagecollector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+var (
+	flunders = schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "flunders"}
+	fischers = schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "fischers"}
+)
+
+func newObject(kind, name string, owners ...*unstructured.Unstructured) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion("example.com/v1")
+	u.SetKind(kind)
+	u.SetNamespace("default")
+	u.SetName(name)
+	u.SetUID(types.UID(kind + "-" + name))
+	var refs []metav1.OwnerReference
+	for _, o := range owners {
+		block := true
+		refs = append(refs, metav1.OwnerReference{
+			APIVersion: o.GetAPIVersion(), Kind: o.GetKind(), Name: o.GetName(), UID: o.GetUID(),
+			BlockOwnerDeletion: &block,
+		})
+	}
+	u.SetOwnerReferences(refs)
+	return u
+}
+
+// deleting returns the owner as it is stored while it is deleted with the finalizer of a propagation policy.
+func deleting(owner *unstructured.Unstructured, finalizer string) *unstructured.Unstructured {
+	now := metav1.Now()
+	owner.SetDeletionTimestamp(&now)
+	owner.SetFinalizers([]string{finalizer})
+	return owner
+}
+
+func run(objs ...runtime.Object) (*dynamicfake.FakeDynamicClient, func()) {
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), objs...)
+	gc := &GarbageCollector{Resources: []Resource{
+		{
+			GroupVersionResource: flunders,
+			Kinds:                []schema.GroupVersionKind{{Group: "example.com", Version: "v1", Kind: "Flunder"}},
+			Namespaced:           true,
+		},
+		{
+			GroupVersionResource: fischers,
+			Kinds: []schema.GroupVersionKind{
+				{Group: "example.com", Version: "v1", Kind: "Fischer"},
+				{Group: "example.com", Version: "v1beta1", Kind: "Fischer"},
+			},
+			Namespaced: true,
+		},
+	}}
+	informers := dynamicinformer.NewDynamicSharedInformerFactory(client, 0)
+	start := gc.Start(client, informers)
+	ctx, cancel := context.WithCancel(context.Background())
+	informers.Start(ctx.Done())
+	stopped := make(chan struct{})
+	go func() {
+		start(ctx)
+		close(stopped)
+	}()
+	return client, func() {
+		cancel()
+		<-stopped
+	}
+}
+
+func eventually(t *testing.T, msg string, condition func() bool) {
+	t.Helper()
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return condition(), nil
+	}); err != nil {
+		t.Fatal(msg)
+	}
+}
+
+func exists(client *dynamicfake.FakeDynamicClient, gvr schema.GroupVersionResource, name string) bool {
+	_, err := client.Resource(gvr).Namespace("default").Get(context.Background(), name, metav1.GetOptions{})
+	return !apierrors.IsNotFound(err)
+}
+
+func TestBackground(t *testing.T) {
+	owner := newObject("Flunder", "owner")
+	client, stop := run(owner,
+		newObject("Fischer", "dependent", owner),
+		newObject("Fischer", "unowned"),
+		newObject("Fischer", "external", newObject("Deployment", "external")))
+	defer stop()
+
+	if err := client.Resource(flunders).Namespace("default").Delete(
+		context.Background(), "owner", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "expected the dependent to be deleted", func() bool {
+		return !exists(client, fischers, "dependent")
+	})
+	if !exists(client, fischers, "unowned") || !exists(client, fischers, "external") {
+		t.Errorf("expected objects without known owners to be kept")
+	}
+}
+
+func TestDanglingOwners(t *testing.T) {
+	owner := newObject("Flunder", "owner")
+	// the Fischer kind is mapped for every version of the resource
+	deleted := newObject("Fischer", "deleted")
+	deleted.SetAPIVersion("example.com/v1beta1")
+	client, stop := run(owner,
+		newObject("Fischer", "orphaned", deleted),
+		newObject("Fischer", "partial", owner, deleted))
+	defer stop()
+
+	eventually(t, "expected the object without owners to be deleted", func() bool {
+		return !exists(client, fischers, "orphaned")
+	})
+	eventually(t, "expected the reference to the deleted owner to be removed", func() bool {
+		u, err := client.Resource(fischers).Namespace("default").Get(context.Background(), "partial", metav1.GetOptions{})
+		return err == nil && len(u.GetOwnerReferences()) == 1 && u.GetOwnerReferences()[0].UID == owner.GetUID()
+	})
+}
+
+func TestOrphan(t *testing.T) {
+	owner := newObject("Flunder", "owner")
+	dependent := newObject("Fischer", "dependent", owner)
+	client, stop := run(deleting(owner, metav1.FinalizerOrphanDependents), dependent)
+	defer stop()
+
+	eventually(t, "expected the orphan finalizer to be removed", func() bool {
+		u, err := client.Resource(flunders).Namespace("default").Get(context.Background(), "owner", metav1.GetOptions{})
+		return err == nil && len(u.GetFinalizers()) == 0
+	})
+	u, err := client.Resource(fischers).Namespace("default").Get(context.Background(), "dependent", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.GetOwnerReferences()) != 0 {
+		t.Errorf("expected the dependent to be orphaned, got %v", u.GetOwnerReferences())
+	}
+}
+
+func TestForeground(t *testing.T) {
+	owner := newObject("Flunder", "owner")
+	client, stop := run(deleting(owner, metav1.FinalizerDeleteDependents),
+		newObject("Fischer", "one", owner),
+		newObject("Fischer", "two", owner))
+	defer stop()
+
+	eventually(t, "expected the dependents to be deleted", func() bool {
+		return !exists(client, fischers, "one") && !exists(client, fischers, "two")
+	})
+	eventually(t, "expected the foreground finalizer to be removed", func() bool {
+		u, err := client.Resource(flunders).Namespace("default").Get(context.Background(), "owner", metav1.GetOptions{})
+		return err == nil && len(u.GetFinalizers()) == 0
+	})
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package structtag walks the fields of resource types for the packages reading rules from their struct tags,
+// and names the OpenAPI definitions of the types.
+package structtag
+
+import (
+	"reflect"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	openapiutil "k8s.io/kube-openapi/pkg/util"
+)
+
+// Wildcard matches the elements of a list or the values of a map in a json path.
+const Wildcard = "[*]"
+
+// JSONName returns the json name of field f, or empty if f is inlined.  ok is false if f is not serialized --
+// e.g. f is unexported or has a `json:"-"` tag.
+func JSONName(f reflect.StructField) (name string, ok bool) {
+	if f.PkgPath != "" {
+		return "", false
+	}
+	tag := f.Tag.Get("json")
+	name = strings.Split(tag, ",")[0]
+	if name == "-" {
+		return "", false
+	}
+	if f.Anonymous && (name == "" || strings.Contains(tag, ",inline")) {
+		return "", true
+	}
+	if name == "" {
+		return f.Name, true
+	}
+	return name, true
+}
+
+// TypeName returns the name of the OpenAPI definition of t, or of the type t points to -- e.g.
+// k8s.io/api/core/v1.Pod for *v1.Pod.
+func TypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return openapiutil.GetCanonicalTypeName(reflect.New(t).Interface())
+}
+
+// Structs calls fn for t, if it is a struct, and for each struct type contained by its serialized fields --
+// including the elements of pointers, slices, arrays and maps.  fn is called once for each type, with the go
+// path of its first occurrence under path.
+func Structs(t reflect.Type, path *field.Path, fn func(t reflect.Type, path *field.Path)) {
+	structs(t, path, map[reflect.Type]bool{}, fn)
+}
+
+func structs(t reflect.Type, path *field.Path, seen map[reflect.Type]bool, fn func(t reflect.Type, path *field.Path)) {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		structs(t.Elem(), path, seen, fn)
+	case reflect.Struct:
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		fn(t, path)
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if _, ok := JSONName(sf); ok {
+				structs(sf.Type, path.Child(sf.Name), seen, fn)
+			}
+		}
+	}
+}
+
+// Fields calls fn for each serialized field of struct t, and of the structs it contains, with the json path of
+// the field -- e.g. "spec.volumes[*].name" for the name of the structs in the volumes list.  The fields of
+// inlined structs are passed with the path of the struct containing them.  The fields of recursive types are
+// only passed for their outermost occurrence.
+func Fields(t reflect.Type, fn func(f reflect.StructField, path string)) {
+	fields(t, "", map[reflect.Type]bool{}, fn)
+}
+
+func fields(t reflect.Type, prefix string, parents map[reflect.Type]bool, fn func(reflect.StructField, string)) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || parents[t] {
+		return
+	}
+	parents[t] = true
+	defer delete(parents, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, ok := JSONName(sf)
+		if !ok {
+			continue
+		}
+		path := prefix
+		if name != "" {
+			path = join(prefix, name)
+			fn(sf, path)
+		}
+
+		// the elements of lists and the values of maps are matched by wildcards
+		ft := sf.Type
+		for {
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() != reflect.Slice && ft.Kind() != reflect.Array && ft.Kind() != reflect.Map {
+				break
+			}
+			ft = ft.Elem()
+			path += Wildcard
+		}
+		fields(ft, path, parents, fn)
+	}
+}
+
+func join(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
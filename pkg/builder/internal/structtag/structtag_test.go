@@ -0,0 +1,71 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package structtag
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+type Volume struct {
+	metav1.TypeMeta `json:",inline"`
+	Spec            VolumeSpec `json:"spec,omitempty"`
+}
+
+type VolumeSpec struct {
+	Name    string            `json:"name"`
+	Mounts  []Mount           `json:"mounts,omitempty"`
+	Matrix  [][]Mount         `json:"matrix,omitempty"`
+	Labels  map[string]*Mount `json:"labels,omitempty"`
+	Next    *VolumeSpec       `json:"next,omitempty"`
+	Cache   Mount             `json:"-"`
+	Default string            `json:",omitempty"`
+	private string
+}
+
+type Mount struct {
+	Path string `json:"path"`
+}
+
+func TestFields(t *testing.T) {
+	var paths []string
+	Fields(reflect.TypeOf(&Volume{}), func(f reflect.StructField, path string) {
+		paths = append(paths, path)
+	})
+	expected := []string{
+		"kind", "apiVersion", "spec", "spec.name", "spec.mounts", "spec.mounts[*].path", "spec.matrix",
+		"spec.matrix[*][*].path", "spec.labels", "spec.labels[*].path", "spec.next", "spec.Default",
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+}
+
+func TestStructs(t *testing.T) {
+	var paths []string
+	Structs(reflect.TypeOf(&Volume{}), field.NewPath("Volume"), func(t reflect.Type, path *field.Path) {
+		paths = append(paths, t.Name()+" "+path.String())
+	})
+	expected := []string{"Volume Volume", "TypeMeta Volume.TypeMeta", "VolumeSpec Volume.Spec",
+		"Mount Volume.Spec.Mounts"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+}
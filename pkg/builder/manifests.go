@@ -0,0 +1,325 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// ManifestOptions configures the manifests deploying the apiserver as an aggregated apiserver.
+type ManifestOptions struct {
+	// Name is the name of the Deployment, Service and ServiceAccount of the apiserver, and the prefix of the
+	// names of its RBAC objects.  Defaults to the first label of the API group.
+	Name string
+	// Namespace is the namespace the apiserver is deployed to.  Defaults to Name.
+	Namespace string
+	// Image is the image of the apiserver.  Defaults to Name:latest.
+	Image string
+	// EtcdServers are the etcd servers of the apiserver.  If empty, etcd is run as a sidecar of the apiserver.
+	EtcdServers string
+	// GroupPriorityMinimum is the priority of the API groups in discovery.
+	GroupPriorityMinimum int32
+	// CABundle is the CA bundle used to verify the serving certificate of the apiserver.  The serving certificate
+	// is not verified if CABundle is empty.
+	CABundle []byte
+}
+
+// complete defaults the options from the API group.
+func (o *ManifestOptions) complete(group string) {
+	if o.Name == "" {
+		o.Name = strings.Split(group, ".")[0]
+	}
+	if o.Namespace == "" {
+		o.Namespace = o.Name
+	}
+	if o.Image == "" {
+		o.Image = o.Name + ":latest"
+	}
+	if o.GroupPriorityMinimum == 0 {
+		o.GroupPriorityMinimum = 1000
+	}
+}
+
+// servicePort is the port of the Service of the apiserver.
+var servicePort int32 = 443
+
+// The verbs granted by the aggregated ClusterRoles.
+var (
+	readVerbs  = []string{"get", "list", "watch"}
+	writeVerbs = []string{"create", "update", "patch", "delete", "deletecollection"}
+)
+
+// Manifests returns the objects deploying the apiserver as an aggregated apiserver: an APIService for each
+// served GroupVersion, a Deployment, Service and ServiceAccount, the RBAC to delegate authentication and
+// authorization to the kube-apiserver, and ClusterRoles aggregated to the view, edit and admin ClusterRoles.
+//
+// The view ClusterRole reads every resource and subresource.  The edit ClusterRole writes every resource and
+// subresource except status, and the admin ClusterRole writes status as well.
+func (a *Server) Manifests(o ManifestOptions) []runtime.Object {
+	o.complete(a.group)
+	labels := map[string]string{"apiserver": o.Name}
+	sa := rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: o.Name, Namespace: o.Namespace}
+
+	// the admission plugins of the apiserver watch namespaces and webhook configurations
+	rules := []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: readVerbs},
+		{
+			APIGroups: []string{"admissionregistration.k8s.io"},
+			Resources: []string{"mutatingwebhookconfigurations", "validatingwebhookconfigurations"},
+			Verbs:     readVerbs,
+		},
+	}
+	if a.registerAPIs != nil {
+		// the apiserver registers its APIServices when started
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{apiregistrationv1.GroupName},
+			Resources: []string{"apiservices"},
+			Verbs:     []string{"get", "create", "update", "delete"},
+		})
+	}
+
+	objects := []runtime.Object{
+		&corev1.Namespace{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+			ObjectMeta: metav1.ObjectMeta{Name: o.Namespace},
+		},
+		&corev1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: metav1.ObjectMeta{Name: o.Name, Namespace: o.Namespace},
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: o.Name + ":system:auth-delegator"},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "system:auth-delegator"},
+			Subjects:   []rbacv1.Subject{sa},
+		},
+		&rbacv1.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: o.Name + "-auth-reader", Namespace: metav1.NamespaceSystem},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName, Kind: "Role", Name: "extension-apiserver-authentication-reader"},
+			Subjects: []rbacv1.Subject{sa},
+		},
+		&rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{Name: o.Name + ":apiserver"},
+			Rules:      rules,
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: o.Name + ":apiserver"},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: o.Name + ":apiserver"},
+			Subjects:   []rbacv1.Subject{sa},
+		},
+	}
+	objects = append(objects, a.aggregatedClusterRoles(o.Name)...)
+	objects = append(objects,
+		&corev1.Service{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+			ObjectMeta: metav1.ObjectMeta{Name: o.Name, Namespace: o.Namespace},
+			Spec: corev1.ServiceSpec{
+				Ports:    []corev1.ServicePort{{Port: servicePort, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromInt(443)}},
+				Selector: labels,
+			},
+		},
+		a.deployment(o, labels))
+	for _, s := range a.apiServices(o) {
+		objects = append(objects, s)
+	}
+	return objects
+}
+
+// apiServices returns an APIService for each GroupVersion served by the apiserver.  Versions are prioritized
+// in the order they were registered, with deprecated versions last.
+func (a *Server) apiServices(o ManifestOptions) []*apiregistrationv1.APIService {
+	versions := map[string]int{}
+	for _, gv := range a.prioritizedGroupVersions() {
+		if gv.Group != "" {
+			versions[gv.Group]++
+		}
+	}
+	var services []*apiregistrationv1.APIService
+	priorities := map[string]int{}
+	for _, gv := range a.prioritizedGroupVersions() {
+		if gv.Group == "" {
+			continue
+		}
+		s := &apiregistrationv1.APIService{
+			TypeMeta: metav1.TypeMeta{APIVersion: apiregistrationv1.SchemeGroupVersion.String(), Kind: "APIService"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   gv.Version + "." + gv.Group,
+				Labels: map[string]string{"apiserver": o.Name},
+			},
+			Spec: apiregistrationv1.APIServiceSpec{
+				Group:                 gv.Group,
+				Version:               gv.Version,
+				Service:               &apiregistrationv1.ServiceReference{Name: o.Name, Namespace: o.Namespace, Port: &servicePort},
+				GroupPriorityMinimum:  o.GroupPriorityMinimum,
+				VersionPriority:       int32(15 * (versions[gv.Group] - priorities[gv.Group])),
+				CABundle:              o.CABundle,
+				InsecureSkipTLSVerify: len(o.CABundle) == 0,
+			},
+		}
+		priorities[gv.Group]++
+		services = append(services, s)
+	}
+	return services
+}
+
+// aggregatedClusterRoles returns the ClusterRoles aggregated to the view, edit and admin ClusterRoles.
+func (a *Server) aggregatedClusterRoles(name string) []runtime.Object {
+	resources := map[string]map[string]bool{}
+	add := func(group, resource string) {
+		if resources[group] == nil {
+			resources[group] = map[string]bool{}
+		}
+		resources[group][resource] = true
+	}
+	for _, r := range a.registrations {
+		add(r.gvr.Group, r.gvr.Resource)
+	}
+	var groups []string
+	for g := range resources {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+
+	var view, edit, admin []rbacv1.PolicyRule
+	for _, g := range groups {
+		var all, writable []string
+		for r := range resources[g] {
+			all = append(all, r)
+			if !strings.HasSuffix(r, "/status") {
+				writable = append(writable, r)
+			}
+		}
+		sort.Strings(all)
+		sort.Strings(writable)
+		view = append(view, rbacv1.PolicyRule{APIGroups: []string{g}, Resources: all, Verbs: readVerbs})
+		edit = append(edit, rbacv1.PolicyRule{APIGroups: []string{g}, Resources: writable, Verbs: writeVerbs})
+		admin = append(admin, rbacv1.PolicyRule{APIGroups: []string{g}, Resources: all, Verbs: writeVerbs})
+	}
+
+	role := func(aggregateTo string, rules []rbacv1.PolicyRule) runtime.Object {
+		return &rbacv1.ClusterRole{
+			TypeMeta: metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name + ":aggregate-to-" + aggregateTo,
+				Labels: map[string]string{"rbac.authorization.k8s.io/aggregate-to-" + aggregateTo: "true"},
+			},
+			Rules: rules,
+		}
+	}
+	return []runtime.Object{role("view", view), role("edit", edit), role("admin", admin)}
+}
+
+// deployment returns a Deployment running the apiserver, with an etcd sidecar unless EtcdServers is set.
+func (a *Server) deployment(o ManifestOptions, labels map[string]string) runtime.Object {
+	etcd := o.EtcdServers
+	if etcd == "" {
+		etcd = "http://localhost:2379"
+	}
+	containers := []corev1.Container{{
+		Name:  "apiserver",
+		Image: o.Image,
+		Args:  []string{"--etcd-servers=" + etcd},
+		Ports: []corev1.ContainerPort{{ContainerPort: 443, Protocol: corev1.ProtocolTCP}},
+	}}
+	if o.EtcdServers == "" {
+		containers = append(containers, corev1.Container{Name: "etcd", Image: "quay.io/coreos/etcd:v3.4.9"})
+	}
+	replicas := int32(1)
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: appsv1.SchemeGroupVersion.String(), Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: o.Name, Namespace: o.Namespace, Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       corev1.PodSpec{ServiceAccountName: o.Name, Containers: containers},
+			},
+		},
+	}
+}
+
+// WriteManifests writes the Manifests to out as a multi-document YAML stream.
+func (a *Server) WriteManifests(out io.Writer, o ManifestOptions) error {
+	for i, obj := range a.Manifests(o) {
+		b, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if _, err := fmt.Fprintln(out, "---"); err != nil {
+				return err
+			}
+		}
+		if _, err := out.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newManifestsCommand returns the 'manifests' command which prints the manifests deploying the apiserver.
+func (a *Server) newManifestsCommand() *Command {
+	o := ManifestOptions{}
+	var caBundleFile string
+	cmd := &Command{
+		Use:   "manifests",
+		Short: "Print the manifests deploying the apiserver as an aggregated apiserver",
+		Long: "Print the APIServices, Deployment, Service, RBAC and aggregated ClusterRoles deploying the " +
+			"apiserver as an aggregated apiserver of a kube-apiserver, derived from the served resources.",
+		RunE: func(c *Command, args []string) error {
+			if caBundleFile != "" {
+				b, err := ioutil.ReadFile(caBundleFile)
+				if err != nil {
+					return err
+				}
+				o.CABundle = b
+			}
+			return a.WriteManifests(c.OutOrStdout(), o)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&o.Name, "name", o.Name,
+		"Name of the apiserver Deployment, Service and ServiceAccount.  Defaults to the first label of the API group.")
+	flags.StringVar(&o.Namespace, "namespace", o.Namespace, "Namespace of the apiserver.  Defaults to --name.")
+	flags.StringVar(&o.Image, "image", o.Image, "Image of the apiserver.  Defaults to <name>:latest.")
+	flags.StringVar(&o.EtcdServers, "etcd-servers", o.EtcdServers,
+		"The etcd servers of the apiserver.  If empty, etcd is run as a sidecar of the apiserver.")
+	flags.Int32Var(&o.GroupPriorityMinimum, "group-priority-minimum", 1000,
+		"The priority of the API groups in discovery.")
+	flags.StringVar(&caBundleFile, "ca-bundle-file", caBundleFile,
+		"File containing the CA bundle which signed the serving certificate of the apiserver.  The serving "+
+			"certificate is not verified if empty.")
+	return cmd
+}
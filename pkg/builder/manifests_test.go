@@ -0,0 +1,108 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/apiservice"
+	appsv1 "k8s.io/api/apps/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+)
+
+func TestManifests(t *testing.T) {
+	v1alpha1 := schema.GroupVersion{Group: testGroupVersion.Group, Version: "v1alpha1"}
+	a := newTestServer().WithResource(&Widget{})
+	a.withGroupVersions(v1alpha1)
+	a.deprecatedVersions = map[schema.GroupVersion]apiLifecycle{
+		v1alpha1: {deprecatedMajor: 1, deprecatedMinor: 20},
+	}
+	a.registrations = append(a.registrations, registration{gvr: testGroupVersion.WithResource("widgets/status")})
+
+	services := map[string]apiregistrationv1.APIServiceSpec{}
+	roles := map[string][]rbacv1.PolicyRule{}
+	var deployment *appsv1.Deployment
+	for _, obj := range a.Manifests(ManifestOptions{}) {
+		switch o := obj.(type) {
+		case *apiregistrationv1.APIService:
+			services[o.Name] = o.Spec
+		case *rbacv1.ClusterRole:
+			roles[o.Name] = o.Rules
+		case *appsv1.Deployment:
+			deployment = o
+		}
+	}
+
+	if len(services) != 2 {
+		t.Fatalf("expected an APIService per version, got %v", services)
+	}
+	v1 := services["v1.test.example.com"]
+	if v1.VersionPriority <= services["v1alpha1.test.example.com"].VersionPriority {
+		t.Errorf("expected the deprecated version to have a lower priority, got %v", services)
+	}
+	if v1.Service.Name != "test" || v1.Service.Namespace != "test" || v1.GroupPriorityMinimum != 1000 ||
+		!v1.InsecureSkipTLSVerify {
+		t.Errorf("expected the APIService to be defaulted, got %+v", v1)
+	}
+
+	expected := map[string][]rbacv1.PolicyRule{
+		"test:aggregate-to-view": {{APIGroups: []string{"test.example.com"},
+			Resources: []string{"widgets", "widgets/status"}, Verbs: readVerbs}},
+		"test:aggregate-to-edit": {{APIGroups: []string{"test.example.com"},
+			Resources: []string{"widgets"}, Verbs: writeVerbs}},
+		"test:aggregate-to-admin": {{APIGroups: []string{"test.example.com"},
+			Resources: []string{"widgets", "widgets/status"}, Verbs: writeVerbs}},
+	}
+	for name, rules := range expected {
+		if !reflect.DeepEqual(roles[name], rules) {
+			t.Errorf("expected %s to have rules %v, got %v", name, rules, roles[name])
+		}
+	}
+
+	if deployment == nil || len(deployment.Spec.Template.Spec.Containers) != 2 ||
+		deployment.Spec.Template.Spec.Containers[0].Image != "test:latest" {
+		t.Errorf("expected a Deployment running the apiserver with an etcd sidecar, got %v", deployment)
+	}
+
+	out := &bytes.Buffer{}
+	if err := a.WriteManifests(out, ManifestOptions{Namespace: "apis", EtcdServers: "https://etcd:2379"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"kind: APIService", "namespace: apis", "--etcd-servers=https://etcd:2379"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("expected the manifests to contain %q, got %s", s, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "quay.io/coreos/etcd") {
+		t.Errorf("expected no etcd sidecar when --etcd-servers is set")
+	}
+
+	a.WithAPIServiceRegistration(apiservice.Config{})
+	for _, obj := range a.Manifests(ManifestOptions{}) {
+		if o, ok := obj.(*rbacv1.ClusterRole); ok && o.Name == "test:apiserver" {
+			last := o.Rules[len(o.Rules)-1]
+			if !reflect.DeepEqual(last.Resources, []string{"apiservices"}) {
+				t.Errorf("expected the apiserver to be allowed to register its APIServices, got %v", o.Rules)
+			}
+		}
+	}
+}
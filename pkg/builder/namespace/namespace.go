@@ -0,0 +1,275 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package namespace serves a minimal core/v1 namespaces resource for apiservers running without a kube-apiserver.
+//
+// Namespaces are created Active with a finalizer.  Deleting a namespace marks it Terminating, which the
+// NamespaceLifecycle admission plugin uses to reject new objects in the namespace, and the namespace controller
+// deletes the objects in the namespace before removing the finalizer so the namespace is deleted.
+package namespace
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/controller"
+	builderrest "github.com/pwittrock/apiserver-runtime/pkg/builder/rest"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/registry/generic"
+	genericregistry "k8s.io/apiserver/pkg/registry/generic/registry"
+	"k8s.io/apiserver/pkg/registry/rest"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Finalizer is the finalizer removed from namespaces by the namespace controller once their contents are deleted.
+const Finalizer = "apiserver-runtime/namespace"
+
+// GroupVersionResource is the namespaces resource.
+var GroupVersionResource = corev1.SchemeGroupVersion.WithResource("namespaces")
+
+// DefaultNamespaces are the namespaces created when the apiserver starts.
+var DefaultNamespaces = []string{metav1.NamespaceDefault, metav1.NamespaceSystem, metav1.NamespacePublic}
+
+// AddToScheme adds the namespace types to the scheme.  The core/v1 types are also registered as the internal
+// version so they are stored without conversion.
+func AddToScheme(s *runtime.Scheme) error {
+	internal := schema.GroupVersion{Group: corev1.GroupName, Version: runtime.APIVersionInternal}
+	s.AddKnownTypes(corev1.SchemeGroupVersion, &corev1.Namespace{}, &corev1.NamespaceList{})
+	s.AddKnownTypes(internal, &corev1.Namespace{}, &corev1.NamespaceList{})
+	return s.SetVersionPriority(corev1.SchemeGroupVersion)
+}
+
+// New returns a StorageProvider for namespaces which stores them in etcd.
+func New(scheme *runtime.Scheme, optsGetter generic.RESTOptionsGetter) (rest.Storage, error) {
+	s := strategy{DefaultStrategy: builderrest.DefaultStrategy{
+		Object:         &corev1.Namespace{},
+		ObjectTyper:    scheme,
+		TableConvertor: rest.NewDefaultTableConvertor(GroupVersionResource.GroupResource()),
+		GroupResource:  GroupVersionResource.GroupResource(),
+	}}
+	store := &genericregistry.Store{
+		NewFunc:                  func() runtime.Object { return &corev1.Namespace{} },
+		NewListFunc:              func() runtime.Object { return &corev1.NamespaceList{} },
+		PredicateFunc:            s.Match,
+		DefaultQualifiedResource: GroupVersionResource.GroupResource(),
+		TableConvertor:           s,
+		CreateStrategy:           s,
+		UpdateStrategy:           s,
+		DeleteStrategy:           s,
+	}
+	options := &generic.StoreOptions{RESTOptions: optsGetter, AttrFunc: storage.DefaultClusterScopedAttr}
+	if err := store.CompleteWithOptions(options); err != nil {
+		return nil, err
+	}
+	return &REST{Store: store}, nil
+}
+
+// REST stores namespaces.
+type REST struct {
+	*genericregistry.Store
+}
+
+// terminatingKey is set in the context of the update marking a deleted namespace as Terminating.
+type terminatingKey struct{}
+
+// Delete deletes the namespace, marking it as Terminating until the namespace controller has deleted its contents.
+func (r *REST) Delete(ctx context.Context, name string, deleteValidation rest.ValidateObjectFunc,
+	options *metav1.DeleteOptions) (runtime.Object, bool, error) {
+	obj, deleted, err := r.Store.Delete(ctx, name, deleteValidation, options)
+	if err != nil || deleted {
+		return obj, deleted, err
+	}
+	return r.Store.Update(context.WithValue(ctx, terminatingKey{}, true), name,
+		rest.DefaultUpdatedObjectInfo(nil, func(_ context.Context, _, old runtime.Object) (runtime.Object, error) {
+			return old.DeepCopyObject(), nil
+		}),
+		rest.ValidateAllObjectFunc, rest.ValidateAllObjectUpdateFunc, false, &metav1.UpdateOptions{})
+}
+
+// strategy is the Strategy for namespaces.
+type strategy struct {
+	builderrest.DefaultStrategy
+}
+
+// NamespaceScoped returns false because namespaces are cluster scoped.
+func (strategy) NamespaceScoped() bool {
+	return false
+}
+
+// PrepareForCreate makes the namespace Active and adds the Finalizer.
+func (s strategy) PrepareForCreate(ctx context.Context, obj runtime.Object) {
+	ns := obj.(*corev1.Namespace)
+	ns.Status = corev1.NamespaceStatus{Phase: corev1.NamespaceActive}
+	if !hasFinalizer(ns) {
+		ns.Finalizers = append(ns.Finalizers, Finalizer)
+	}
+}
+
+// PrepareForUpdate keeps the status of the namespace, which is only changed when the namespace is deleted.
+func (s strategy) PrepareForUpdate(ctx context.Context, obj, old runtime.Object) {
+	ns := obj.(*corev1.Namespace)
+	ns.Status = old.(*corev1.Namespace).Status
+	if terminating, _ := ctx.Value(terminatingKey{}).(bool); terminating {
+		ns.Status.Phase = corev1.NamespaceTerminating
+	}
+}
+
+// Validate validates the name of the namespace.
+func (strategy) Validate(ctx context.Context, obj runtime.Object) field.ErrorList {
+	return validateName(obj.(*corev1.Namespace).Name)
+}
+
+// ValidateUpdate validates the name of the namespace.
+func (strategy) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	return validateName(obj.(*corev1.Namespace).Name)
+}
+
+// Match returns a SelectionPredicate for the labels and fields of namespaces.
+func (strategy) Match(label labels.Selector, field fields.Selector) storage.SelectionPredicate {
+	return storage.SelectionPredicate{Label: label, Field: field, GetAttrs: storage.DefaultClusterScopedAttr}
+}
+
+func validateName(name string) field.ErrorList {
+	var errs field.ErrorList
+	for _, msg := range validation.IsDNS1123Label(name) {
+		errs = append(errs, field.Invalid(field.NewPath("metadata", "name"), name, msg))
+	}
+	return errs
+}
+
+func hasFinalizer(obj metav1.Object) bool {
+	for _, f := range obj.GetFinalizers() {
+		if f == Finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// Reconcile returns a function reconciling namespaces which deletes the objects of the namespaced resources in
+// deleted namespaces, and then removes the Finalizer so the namespace is deleted.
+func Reconcile(resources []schema.GroupVersionResource) controller.ReconcileFunc {
+	return func(ctx context.Context, req controller.Request) error {
+		obj, err := req.Lister.Get(req.Name)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ns := obj.(*unstructured.Unstructured)
+		if ns.GetDeletionTimestamp() == nil || !hasFinalizer(ns) {
+			return nil
+		}
+
+		remaining := false
+		background := metav1.DeletePropagationBackground
+		for _, gvr := range resources {
+			c := req.Client.Resource(gvr).Namespace(req.Name)
+			list, err := c.List(ctx, metav1.ListOptions{})
+			if apierrors.IsNotFound(err) || apierrors.IsMethodNotSupported(err) {
+				// resources served by handlers may not be listable
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			for _, item := range list.Items {
+				if item.GetDeletionTimestamp() != nil {
+					remaining = true
+					continue
+				}
+				err := c.Delete(ctx, item.GetName(), metav1.DeleteOptions{PropagationPolicy: &background})
+				switch {
+				case err == nil:
+					remaining = true
+				case !apierrors.IsNotFound(err) && !apierrors.IsMethodNotSupported(err):
+					return err
+				}
+			}
+		}
+		if remaining {
+			// the namespace is reconciled again until the deleted objects are gone, which may take a while for
+			// objects with finalizers
+			return fmt.Errorf("waiting for the objects in namespace %s to be deleted", req.Name)
+		}
+
+		ns = ns.DeepCopy()
+		var finalizers []string
+		for _, f := range ns.GetFinalizers() {
+			if f != Finalizer {
+				finalizers = append(finalizers, f)
+			}
+		}
+		ns.SetFinalizers(finalizers)
+		_, err = req.Client.Resource(GroupVersionResource).Update(ctx, ns, metav1.UpdateOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+}
+
+// LoopbackInformers configures the admission plugins to use clients and informers for the apiserver itself
+// rather than a kube-apiserver -- e.g. so the NamespaceLifecycle admission plugin watches the namespaces served
+// by the apiserver.  LoopbackInformers is used as the ExtraAdmissionInitializers of the RecommendedOptions, which
+// are called after the loopback config is created and before the admission plugins are initialized.
+func LoopbackInformers(c *genericapiserver.RecommendedConfig) ([]admission.PluginInitializer, error) {
+	client, err := kubernetes.NewForConfig(c.LoopbackClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	c.ClientConfig = c.LoopbackClientConfig
+	c.SharedInformerFactory = informers.NewSharedInformerFactory(client, 10*time.Minute)
+	return nil, nil
+}
+
+// CreateDefaultNamespaces returns an apiserver post-start hook which creates the DefaultNamespaces if they do not
+// exist.
+func CreateDefaultNamespaces() genericapiserver.PostStartHookFunc {
+	return func(hookContext genericapiserver.PostStartHookContext) error {
+		client, err := kubernetes.NewForConfig(hookContext.LoopbackClientConfig)
+		if err != nil {
+			return err
+		}
+		return wait.PollImmediateUntil(time.Second, func() (bool, error) {
+			for _, name := range DefaultNamespaces {
+				_, err := client.CoreV1().Namespaces().Create(context.Background(),
+					&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}, metav1.CreateOptions{})
+				if err != nil && !apierrors.IsAlreadyExists(err) {
+					// the apiserver may not be ready to serve requests yet
+					return false, nil
+				}
+			}
+			return true, nil
+		}, hookContext.StopCh)
+	}
+}
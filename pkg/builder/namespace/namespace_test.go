@@ -0,0 +1,114 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespace
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/controller"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+var flunders = schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "flunders"}
+
+func TestStrategy(t *testing.T) {
+	s := strategy{}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "example"}}
+	s.PrepareForCreate(context.Background(), ns)
+	if ns.Status.Phase != corev1.NamespaceActive || !reflect.DeepEqual(ns.Finalizers, []string{Finalizer}) {
+		t.Errorf("expected an Active namespace with the finalizer, got %+v", ns)
+	}
+
+	updated := ns.DeepCopy()
+	updated.Status.Phase = corev1.NamespaceTerminating
+	s.PrepareForUpdate(context.Background(), updated, ns)
+	if updated.Status.Phase != corev1.NamespaceActive {
+		t.Errorf("expected the status to be kept, got %v", updated.Status.Phase)
+	}
+	s.PrepareForUpdate(context.WithValue(context.Background(), terminatingKey{}, true), updated, ns)
+	if updated.Status.Phase != corev1.NamespaceTerminating {
+		t.Errorf("expected the deleted namespace to be Terminating, got %v", updated.Status.Phase)
+	}
+
+	if errs := s.Validate(context.Background(), &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "Not.A.Label"}}); len(errs) != 1 {
+		t.Errorf("expected an invalid name, got %v", errs)
+	}
+}
+
+func newObject(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion(apiVersion)
+	u.SetKind(kind)
+	u.SetNamespace(namespace)
+	u.SetName(name)
+	return u
+}
+
+func TestReconcile(t *testing.T) {
+	ns := newObject("v1", "Namespace", "", "example")
+	ns.SetFinalizers([]string{Finalizer})
+	now := metav1.Now()
+	ns.SetDeletionTimestamp(&now)
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), ns,
+		newObject("example.com/v1", "Flunder", "example", "one"),
+		newObject("example.com/v1", "Flunder", "other", "two"))
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := indexer.Add(ns); err != nil {
+		t.Fatal(err)
+	}
+	req := controller.Request{
+		NamespacedName: types.NamespacedName{Name: "example"},
+		Client:         client,
+		Lister:         cache.NewGenericLister(indexer, GroupVersionResource.GroupResource()),
+	}
+	reconcile := Reconcile([]schema.GroupVersionResource{flunders})
+	// the namespace is reconciled again once its objects are deleted
+	if err := reconcile(context.Background(), req); err == nil {
+		t.Fatalf("expected to wait for the objects to be deleted")
+	}
+	if err := reconcile(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	for namespace, expected := range map[string]int{"example": 0, "other": 1} {
+		list, err := client.Resource(flunders).Namespace(namespace).List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list.Items) != expected {
+			t.Errorf("expected %d flunders in %s, got %d", expected, namespace, len(list.Items))
+		}
+	}
+	u, err := client.Resource(GroupVersionResource).Get(context.Background(), "example", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.GetFinalizers()) != 0 {
+		t.Errorf("expected the finalizer to be removed, got %v", u.GetFinalizers())
+	}
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openapi
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/go-openapi/spec"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/internal/structtag"
+	generatedopenapi "github.com/pwittrock/apiserver-runtime/pkg/generated/openapi"
+	"k8s.io/kube-openapi/pkg/common"
+	openapiutil "k8s.io/kube-openapi/pkg/util"
+)
+
+// apimachineryPrefix is the prefix of the definitions for the types shared by all apiservers -- e.g. ObjectMeta.
+const apimachineryPrefix = "k8s.io/apimachinery/"
+
+// SchemaFn may be used to modify the schema derived for a go struct field.  SchemaFns are invoked for each
+// field of each struct after the field's schema has been derived.
+type SchemaFn func(field reflect.StructField, schema *spec.Schema)
+
+// openAPISchemaType is implemented by types which are serialized as a primitive -- e.g. metav1.Time.
+type openAPISchemaType interface {
+	OpenAPISchemaType() []string
+	OpenAPISchemaFormat() string
+}
+
+// openAPIDefinitionGetter is implemented by types which provide their own OpenAPI definition.
+type openAPIDefinitionGetter interface {
+	OpenAPIDefinition() common.OpenAPIDefinition
+}
+
+// DefinitionsFor returns OpenAPI definitions derived from the go types of the objects and every type they
+// reference, using the same names and json conventions as openapi-gen.  Definitions for the types shared
+// by all apiservers, such as ObjectMeta, are included.
+//
+// DefinitionsFor may be used for resources which do not have definitions generated by openapi-gen.
+func DefinitionsFor(objs []interface{}, fns ...SchemaFn) common.GetOpenAPIDefinitions {
+	return func(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+		d := &deriver{ref: ref, fns: fns, defs: map[string]common.OpenAPIDefinition{}}
+		for name, def := range generatedopenapi.GetOpenAPIDefinitions(ref) {
+			if strings.HasPrefix(name, apimachineryPrefix) {
+				d.defs[name] = def
+			}
+		}
+		for i := range objs {
+			d.define(reflect.TypeOf(objs[i]))
+		}
+		return d.defs
+	}
+}
+
+// Merge returns a GetOpenAPIDefinitions which returns the definitions of each of fns.  If multiple fns define the
+// same name, the definition from the first is used.
+func Merge(fns ...common.GetOpenAPIDefinitions) common.GetOpenAPIDefinitions {
+	return func(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+		defs := map[string]common.OpenAPIDefinition{}
+		for i := len(fns) - 1; i >= 0; i-- {
+			if fns[i] == nil {
+				continue
+			}
+			for name, def := range fns[i](ref) {
+				defs[name] = def
+			}
+		}
+		return defs
+	}
+}
+
+// DefinitionName returns the name of the definition for the object's go type.
+func DefinitionName(obj interface{}) string {
+	return openapiutil.GetCanonicalTypeName(obj)
+}
+
+type deriver struct {
+	ref  common.ReferenceCallback
+	fns  []SchemaFn
+	defs map[string]common.OpenAPIDefinition
+}
+
+// define adds the definition for a named type and the types it references.
+func (d *deriver) define(t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	name := structtag.TypeName(t)
+	if _, found := d.defs[name]; found {
+		return
+	}
+
+	// reserve the name before deriving the schema to support recursive types
+	d.defs[name] = common.OpenAPIDefinition{}
+	if g, ok := reflect.New(t).Elem().Interface().(openAPIDefinitionGetter); ok {
+		d.defs[name] = g.OpenAPIDefinition()
+		return
+	}
+	deps := map[string]bool{}
+	s := d.schemaForType(t, deps, true)
+	def := common.OpenAPIDefinition{Schema: s}
+	for dep := range deps {
+		def.Dependencies = append(def.Dependencies, dep)
+	}
+	sort.Strings(def.Dependencies)
+	d.defs[name] = def
+}
+
+// schemaForType returns the schema for t.  Named struct types are referenced rather than inlined unless
+// top is true.
+func (d *deriver) schemaForType(t reflect.Type, deps map[string]bool, top bool) spec.Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if p, ok := reflect.New(t).Elem().Interface().(openAPISchemaType); ok && !top {
+		return spec.Schema{SchemaProps: spec.SchemaProps{Type: p.OpenAPISchemaType(), Format: p.OpenAPISchemaFormat()}}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if !top && t.Name() != "" {
+			d.define(t)
+			deps[structtag.TypeName(t)] = true
+			return spec.Schema{SchemaProps: spec.SchemaProps{Ref: d.ref(structtag.TypeName(t))}}
+		}
+		if p, ok := reflect.New(t).Elem().Interface().(openAPISchemaType); ok {
+			return spec.Schema{SchemaProps: spec.SchemaProps{Type: p.OpenAPISchemaType(), Format: p.OpenAPISchemaFormat()}}
+		}
+		s := spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"object"}, Properties: map[string]spec.Schema{}}}
+		d.addProperties(t, &s, deps)
+		return s
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return primitive("string", "byte")
+		}
+		items := d.schemaForType(t.Elem(), deps, false)
+		return spec.Schema{SchemaProps: spec.SchemaProps{
+			Type: []string{"array"}, Items: &spec.SchemaOrArray{Schema: &items}}}
+	case reflect.Map:
+		values := d.schemaForType(t.Elem(), deps, false)
+		return spec.Schema{SchemaProps: spec.SchemaProps{
+			Type: []string{"object"}, AdditionalProperties: &spec.SchemaOrBool{Allows: true, Schema: &values}}}
+	case reflect.String:
+		return primitive("string", "")
+	case reflect.Bool:
+		return primitive("boolean", "")
+	case reflect.Int32, reflect.Int16, reflect.Int8, reflect.Uint32, reflect.Uint16, reflect.Uint8:
+		return primitive("integer", "int32")
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return primitive("integer", "int64")
+	case reflect.Float32:
+		return primitive("number", "float")
+	case reflect.Float64:
+		return primitive("number", "double")
+	}
+	// interfaces and other types may contain any value
+	return spec.Schema{}
+}
+
+// addProperties adds the json serialized fields of struct t to s.  Embedded structs without a json name
+// are inlined.
+func (d *deriver) addProperties(t reflect.Type, s *spec.Schema, deps map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			// unexported
+			continue
+		}
+		name, opts := parseJSONTag(f)
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && (name == "" || opts["inline"]) {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				d.addProperties(ft, s, deps)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		p := d.schemaForType(f.Type, deps, false)
+		if v := f.Tag.Get("patchStrategy"); v != "" {
+			p.AddExtension("x-kubernetes-patch-strategy", v)
+		}
+		if v := f.Tag.Get("patchMergeKey"); v != "" {
+			p.AddExtension("x-kubernetes-patch-merge-key", v)
+		}
+		for _, fn := range d.fns {
+			fn(f, &p)
+		}
+		s.Properties[name] = p
+		if !opts["omitempty"] && f.Type.Kind() != reflect.Ptr && !requiredExempt(name) {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// requiredExempt returns true for fields which are never required even if they are not omitempty.
+func requiredExempt(name string) bool {
+	return name == "kind" || name == "apiVersion"
+}
+
+func parseJSONTag(f reflect.StructField) (string, map[string]bool) {
+	parts := strings.Split(f.Tag.Get("json"), ",")
+	opts := map[string]bool{}
+	for _, o := range parts[1:] {
+		opts[o] = true
+	}
+	return parts[0], opts
+}
+
+func primitive(t, format string) spec.Schema {
+	return spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{t}, Format: format}}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openapi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-openapi/spec"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kube-openapi/pkg/common"
+)
+
+type Thing struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ThingSpec `json:"spec,omitempty"`
+}
+
+type ThingSpec struct {
+	Size     int32             `json:"size"`
+	Ratio    float64           `json:"ratio,omitempty"`
+	Data     []byte            `json:"data,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Parts    []ThingSpec       `json:"parts,omitempty" patchStrategy:"merge" patchMergeKey:"name"`
+	Created  metav1.Time       `json:"created,omitempty"`
+	internal string
+}
+
+func ref(name string) spec.Ref {
+	return spec.MustCreateRef("#/definitions/" + name)
+}
+
+func TestDefinitionsFor(t *testing.T) {
+	defs := DefinitionsFor([]interface{}{&Thing{}})(ref)
+
+	thing, found := defs[DefinitionName(&Thing{})]
+	if !found {
+		t.Fatalf("expected a definition for Thing")
+	}
+	var properties []string
+	for name := range thing.Schema.Properties {
+		properties = append(properties, name)
+	}
+	for _, name := range []string{"kind", "apiVersion", "metadata", "spec"} {
+		if _, found := thing.Schema.Properties[name]; !found {
+			t.Errorf("expected property %s, got %v", name, properties)
+		}
+	}
+	metadata := thing.Schema.Properties["metadata"]
+	if r := metadata.Ref.String(); r != "#/definitions/k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta" {
+		t.Errorf("expected metadata to reference ObjectMeta, got %s", r)
+	}
+	if _, found := defs["k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"]; !found {
+		t.Errorf("expected a definition for ObjectMeta")
+	}
+
+	s := defs[DefinitionName(&ThingSpec{})].Schema
+	expected := map[string]spec.Schema{
+		"size":  {SchemaProps: spec.SchemaProps{Type: []string{"integer"}, Format: "int32"}},
+		"ratio": {SchemaProps: spec.SchemaProps{Type: []string{"number"}, Format: "double"}},
+		"data":  {SchemaProps: spec.SchemaProps{Type: []string{"string"}, Format: "byte"}},
+		"labels": {SchemaProps: spec.SchemaProps{Type: []string{"object"}, AdditionalProperties: &spec.SchemaOrBool{
+			Allows: true, Schema: &spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"string"}}}}}},
+		"parts": {
+			VendorExtensible: spec.VendorExtensible{Extensions: spec.Extensions{
+				"x-kubernetes-patch-strategy":  "merge",
+				"x-kubernetes-patch-merge-key": "name",
+			}},
+			SchemaProps: spec.SchemaProps{Type: []string{"array"}, Items: &spec.SchemaOrArray{
+				Schema: &spec.Schema{SchemaProps: spec.SchemaProps{Ref: ref(DefinitionName(&ThingSpec{}))}}}},
+		},
+		"created": {SchemaProps: spec.SchemaProps{Type: []string{"string"}, Format: "date-time"}},
+	}
+	if !reflect.DeepEqual(s.Properties, expected) {
+		t.Errorf("expected properties %+v, got %+v", expected, s.Properties)
+	}
+	if !reflect.DeepEqual(s.Required, []string{"size"}) {
+		t.Errorf("expected size to be required, got %v", s.Required)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	first := func(common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+		return map[string]common.OpenAPIDefinition{"a": {Dependencies: []string{"first"}}}
+	}
+	second := func(common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+		return map[string]common.OpenAPIDefinition{
+			"a": {Dependencies: []string{"second"}},
+			"b": {Dependencies: []string{"second"}},
+		}
+	}
+	defs := Merge(first, nil, second)(ref)
+	if len(defs) != 2 || defs["a"].Dependencies[0] != "first" || defs["b"].Dependencies[0] != "second" {
+		t.Errorf("expected definitions from the first function to win, got %+v", defs)
+	}
+}
@@ -0,0 +1,475 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protobuf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// marshaler is implemented by types with generated protobuf functions -- e.g. ObjectMeta.
+type marshaler interface {
+	Marshal() ([]byte, error)
+}
+
+// unmarshaler is implemented by types with generated protobuf functions -- e.g. ObjectMeta.
+type unmarshaler interface {
+	Unmarshal([]byte) error
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*unmarshaler)(nil)).Elem()
+	typeMetaType    = reflect.TypeOf(metav1.TypeMeta{})
+)
+
+// generated returns true if pointers to t have generated protobuf functions.
+func generated(t reflect.Type) bool {
+	p := reflect.PtrTo(t)
+	return p.Implements(marshalerType) && p.Implements(unmarshalerType)
+}
+
+// field describes how a struct field is encoded.
+type field struct {
+	index  int
+	num    uint64
+	name   string
+	zigzag bool
+	fixed  bool
+}
+
+// message describes how a struct is encoded.
+type message struct {
+	fields []field
+	byNum  map[uint64]field
+}
+
+var messages sync.Map
+
+// messageFor returns the encoding of struct type t.  Every exported field serialized to json must have a
+// protobuf tag, except for the TypeMeta which is carried by the runtime.Unknown envelope.
+func messageFor(t reflect.Type) (*message, error) {
+	return messageForType(t, map[reflect.Type]bool{})
+}
+
+// messageForType returns the encoding of struct type t.  seen contains the types being checked to support
+// recursive types.
+func messageForType(t reflect.Type, seen map[reflect.Type]bool) (*message, error) {
+	if m, found := messages.Load(t); found {
+		return m.(*message), nil
+	}
+	seen[t] = true
+	m := &message{byNum: map[uint64]field{}}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("protobuf")
+		if tag == "" {
+			if f.PkgPath != "" || f.Type == typeMetaType || f.Tag.Get("json") == "-" {
+				continue
+			}
+			return nil, fmt.Errorf("field %s.%s has no protobuf tag", t.Name(), f.Name)
+		}
+		parts := strings.Split(tag, ",")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("field %s.%s has invalid protobuf tag %q", t.Name(), f.Name, tag)
+		}
+		num, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil || num == 0 {
+			return nil, fmt.Errorf("field %s.%s has invalid protobuf field number %q", t.Name(), f.Name, parts[1])
+		}
+		if _, found := m.byNum[num]; found {
+			return nil, fmt.Errorf("field %s.%s reuses protobuf field number %d", t.Name(), f.Name, num)
+		}
+		pf := field{
+			index:  i,
+			num:    num,
+			name:   f.Name,
+			zigzag: strings.HasPrefix(parts[0], "zigzag"),
+			fixed:  strings.Contains(parts[0], "fixed"),
+		}
+		if err := checkType(f.Type, seen); err != nil {
+			return nil, fmt.Errorf("field %s.%s: %v", t.Name(), f.Name, err)
+		}
+		m.fields = append(m.fields, pf)
+		m.byNum[num] = pf
+	}
+	sort.Slice(m.fields, func(i, j int) bool { return m.fields[i].num < m.fields[j].num })
+	messages.Store(t, m)
+	return m, nil
+}
+
+// checkType returns an error if values of type t cannot be encoded.
+func checkType(t reflect.Type, seen map[reflect.Type]bool) error {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return checkType(t.Elem(), seen)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		if t.Elem().Kind() == reflect.Slice && t.Elem().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("nested repeated values are not supported")
+		}
+		return checkType(t.Elem(), seen)
+	case reflect.Map:
+		if err := checkType(t.Key(), seen); err != nil {
+			return err
+		}
+		return checkType(t.Elem(), seen)
+	case reflect.Struct:
+		if generated(t) || seen[t] {
+			return nil
+		}
+		_, err := messageForType(t, seen)
+		return err
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return nil
+	}
+	return fmt.Errorf("%v values are not supported", t)
+}
+
+// marshal returns the protobuf encoding of the struct v.
+func marshal(v reflect.Value) ([]byte, error) {
+	m, err := messageFor(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	for _, f := range m.fields {
+		if b, err = appendField(b, f, v.Field(f.index)); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// appendField appends the encoding of the field value v.  As with the generated functions, non-pointer
+// fields are always written, and nil pointers, slices and maps are omitted.
+func appendField(b []byte, f field, v reflect.Value) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return b, nil
+		}
+		return appendField(b, f, v.Elem())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.IsNil() {
+				return b, nil
+			}
+			return appendBytes(appendTag(b, f.num, wireBytes), v.Bytes()), nil
+		}
+		var err error
+		for i := 0; i < v.Len(); i++ {
+			if b, err = appendField(b, f, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, k := range keys {
+			entry, err := appendField(nil, field{num: 1}, k)
+			if err != nil {
+				return nil, err
+			}
+			if entry, err = appendField(entry, field{num: 2}, v.MapIndex(k)); err != nil {
+				return nil, err
+			}
+			b = appendBytes(appendTag(b, f.num, wireBytes), entry)
+		}
+		return b, nil
+	case reflect.Struct:
+		var data []byte
+		var err error
+		if generated(v.Type()) {
+			p := reflect.New(v.Type())
+			p.Elem().Set(v)
+			data, err = p.Interface().(marshaler).Marshal()
+		} else {
+			data, err = marshal(v)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return appendBytes(appendTag(b, f.num, wireBytes), data), nil
+	case reflect.String:
+		return appendBytes(appendTag(b, f.num, wireBytes), []byte(v.String())), nil
+	case reflect.Bool:
+		x := uint64(0)
+		if v.Bool() {
+			x = 1
+		}
+		return appendVarint(appendTag(b, f.num, wireVarint), x), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x := v.Int()
+		switch {
+		case f.fixed && v.Type().Bits() <= 32:
+			return appendFixed32(appendTag(b, f.num, wireFixed32), uint32(x)), nil
+		case f.fixed:
+			return appendFixed64(appendTag(b, f.num, wireFixed64), uint64(x)), nil
+		case f.zigzag:
+			return appendVarint(appendTag(b, f.num, wireVarint), uint64(x<<1)^uint64(x>>63)), nil
+		}
+		return appendVarint(appendTag(b, f.num, wireVarint), uint64(x)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		x := v.Uint()
+		switch {
+		case f.fixed && v.Type().Bits() <= 32:
+			return appendFixed32(appendTag(b, f.num, wireFixed32), uint32(x)), nil
+		case f.fixed:
+			return appendFixed64(appendTag(b, f.num, wireFixed64), x), nil
+		}
+		return appendVarint(appendTag(b, f.num, wireVarint), x), nil
+	case reflect.Float32:
+		return appendFixed32(appendTag(b, f.num, wireFixed32), math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		return appendFixed64(appendTag(b, f.num, wireFixed64), math.Float64bits(v.Float())), nil
+	}
+	return nil, fmt.Errorf("%v values are not supported", v.Type())
+}
+
+func appendTag(b []byte, num uint64, wireType int) []byte {
+	return appendVarint(b, num<<3|uint64(wireType))
+}
+
+func appendVarint(b []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], x)]...)
+}
+
+func appendBytes(b, data []byte) []byte {
+	return append(appendVarint(b, uint64(len(data))), data...)
+}
+
+func appendFixed32(b []byte, x uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], x)
+	return append(b, buf[:]...)
+}
+
+func appendFixed64(b []byte, x uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], x)
+	return append(b, buf[:]...)
+}
+
+// unmarshal decodes the protobuf encoding of a struct into v.  Fields which are not known are ignored.
+func unmarshal(data []byte, v reflect.Value) error {
+	m, err := messageFor(v.Type())
+	if err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("invalid field key")
+		}
+		data = data[n:]
+		num, wireType := key>>3, int(key&7)
+		value, rest, err := readValue(data, wireType)
+		if err != nil {
+			return fmt.Errorf("field %d: %v", num, err)
+		}
+		data = rest
+		f, found := m.byNum[num]
+		if !found {
+			continue
+		}
+		if err := setField(f, v.Field(f.index), wireType, value); err != nil {
+			return fmt.Errorf("field %s: %v", f.name, err)
+		}
+	}
+	return nil
+}
+
+// readValue splits the value of a field from the rest of the data.  Varints are returned as their encoded bytes.
+func readValue(data []byte, wireType int) ([]byte, []byte, error) {
+	switch wireType {
+	case wireVarint:
+		_, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, nil, fmt.Errorf("invalid varint")
+		}
+		return data[:n], data[n:], nil
+	case wireFixed64:
+		if len(data) < 8 {
+			return nil, nil, fmt.Errorf("unexpected end of data")
+		}
+		return data[:8], data[8:], nil
+	case wireFixed32:
+		if len(data) < 4 {
+			return nil, nil, fmt.Errorf("unexpected end of data")
+		}
+		return data[:4], data[4:], nil
+	case wireBytes:
+		l, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < l {
+			return nil, nil, fmt.Errorf("unexpected end of data")
+		}
+		return data[n : n+int(l)], data[n+int(l):], nil
+	}
+	return nil, nil, fmt.Errorf("unsupported wire type %d", wireType)
+}
+
+// setField decodes a value of the field into v.  Repeated fields append the value, and accept packed values.
+func setField(f field, v reflect.Value, wireType int, value []byte) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setField(f, v.Elem(), wireType, value)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(append([]byte{}, value...))
+			return nil
+		}
+		elem := v.Type().Elem()
+		if wireType == wireBytes && packable(elem) {
+			for len(value) > 0 {
+				item, rest, err := readValue(value, scalarWireType(f, elem))
+				if err != nil {
+					return err
+				}
+				value = rest
+				e := reflect.New(elem).Elem()
+				if err := setField(f, e, scalarWireType(f, elem), item); err != nil {
+					return err
+				}
+				v.Set(reflect.Append(v, e))
+			}
+			return nil
+		}
+		e := reflect.New(elem).Elem()
+		if err := setField(f, e, wireType, value); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, e))
+		return nil
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		entry := reflect.New(reflect.StructOf([]reflect.StructField{
+			{Name: "Key", Type: v.Type().Key(), Tag: `protobuf:"bytes,1,opt,name=key"`},
+			{Name: "Value", Type: v.Type().Elem(), Tag: `protobuf:"bytes,2,opt,name=value"`},
+		})).Elem()
+		if err := unmarshal(value, entry); err != nil {
+			return err
+		}
+		v.SetMapIndex(entry.Field(0), entry.Field(1))
+		return nil
+	case reflect.Struct:
+		if wireType != wireBytes {
+			return fmt.Errorf("unexpected wire type %d", wireType)
+		}
+		if generated(v.Type()) {
+			return v.Addr().Interface().(unmarshaler).Unmarshal(value)
+		}
+		return unmarshal(value, v)
+	case reflect.String:
+		v.SetString(string(value))
+		return nil
+	}
+
+	x, err := scalar(wireType, value)
+	if err != nil {
+		return err
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(x != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch {
+		case f.zigzag:
+			v.SetInt(int64(x>>1) ^ -int64(x&1))
+		case wireType == wireFixed32:
+			v.SetInt(int64(int32(x)))
+		default:
+			v.SetInt(int64(x))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(x)
+	case reflect.Float32:
+		v.SetFloat(float64(math.Float32frombits(uint32(x))))
+	case reflect.Float64:
+		v.SetFloat(math.Float64frombits(x))
+	default:
+		return fmt.Errorf("%v values are not supported", v.Type())
+	}
+	return nil
+}
+
+// scalar returns the bits of a varint or fixed value.
+func scalar(wireType int, value []byte) (uint64, error) {
+	switch wireType {
+	case wireVarint:
+		x, _ := binary.Uvarint(value)
+		return x, nil
+	case wireFixed32:
+		return uint64(binary.LittleEndian.Uint32(value)), nil
+	case wireFixed64:
+		return binary.LittleEndian.Uint64(value), nil
+	}
+	return 0, fmt.Errorf("unexpected wire type %d", wireType)
+}
+
+// packable returns true if repeated values of t may be packed into a single bytes field.
+func packable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// scalarWireType returns the wire type of scalar values of t for the field.
+func scalarWireType(f field, t reflect.Type) int {
+	switch {
+	case t.Kind() == reflect.Float32:
+		return wireFixed32
+	case t.Kind() == reflect.Float64:
+		return wireFixed64
+	case f.fixed && t.Bits() <= 32:
+		return wireFixed32
+	case f.fixed:
+		return wireFixed64
+	}
+	return wireVarint
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package protobuf serializes objects to the Kubernetes protobuf wire format using their protobuf struct tags.
+//
+// Objects with functions generated by go-to-protobuf are serialized by the generated functions.  Other objects
+// are serialized by reflecting over the `protobuf:"..."` tags of their fields.  Every exported field serialized
+// to json must have a protobuf tag, except for the TypeMeta, which is carried in the runtime.Unknown envelope
+// as it is for generated types.  Objects with fields lacking tags cannot be serialized to protobuf, and requests
+// for them are rejected as NotAcceptable so clients fall back to json.
+package protobuf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer/protobuf"
+)
+
+// prefix identifies the Kubernetes protobuf encoding.  It is the same as the prefix used by generated types.
+var prefix = []byte{0x6b, 0x38, 0x73, 0x00}
+
+const identifier runtime.Identifier = "builder-protobuf"
+
+// Serializer serializes objects to the Kubernetes protobuf wire format.
+type Serializer struct {
+	creater runtime.ObjectCreater
+	typer   runtime.ObjectTyper
+	// generated serializes objects which have generated protobuf functions
+	generated *protobuf.Serializer
+}
+
+var _ runtime.Serializer = &Serializer{}
+
+// NewSerializer returns a Serializer for objects created and typed by the scheme.
+func NewSerializer(creater runtime.ObjectCreater, typer runtime.ObjectTyper) *Serializer {
+	return &Serializer{
+		creater:   creater,
+		typer:     typer,
+		generated: protobuf.NewSerializer(creater, typer),
+	}
+}
+
+// Encode implements runtime.Encoder
+func (s *Serializer) Encode(obj runtime.Object, w io.Writer) error {
+	if co, ok := obj.(runtime.CacheableObject); ok {
+		return co.CacheEncode(s.Identifier(), s.doEncode, w)
+	}
+	return s.doEncode(obj, w)
+}
+
+func (s *Serializer) doEncode(obj runtime.Object, w io.Writer) error {
+	if hasGeneratedFunctions(obj) {
+		return s.generated.Encode(obj, w)
+	}
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errNotMarshalable{t: reflect.TypeOf(obj), err: fmt.Errorf("only pointers to structs are supported")}
+	}
+	raw, err := marshal(v.Elem())
+	if err != nil {
+		return errNotMarshalable{t: reflect.TypeOf(obj), err: err}
+	}
+
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	unk := runtime.Unknown{
+		TypeMeta: runtime.TypeMeta{APIVersion: gvk.GroupVersion().String(), Kind: gvk.Kind},
+		Raw:      raw,
+	}
+	data, err := unk.Marshal()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Decode implements runtime.Decoder
+func (s *Serializer) Decode(
+	data []byte, gvk *schema.GroupVersionKind, into runtime.Object) (runtime.Object, *schema.GroupVersionKind, error) {
+	if _, ok := into.(*runtime.Unknown); ok || (into != nil && hasGeneratedFunctions(into)) {
+		return s.generated.Decode(data, gvk, into)
+	}
+	if len(data) <= len(prefix) || !bytes.Equal(prefix, data[:len(prefix)]) {
+		return s.generated.Decode(data, gvk, into)
+	}
+	unk := runtime.Unknown{}
+	if err := unk.Unmarshal(data[len(prefix):]); err != nil {
+		return nil, nil, err
+	}
+
+	actual := unk.GroupVersionKind()
+	copyKindDefaults(&actual, gvk)
+	if into != nil {
+		types, _, err := s.typer.ObjectKinds(into)
+		if err != nil {
+			return nil, &actual, err
+		}
+		copyKindDefaults(&actual, &types[0])
+		if len(actual.Version) == 0 && len(actual.Group) == 0 {
+			actual.Group = types[0].Group
+		}
+	}
+	if len(actual.Kind) == 0 {
+		return nil, &actual, runtime.NewMissingKindErr(fmt.Sprintf("%#v", unk.TypeMeta))
+	}
+	if len(actual.Version) == 0 {
+		return nil, &actual, runtime.NewMissingVersionErr(fmt.Sprintf("%#v", unk.TypeMeta))
+	}
+
+	obj, err := runtime.UseOrCreateObject(s.typer, s.creater, actual, into)
+	if err != nil {
+		return nil, &actual, err
+	}
+	if hasGeneratedFunctions(obj) {
+		return s.generated.Decode(data, gvk, into)
+	}
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil, &actual, errNotMarshalable{t: reflect.TypeOf(obj), err: fmt.Errorf("only pointers to structs are supported")}
+	}
+	v.Elem().Set(reflect.Zero(v.Elem().Type()))
+	if err := unmarshal(unk.Raw, v.Elem()); err != nil {
+		return nil, &actual, err
+	}
+	return obj, &actual, nil
+}
+
+// Identifier implements runtime.Encoder
+func (s *Serializer) Identifier() runtime.Identifier {
+	return identifier
+}
+
+// RecognizesData implements the RecognizingDecoder interface.
+func (s *Serializer) RecognizesData(peek io.Reader) (bool, bool, error) {
+	return s.generated.RecognizesData(peek)
+}
+
+// hasGeneratedFunctions returns true if the object is serialized by functions generated by go-to-protobuf.
+func hasGeneratedFunctions(obj runtime.Object) bool {
+	_, isMarshaler := obj.(marshaler)
+	_, isMessage := obj.(interface{ ProtoMessage() })
+	return isMarshaler && isMessage
+}
+
+// copyKindDefaults defaults dst to the value in src if dst does not have a value set.
+func copyKindDefaults(dst, src *schema.GroupVersionKind) {
+	if src == nil {
+		return
+	}
+	// apply kind and version defaulting from provided default
+	if len(dst.Kind) == 0 {
+		dst.Kind = src.Kind
+	}
+	if len(dst.Version) == 0 && len(src.Version) > 0 {
+		dst.Group = src.Group
+		dst.Version = src.Version
+	}
+}
+
+// errNotMarshalable is returned for objects which cannot be serialized to protobuf.
+type errNotMarshalable struct {
+	t   reflect.Type
+	err error
+}
+
+func (e errNotMarshalable) Error() string {
+	return fmt.Sprintf("object %v cannot be encoded to a protobuf message: %v", e.t, e.err)
+}
+
+func (e errNotMarshalable) Status() metav1.Status {
+	return metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusNotAcceptable,
+		Reason:  metav1.StatusReason("NotAcceptable"),
+		Message: e.Error(),
+	}
+}
+
+// IsNotMarshalable returns true if the error is returned for an object which cannot be serialized to protobuf.
+func IsNotMarshalable(err error) bool {
+	_, ok := err.(errNotMarshalable)
+	return ok || protobuf.IsNotMarshalable(err)
+}
+
+// NegotiatedSerializer returns ns with its protobuf serializer replaced by a Serializer, so that objects without
+// generated protobuf functions may be served as protobuf.
+func NegotiatedSerializer(ns runtime.NegotiatedSerializer, creater runtime.ObjectCreater,
+	typer runtime.ObjectTyper) runtime.NegotiatedSerializer {
+	return negotiatedSerializer{NegotiatedSerializer: ns, serializer: NewSerializer(creater, typer)}
+}
+
+type negotiatedSerializer struct {
+	runtime.NegotiatedSerializer
+	serializer *Serializer
+}
+
+// SupportedMediaTypes implements runtime.NegotiatedSerializer
+func (n negotiatedSerializer) SupportedMediaTypes() []runtime.SerializerInfo {
+	infos := append([]runtime.SerializerInfo{}, n.NegotiatedSerializer.SupportedMediaTypes()...)
+	for i := range infos {
+		if infos[i].MediaType == runtime.ContentTypeProtobuf {
+			infos[i].Serializer = n.serializer
+		}
+	}
+	return infos
+}
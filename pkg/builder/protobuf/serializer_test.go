@@ -0,0 +1,270 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protobuf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/runtime/serializer/json"
+)
+
+var testGroupVersion = schema.GroupVersion{Group: "test.example.com", Version: "v1"}
+
+type Thing struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
+
+	Spec ThingSpec `json:"spec,omitempty" protobuf:"bytes,2,opt,name=spec"`
+}
+
+type ThingSpec struct {
+	Name     string            `json:"name,omitempty" protobuf:"bytes,1,opt,name=name"`
+	Replicas *int32            `json:"replicas,omitempty" protobuf:"varint,2,opt,name=replicas"`
+	Offset   int64             `json:"offset,omitempty" protobuf:"zigzag64,3,opt,name=offset"`
+	Delta    int32             `json:"delta,omitempty" protobuf:"varint,4,opt,name=delta"`
+	Enabled  bool              `json:"enabled,omitempty" protobuf:"varint,5,opt,name=enabled"`
+	Ratio    float64           `json:"ratio,omitempty" protobuf:"fixed64,6,opt,name=ratio"`
+	Data     []byte            `json:"data,omitempty" protobuf:"bytes,7,opt,name=data"`
+	Tags     []string          `json:"tags,omitempty" protobuf:"bytes,8,rep,name=tags"`
+	Ports    []int32           `json:"ports,omitempty" protobuf:"varint,9,rep,name=ports"`
+	Labels   map[string]string `json:"labels,omitempty" protobuf:"bytes,10,rep,name=labels"`
+	Parts    []ThingSpec       `json:"parts,omitempty" protobuf:"bytes,11,rep,name=parts"`
+	Size     resource.Quantity `json:"size,omitempty" protobuf:"bytes,12,opt,name=size"`
+	Child    *ThingSpec        `json:"child,omitempty" protobuf:"bytes,13,opt,name=child"`
+	Started  *metav1.Time      `json:"started,omitempty" protobuf:"bytes,14,opt,name=started"`
+	Weights  map[string]int64  `json:"weights,omitempty" protobuf:"bytes,15,rep,name=weights"`
+	internal string
+}
+
+type ThingList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
+
+	Items []Thing `json:"items" protobuf:"bytes,2,rep,name=items"`
+}
+
+// Untagged has a field without a protobuf tag and cannot be serialized to protobuf.
+type Untagged struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
+
+	Value string `json:"value"`
+}
+
+func (t *Thing) DeepCopyObject() runtime.Object     { panic("not implemented") }
+func (t *ThingList) DeepCopyObject() runtime.Object { panic("not implemented") }
+func (t *Untagged) DeepCopyObject() runtime.Object  { panic("not implemented") }
+
+func newScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(testGroupVersion, &Thing{}, &ThingList{}, &Untagged{})
+	metav1.AddToGroupVersion(scheme, testGroupVersion)
+	return scheme
+}
+
+func newThing() *Thing {
+	replicas := int32(3)
+	started := metav1.NewTime(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	return &Thing{
+		TypeMeta: metav1.TypeMeta{APIVersion: testGroupVersion.String(), Kind: "Thing"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "thing", Namespace: "default", Labels: map[string]string{"app": "thing"},
+			ResourceVersion: "12",
+		},
+		Spec: ThingSpec{
+			Name:     "spec",
+			Replicas: &replicas,
+			Offset:   -42,
+			Delta:    -7,
+			Enabled:  true,
+			Ratio:    0.25,
+			Data:     []byte("data"),
+			Tags:     []string{"a", "b"},
+			Ports:    []int32{80, 443},
+			Labels:   map[string]string{"b": "2", "a": "1"},
+			Parts:    []ThingSpec{{Name: "part", Tags: []string{"c"}}},
+			Size:     resource.MustParse("10Gi"),
+			Child:    &ThingSpec{Name: "child", Ratio: 1.5},
+			Started:  &started,
+			Weights:  map[string]int64{"x": -1, "y": 1 << 40},
+			internal: "not serialized",
+		},
+	}
+}
+
+// roundTrip encodes the object with the protobuf serializer, decodes it and returns the json encoding of both the
+// original and decoded objects.
+func roundTrip(t *testing.T, s runtime.Serializer, obj runtime.Object) (string, string) {
+	scheme := newScheme()
+	j := json.NewSerializer(json.DefaultMetaFactory, scheme, scheme, false)
+
+	expected := &bytes.Buffer{}
+	if err := j.Encode(obj, expected); err != nil {
+		t.Fatal(err)
+	}
+	data := &bytes.Buffer{}
+	if err := s.Encode(obj, data); err != nil {
+		t.Fatal(err)
+	}
+	decoded, gvk, err := s.Decode(data.Bytes(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gvk.GroupVersion() != testGroupVersion {
+		t.Errorf("expected %v, got %v", testGroupVersion, gvk)
+	}
+	decoded.GetObjectKind().SetGroupVersionKind(*gvk)
+	actual := &bytes.Buffer{}
+	if err := j.Encode(decoded, actual); err != nil {
+		t.Fatal(err)
+	}
+	return expected.String(), actual.String()
+}
+
+func TestRoundTrip(t *testing.T) {
+	scheme := newScheme()
+	s := NewSerializer(scheme, scheme)
+
+	// as with generated types, the TypeMeta of list items is not serialized
+	item := newThing()
+	item.TypeMeta = metav1.TypeMeta{}
+
+	tests := map[string]runtime.Object{
+		"object":       newThing(),
+		"empty object": &Thing{TypeMeta: metav1.TypeMeta{APIVersion: testGroupVersion.String(), Kind: "Thing"}},
+		"list": &ThingList{
+			TypeMeta: metav1.TypeMeta{APIVersion: testGroupVersion.String(), Kind: "ThingList"},
+			ListMeta: metav1.ListMeta{ResourceVersion: "5", Continue: "next"},
+			Items:    []Thing{*item, *item},
+		},
+	}
+	for name, obj := range tests {
+		t.Run(name, func(t *testing.T) {
+			expected, actual := roundTrip(t, s, obj)
+			if expected != actual {
+				t.Errorf("expected %s\ngot %s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestGenerated(t *testing.T) {
+	scheme := newScheme()
+	s := NewSerializer(scheme, scheme)
+
+	// objects with generated functions are encoded by the generated functions
+	status := &metav1.Status{Status: metav1.StatusFailure, Message: "failed", Code: 500}
+	status.GetObjectKind().SetGroupVersionKind(testGroupVersion.WithKind("Status"))
+	generated := &bytes.Buffer{}
+	if err := s.generated.Encode(status, generated); err != nil {
+		t.Fatal(err)
+	}
+	data := &bytes.Buffer{}
+	if err := s.Encode(status, data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(generated.Bytes(), data.Bytes()) {
+		t.Errorf("expected the generated encoding")
+	}
+	decoded, _, err := s.Decode(data.Bytes(), nil, &metav1.Status{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.(*metav1.Status).Message != "failed" {
+		t.Errorf("expected the status to be decoded, got %+v", decoded)
+	}
+}
+
+func TestNotMarshalable(t *testing.T) {
+	scheme := newScheme()
+	s := NewSerializer(scheme, scheme)
+
+	obj := &Untagged{TypeMeta: metav1.TypeMeta{APIVersion: testGroupVersion.String(), Kind: "Untagged"}}
+	err := s.Encode(obj, &bytes.Buffer{})
+	if !IsNotMarshalable(err) {
+		t.Errorf("expected a NotMarshalable error, got %v", err)
+	}
+}
+
+func TestNegotiatedSerializer(t *testing.T) {
+	scheme := newScheme()
+	ns := NegotiatedSerializer(serializer.NewCodecFactory(scheme), scheme, scheme)
+	info, ok := runtime.SerializerInfoForMediaType(ns.SupportedMediaTypes(), runtime.ContentTypeProtobuf)
+	if !ok {
+		t.Fatalf("expected a protobuf serializer")
+	}
+
+	obj := newThing()
+	obj.TypeMeta = metav1.TypeMeta{}
+	data, err := runtime.Encode(ns.EncoderForVersion(info.Serializer, testGroupVersion), obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := runtime.Decode(ns.DecoderToVersion(info.Serializer, testGroupVersion), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thing := decoded.(*Thing); thing.Name != "thing" || *thing.Spec.Replicas != 3 || thing.Kind != "Thing" {
+		t.Errorf("expected the object to be decoded, got %+v", thing)
+	}
+}
+
+func TestCompatibleWithGenerated(t *testing.T) {
+	controller := true
+	remaining := int64(-3)
+	tests := map[string]marshaler{
+		"OwnerReference": &metav1.OwnerReference{
+			APIVersion: "v1", Kind: "Thing", Name: "owner", UID: "1234", Controller: &controller},
+		"ListMeta": &metav1.ListMeta{ResourceVersion: "5", Continue: "next", RemainingItemCount: &remaining},
+		"LabelSelector": &metav1.LabelSelector{
+			MatchLabels: map[string]string{"b": "2", "a": "1"},
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "key", Operator: metav1.LabelSelectorOpIn, Values: []string{"x", "y"}}},
+		},
+	}
+	for name, obj := range tests {
+		t.Run(name, func(t *testing.T) {
+			expected, err := obj.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := marshal(reflect.ValueOf(obj).Elem())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(expected, actual) {
+				t.Errorf("expected %v, got %v", expected, actual)
+			}
+
+			decoded := reflect.New(reflect.TypeOf(obj).Elem())
+			if err := unmarshal(expected, decoded.Elem()); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded.Interface(), obj) {
+				t.Errorf("expected %+v, got %+v", obj, decoded.Interface())
+			}
+		})
+	}
+}
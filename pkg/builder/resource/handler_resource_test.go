@@ -66,12 +66,12 @@ func (e ExampleResourceWithHandler) NamespaceScoped() bool {
 
 // New returns a new instance of the object for this resource.
 func (e ExampleResourceWithHandler) New() runtime.Object {
-	return &ExampleResource{}
+	return &ExampleResourceWithHandler{}
 }
 
 // NewList returns a new instance of the list object for this resource.
 func (e ExampleResourceWithHandler) NewList() runtime.Object {
-	return &ExampleResourceList{}
+	return &ExampleResourceWithHandlerList{}
 }
 
 // GetGroupVersionResource returns the GroupVersionResource for this type.
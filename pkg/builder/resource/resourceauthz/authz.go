@@ -0,0 +1,248 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package resourceauthz restricts which users may write the fields of resources.
+//
+// A field may only be written by the users authorized for a verb on a pseudo-subresource of its resource if it
+// has an `authorize:"<subresource>"` or `authorize:"<subresource>,<verb>"` struct tag, or is returned by the
+// AuthorizedFields function of a resourcestrategy.FieldAuthorizer.  The verb defaults to "update".  The field is
+// written by creating an object with the field set, or by changing it in an update -- including adding or
+// removing the elements of lists and maps containing the field.
+//
+// The users are authorized by the authorizer of the apiserver, so may be granted the pseudo-subresources with
+// RBAC as for any other subresource.  For the following type, the users must be allowed to update
+// widgets/owner to set the owner of a Widget.
+//
+//	type WidgetSpec struct {
+//		Owner string `json:"owner,omitempty" authorize:"owner"`
+//	}
+package resourceauthz
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/internal/structtag"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourceimmutable"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcestrategy"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// Tag is the struct tag restricting which users may write a field.
+const Tag = "authorize"
+
+// DefaultVerb is the verb users must be authorized for if a field does not set its verb.
+const DefaultVerb = "update"
+
+// typeFields are the authorized fields in the struct tags of a type.
+type typeFields struct {
+	fields []resourcestrategy.AuthorizedField
+	err    error
+}
+
+var (
+	lock  sync.Mutex
+	types = map[reflect.Type]*typeFields{}
+)
+
+type key int
+
+const authorizerKey key = iota
+
+// WithAuthorizer returns a copy of ctx with the authorizer used to authorize the users writing fields.
+func WithAuthorizer(ctx context.Context, a authorizer.Authorizer) context.Context {
+	return context.WithValue(ctx, authorizerKey, a)
+}
+
+// AuthorizerFrom returns the authorizer used to authorize the users writing fields, if ctx has one.
+func AuthorizerFrom(ctx context.Context) (authorizer.Authorizer, bool) {
+	a, ok := ctx.Value(authorizerKey).(authorizer.Authorizer)
+	return a, ok && a != nil
+}
+
+// WithRequestAuthorizer adds the authorizer to the context of the requests served by handler --
+// e.g. the handler passed to the BuildHandlerChainFunc of the apiserver config.
+func WithRequestAuthorizer(handler http.Handler, a authorizer.Authorizer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		handler.ServeHTTP(w, req.WithContext(WithAuthorizer(req.Context(), a)))
+	})
+}
+
+// Fields returns the authorized fields of obj, from its struct tags and from its AuthorizedFields function if
+// obj is a resourcestrategy.FieldAuthorizer.  Fields returns an error for the tags and fields which cannot be
+// parsed, as well as the fields which can be parsed.
+func Fields(obj interface{}) ([]resourcestrategy.AuthorizedField, error) {
+	t := fieldsFor(reflect.TypeOf(obj))
+	fields := append([]resourcestrategy.AuthorizedField{}, t.fields...)
+	var errs []string
+	if t.err != nil {
+		errs = append(errs, t.err.Error())
+	}
+	if p, ok := obj.(resourcestrategy.FieldAuthorizer); ok {
+		for _, f := range p.AuthorizedFields() {
+			// Changed parses the path
+			if _, err := resourceimmutable.Changed(nil, nil, f.Path); err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			if f.Subresource == "" {
+				errs = append(errs, fmt.Sprintf("field %s must have a subresource", f.Path))
+				continue
+			}
+			if f.Verb == "" {
+				f.Verb = DefaultVerb
+			}
+			fields = append(fields, f)
+		}
+	}
+	if len(errs) > 0 {
+		return fields, fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return fields, nil
+}
+
+// Authorize returns a field.Forbidden error for each authorized field of obj which is written by the user of
+// the request in ctx, if the user is not authorized to write it.  old is the object being updated, or nil for
+// a create.  The fields are not authorized if ctx does not have an authorizer -- e.g. for objects which are not
+// written by an apiserver request.  Fields which cannot be parsed are not authorized.
+func Authorize(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	a, ok := AuthorizerFrom(ctx)
+	if !ok {
+		return nil
+	}
+	fields, _ := Fields(obj)
+	if len(fields) == 0 {
+		return nil
+	}
+	n, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return field.ErrorList{field.InternalError(nil, err)}
+	}
+	var o map[string]interface{}
+	if old != nil {
+		if o, err = runtime.DefaultUnstructuredConverter.ToUnstructured(old); err != nil {
+			return field.ErrorList{field.InternalError(nil, err)}
+		}
+	}
+
+	attributes := attributesFor(ctx, obj)
+	// the decisions by verb and subresource, as several fields may be authorized by the same subresource
+	decisions := map[[2]string]string{}
+	var errs field.ErrorList
+	for _, f := range fields {
+		paths, _ := resourceimmutable.Changed(n, o, f.Path)
+		if len(paths) == 0 {
+			continue
+		}
+		k := [2]string{f.Verb, f.Subresource}
+		reason, found := decisions[k]
+		if !found {
+			reason = authorize(ctx, a, attributes, f)
+			decisions[k] = reason
+		}
+		if reason == "" {
+			continue
+		}
+		for _, p := range paths {
+			errs = append(errs, field.Forbidden(p, reason))
+		}
+	}
+	return errs
+}
+
+// authorize returns the reason the user may not write field f, or empty if the user is authorized.
+func authorize(ctx context.Context, a authorizer.Authorizer, attributes authorizer.AttributesRecord,
+	f resourcestrategy.AuthorizedField) string {
+	attributes.Verb, attributes.Subresource = f.Verb, f.Subresource
+	decision, reason, err := a.Authorize(ctx, attributes)
+	if decision == authorizer.DecisionAllow {
+		return ""
+	}
+	msg := fmt.Sprintf("may only be written by users authorized to %s %s/%s", f.Verb, attributes.Resource,
+		f.Subresource)
+	if err != nil {
+		return fmt.Sprintf("%s: %v", msg, err)
+	}
+	if reason != "" {
+		return fmt.Sprintf("%s: %s", msg, reason)
+	}
+	return msg
+}
+
+// attributesFor returns the attributes of the request in ctx writing obj.  The resource is read from the
+// object if ctx does not have a resource request.
+func attributesFor(ctx context.Context, obj runtime.Object) authorizer.AttributesRecord {
+	user, _ := request.UserFrom(ctx)
+	attributes := authorizer.AttributesRecord{User: user, ResourceRequest: true}
+	if accessor, err := meta.Accessor(obj); err == nil {
+		attributes.Namespace, attributes.Name = accessor.GetNamespace(), accessor.GetName()
+	}
+	if info, ok := request.RequestInfoFrom(ctx); ok && info.IsResourceRequest {
+		attributes.APIGroup, attributes.APIVersion = info.APIGroup, info.APIVersion
+		attributes.Resource = info.Resource
+		if info.Namespace != "" {
+			attributes.Namespace = info.Namespace
+		}
+	} else if o, ok := obj.(interface {
+		GetGroupVersionResource() schema.GroupVersionResource
+	}); ok {
+		gvr := o.GetGroupVersionResource()
+		attributes.APIGroup, attributes.APIVersion = gvr.Group, gvr.Version
+		attributes.Resource = gvr.Resource
+	}
+	return attributes
+}
+
+// fieldsFor returns the authorized fields in the struct tags of t.
+func fieldsFor(t reflect.Type) *typeFields {
+	lock.Lock()
+	defer lock.Unlock()
+	if f, found := types[t]; found {
+		return f
+	}
+	f := &typeFields{}
+	var errs []string
+	structtag.Fields(t, func(sf reflect.StructField, path string) {
+		tag, ok := sf.Tag.Lookup(Tag)
+		if !ok {
+			return
+		}
+		parts := strings.Split(tag, ",")
+		af := resourcestrategy.AuthorizedField{Path: path, Subresource: parts[0], Verb: DefaultVerb}
+		if len(parts) > 1 {
+			af.Verb = parts[1]
+		}
+		if af.Subresource == "" || af.Verb == "" || len(parts) > 2 {
+			errs = append(errs, fmt.Sprintf("field %s has invalid %s tag %q", sf.Name, Tag, tag))
+			return
+		}
+		f.fields = append(f.fields, af)
+	})
+	if len(errs) > 0 {
+		f.err = fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	types[t] = f
+	return f
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resourceauthz
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcestrategy"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// widget authorizes the writes of its owner, budget and part suppliers by their struct tags, and of its size as
+// a budget.
+type widget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              spec `json:"spec,omitempty"`
+}
+
+type spec struct {
+	Size   int    `json:"size,omitempty"`
+	Color  string `json:"color,omitempty"`
+	Owner  string `json:"owner,omitempty" authorize:"owner"`
+	Budget int    `json:"budget,omitempty" authorize:"budget,approve"`
+	Parts  []part `json:"parts,omitempty"`
+}
+
+type part struct {
+	Name     string `json:"name"`
+	Supplier string `json:"supplier,omitempty" authorize:"suppliers"`
+}
+
+func (w *widget) DeepCopyObject() runtime.Object {
+	c := *w
+	w.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	c.Spec.Parts = append([]part(nil), w.Spec.Parts...)
+	return &c
+}
+
+func (w *widget) GetGroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+}
+
+func (w *widget) AuthorizedFields() []resourcestrategy.AuthorizedField {
+	return []resourcestrategy.AuthorizedField{{Path: "spec.size", Subresource: "budget", Verb: "approve"}}
+}
+
+func TestFields(t *testing.T) {
+	fields, err := Fields(&widget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []resourcestrategy.AuthorizedField{
+		{Path: "spec.owner", Subresource: "owner", Verb: "update"},
+		{Path: "spec.budget", Subresource: "budget", Verb: "approve"},
+		{Path: "spec.parts[*].supplier", Subresource: "suppliers", Verb: "update"},
+		{Path: "spec.size", Subresource: "budget", Verb: "approve"},
+	}
+	if fmt.Sprint(fields) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, fields)
+	}
+}
+
+// allow allows the users to update widgets/owner, and the admin to do anything.
+var allow = authorizer.AuthorizerFunc(func(a authorizer.Attributes) (authorizer.Decision, string, error) {
+	if a.GetUser().GetName() == "admin" {
+		return authorizer.DecisionAllow, "", nil
+	}
+	if a.GetResource() == "widgets" && a.GetSubresource() == "owner" && a.GetVerb() == "update" &&
+		a.GetNamespace() == "default" && a.GetName() == "one" {
+		return authorizer.DecisionAllow, "", nil
+	}
+	return authorizer.DecisionNoOpinion, "not allowed", nil
+})
+
+func newWidget(s spec) *widget {
+	return &widget{ObjectMeta: metav1.ObjectMeta{Name: "one", Namespace: "default"}, Spec: s}
+}
+
+func contextFor(name string) context.Context {
+	return WithAuthorizer(request.WithUser(context.Background(), &user.DefaultInfo{Name: name}), allow)
+}
+
+func TestAuthorize(t *testing.T) {
+	ctx := contextFor("user")
+	if errs := Authorize(ctx, newWidget(spec{Owner: "user"}), nil); len(errs) != 0 {
+		t.Errorf("expected the create to be authorized, got %v", errs)
+	}
+
+	errs := Authorize(ctx, newWidget(spec{Budget: 10, Parts: []part{{Name: "a", Supplier: "acme"}}}), nil)
+	expected := []string{
+		`spec.budget: Forbidden: may only be written by users authorized to approve widgets/budget: not allowed`,
+		`spec.parts[0].supplier: Forbidden: may only be written by users authorized to update widgets/suppliers: ` +
+			`not allowed`,
+	}
+	if fmt.Sprint(errs) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, errs)
+	}
+	if errs := Authorize(contextFor("admin"), newWidget(spec{Budget: 10}), nil); len(errs) != 0 {
+		t.Errorf("expected the admin to be authorized, got %v", errs)
+	}
+
+	// updates are only authorized for the fields they change
+	old := newWidget(spec{Budget: 10, Parts: []part{{Name: "a", Supplier: "acme"}}})
+	update := newWidget(spec{Budget: 10, Color: "changed", Parts: []part{{Name: "b", Supplier: "acme"}}})
+	if errs := Authorize(ctx, update, old); len(errs) != 0 {
+		t.Errorf("expected the update to be authorized, got %v", errs)
+	}
+	update = newWidget(spec{Size: 1, Parts: []part{{Name: "a", Supplier: "acme"}, {Name: "b", Supplier: "other"}}})
+	errs = Authorize(ctx, update, old)
+	expected = []string{"spec.budget", "spec.parts[1].supplier", "spec.size"}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for i := range expected {
+		if errs[i].Field != expected[i] || !strings.Contains(errs[i].Error(), "Forbidden") {
+			t.Errorf("expected %s to be forbidden, got %v", expected[i], errs[i])
+		}
+	}
+
+	// fields are not authorized without an authorizer
+	if errs := Authorize(context.Background(), newWidget(spec{Budget: 10}), nil); len(errs) != 0 {
+		t.Errorf("expected the fields not to be authorized, got %v", errs)
+	}
+}
+
+func TestRequestInfo(t *testing.T) {
+	var attributes authorizer.Attributes
+	a := authorizer.AuthorizerFunc(func(a authorizer.Attributes) (authorizer.Decision, string, error) {
+		attributes = a
+		return authorizer.DecisionAllow, "", nil
+	})
+	ctx := request.WithRequestInfo(WithAuthorizer(context.Background(), a), &request.RequestInfo{
+		IsResourceRequest: true, APIGroup: "example.com", APIVersion: "v2", Resource: "gadgets", Namespace: "other",
+	})
+	if errs := Authorize(ctx, newWidget(spec{Owner: "user"}), nil); len(errs) != 0 {
+		t.Fatalf("expected the create to be authorized, got %v", errs)
+	}
+	if attributes.GetAPIVersion() != "v2" || attributes.GetResource() != "gadgets" ||
+		attributes.GetNamespace() != "other" || attributes.GetSubresource() != "owner" {
+		t.Errorf("expected the resource of the request, got %v", attributes)
+	}
+}
+
+type Invalid struct {
+	metav1.TypeMeta `json:",inline"`
+	Name            string `json:"name" authorize:""`
+	Owner           string `json:"owner" authorize:"owner,update,patch"`
+	Budget          string `json:"budget" authorize:"budget"`
+}
+
+func (i *Invalid) DeepCopyObject() runtime.Object { c := *i; return &c }
+func (i *Invalid) AuthorizedFields() []resourcestrategy.AuthorizedField {
+	return []resourcestrategy.AuthorizedField{{Path: "spec[0]", Subresource: "spec"}, {Path: "kind"}}
+}
+
+func TestInvalidFields(t *testing.T) {
+	fields, err := Fields(&Invalid{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, s := range []string{
+		`field Name has invalid authorize tag ""`,
+		`field Owner has invalid authorize tag "owner,update,patch"`,
+		`invalid field path "spec[0]"`,
+		`field kind must have a subresource`,
+	} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected %q in %v", s, err)
+		}
+	}
+	if len(fields) != 1 || fields[0].Path != "budget" {
+		t.Errorf("expected the valid fields to be returned, got %v", fields)
+	}
+}
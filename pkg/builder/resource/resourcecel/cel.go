@@ -0,0 +1,302 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package resourcecel validates resources with the CEL expressions returned by their CELRules function.
+//
+// The expressions are evaluated against the json form of the objects: self is the object and oldSelf is the
+// old object for an update -- e.g. self.spec.replicas for the replicas field of the spec.  Fields which are
+// omitted from the json of an object must be checked with has before they are read, otherwise the rule cannot
+// be evaluated and the object is rejected.
+//
+//	func (f *Flunder) CELRules() []resourcestrategy.CELRule {
+//		return []resourcestrategy.CELRule{{
+//			Rule:    "!has(self.spec.flunderReference) || !has(self.spec.fischerReference)",
+//			Message: "flunderReference and fischerReference are mutually exclusive",
+//			Path:    "spec.fischerReference",
+//		}}
+//	}
+package resourcecel
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	celtypes "github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcestrategy"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// DefaultCostLimit is the maximum cost of evaluating a rule which does not set its CostLimit.  The cost of a
+// rule is the number of expressions evaluated, counting the expressions of comprehensions once per element.
+const DefaultCostLimit = 1000000
+
+// interruptCheckFrequency is the number of iterations of a comprehension after which the evaluation of a rule
+// checks whether its request has been cancelled.
+const interruptCheckFrequency = 100
+
+// program is a compiled rule.
+type program struct {
+	rule    resourcestrategy.CELRule
+	program cel.Program
+	// oldSelf is true if the rule uses oldSelf, so is only evaluated for updates.
+	oldSelf bool
+	// limit is the cost limit of the rule.
+	limit uint64
+}
+
+// typePrograms are the compiled rules of a type.
+type typePrograms struct {
+	programs []program
+	err      error
+}
+
+var (
+	lock  sync.Mutex
+	types = map[reflect.Type]*typePrograms{}
+
+	envOnce sync.Once
+	env     *cel.Env
+	envErr  error
+)
+
+// Compile compiles the rules of obj if it is a resourcestrategy.CELRulesProvider, and returns an error for the
+// rules which cannot be compiled.  The compiled rules are reused to validate the objects of obj's type.
+func Compile(obj interface{}) error {
+	return programsFor(obj).err
+}
+
+// Validate returns an error for each rule of obj which it does not satisfy.  old is the object being updated,
+// or nil for a create.  Rules which cannot be compiled are not evaluated.
+func Validate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	programs := programsFor(obj).programs
+	if len(programs) == 0 {
+		return nil
+	}
+	self, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return field.ErrorList{field.InternalError(nil, err)}
+	}
+	vars := map[string]interface{}{"self": self}
+	if old != nil {
+		oldSelf, err := runtime.DefaultUnstructuredConverter.ToUnstructured(old)
+		if err != nil {
+			return field.ErrorList{field.InternalError(nil, err)}
+		}
+		vars["oldSelf"] = oldSelf
+	}
+
+	var errs field.ErrorList
+	for _, p := range programs {
+		if p.oldSelf && old == nil {
+			continue
+		}
+		path, value := fieldFor(self, p.rule.Path)
+		result, _, err := p.program.Eval(&activation{vars: vars, ctx: ctx, remaining: p.limit})
+		switch {
+		case err != nil:
+			errs = append(errs, field.Invalid(path, value,
+				fmt.Sprintf("rule %q could not be evaluated: %v", p.rule.Rule, err)))
+		case result.Value() != true:
+			message := p.rule.Message
+			if message == "" {
+				message = fmt.Sprintf("failed rule: %s", p.rule.Rule)
+			}
+			errs = append(errs, field.Invalid(path, value, message))
+		}
+	}
+	return errs
+}
+
+// fieldFor returns the field.Path for the json path of a rule, and the value of the field in obj to report.
+// Objects and lists are reported by their type rather than their value.
+func fieldFor(obj map[string]interface{}, path string) (*field.Path, interface{}) {
+	if path == "" {
+		return nil, "object"
+	}
+	names := strings.Split(path, ".")
+	value, _, _ := unstructured.NestedFieldNoCopy(obj, names...)
+	switch value.(type) {
+	case map[string]interface{}:
+		value = "object"
+	case []interface{}:
+		value = "array"
+	}
+	return field.NewPath(names[0], names[1:]...), value
+}
+
+// programsFor returns the compiled rules of the type of obj, compiling them the first time they are used.
+func programsFor(obj interface{}) *typePrograms {
+	provider, ok := obj.(resourcestrategy.CELRulesProvider)
+	if !ok {
+		return &typePrograms{}
+	}
+	t := reflect.TypeOf(obj)
+	lock.Lock()
+	defer lock.Unlock()
+	if p, found := types[t]; found {
+		return p
+	}
+	p := &typePrograms{}
+	var errs []string
+	for _, rule := range provider.CELRules() {
+		prg, err := compile(rule)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("rule %q is invalid: %v", rule.Rule, err))
+			continue
+		}
+		p.programs = append(p.programs, prg)
+	}
+	if len(errs) > 0 {
+		p.err = fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	types[t] = p
+	return p
+}
+
+func compile(rule resourcestrategy.CELRule) (program, error) {
+	envOnce.Do(func() {
+		env, envErr = cel.NewEnv(cel.Declarations(
+			decls.NewVar("self", decls.Dyn),
+			decls.NewVar("oldSelf", decls.Dyn),
+		))
+	})
+	if envErr != nil {
+		return program{}, envErr
+	}
+
+	ast, issues := env.Compile(rule.Rule)
+	if issues.Err() != nil {
+		return program{}, issues.Err()
+	}
+	if t := ast.ResultType(); t.GetPrimitive() != exprpb.Type_BOOL && t.GetDyn() == nil {
+		return program{}, fmt.Errorf("must evaluate to a bool")
+	}
+	checked, err := cel.AstToCheckedExpr(ast)
+	if err != nil {
+		return program{}, err
+	}
+	p := program{rule: rule}
+	for _, ref := range checked.ReferenceMap {
+		if ref.Name == "oldSelf" {
+			p.oldSelf = true
+		}
+	}
+
+	p.limit = rule.CostLimit
+	if p.limit == 0 {
+		p.limit = DefaultCostLimit
+	}
+	p.program, err = env.Program(ast, cel.CustomDecorator(decorateCost))
+	return p, err
+}
+
+// activation holds the variables of an evaluation, and the cost remaining before the evaluation is stopped.
+type activation struct {
+	vars      map[string]interface{}
+	ctx       context.Context
+	remaining uint64
+	evaluated uint64
+}
+
+func (a *activation) ResolveName(name string) (interface{}, bool) {
+	v, found := a.vars[name]
+	return v, found
+}
+
+func (a *activation) Parent() interpreter.Activation { return nil }
+
+// spend charges the evaluation of an expression to the activation the variables of vars descend from.  It
+// returns an error once the cost limit is exceeded, or the request of the evaluation has been cancelled.
+func spend(vars interpreter.Activation) ref.Val {
+	for vars != nil {
+		a, ok := vars.(*activation)
+		if !ok {
+			vars = vars.Parent()
+			continue
+		}
+		if a.remaining == 0 {
+			return celtypes.NewErr("operation cancelled: actual cost limit exceeded")
+		}
+		a.remaining--
+		a.evaluated++
+		if a.evaluated%interruptCheckFrequency == 0 && a.ctx.Err() != nil {
+			return celtypes.NewErr("operation interrupted: %v", a.ctx.Err())
+		}
+		return nil
+	}
+	return nil
+}
+
+// decorateCost charges a cost of one for the evaluation of each expression of a program.  Comprehensions
+// evaluate their expressions once per element, so their cost grows with the size of the lists they iterate.
+func decorateCost(i interpreter.Interpretable) (interpreter.Interpretable, error) {
+	switch inst := i.(type) {
+	case *costAttr, *costConst, *cost:
+		return i, nil
+	case interpreter.InterpretableAttribute:
+		return &costAttr{InterpretableAttribute: inst}, nil
+	case interpreter.InterpretableConst:
+		return &costConst{InterpretableConst: inst}, nil
+	default:
+		return &cost{Interpretable: i}, nil
+	}
+}
+
+type cost struct {
+	interpreter.Interpretable
+}
+
+func (c *cost) Eval(vars interpreter.Activation) ref.Val {
+	if err := spend(vars); err != nil {
+		return err
+	}
+	return c.Interpretable.Eval(vars)
+}
+
+// costAttr implements interpreter.InterpretableAttribute, so the attribute may still be qualified when the
+// program is planned.
+type costAttr struct {
+	interpreter.InterpretableAttribute
+}
+
+func (c *costAttr) Eval(vars interpreter.Activation) ref.Val {
+	if err := spend(vars); err != nil {
+		return err
+	}
+	return c.InterpretableAttribute.Eval(vars)
+}
+
+// costConst implements interpreter.InterpretableConst, so constants may still be folded.
+type costConst struct {
+	interpreter.InterpretableConst
+}
+
+func (c *costConst) Eval(vars interpreter.Activation) ref.Val {
+	if err := spend(vars); err != nil {
+		return err
+	}
+	return c.InterpretableConst.Eval(vars)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resourcecel
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcestrategy"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+type Flunder struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              FlunderSpec `json:"spec,omitempty"`
+}
+
+type FlunderSpec struct {
+	FlunderReference string   `json:"flunderReference,omitempty"`
+	FischerReference string   `json:"fischerReference,omitempty"`
+	Replicas         int      `json:"replicas,omitempty"`
+	Tags             []string `json:"tags,omitempty"`
+}
+
+func (f *Flunder) DeepCopyObject() runtime.Object { c := *f; return &c }
+func (f *Flunder) CELRules() []resourcestrategy.CELRule {
+	return []resourcestrategy.CELRule{
+		{
+			Rule:    "!has(self.spec.flunderReference) || !has(self.spec.fischerReference)",
+			Message: "flunderReference and fischerReference are mutually exclusive",
+			Path:    "spec.fischerReference",
+		},
+		{Rule: "!has(oldSelf.spec.replicas) || self.spec.replicas >= oldSelf.spec.replicas", Path: "spec.replicas"},
+		{Rule: "!has(self.spec.tags) || self.spec.tags.all(t, t.size() < 10)", CostLimit: 20},
+	}
+}
+
+func newFlunder(spec FlunderSpec) *Flunder {
+	return &Flunder{ObjectMeta: metav1.ObjectMeta{Name: "one"}, Spec: spec}
+}
+
+func TestValidate(t *testing.T) {
+	ctx := context.Background()
+	if err := Compile(&Flunder{}); err != nil {
+		t.Fatal(err)
+	}
+	if errs := Validate(ctx, newFlunder(FlunderSpec{FlunderReference: "a", Tags: []string{"a"}}), nil); len(errs) != 0 {
+		t.Errorf("expected the Flunder to be valid, got %v", errs)
+	}
+
+	errs := Validate(ctx, newFlunder(FlunderSpec{FlunderReference: "a", FischerReference: "b"}), nil)
+	expected := `spec.fischerReference: Invalid value: "b": flunderReference and fischerReference are mutually exclusive`
+	if len(errs) != 1 || errs[0].Error() != expected {
+		t.Errorf("expected %q, got %v", expected, errs)
+	}
+
+	// rules using oldSelf are only evaluated for updates
+	old := newFlunder(FlunderSpec{Replicas: 3})
+	if errs := Validate(ctx, newFlunder(FlunderSpec{Replicas: 4}), old); len(errs) != 0 {
+		t.Errorf("expected the update to be valid, got %v", errs)
+	}
+	errs = Validate(ctx, newFlunder(FlunderSpec{Replicas: 2}), old)
+	expected = `spec.replicas: Invalid value: 2: failed rule: ` +
+		`!has(oldSelf.spec.replicas) || self.spec.replicas >= oldSelf.spec.replicas`
+	if len(errs) != 1 || errs[0].Error() != expected {
+		t.Errorf("expected %q, got %v", expected, errs)
+	}
+
+	tags := make([]string, 20)
+	for i := range tags {
+		tags[i] = "tag"
+	}
+	errs = Validate(ctx, newFlunder(FlunderSpec{Tags: tags}), nil)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "cost limit exceeded") {
+		t.Errorf("expected the rule to exceed its cost limit, got %v", errs)
+	}
+}
+
+type Looper struct {
+	metav1.TypeMeta `json:",inline"`
+	Items           []int `json:"items,omitempty"`
+}
+
+func (l *Looper) DeepCopyObject() runtime.Object { c := *l; return &c }
+func (l *Looper) CELRules() []resourcestrategy.CELRule {
+	return []resourcestrategy.CELRule{{Rule: "self.items.all(i, self.items.all(j, i + j >= 0))"}}
+}
+
+func TestValidateCancelled(t *testing.T) {
+	looper := &Looper{Items: make([]int, 100)}
+	if errs := Validate(context.Background(), looper, nil); len(errs) != 0 {
+		t.Errorf("expected the rule to be satisfied, got %v", errs)
+	}
+
+	// the evaluation is interrupted once the request has been cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errs := Validate(ctx, looper, nil)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "operation interrupted: context canceled") {
+		t.Errorf("expected the evaluation to be interrupted, got %v", errs)
+	}
+}
+
+type Invalid struct {
+	metav1.TypeMeta `json:",inline"`
+}
+
+func (i *Invalid) DeepCopyObject() runtime.Object { c := *i; return &c }
+func (i *Invalid) CELRules() []resourcestrategy.CELRule {
+	return []resourcestrategy.CELRule{{Rule: "self.spec ==="}, {Rule: "self.spec.size + 1"}, {Rule: "has(self.kind)"}}
+}
+
+func TestCompile(t *testing.T) {
+	err := Compile(&Invalid{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, s := range []string{`rule "self.spec ===" is invalid: ERROR`, `rule "self.spec.size + 1" is invalid`} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected %q in %v", s, err)
+		}
+	}
+	// the valid rules are still evaluated
+	if errs := Validate(context.Background(), &Invalid{}, nil); len(errs) != 1 {
+		t.Errorf("expected the valid rule to be evaluated, got %v", errs)
+	}
+}
@@ -0,0 +1,316 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package resourcedefault defaults the fields of resources from their `default` struct tags.
+//
+// The default of a field is applied when the field has its zero value -- e.g. an empty string or a nil pointer.
+// Strings are defaulted to the tag as is, and booleans and numbers to the parsed tag.  Other fields, and
+// pointers to them, are defaulted to the tag parsed as json -- e.g. `default:"[\"a\", \"b\"]"` for a slice or
+// `default:"{}"` for a struct -- or else to the tag as a json string -- e.g. `default:"1Gi"` for a Quantity.
+//
+// Fields are defaulted before the fields they contain, so the fields of a struct defaulted to `default:"{}"`
+// are also defaulted, as are the fields of the structs in slices and maps.
+//
+//	type DeploymentSpec struct {
+//		Replicas *int32   `json:"replicas,omitempty" default:"1"`
+//		Strategy Strategy `json:"strategy,omitempty"`
+//	}
+//
+//	type Strategy struct {
+//		Type string `json:"type,omitempty" default:"RollingUpdate"`
+//	}
+package resourcedefault
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/internal/structtag"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/kube-openapi/pkg/common"
+)
+
+// Tag is the struct tag containing the default of a field.
+const Tag = "default"
+
+// fieldDefault is the default of a field of a struct.
+type fieldDefault struct {
+	index int
+	// name is the json name of the field, or empty if the field is inlined.
+	name string
+	// tag is the default of the field, or empty if the field has no default.
+	tag string
+	// json is the default of the field in the OpenAPI schema.
+	json interface{}
+}
+
+// typeDefaults are the defaults of the fields of a struct.
+type typeDefaults struct {
+	fields []fieldDefault
+	err    error
+}
+
+var (
+	lock  sync.Mutex
+	types = map[reflect.Type]*typeDefaults{}
+	// defaulted caches whether a type contains fields with defaults.
+	defaulted = map[reflect.Type]bool{}
+)
+
+// Validate returns an error for each default tag of the fields of obj which cannot be parsed.
+func Validate(obj interface{}) error {
+	var errs field.ErrorList
+	structtag.Structs(reflect.TypeOf(obj), field.NewPath(structtag.TypeName(reflect.TypeOf(obj))),
+		func(t reflect.Type, path *field.Path) {
+			if err := defaultsFor(t).err; err != nil {
+				errs = append(errs, field.Invalid(path, t.String(), err.Error()))
+			}
+		})
+	return errs.ToAggregate()
+}
+
+// Default sets the fields of obj which have their zero value to their defaults.  obj must be a pointer.
+// The defaults must have been validated with Validate.
+func Default(obj interface{}) {
+	v := reflect.ValueOf(obj)
+	if !hasDefaults(v.Type()) {
+		return
+	}
+	defaultValue(v)
+}
+
+func defaultValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			defaultValue(v.Elem())
+		}
+	case reflect.Struct:
+		for _, f := range defaultsFor(v.Type()).fields {
+			fv := v.Field(f.index)
+			if f.tag != "" && fv.IsZero() {
+				if d, err := parse(fv.Type(), f.tag); err == nil {
+					fv.Set(d)
+				}
+			}
+			if hasDefaults(fv.Type()) {
+				defaultValue(fv)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			defaultValue(v.Index(i))
+		}
+	case reflect.Map:
+		// map values are not addressable, so the values are defaulted and then set
+		for _, k := range v.MapKeys() {
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(k))
+			defaultValue(e)
+			v.SetMapIndex(k, e)
+		}
+	}
+}
+
+// hasDefaults returns true if t contains a field with a default.
+func hasDefaults(t reflect.Type) bool {
+	lock.Lock()
+	result, found := defaulted[t]
+	lock.Unlock()
+	if found {
+		return result
+	}
+	structtag.Structs(t, nil, func(t reflect.Type, _ *field.Path) {
+		for _, f := range defaultsFor(t).fields {
+			if f.tag != "" {
+				result = true
+			}
+		}
+	})
+	lock.Lock()
+	defaulted[t] = result
+	lock.Unlock()
+	return result
+}
+
+// defaultsFor returns the defaults of the fields of struct t.
+func defaultsFor(t reflect.Type) *typeDefaults {
+	lock.Lock()
+	defer lock.Unlock()
+	if d, found := types[t]; found {
+		return d
+	}
+	d := &typeDefaults{}
+	var errs []string
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, ok := structtag.JSONName(sf)
+		if !ok {
+			continue
+		}
+		f := fieldDefault{index: i, name: name, tag: sf.Tag.Get(Tag)}
+		if f.tag != "" {
+			v, err := parse(sf.Type, f.tag)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("field %s has invalid default %q: %v", sf.Name, f.tag, err))
+				continue
+			}
+			f.json = schemaValue(v, f.tag)
+		}
+		d.fields = append(d.fields, f)
+	}
+	if len(errs) > 0 {
+		d.err = fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	types[t] = d
+	return d
+}
+
+// parse returns the default of a value of type t.
+func parse(t reflect.Type, tag string) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	if t.Kind() == reflect.Ptr && isScalar(t.Elem()) {
+		e, err := parse(t.Elem(), tag)
+		if err != nil {
+			return v, err
+		}
+		v.Set(reflect.New(t.Elem()))
+		v.Elem().Set(e)
+		return v, nil
+	}
+	if _, ok := v.Addr().Interface().(json.Unmarshaler); ok || !isScalar(t) {
+		if err := json.Unmarshal([]byte(tag), v.Addr().Interface()); err != nil {
+			quoted, _ := json.Marshal(tag)
+			if json.Unmarshal(quoted, v.Addr().Interface()) != nil {
+				return v, err
+			}
+		}
+		return v, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(tag)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(tag)
+		if err != nil {
+			return v, err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(tag, 10, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(tag, 10, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(tag, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetFloat(f)
+	}
+	return v, nil
+}
+
+func isScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// schemaValue returns the json value of a default for the OpenAPI schema.  Defaults parsed from json are
+// published as written, rather than as serialized, so fields which are not set by the default are omitted.
+func schemaValue(v reflect.Value, tag string) interface{} {
+	var result interface{}
+	if !isScalar(v.Type()) && (v.Kind() != reflect.Ptr || !isScalar(v.Type().Elem())) &&
+		json.Unmarshal([]byte(tag), &result) == nil {
+		return result
+	}
+	b, err := json.Marshal(v.Interface())
+	if err != nil || json.Unmarshal(b, &result) != nil {
+		return tag
+	}
+	return result
+}
+
+// OpenAPIDefinitions returns the definitions of defs with the defaults of the fields of the objs and of the
+// types they contain.
+func OpenAPIDefinitions(defs common.GetOpenAPIDefinitions, objs ...interface{}) common.GetOpenAPIDefinitions {
+	// the defaults by definition name and property
+	defaults := map[string]map[string]interface{}{}
+	for i := range objs {
+		structtag.Structs(reflect.TypeOf(objs[i]), nil, func(t reflect.Type, _ *field.Path) {
+			addSchemaDefaults(t, structtag.TypeName(t), defaults)
+		})
+	}
+	return func(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+		result := defs(ref)
+		for name, properties := range defaults {
+			def, found := result[name]
+			if !found {
+				continue
+			}
+			for property, value := range properties {
+				if p, found := def.Schema.Properties[property]; found {
+					p.Default = value
+					def.Schema.Properties[property] = p
+				}
+			}
+			result[name] = def
+		}
+		return result
+	}
+}
+
+// addSchemaDefaults adds the defaults of the fields of struct t to the definition name, including the fields of
+// inlined structs.
+func addSchemaDefaults(t reflect.Type, name string, defaults map[string]map[string]interface{}) {
+	for _, f := range defaultsFor(t).fields {
+		if f.name == "" {
+			ft := t.Field(f.index).Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addSchemaDefaults(ft, name, defaults)
+			}
+			continue
+		}
+		if f.tag == "" {
+			continue
+		}
+		if defaults[name] == nil {
+			defaults[name] = map[string]interface{}{}
+		}
+		defaults[name][f.name] = f.json
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resourcedefault
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/spec"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/openapi"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type Pool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              PoolSpec `json:"spec,omitempty"`
+}
+
+type PoolSpec struct {
+	Name     string             `json:"name,omitempty" default:"pool"`
+	Replicas *int32             `json:"replicas,omitempty" default:"3"`
+	Enabled  bool               `json:"enabled,omitempty" default:"true"`
+	Ratio    float64            `json:"ratio,omitempty" default:"0.5"`
+	Zones    []string           `json:"zones,omitempty" default:"[\"a\",\"b\"]"`
+	Memory   *resource.Quantity `json:"memory,omitempty" default:"1Gi"`
+	Timeout  metav1.Duration    `json:"timeout,omitempty" default:"30s"`
+	Policy   *Policy            `json:"policy,omitempty" default:"{}"`
+	Members  []Member           `json:"members,omitempty"`
+	Labelled map[string]Member  `json:"labelled,omitempty"`
+	Optional *Policy            `json:"optional,omitempty"`
+	Internal Policy             `json:"-"`
+}
+
+type Policy struct {
+	Mode string `json:"mode,omitempty" default:"Retain"`
+}
+
+type Member struct {
+	Weight int `json:"weight,omitempty" default:"1"`
+}
+
+func TestDefault(t *testing.T) {
+	if err := Validate(&Pool{}); err != nil {
+		t.Fatal(err)
+	}
+	three, two := int32(3), int32(2)
+	memory := resource.MustParse("1Gi")
+
+	p := &Pool{Spec: PoolSpec{
+		Members:  []Member{{}, {Weight: 5}},
+		Labelled: map[string]Member{"x": {}},
+	}}
+	Default(p)
+	expected := PoolSpec{
+		Name:     "pool",
+		Replicas: &three,
+		Enabled:  true,
+		Ratio:    0.5,
+		Zones:    []string{"a", "b"},
+		Memory:   &memory,
+		Timeout:  metav1.Duration{Duration: 30 * time.Second},
+		Policy:   &Policy{Mode: "Retain"},
+		Members:  []Member{{Weight: 1}, {Weight: 5}},
+		Labelled: map[string]Member{"x": {Weight: 1}},
+		// fields which are not serialized are not defaulted
+		Internal: Policy{},
+	}
+	if !reflect.DeepEqual(p.Spec, expected) {
+		t.Errorf("expected %+v, got %+v", expected, p.Spec)
+	}
+
+	// set fields are not defaulted
+	p = &Pool{Spec: PoolSpec{Name: "set", Replicas: &two, Policy: &Policy{Mode: "Delete"}}}
+	Default(p)
+	if p.Spec.Name != "set" || *p.Spec.Replicas != 2 || p.Spec.Policy.Mode != "Delete" {
+		t.Errorf("expected set fields to be kept, got %+v", p.Spec)
+	}
+
+	// the defaults are not shared between objects
+	p.Spec.Zones = nil
+	Default(p)
+	p.Spec.Zones[0] = "modified"
+	other := &Pool{}
+	Default(other)
+	if other.Spec.Zones[0] != "a" {
+		t.Errorf("expected the default to be copied, got %v", other.Spec.Zones)
+	}
+}
+
+type Invalid struct {
+	Count  int      `json:"count" default:"many"`
+	Nested []Broken `json:"nested"`
+}
+
+type Broken struct {
+	Enabled bool `json:"enabled" default:"maybe"`
+}
+
+func TestValidate(t *testing.T) {
+	err := Validate(&Invalid{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, s := range []string{`field Count has invalid default "many"`, `field Enabled has invalid default "maybe"`} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected %q in %v", s, err)
+		}
+	}
+}
+
+func TestOpenAPIDefinitions(t *testing.T) {
+	defs := OpenAPIDefinitions(openapi.DefinitionsFor([]interface{}{&Pool{}}), &Pool{})(
+		func(path string) spec.Ref { return spec.MustCreateRef("#/definitions/" + path) })
+
+	name := func(obj interface{}) string { return openapi.DefinitionName(obj) }
+	properties := defs[name(&PoolSpec{})].Schema.Properties
+	expected := map[string]interface{}{
+		"name":     "pool",
+		"replicas": float64(3),
+		"enabled":  true,
+		"ratio":    0.5,
+		"zones":    []interface{}{"a", "b"},
+		"memory":   "1Gi",
+		"timeout":  "30s",
+		"policy":   map[string]interface{}{},
+	}
+	for property, value := range expected {
+		if !reflect.DeepEqual(properties[property].Default, value) {
+			t.Errorf("expected %s to default to %v, got %#v", property, value, properties[property].Default)
+		}
+	}
+	if properties["members"].Default != nil {
+		t.Errorf("expected fields without a default tag to have no default, got %v", properties["members"].Default)
+	}
+	if d := defs[name(&Member{})].Schema.Properties["weight"].Default; d != float64(1) {
+		t.Errorf("expected the defaults of referenced types to be published, got %v", d)
+	}
+}
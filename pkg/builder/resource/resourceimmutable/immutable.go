@@ -0,0 +1,260 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package resourceimmutable forbids updates from changing the immutable fields of resources.
+//
+// Fields are immutable if they have an `immutable:"true"` struct tag, or are returned by the ImmutableFields
+// function of a resourcestrategy.ImmutableFieldsProvider.  Fields with an `immutable:"once"` tag are write-once:
+// an update may set them if they are unset -- e.g. an empty string or slice, or a nil pointer -- but not change
+// them afterwards.
+//
+// The fields of the structs in slices and maps are compared for the elements with the same index or key in the
+// old and new object, so elements may be added and removed unless the slice or map is itself immutable.  The tags
+// of recursive types are only read for their outermost occurrence.
+//
+//	type VolumeSpec struct {
+//		StorageClassName string `json:"storageClassName,omitempty" immutable:"true"`
+//		VolumeName       string `json:"volumeName,omitempty" immutable:"once"`
+//	}
+package resourceimmutable
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/internal/structtag"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcestrategy"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Tag is the struct tag marking a field immutable.
+const Tag = "immutable"
+
+// typeFields are the immutable fields in the struct tags of a type.
+type typeFields struct {
+	fields []resourcestrategy.ImmutableField
+	err    error
+}
+
+var (
+	lock  sync.Mutex
+	types = map[reflect.Type]*typeFields{}
+)
+
+// Fields returns the immutable fields of obj, from its struct tags and from its ImmutableFields function if obj
+// is a resourcestrategy.ImmutableFieldsProvider.  Fields returns an error for the tags and paths which cannot
+// be parsed, as well as the fields which can be parsed.
+func Fields(obj interface{}) ([]resourcestrategy.ImmutableField, error) {
+	t := fieldsFor(reflect.TypeOf(obj))
+	fields := append([]resourcestrategy.ImmutableField{}, t.fields...)
+	var errs []string
+	if t.err != nil {
+		errs = append(errs, t.err.Error())
+	}
+	if p, ok := obj.(resourcestrategy.ImmutableFieldsProvider); ok {
+		for _, f := range p.ImmutableFields() {
+			if _, err := parsePath(f.Path); err != nil {
+				errs = append(errs, fmt.Sprintf("invalid immutable field path %q", f.Path))
+				continue
+			}
+			fields = append(fields, f)
+		}
+	}
+	if len(errs) > 0 {
+		return fields, fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return fields, nil
+}
+
+// ValidateUpdate returns a field.Forbidden error for each immutable field of obj which is changed from old.
+// Fields which cannot be parsed are not validated.
+func ValidateUpdate(obj, old runtime.Object) field.ErrorList {
+	fields, _ := Fields(obj)
+	if len(fields) == 0 {
+		return nil
+	}
+	o, err := runtime.DefaultUnstructuredConverter.ToUnstructured(old)
+	if err != nil {
+		return field.ErrorList{field.InternalError(nil, err)}
+	}
+	n, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return field.ErrorList{field.InternalError(nil, err)}
+	}
+
+	var errs field.ErrorList
+	for _, f := range fields {
+		segments, _ := parsePath(f.Path)
+		for _, p := range changed(o, n, segments, nil, f.WriteOnce, false) {
+			errs = append(errs, field.Forbidden(p, apivalidation.FieldImmutableErrorMsg))
+		}
+	}
+	return errs
+}
+
+// Changed returns the fields matched by path which are changed from old to obj -- e.g. spec.volumes[0].name
+// for the path "spec.volumes[*].name".  obj and old are the unstructured forms of the objects, and old is nil
+// for a create.  Unlike for the immutable fields, the fields of elements which are added or removed are changed,
+// unless the fields are unset.
+func Changed(obj, old map[string]interface{}, path string) ([]*field.Path, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return changed(old, obj, segments, nil, false, true), nil
+}
+
+// segment is an element of a path -- e.g. "volumes[*]" for the elements of the volumes list.
+type segment struct {
+	name string
+	// wildcards is the number of lists or maps matched by the segment -- e.g. 2 for "matrix[*][*]".
+	wildcards int
+}
+
+func parsePath(path string) ([]segment, error) {
+	var segments []segment
+	for _, s := range strings.Split(path, ".") {
+		seg := segment{}
+		for strings.HasSuffix(s, structtag.Wildcard) {
+			s = strings.TrimSuffix(s, structtag.Wildcard)
+			seg.wildcards++
+		}
+		if s == "" || strings.ContainsAny(s, "[]") {
+			return nil, fmt.Errorf("invalid field path %q", path)
+		}
+		seg.name = s
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
+
+// changed returns the fields matched by the segments which are changed from old to obj.  Unset fields may be
+// set if writeOnce.  The elements of lists and maps which are only in old or obj are compared to unset elements
+// if all, and otherwise are skipped -- so fields which are unset in both are not changed.
+func changed(old, obj interface{}, segments []segment, path *field.Path, writeOnce, all bool) []*field.Path {
+	if len(segments) == 0 {
+		if reflect.DeepEqual(old, obj) || (writeOnce && isUnset(old)) || (all && isUnset(old) && isUnset(obj)) {
+			return nil
+		}
+		return []*field.Path{path}
+	}
+	s := segments[0]
+	return elements(child(old, s.name), child(obj, s.name), s.wildcards, path.Child(s.name), all,
+		func(old, obj interface{}, path *field.Path) []*field.Path {
+			return changed(old, obj, segments[1:], path, writeOnce, all)
+		})
+}
+
+// elements calls fn for the elements of old and obj with the same index or key, descending depth lists or maps.
+// The elements which are only in old or obj are included if all.
+func elements(old, obj interface{}, depth int, path *field.Path, all bool,
+	fn func(old, obj interface{}, path *field.Path) []*field.Path) []*field.Path {
+	if depth == 0 {
+		return fn(old, obj, path)
+	}
+	var paths []*field.Path
+	o, oldIsMap := old.(map[string]interface{})
+	n, objIsMap := obj.(map[string]interface{})
+	if oldIsMap || objIsMap {
+		var keys []string
+		for k := range o {
+			if _, found := n[k]; found || all {
+				keys = append(keys, k)
+			}
+		}
+		for k := range n {
+			if _, found := o[k]; !found && all {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			paths = append(paths, elements(o[k], n[k], depth-1, path.Key(k), all, fn)...)
+		}
+		return paths
+	}
+
+	ol, _ := old.([]interface{})
+	nl, _ := obj.([]interface{})
+	length := len(ol)
+	if (all && len(nl) > length) || (!all && len(nl) < length) {
+		length = len(nl)
+	}
+	for i := 0; i < length; i++ {
+		paths = append(paths, elements(index(ol, i), index(nl, i), depth-1, path.Index(i), all, fn)...)
+	}
+	return paths
+}
+
+// index returns the element i of l, or nil if l does not have the element.
+func index(l []interface{}, i int) interface{} {
+	if i < len(l) {
+		return l[i]
+	}
+	return nil
+}
+
+func child(obj interface{}, name string) interface{} {
+	if m, ok := obj.(map[string]interface{}); ok {
+		return m[name]
+	}
+	return nil
+}
+
+// isUnset returns true if the json value v is missing or empty.
+func isUnset(v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case []interface{}:
+		return len(value) == 0
+	case map[string]interface{}:
+		return len(value) == 0
+	}
+	return false
+}
+
+// fieldsFor returns the immutable fields in the struct tags of t.
+func fieldsFor(t reflect.Type) *typeFields {
+	lock.Lock()
+	defer lock.Unlock()
+	if f, found := types[t]; found {
+		return f
+	}
+	f := &typeFields{}
+	var errs []string
+	structtag.Fields(t, func(sf reflect.StructField, path string) {
+		switch tag := sf.Tag.Get(Tag); tag {
+		case "", "false":
+		case "true", "once":
+			f.fields = append(f.fields, resourcestrategy.ImmutableField{Path: path, WriteOnce: tag == "once"})
+		default:
+			errs = append(errs, fmt.Sprintf("field %s has invalid %s tag %q", sf.Name, Tag, tag))
+		}
+	})
+	if len(errs) > 0 {
+		f.err = fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	types[t] = f
+	return f
+}
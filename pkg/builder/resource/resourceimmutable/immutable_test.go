@@ -0,0 +1,173 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resourceimmutable
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcestrategy"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+type Volume struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              VolumeSpec   `json:"spec,omitempty"`
+	Status            VolumeStatus `json:"status,omitempty"`
+}
+
+type VolumeSpec struct {
+	StorageClassName string            `json:"storageClassName,omitempty" immutable:"true"`
+	VolumeName       string            `json:"volumeName,omitempty" immutable:"once"`
+	Size             int               `json:"size,omitempty"`
+	Mounts           []Mount           `json:"mounts,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	Next             *VolumeSpec       `json:"next,omitempty"`
+}
+
+type Mount struct {
+	Path     string `json:"path" immutable:"true"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+}
+
+type VolumeStatus struct {
+	Node string `json:"node,omitempty" immutable:"once"`
+}
+
+func (v *Volume) DeepCopyObject() runtime.Object { c := *v; return &c }
+func (v *Volume) ImmutableFields() []resourcestrategy.ImmutableField {
+	return []resourcestrategy.ImmutableField{{Path: "spec.labels[*]", WriteOnce: true}}
+}
+
+func TestFields(t *testing.T) {
+	fields, err := Fields(&Volume{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// recursive types are only walked once
+	expected := []resourcestrategy.ImmutableField{
+		{Path: "spec.storageClassName"},
+		{Path: "spec.volumeName", WriteOnce: true},
+		{Path: "spec.mounts[*].path"},
+		{Path: "status.node", WriteOnce: true},
+		{Path: "spec.labels[*]", WriteOnce: true},
+	}
+	if fmt.Sprint(fields) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, fields)
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	old := &Volume{
+		Spec: VolumeSpec{
+			StorageClassName: "fast",
+			Mounts:           []Mount{{Path: "/a"}, {Path: "/b"}},
+			Labels:           map[string]string{"app": "one", "unset": ""},
+		},
+	}
+
+	allowed := &Volume{
+		Spec: VolumeSpec{
+			StorageClassName: "fast",
+			VolumeName:       "pv-1",
+			Size:             2,
+			Mounts:           []Mount{{Path: "/a", ReadOnly: true}, {Path: "/b"}},
+			Labels:           map[string]string{"app": "one", "unset": "set", "new": "label"},
+		},
+		Status: VolumeStatus{Node: "node-1"},
+	}
+	if errs := ValidateUpdate(allowed, old); len(errs) != 0 {
+		t.Errorf("expected the update to be allowed, got %v", errs)
+	}
+
+	changed := &Volume{
+		Spec: VolumeSpec{
+			StorageClassName: "slow",
+			VolumeName:       "pv-2",
+			Mounts:           []Mount{{Path: "/a"}, {Path: "/c"}},
+			Labels:           map[string]string{"app": "two"},
+		},
+		Status: VolumeStatus{Node: "node-2"},
+	}
+	errs := ValidateUpdate(changed, allowed)
+	expected := []string{"spec.storageClassName", "spec.volumeName", "spec.mounts[1].path", "status.node",
+		"spec.labels[app]"}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for i := range expected {
+		if errs[i].Field != expected[i] || !strings.Contains(errs[i].Error(), "Forbidden: field is immutable") {
+			t.Errorf("expected %s to be forbidden, got %v", expected[i], errs[i])
+		}
+	}
+	// write-once fields may not be unset, but elements may be removed
+	if errs := ValidateUpdate(&Volume{Spec: VolumeSpec{StorageClassName: "fast"}}, allowed); len(errs) != 2 {
+		t.Errorf("expected only unsetting the write-once fields to be forbidden, got %v", errs)
+	}
+}
+
+type Invalid struct {
+	metav1.TypeMeta `json:",inline"`
+	Name            string `json:"name" immutable:"always"`
+}
+
+func (i *Invalid) DeepCopyObject() runtime.Object { c := *i; return &c }
+func (i *Invalid) ImmutableFields() []resourcestrategy.ImmutableField {
+	return []resourcestrategy.ImmutableField{{Path: "spec[0]"}, {Path: "name"}}
+}
+
+func TestInvalidFields(t *testing.T) {
+	fields, err := Fields(&Invalid{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, s := range []string{`field Name has invalid immutable tag "always"`, `invalid immutable field path "spec[0]"`} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected %q in %v", s, err)
+		}
+	}
+	if len(fields) != 1 || fields[0].Path != "name" {
+		t.Errorf("expected the valid fields to be returned, got %v", fields)
+	}
+}
+
+func TestChanged(t *testing.T) {
+	old := map[string]interface{}{"spec": map[string]interface{}{
+		"mounts": []interface{}{map[string]interface{}{"path": "/a"}, map[string]interface{}{"path": "/b"}},
+	}}
+	obj := map[string]interface{}{"spec": map[string]interface{}{
+		"mounts": []interface{}{map[string]interface{}{"path": "/a"}, map[string]interface{}{"path": ""},
+			map[string]interface{}{"path": "/c"}},
+	}}
+	paths, err := Changed(obj, old, "spec.mounts[*].path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(paths) != "[spec.mounts[1].path spec.mounts[2].path]" {
+		t.Errorf("expected the changed and added paths, got %v", paths)
+	}
+	// all the set fields are changed by a create
+	if paths, _ := Changed(obj, nil, "spec.mounts[*].path"); len(paths) != 2 {
+		t.Errorf("expected the set paths, got %v", paths)
+	}
+	if _, err := Changed(obj, old, "spec.mounts[0]"); err == nil {
+		t.Error("expected an error for an invalid path")
+	}
+}
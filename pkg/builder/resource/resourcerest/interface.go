@@ -48,7 +48,8 @@ type Lister = rest.Lister
 // Patcher if implemented will expose POST and GET endpoints for the resource and publish them in the Kubernetes
 // discovery service and OpenAPI.
 //
-// Required by `kubectl apply` and most controllers.
+// Required by `kubectl apply` and most controllers.  Patchers also support server-side apply
+// (`kubectl apply --server-side`) using the OpenAPI definitions registered with the builder.
 type Patcher = rest.Patcher
 
 // Redirector know how to return a remote resource's location.
@@ -46,6 +46,14 @@ type Defaulter interface {
 	Default()
 }
 
+// TagDefaulter may be implemented by a version of a resource to default its fields from their `default` struct
+// tags, as described by the resourcedefault package.  The fields are defaulted before the Default function of
+// the version is called, and their defaults are published in the OpenAPI schema of the resource.
+type TagDefaulter interface {
+	// DefaultFromTags returns true if the fields of the version are defaulted from their `default` struct tags.
+	DefaultFromTags() bool
+}
+
 // PrepareForCreater functions are invoked before an object is stored during creation.  If PrepareForCreate
 // is implemented for a type, it will be invoked before creating an object of that type.
 //
@@ -78,3 +86,63 @@ type Validater interface {
 type ValidateUpdater interface {
 	ValidateUpdate(ctx context.Context, obj runtime.Object) field.ErrorList
 }
+
+// ImmutableFieldsProvider may be implemented by a resource to list the fields which may not be changed by an
+// update, in addition to the fields with an `immutable` struct tag.  The fields are enforced before ValidateUpdate
+// is called, for updates of the resource and of its status.  See the resourceimmutable package.
+type ImmutableFieldsProvider interface {
+	// ImmutableFields returns the immutable fields of the resource.
+	ImmutableFields() []ImmutableField
+}
+
+// ImmutableField is a field which may not be changed by an update.
+type ImmutableField struct {
+	// Path is the json path of the field -- e.g. "spec.storageClassName".  The elements of lists and the values
+	// of maps are matched by [*] -- e.g. "spec.volumes[*].name".
+	Path string
+	// WriteOnce allows an update to set the field if it is unset -- e.g. empty or missing.
+	WriteOnce bool
+}
+
+// CELRulesProvider may be implemented by a resource to validate it with CEL expressions, as described by the
+// resourcecel package.  The rules are compiled when the apiserver is built, and evaluated before Validate and
+// ValidateUpdate are called.  The rules must be the same for every object of the resource's type.
+type CELRulesProvider interface {
+	// CELRules returns the rules of the resource.
+	CELRules() []CELRule
+}
+
+// CELRule is a CEL expression which must evaluate to true for an object to be valid.
+type CELRule struct {
+	// Rule is the expression -- e.g. "!has(self.spec.flunderReference) || !has(self.spec.fischerReference)".
+	// self is the object, and oldSelf is the old object for an update.  Rules using oldSelf are only evaluated
+	// for updates.
+	Rule string
+	// Message is the error message for the objects which do not satisfy the rule.  Defaults to the rule.
+	Message string
+	// Path is the json path of the field the errors are reported for -- e.g. "spec.fischerReference".
+	// Defaults to the object.
+	Path string
+	// CostLimit is the maximum cost of evaluating the rule.  Defaults to resourcecel.DefaultCostLimit.
+	CostLimit uint64
+}
+
+// FieldAuthorizer may be implemented by a resource to restrict which users may write its fields, in addition
+// to the fields with an `authorize` struct tag.  The users must be authorized for a verb on a pseudo-subresource
+// of the resource to create an object with the fields set, or to update them.  See the resourceauthz package.
+type FieldAuthorizer interface {
+	// AuthorizedFields returns the fields which may only be written by authorized users.
+	AuthorizedFields() []AuthorizedField
+}
+
+// AuthorizedField is a field which may only be written by the users authorized for a verb on a pseudo-subresource
+// of its resource.
+type AuthorizedField struct {
+	// Path is the json path of the field, as for an ImmutableField -- e.g. "spec.owner".
+	Path string
+	// Subresource is the pseudo-subresource the users must be authorized for -- e.g. "owner" to authorize the
+	// users for widgets/owner.
+	Subresource string
+	// Verb is the verb the users must be authorized for.  Defaults to "update".
+	Verb string
+}
@@ -0,0 +1,485 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package resourcevalidation validates the fields of resources against the rules in their struct tags.
+//
+// The rules of a field are set by the following struct tags:
+//
+//	required:"true"     the field must be set -- e.g. a non-empty string or slice, or a non-nil pointer
+//	minimum:"1"         the number must be greater than or equal to 1
+//	maximum:"10"        the number must be less than or equal to 10
+//	maxLength:"63"      the string must have at most 63 characters
+//	pattern:"^[a-z]+$"  the string must match the regular expression
+//	enum:"A,B"          the string or number must be one of the comma separated values
+//	format:"dns1123"    the string must be a DNS-1123 subdomain, or a DNS-1123 label for "dns1123-label"
+//
+// Other than required, the rules apply to strings, numbers and pointers to them.  Empty strings and nil
+// pointers are unset, so are only checked by required.
+//
+// The fields of structs, including the structs in slices and maps, are validated recursively.  Errors identify
+// the invalid fields by their json path -- e.g. spec.members[0].name.
+//
+//	type FlunderSpec struct {
+//		Reference     string        `json:"reference,omitempty" format:"dns1123"`
+//		ReferenceType ReferenceType `json:"referenceType,omitempty" enum:"Flunder,Fischer"`
+//	}
+package resourcevalidation
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/internal/structtag"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/kube-openapi/pkg/common"
+)
+
+// formats are the functions validating the values of each format.
+var formats = map[string]func(string) []string{
+	"dns1123":       validation.IsDNS1123Subdomain,
+	"dns1123-label": validation.IsDNS1123Label,
+}
+
+// fieldRules are the rules of a field of a struct.
+type fieldRules struct {
+	index int
+	// name is the json name of the field, or empty if the field is inlined.
+	name      string
+	required  bool
+	minimum   *float64
+	maximum   *float64
+	maxLength *int64
+	pattern   *regexp.Regexp
+	// enum are the values of the field, as written and as parsed for the field's type.
+	enum       []string
+	enumValues []interface{}
+	format     string
+}
+
+// scalar returns true if the field has rules which apply to its value rather than whether it is set.
+func (f *fieldRules) scalar() bool {
+	return f.minimum != nil || f.maximum != nil || f.maxLength != nil || f.pattern != nil || f.enum != nil ||
+		f.format != ""
+}
+
+// typeRules are the rules of the fields of a struct.
+type typeRules struct {
+	fields []fieldRules
+	err    error
+}
+
+var (
+	lock  sync.Mutex
+	types = map[reflect.Type]*typeRules{}
+	// validated caches whether a type contains fields with rules.
+	validated = map[reflect.Type]bool{}
+)
+
+// ValidateRules returns an error for each rule of the fields of obj which cannot be parsed, or which does not
+// apply to the type of its field.
+func ValidateRules(obj interface{}) error {
+	var errs field.ErrorList
+	structtag.Structs(reflect.TypeOf(obj), field.NewPath(structtag.TypeName(reflect.TypeOf(obj))),
+		func(t reflect.Type, path *field.Path) {
+			if err := rulesFor(t).err; err != nil {
+				errs = append(errs, field.Invalid(path, t.String(), err.Error()))
+			}
+		})
+	return errs.ToAggregate()
+}
+
+// Validate returns the errors for the fields of obj which do not satisfy their rules.  Fields with rules
+// which cannot be parsed are not validated.
+func Validate(obj interface{}) field.ErrorList {
+	v := reflect.ValueOf(obj)
+	if obj == nil || !hasRules(v.Type()) {
+		return nil
+	}
+	return validateValue(v, nil)
+}
+
+func validateValue(v reflect.Value, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			errs = append(errs, validateValue(v.Elem(), path)...)
+		}
+	case reflect.Struct:
+		fields := rulesFor(v.Type()).fields
+		for i := range fields {
+			f := &fields[i]
+			fv := v.Field(f.index)
+			fp := path
+			if f.name != "" {
+				fp = path.Child(f.name)
+			}
+			errs = append(errs, f.validate(fv, fp)...)
+			if hasRules(fv.Type()) {
+				errs = append(errs, validateValue(fv, fp)...)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			errs = append(errs, validateValue(v.Index(i), path.Index(i))...)
+		}
+	case reflect.Map:
+		// sort the keys so the errors are reported in the same order
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, k := range keys {
+			errs = append(errs, validateValue(v.MapIndex(k), path.Key(fmt.Sprint(k)))...)
+		}
+	}
+	return errs
+}
+
+// validate returns the errors for the value v of the field if it does not satisfy the rules.
+func (f *fieldRules) validate(v reflect.Value, path *field.Path) field.ErrorList {
+	if isEmpty(v) {
+		if f.required {
+			return field.ErrorList{field.Required(path, "")}
+		}
+		return nil
+	}
+	if !f.scalar() {
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	var errs field.ErrorList
+	value := v.Interface()
+	if isNumber(v.Type()) {
+		n := number(v)
+		if f.minimum != nil && n < *f.minimum {
+			errs = append(errs, field.Invalid(path, value,
+				fmt.Sprintf("must be greater than or equal to %v", *f.minimum)))
+		}
+		if f.maximum != nil && n > *f.maximum {
+			errs = append(errs, field.Invalid(path, value,
+				fmt.Sprintf("must be less than or equal to %v", *f.maximum)))
+		}
+	}
+	if v.Kind() == reflect.String {
+		s := v.String()
+		if f.maxLength != nil && int64(utf8.RuneCountInString(s)) > *f.maxLength {
+			errs = append(errs, field.TooLong(path, value, int(*f.maxLength)))
+		}
+		if f.pattern != nil && !f.pattern.MatchString(s) {
+			errs = append(errs, field.Invalid(path, value,
+				fmt.Sprintf("must match the regular expression %q", f.pattern.String())))
+		}
+		if f.format != "" {
+			if msgs := formats[f.format](s); len(msgs) > 0 {
+				errs = append(errs, field.Invalid(path, value, strings.Join(msgs, ", ")))
+			}
+		}
+	}
+	if f.enum != nil {
+		found := false
+		for _, e := range f.enumValues {
+			if e == scalarValue(v) {
+				found = true
+			}
+		}
+		if !found {
+			errs = append(errs, field.NotSupported(path, value, f.enum))
+		}
+	}
+	return errs
+}
+
+// isEmpty returns true if v is unset.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Struct, reflect.Array:
+		return v.IsZero()
+	}
+	// numbers and booleans are always set
+	return false
+}
+
+// hasRules returns true if t contains a field with rules.
+func hasRules(t reflect.Type) bool {
+	lock.Lock()
+	result, found := validated[t]
+	lock.Unlock()
+	if found {
+		return result
+	}
+	structtag.Structs(t, nil, func(t reflect.Type, _ *field.Path) {
+		for _, f := range rulesFor(t).fields {
+			if f.required || f.scalar() {
+				result = true
+			}
+		}
+	})
+	lock.Lock()
+	validated[t] = result
+	lock.Unlock()
+	return result
+}
+
+// rulesFor returns the rules of the fields of struct t.
+func rulesFor(t reflect.Type) *typeRules {
+	lock.Lock()
+	defer lock.Unlock()
+	if r, found := types[t]; found {
+		return r
+	}
+	r := &typeRules{}
+	var errs []string
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, ok := structtag.JSONName(sf)
+		if !ok {
+			continue
+		}
+		f, err := parseRules(sf)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("field %s has %v", sf.Name, err))
+			continue
+		}
+		f.index, f.name = i, name
+		r.fields = append(r.fields, f)
+	}
+	if len(errs) > 0 {
+		r.err = fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	types[t] = r
+	return r
+}
+
+// parseRules returns the rules in the struct tags of field sf.
+func parseRules(sf reflect.StructField) (fieldRules, error) {
+	f := fieldRules{}
+	t := sf.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	invalid := func(tag, value string, err interface{}) error {
+		return fmt.Errorf("invalid %s rule %q: %v", tag, value, err)
+	}
+
+	if s, ok := sf.Tag.Lookup("required"); ok {
+		required, err := strconv.ParseBool(s)
+		if err != nil {
+			return f, invalid("required", s, err)
+		}
+		f.required = required
+	}
+	for _, tag := range []string{"minimum", "maximum"} {
+		s, ok := sf.Tag.Lookup(tag)
+		if !ok {
+			continue
+		}
+		if !isNumber(t) {
+			return f, invalid(tag, s, "applies to numbers")
+		}
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return f, invalid(tag, s, err)
+		}
+		if tag == "minimum" {
+			f.minimum = &n
+		} else {
+			f.maximum = &n
+		}
+	}
+	if s, ok := sf.Tag.Lookup("maxLength"); ok {
+		if t.Kind() != reflect.String {
+			return f, invalid("maxLength", s, "applies to strings")
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return f, invalid("maxLength", s, err)
+		}
+		f.maxLength = &n
+	}
+	if s, ok := sf.Tag.Lookup("pattern"); ok {
+		if t.Kind() != reflect.String {
+			return f, invalid("pattern", s, "applies to strings")
+		}
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return f, invalid("pattern", s, err)
+		}
+		f.pattern = re
+	}
+	if s, ok := sf.Tag.Lookup("format"); ok {
+		if t.Kind() != reflect.String {
+			return f, invalid("format", s, "applies to strings")
+		}
+		if _, found := formats[s]; !found {
+			return f, invalid("format", s, "unknown format")
+		}
+		f.format = s
+	}
+	if s, ok := sf.Tag.Lookup("enum"); ok {
+		if t.Kind() != reflect.String && !isNumber(t) {
+			return f, invalid("enum", s, "applies to strings and numbers")
+		}
+		f.enum = strings.Split(s, ",")
+		for _, e := range f.enum {
+			v, err := parseScalar(t, e)
+			if err != nil {
+				return f, invalid("enum", s, err)
+			}
+			f.enumValues = append(f.enumValues, v)
+		}
+	}
+	return f, nil
+}
+
+// parseScalar parses s as a value of the string or number type t, returning the same value as scalarValue.
+func parseScalar(t reflect.Type, s string) (interface{}, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return s, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(s, 10, t.Bits())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(s, 10, t.Bits())
+	}
+	return strconv.ParseFloat(s, t.Bits())
+}
+
+// scalarValue returns the value of the string or number v as a string, int64, uint64 or float64.
+func scalarValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint()
+	}
+	return v.Float()
+}
+
+func number(v reflect.Value) float64 {
+	switch n := scalarValue(v).(type) {
+	case int64:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	default:
+		return n.(float64)
+	}
+}
+
+func isNumber(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// OpenAPIDefinitions returns the definitions of defs with the rules of the fields of the objs and of the types
+// they contain.
+func OpenAPIDefinitions(defs common.GetOpenAPIDefinitions, objs ...interface{}) common.GetOpenAPIDefinitions {
+	// the rules by definition name
+	rules := map[string][]fieldRules{}
+	for i := range objs {
+		structtag.Structs(reflect.TypeOf(objs[i]), nil, func(t reflect.Type, _ *field.Path) {
+			addSchemaRules(t, structtag.TypeName(t), rules)
+		})
+	}
+	return func(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+		result := defs(ref)
+		for name, fields := range rules {
+			def, found := result[name]
+			if !found {
+				continue
+			}
+			for _, f := range fields {
+				p, found := def.Schema.Properties[f.name]
+				if !found {
+					continue
+				}
+				if f.minimum != nil {
+					p.Minimum = f.minimum
+				}
+				if f.maximum != nil {
+					p.Maximum = f.maximum
+				}
+				if f.maxLength != nil {
+					p.MaxLength = f.maxLength
+				}
+				if f.pattern != nil {
+					p.Pattern = f.pattern.String()
+				}
+				if f.format != "" {
+					p.Format = f.format
+				}
+				if f.enum != nil {
+					p.Enum = append([]interface{}{}, f.enumValues...)
+				}
+				def.Schema.Properties[f.name] = p
+				if f.required && !contains(def.Schema.Required, f.name) {
+					def.Schema.Required = append(def.Schema.Required, f.name)
+				}
+			}
+			result[name] = def
+		}
+		return result
+	}
+}
+
+// addSchemaRules adds the rules of the fields of struct t to the definition name, including the fields of
+// inlined structs.
+func addSchemaRules(t reflect.Type, name string, rules map[string][]fieldRules) {
+	for _, f := range rulesFor(t).fields {
+		if f.name == "" {
+			ft := t.Field(f.index).Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addSchemaRules(ft, name, rules)
+			}
+			continue
+		}
+		if f.required || f.scalar() {
+			rules[name] = append(rules[name], f)
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resourcevalidation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-openapi/spec"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/openapi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type ReferenceType string
+
+type Flunder struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              FlunderSpec `json:"spec,omitempty"`
+}
+
+type FlunderSpec struct {
+	Reference     string            `json:"reference,omitempty" required:"true" format:"dns1123"`
+	ReferenceType ReferenceType     `json:"referenceType,omitempty" enum:"Flunder,Fischer"`
+	Replicas      *int32            `json:"replicas,omitempty" minimum:"1" maximum:"5"`
+	Weight        float64           `json:"weight" maximum:"1.5"`
+	Priority      int               `json:"priority,omitempty" enum:"1,2,3"`
+	Label         string            `json:"label,omitempty" maxLength:"4" pattern:"^[a-z]+$"`
+	Members       []Member          `json:"members,omitempty"`
+	Named         map[string]Member `json:"named,omitempty"`
+	Owner         *Member           `json:"owner,omitempty" required:"true"`
+	// Cache is not serialized, so is not validated
+	Cache Member `json:"-"`
+}
+
+type Member struct {
+	Name string `json:"name,omitempty" required:"true" format:"dns1123-label"`
+}
+
+func TestValidate(t *testing.T) {
+	if err := ValidateRules(&Flunder{}); err != nil {
+		t.Fatal(err)
+	}
+	one := int32(1)
+	valid := &Flunder{Spec: FlunderSpec{
+		Reference:     "example.com",
+		ReferenceType: "Flunder",
+		Replicas:      &one,
+		Weight:        1.5,
+		Priority:      2,
+		Label:         "abc",
+		Members:       []Member{{Name: "a"}},
+		Named:         map[string]Member{"x": {Name: "b"}},
+		Owner:         &Member{Name: "c"},
+	}}
+	if errs := Validate(valid); len(errs) != 0 {
+		t.Errorf("expected the Flunder to be valid, got %v", errs)
+	}
+	// unset fields are only checked by required
+	unset := &Flunder{Spec: FlunderSpec{Reference: "a", Priority: 1, Owner: &Member{Name: "b"}}}
+	if errs := Validate(unset); len(errs) != 0 {
+		t.Errorf("expected the unset fields to be valid, got %v", errs)
+	}
+
+	six := int32(6)
+	invalid := &Flunder{Spec: FlunderSpec{
+		Reference:     "Not_A_Name",
+		ReferenceType: "Other",
+		Replicas:      &six,
+		Weight:        2,
+		Priority:      4,
+		Label:         "ABCDE",
+		Members:       []Member{{Name: "a"}, {}},
+		Named:         map[string]Member{"x": {Name: "a.b"}},
+	}}
+	expected := []string{
+		`spec.reference: Invalid value: "Not_A_Name": a DNS-1123 subdomain`,
+		`spec.referenceType: Unsupported value: "Other": supported values: "Flunder", "Fischer"`,
+		`spec.replicas: Invalid value: 6: must be less than or equal to 5`,
+		`spec.weight: Invalid value: 2: must be less than or equal to 1.5`,
+		`spec.priority: Unsupported value: 4: supported values: "1", "2", "3"`,
+		`spec.label: Too long: must have at most 4`,
+		`spec.label: Invalid value: "ABCDE": must match the regular expression "^[a-z]+$"`,
+		`spec.members[1].name: Required value`,
+		`spec.named[x].name: Invalid value: "a.b": a DNS-1123 label`,
+		`spec.owner: Required value`,
+	}
+	errs := Validate(invalid)
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for i := range expected {
+		if !strings.HasPrefix(errs[i].Error(), expected[i]) {
+			t.Errorf("expected %q, got %q", expected[i], errs[i].Error())
+		}
+	}
+}
+
+type Invalid struct {
+	Count  string   `json:"count" minimum:"1"`
+	Nested []Broken `json:"nested"`
+}
+
+type Broken struct {
+	Name string `json:"name" pattern:"[" format:"uri"`
+	Size int    `json:"size" enum:"small"`
+}
+
+func TestValidateRules(t *testing.T) {
+	err := ValidateRules(&Invalid{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, s := range []string{
+		`field Count has invalid minimum rule "1": applies to numbers`,
+		`field Name has invalid pattern rule "["`,
+		`field Size has invalid enum rule "small"`,
+	} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected %q in %v", s, err)
+		}
+	}
+}
+
+func TestOpenAPIDefinitions(t *testing.T) {
+	defs := OpenAPIDefinitions(openapi.DefinitionsFor([]interface{}{&Flunder{}}), &Flunder{})(
+		func(path string) spec.Ref { return spec.MustCreateRef("#/definitions/" + path) })
+
+	s := defs[openapi.DefinitionName(&FlunderSpec{})].Schema
+	one, five, four := float64(1), float64(5), int64(4)
+	expected := map[string]spec.SchemaProps{
+		"reference":     {Format: "dns1123"},
+		"referenceType": {Enum: []interface{}{"Flunder", "Fischer"}},
+		"replicas":      {Format: "int32", Minimum: &one, Maximum: &five},
+		"priority":      {Enum: []interface{}{int64(1), int64(2), int64(3)}},
+		"label":         {MaxLength: &four, Pattern: "^[a-z]+$"},
+	}
+	for property, props := range expected {
+		p := s.Properties[property].SchemaProps
+		actual := spec.SchemaProps{Format: p.Format, Enum: p.Enum, Minimum: p.Minimum, Maximum: p.Maximum,
+			MaxLength: p.MaxLength, Pattern: p.Pattern}
+		if props.Format == "" {
+			actual.Format = ""
+		}
+		if !reflect.DeepEqual(actual, props) {
+			t.Errorf("expected %s to have %+v, got %+v", property, props, actual)
+		}
+	}
+	for _, name := range []string{"reference", "owner", "weight"} {
+		if !contains(s.Required, name) {
+			t.Errorf("expected %s to be required, got %v", name, s.Required)
+		}
+	}
+	if p := defs[openapi.DefinitionName(&Member{})].Schema.Properties["name"]; p.Format != "dns1123-label" {
+		t.Errorf("expected the rules of referenced types to be published, got %+v", p)
+	}
+}
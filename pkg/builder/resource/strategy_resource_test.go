@@ -69,12 +69,12 @@ func (e ExampleResourceWithStrategy) NamespaceScoped() bool {
 
 // New returns a new instance of the object for this resource.
 func (e ExampleResourceWithStrategy) New() runtime.Object {
-	return &ExampleResource{}
+	return &ExampleResourceWithStrategy{}
 }
 
 // NewList returns a new instance of the list object for this resource.
 func (e ExampleResourceWithStrategy) NewList() runtime.Object {
-	return &ExampleResourceList{}
+	return &ExampleResourceWithStrategyList{}
 }
 
 // GetGroupVersionResource returns the GroupVersionResource for this type.
@@ -19,6 +19,7 @@ package resource
 import (
 	"context"
 
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcedefault"
 	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcestrategy"
 
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -65,12 +66,37 @@ type StatusGetSetter interface {
 	CopySpec(ctx context.Context, from runtime.Object)
 }
 
+// Deprecated may be implemented by a version of a resource to mark the version as deprecated.
+//
+// Requests for deprecated versions return a deprecation warning header, and deprecated versions are
+// the least preferred versions of the group in discovery.
+type Deprecated interface {
+	Object
+
+	// APILifecycleDeprecated returns the release in which the version was deprecated -- e.g. 1, 20.
+	APILifecycleDeprecated() (major, minor int)
+
+	// APILifecycleRemoved returns the release in which the version will no longer be served -- e.g. 1, 22.
+	// Returns 0, 0 if no removal release has been decided.
+	APILifecycleRemoved() (major, minor int)
+}
+
+// IsDeprecated returns true if the object implements Deprecated with a non-zero deprecation release.
+func IsDeprecated(obj Object) bool {
+	d, ok := obj.(Deprecated)
+	if !ok {
+		return false
+	}
+	major, minor := d.APILifecycleDeprecated()
+	return major != 0 || minor != 0
+}
+
 // AddToScheme returns a function to add the Objects to the scheme.
 //
 // AddToScheme will register the objects returned by New and NewList under the GroupVersion for each object.
 // AddToScheme will also register the objects under the "__internal" group version for each object that
 // returns true for IsInternalVersion.
-// AddToScheme will register the defaulting function if it implements the Defaulter inteface.
+// AddToScheme will register the defaulting function if it implements the Defaulter or TagDefaulter interfaces.
 func AddToScheme(objs ...Object) func(s *runtime.Scheme) error {
 	return func(s *runtime.Scheme) error {
 		for i := range objs {
@@ -81,12 +107,46 @@ func AddToScheme(objs ...Object) func(s *runtime.Scheme) error {
 					Group:   runtime.APIVersionInternal,
 					Version: obj.GetGroupVersionResource().Version}, obj.New(), obj.NewList())
 			}
-			if _, ok := obj.(resourcestrategy.Defaulter); ok {
-				s.AddTypeDefaultingFunc(obj, func(o interface{}) {
-					o.(resourcestrategy.Defaulter).Default()
-				})
+			fn, err := DefaultingFunc(obj)
+			if err != nil {
+				return err
+			}
+			if fn != nil {
+				s.AddTypeDefaultingFunc(obj, fn)
 			}
 		}
 		return nil
 	}
 }
+
+// IsTagDefaulted returns true if obj implements resourcestrategy.TagDefaulter and is defaulted from its tags.
+func IsTagDefaulted(obj interface{}) bool {
+	d, ok := obj.(resourcestrategy.TagDefaulter)
+	return ok && d.DefaultFromTags()
+}
+
+// DefaultingFunc returns the function defaulting the objects of obj's type, or nil if they are not defaulted.
+//
+// Objects are defaulted from their `default` struct tags if obj is a resourcestrategy.TagDefaulter, and then
+// by their Default function if obj is a resourcestrategy.Defaulter.  DefaultingFunc returns an error if the
+// default tags cannot be parsed.
+func DefaultingFunc(obj Object) (func(obj interface{}), error) {
+	tags := IsTagDefaulted(obj)
+	if tags {
+		if err := resourcedefault.Validate(obj); err != nil {
+			return nil, err
+		}
+	}
+	_, defaulter := obj.(resourcestrategy.Defaulter)
+	if !tags && !defaulter {
+		return nil, nil
+	}
+	return func(o interface{}) {
+		if tags {
+			resourcedefault.Default(o)
+		}
+		if defaulter {
+			o.(resourcestrategy.Defaulter).Default()
+		}
+	}, nil
+}
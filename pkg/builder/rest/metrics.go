@@ -0,0 +1,112 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+var (
+	strategyDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      "apiserver_runtime",
+			Subsystem:      "strategy",
+			Name:           "duration_seconds",
+			Help:           "Latency of the strategy hooks of builder resources, by group, resource and hook.",
+			Buckets:        metrics.ExponentialBuckets(0.0001, 4, 10),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"group", "resource", "verb"},
+	)
+	strategyRejections = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "apiserver_runtime",
+			Subsystem:      "strategy",
+			Name:           "rejections_total",
+			Help:           "Number of objects of builder resources rejected by the Validate and ValidateUpdate hooks.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"group", "resource", "verb"},
+	)
+	handlerDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      "apiserver_runtime",
+			Subsystem:      "handler",
+			Name:           "duration_seconds",
+			Help:           "Latency of the requests to handler backed builder resources, by group, resource and verb.",
+			Buckets:        metrics.ExponentialBuckets(0.001, 4, 10),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"group", "resource", "verb"},
+	)
+
+	registerMetrics sync.Once
+)
+
+// RegisterMetrics registers the strategy and handler metrics with the legacy registry served at /metrics.
+func RegisterMetrics() {
+	registerMetrics.Do(func() {
+		legacyregistry.MustRegister(strategyDuration, strategyRejections, handlerDuration)
+	})
+}
+
+// observeStrategy records the latency of a strategy hook.  observeStrategy is called when the hook starts, and
+// the returned function is called when it returns.
+func observeStrategy(gr schema.GroupResource, hook string) func() {
+	start := time.Now()
+	return func() {
+		strategyDuration.WithLabelValues(gr.Group, gr.Resource, hook).Observe(time.Since(start).Seconds())
+	}
+}
+
+// recordRejection counts objects rejected by a validation hook.
+func recordRejection(gr schema.GroupResource, hook string) {
+	strategyRejections.WithLabelValues(gr.Group, gr.Resource, hook).Inc()
+}
+
+// WithHandlerMetrics records the latency of the requests for the handler backed resources and subresources --
+// e.g. "flunders" or "flunders/scale".  WithHandlerMetrics must wrap the API handler within the handler chain so
+// the RequestInfo is set -- e.g. the handler passed to the BuildHandlerChainFunc of the apiserver config.
+func WithHandlerMetrics(handler http.Handler, resources map[schema.GroupResource]bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		info, ok := request.RequestInfoFrom(req.Context())
+		if !ok || !info.IsResourceRequest {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		resource := info.Resource
+		if info.Subresource != "" {
+			resource += "/" + info.Subresource
+		}
+		if !resources[schema.GroupResource{Group: info.APIGroup, Resource: resource}] {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		start := time.Now()
+		defer func() {
+			handlerDuration.WithLabelValues(info.APIGroup, resource, info.Verb).Observe(time.Since(start).Seconds())
+		}()
+		handler.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+// Gauge rejects a negative reading.
+type Gauge struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Reading           int `json:"reading,omitempty"`
+}
+
+func (g *Gauge) DeepCopyObject() runtime.Object {
+	c := *g
+	g.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	return &c
+}
+
+func (g *Gauge) Validate(context.Context) field.ErrorList {
+	if g.Reading < 0 {
+		return field.ErrorList{field.Invalid(field.NewPath("reading"), g.Reading, "must not be negative")}
+	}
+	return nil
+}
+
+func TestStrategyMetrics(t *testing.T) {
+	RegisterMetrics()
+	s := DefaultStrategy{Object: &Gauge{}, GroupResource: schema.GroupResource{Group: "example.com", Resource: "gauges"}}
+	s.Validate(context.Background(), &Gauge{ObjectMeta: metav1.ObjectMeta{Name: "valid"}, Reading: 1})
+	s.Validate(context.Background(), &Gauge{ObjectMeta: metav1.ObjectMeta{Name: "invalid"}, Reading: -1})
+	s.PrepareForCreate(context.Background(), &Gauge{ObjectMeta: metav1.ObjectMeta{Name: "valid"}, Reading: 1})
+
+	rejections := strategyRejections.WithLabelValues("example.com", "gauges", "Validate")
+	rejected, err := testutil.GetCounterMetricValue(rejections)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rejected != 1 {
+		t.Errorf("expected 1 rejection, got %v", rejected)
+	}
+
+	counts := sampleCounts(t, "apiserver_runtime_strategy_duration_seconds", "verb",
+		map[string]string{"group": "example.com", "resource": "gauges"})
+	if counts["Validate"] != 2 || counts["PrepareForCreate"] != 1 {
+		t.Errorf("expected 2 Validate and 1 PrepareForCreate observations, got %v", counts)
+	}
+}
+
+func TestWithHandlerMetrics(t *testing.T) {
+	RegisterMetrics()
+	handler := WithHandlerMetrics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		map[schema.GroupResource]bool{{Group: "example.com", Resource: "widgets/scale"}: true})
+	for _, info := range []*request.RequestInfo{
+		{IsResourceRequest: true, APIGroup: "example.com", Resource: "widgets", Subresource: "scale", Verb: "get"},
+		{IsResourceRequest: true, APIGroup: "example.com", Resource: "widgets", Verb: "get"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(request.WithRequestInfo(req.Context(), info))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	observed := sampleCounts(t, "apiserver_runtime_handler_duration_seconds", "resource",
+		map[string]string{"group": "example.com"})
+	if observed["widgets/scale"] != 1 || observed["widgets"] != 0 {
+		t.Errorf("expected only the handler backed subresource to be observed, got %v", observed)
+	}
+}
+
+// sampleCounts returns the number of observations of the histogram matching labels, by the value of label.
+func sampleCounts(t *testing.T, name, label string, labels map[string]string) map[string]uint64 {
+	families, err := legacyregistry.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]uint64{}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			if !testutil.LabelsMatch(m, labels) {
+				continue
+			}
+			for _, l := range m.GetLabel() {
+				if l.GetName() == label {
+					counts[l.GetValue()] += m.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return counts
+}
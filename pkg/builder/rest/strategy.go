@@ -18,15 +18,24 @@ package rest
 
 import (
 	"context"
+	"reflect"
 
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourceauthz"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcecel"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourceimmutable"
 	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcestrategy"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcevalidation"
 
 	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apiserver/pkg/endpoints/request"
 	"k8s.io/apiserver/pkg/registry/rest"
 	"k8s.io/apiserver/pkg/storage"
 	"k8s.io/apiserver/pkg/storage/names"
@@ -48,10 +57,88 @@ var _ Strategy = DefaultStrategy{}
 // DefaultStrategy implements Strategy.  DefaultStrategy may be embedded in another struct to override
 // is implementation.  DefaultStrategy will delegate to functions specified on the resource type go structs
 // if implemented.  See the typeintf package for the implementable functions.
+//
+// The rules in the struct tags of a resource are enforced for the version of the request, which may differ from
+// the version of the objects passed to the strategy.  The objects are converted to the version of the request
+// using ObjectTyper if it is also a runtime.ObjectCreater and runtime.ObjectConvertor -- e.g. a runtime.Scheme.
+//
+// DefaultStrategy records the latency of its hooks, and the objects rejected by Validate and ValidateUpdate, in
+// the metrics registered by RegisterMetrics.  The hooks are traced when tracing is enabled.
 type DefaultStrategy struct {
 	Object runtime.Object
 	runtime.ObjectTyper
 	TableConvertor rest.TableConvertor
+	// GroupResource labels the metrics recorded for the hooks.  Defaults to the GroupResource of Object.
+	GroupResource schema.GroupResource
+}
+
+// start records the latency of a hook and traces it.  The returned function is called when the hook returns.
+func (d DefaultStrategy) start(ctx context.Context, hook string) (context.Context, func()) {
+	gr := d.groupResource()
+	observe := observeStrategy(gr, hook)
+	ctx, span := tracing.Start(ctx, "strategy "+hook,
+		attribute.String("k8s.group", gr.Group), attribute.String("k8s.resource", gr.Resource))
+	return ctx, func() {
+		span.End()
+		observe()
+	}
+}
+
+// groupResource returns the GroupResource used to label the metrics of the strategy.
+func (d DefaultStrategy) groupResource() schema.GroupResource {
+	if !d.GroupResource.Empty() {
+		return d.GroupResource
+	}
+	if o, ok := d.Object.(interface {
+		GetGroupVersionResource() schema.GroupVersionResource
+	}); ok {
+		return o.GetGroupVersionResource().GroupResource()
+	}
+	return schema.GroupResource{}
+}
+
+// requestVersion returns obj converted to the version of the request in ctx.  obj is returned unconverted if
+// the request version has the same type as obj, if ctx has no resource request -- e.g. for objects which are not
+// written by an apiserver request -- or if the strategy cannot convert objects.
+func (d DefaultStrategy) requestVersion(ctx context.Context, obj runtime.Object) (runtime.Object, error) {
+	info, ok := request.RequestInfoFrom(ctx)
+	if !ok || !info.IsResourceRequest || obj == nil {
+		return obj, nil
+	}
+	scheme, ok := d.ObjectTyper.(interface {
+		runtime.ObjectCreater
+		runtime.ObjectConvertor
+	})
+	if !ok {
+		return obj, nil
+	}
+	kinds, _, err := d.ObjectTyper.ObjectKinds(obj)
+	if err != nil {
+		return nil, err
+	}
+	versioned, err := scheme.New(schema.GroupVersionKind{
+		Group: info.APIGroup, Version: info.APIVersion, Kind: kinds[0].Kind})
+	if err != nil || reflect.TypeOf(versioned) == reflect.TypeOf(obj) {
+		return obj, nil
+	}
+	if err := scheme.Convert(obj, versioned, nil); err != nil {
+		return nil, err
+	}
+	return versioned, nil
+}
+
+// requestVersions returns obj and old converted to the version of the request in ctx.  old may be nil.
+func (d DefaultStrategy) requestVersions(ctx context.Context, obj, old runtime.Object) (
+	runtime.Object, runtime.Object, *field.Error) {
+	v, err := d.requestVersion(ctx, obj)
+	if err != nil {
+		return nil, nil, field.InternalError(nil, err)
+	}
+	o, err := d.requestVersion(ctx, old)
+	if err != nil {
+		return nil, nil, field.InternalError(nil, err)
+	}
+	return v, o, nil
 }
 
 func (d DefaultStrategy) GenerateName(base string) string {
@@ -75,14 +162,18 @@ func (d DefaultStrategy) NamespaceScoped() bool {
 }
 
 // PrepareForCreate calls the PrepareForCreate function on obj if supported, otherwise does nothing.
-func (DefaultStrategy) PrepareForCreate(ctx context.Context, obj runtime.Object) {
+func (d DefaultStrategy) PrepareForCreate(ctx context.Context, obj runtime.Object) {
+	ctx, end := d.start(ctx, "PrepareForCreate")
+	defer end()
 	if v, ok := obj.(resourcestrategy.PrepareForCreater); ok {
 		v.PrepareForCreate(ctx)
 	}
 }
 
 // PrepareForUpdate calls the PrepareForUpdate function on obj if supported, otherwise does nothing.
-func (DefaultStrategy) PrepareForUpdate(ctx context.Context, obj, old runtime.Object) {
+func (d DefaultStrategy) PrepareForUpdate(ctx context.Context, obj, old runtime.Object) {
+	ctx, end := d.start(ctx, "PrepareForUpdate")
+	defer end()
 	if v, ok := obj.(resource.StatusGetSetter); ok {
 		// don't modify the status
 		v.CopyStatus(ctx, old)
@@ -92,12 +183,28 @@ func (DefaultStrategy) PrepareForUpdate(ctx context.Context, obj, old runtime.Ob
 	}
 }
 
-// Validate calls the Validate function on obj if supported, otherwise does nothing.
-func (DefaultStrategy) Validate(ctx context.Context, obj runtime.Object) field.ErrorList {
+// Validate validates the fields of obj against the rules in the struct tags of the request version, as described by
+// the resourcevalidation package, and against the CEL rules of the request version, as described by the resourcecel
+// package.  It authorizes the user to set the authorized fields of the request version, as described by the
+// resourceauthz package, and then calls the Validate function on obj if supported.
+func (d DefaultStrategy) Validate(ctx context.Context, obj runtime.Object) field.ErrorList {
+	ctx, end := d.start(ctx, "Validate")
+	defer end()
+	v, _, err := d.requestVersions(ctx, obj, nil)
+	if err != nil {
+		recordRejection(d.groupResource(), "Validate")
+		return field.ErrorList{err}
+	}
+	errs := append(field.ErrorList{}, resourcevalidation.Validate(v)...)
+	errs = append(errs, resourcecel.Validate(ctx, v, nil)...)
+	errs = append(errs, resourceauthz.Authorize(ctx, v, nil)...)
 	if v, ok := obj.(resourcestrategy.Validater); ok {
-		return v.Validate(ctx)
+		errs = append(errs, v.Validate(ctx)...)
+	}
+	if len(errs) > 0 {
+		recordRejection(d.groupResource(), "Validate")
 	}
-	return field.ErrorList{}
+	return errs
 }
 
 func (d DefaultStrategy) AllowCreateOnUpdate() bool {
@@ -121,18 +228,37 @@ func (d DefaultStrategy) AllowUnconditionalUpdate() bool {
 }
 
 // Canonicalize calls the Canonicalize function on obj if supported, otherwise does nothing.
-func (DefaultStrategy) Canonicalize(obj runtime.Object) {
+func (d DefaultStrategy) Canonicalize(obj runtime.Object) {
+	defer observeStrategy(d.groupResource(), "Canonicalize")()
 	if c, ok := obj.(resourcestrategy.Canonicalizer); ok {
 		c.Canonicalize()
 	}
 }
 
-// ValidateUpdate calls the ValidateUpdate function on obj if supported, otherwise does nothing.
-func (DefaultStrategy) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+// ValidateUpdate validates the fields of obj against the rules in the struct tags of the request version, as
+// described by the resourcevalidation package, and forbids changes to the immutable fields of the request version, as
+// described by the resourceimmutable package.  It validates obj against the CEL rules of the request version, as
+// described by the resourcecel package, authorizes the user to change the authorized fields of the request version,
+// as described by the resourceauthz package, and then calls the ValidateUpdate function on obj if supported.
+func (d DefaultStrategy) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	ctx, end := d.start(ctx, "ValidateUpdate")
+	defer end()
+	v, o, err := d.requestVersions(ctx, obj, old)
+	if err != nil {
+		recordRejection(d.groupResource(), "ValidateUpdate")
+		return field.ErrorList{err}
+	}
+	errs := append(field.ErrorList{}, resourcevalidation.Validate(v)...)
+	errs = append(errs, resourceimmutable.ValidateUpdate(v, o)...)
+	errs = append(errs, resourcecel.Validate(ctx, v, o)...)
+	errs = append(errs, resourceauthz.Authorize(ctx, v, o)...)
 	if v, ok := obj.(resourcestrategy.ValidateUpdater); ok {
-		return v.ValidateUpdate(ctx, old)
+		errs = append(errs, v.ValidateUpdate(ctx, old)...)
+	}
+	if len(errs) > 0 {
+		recordRejection(d.groupResource(), "ValidateUpdate")
 	}
-	return field.ErrorList{}
+	return errs
 }
 
 // Match is the filter used by the generic etcd backend to watch events
@@ -147,6 +273,8 @@ func (DefaultStrategy) Match(label labels.Selector, field fields.Selector) stora
 
 func (d DefaultStrategy) ConvertToTable(
 	ctx context.Context, obj runtime.Object, tableOptions runtime.Object) (*metav1.Table, error) {
+	ctx, end := d.start(ctx, "ConvertToTable")
+	defer end()
 	if c, ok := obj.(resourcestrategy.TableConverter); ok {
 		return c.ConvertToTable(ctx, tableOptions)
 	}
@@ -0,0 +1,170 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourceauthz"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcestrategy"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// Meter is the version of the meters stored by the strategy.  It has no rules.
+type Meter struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Level             int    `json:"level,omitempty"`
+	Serial            string `json:"serial,omitempty"`
+	Owner             string `json:"owner,omitempty"`
+}
+
+func (m *Meter) DeepCopyObject() runtime.Object {
+	c := *m
+	m.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	return &c
+}
+
+// MeterV2 is the v2 version of Meter, with rules in its struct tags and CEL rules.
+type MeterV2 struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Level             int    `json:"level,omitempty" maximum:"10"`
+	Serial            string `json:"serial,omitempty" immutable:"true"`
+	Owner             string `json:"owner,omitempty" authorize:"owner"`
+}
+
+func (m *MeterV2) DeepCopyObject() runtime.Object {
+	c := *m
+	m.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	return &c
+}
+
+func (m *MeterV2) CELRules() []resourcestrategy.CELRule {
+	return []resourcestrategy.CELRule{{Rule: "!has(self.level) || self.level % 2 == 0", Path: "level"}}
+}
+
+// newMeterScheme returns a scheme with Meter as the v1 and internal versions of meters, and MeterV2 as v2.
+func newMeterScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	for _, v := range []string{"v1", runtime.APIVersionInternal} {
+		scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "example.com", Version: v, Kind: "Meter"}, &Meter{})
+	}
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "example.com", Version: "v2", Kind: "Meter"}, &MeterV2{})
+	if err := scheme.AddConversionFunc((*Meter)(nil), (*MeterV2)(nil),
+		func(in, out interface{}, _ conversion.Scope) error {
+			m, v2 := in.(*Meter), out.(*MeterV2)
+			v2.ObjectMeta, v2.Level, v2.Serial, v2.Owner = m.ObjectMeta, m.Level, m.Serial, m.Owner
+			return nil
+		}); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+// contextForVersion returns a context for a request writing meters in version.
+func contextForVersion(version string) context.Context {
+	return request.WithRequestInfo(context.Background(), &request.RequestInfo{
+		IsResourceRequest: true, APIGroup: "example.com", APIVersion: version, Resource: "meters"})
+}
+
+func TestRequestVersionRules(t *testing.T) {
+	s := DefaultStrategy{Object: &Meter{}, ObjectTyper: newMeterScheme(t)}
+	meter := &Meter{ObjectMeta: metav1.ObjectMeta{Name: "one"}, Level: 12}
+
+	// the rules of v2 are enforced for requests writing v2
+	errs := s.Validate(contextForVersion("v2"), meter)
+	if len(errs) != 1 || errs[0].Field != "level" {
+		t.Errorf("expected the v2 maximum to be validated, got %v", errs)
+	}
+	errs = s.ValidateUpdate(contextForVersion("v2"), meter, &Meter{ObjectMeta: metav1.ObjectMeta{Name: "one"}})
+	if len(errs) != 1 || errs[0].Field != "level" {
+		t.Errorf("expected the v2 maximum to be validated for the update, got %v", errs)
+	}
+
+	// v1 and objects written without a request have no rules
+	if errs := s.Validate(contextForVersion("v1"), meter); len(errs) != 0 {
+		t.Errorf("expected the v1 meter to be valid, got %v", errs)
+	}
+	if errs := s.Validate(context.Background(), meter); len(errs) != 0 {
+		t.Errorf("expected the meter to be valid without a request, got %v", errs)
+	}
+}
+
+func TestRequestVersionImmutableFields(t *testing.T) {
+	s := DefaultStrategy{Object: &Meter{}, ObjectTyper: newMeterScheme(t)}
+	old := &Meter{ObjectMeta: metav1.ObjectMeta{Name: "one"}, Serial: "a"}
+	meter := &Meter{ObjectMeta: metav1.ObjectMeta{Name: "one"}, Serial: "b"}
+
+	if errs := s.ValidateUpdate(contextForVersion("v2"), meter, old); len(errs) != 1 || errs[0].Field != "serial" {
+		t.Errorf("expected the v2 serial to be immutable, got %v", errs)
+	}
+	if errs := s.ValidateUpdate(contextForVersion("v1"), meter, old); len(errs) != 0 {
+		t.Errorf("expected the v1 serial to be mutable, got %v", errs)
+	}
+}
+
+func TestRequestVersionCELRules(t *testing.T) {
+	s := DefaultStrategy{Object: &Meter{}, ObjectTyper: newMeterScheme(t)}
+	meter := &Meter{ObjectMeta: metav1.ObjectMeta{Name: "one"}, Level: 3}
+
+	if errs := s.Validate(contextForVersion("v2"), meter); len(errs) != 1 || errs[0].Field != "level" {
+		t.Errorf("expected the v2 rule to be evaluated, got %v", errs)
+	}
+	if errs := s.ValidateUpdate(contextForVersion("v2"), meter, meter); len(errs) != 1 || errs[0].Field != "level" {
+		t.Errorf("expected the v2 rule to be evaluated for the update, got %v", errs)
+	}
+	if errs := s.Validate(contextForVersion("v1"), meter); len(errs) != 0 {
+		t.Errorf("expected the v1 meter to be valid, got %v", errs)
+	}
+}
+
+func TestRequestVersionAuthorizedFields(t *testing.T) {
+	s := DefaultStrategy{Object: &Meter{}, ObjectTyper: newMeterScheme(t)}
+	var attributes authorizer.Attributes
+	deny := authorizer.AuthorizerFunc(func(a authorizer.Attributes) (authorizer.Decision, string, error) {
+		attributes = a
+		return authorizer.DecisionNoOpinion, "", nil
+	})
+	contextFor := func(version string) context.Context {
+		return resourceauthz.WithAuthorizer(
+			request.WithUser(contextForVersion(version), &user.DefaultInfo{Name: "user"}), deny)
+	}
+	meter := &Meter{ObjectMeta: metav1.ObjectMeta{Name: "one"}, Owner: "user"}
+
+	// the owner is only authorized when written through v2, which is not the version of the stored meters
+	if errs := s.Validate(contextFor("v2"), meter); len(errs) != 1 || errs[0].Field != "owner" {
+		t.Errorf("expected the v2 owner to be authorized, got %v", errs)
+	}
+	if attributes == nil || attributes.GetAPIVersion() != "v2" || attributes.GetSubresource() != "owner" {
+		t.Errorf("expected meters/owner to be authorized in v2, got %v", attributes)
+	}
+	errs := s.ValidateUpdate(contextFor("v2"), meter, &Meter{ObjectMeta: metav1.ObjectMeta{Name: "one"}})
+	if len(errs) != 1 || errs[0].Field != "owner" {
+		t.Errorf("expected the v2 owner to be authorized for the update, got %v", errs)
+	}
+	if errs := s.Validate(contextFor("v1"), meter); len(errs) != 0 {
+		t.Errorf("expected the v1 owner not to be authorized, got %v", errs)
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package testing contains helpers for testing the resources registered with a builder.Server.
+package testing
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	fuzz "github.com/google/gofuzz"
+	"github.com/pwittrock/apiserver-runtime/pkg/apiserver"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/protobuf"
+	"k8s.io/apimachinery/pkg/api/apitesting/fuzzer"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metafuzzer "k8s.io/apimachinery/pkg/apis/meta/fuzzer"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	runtimeserializer "k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/diff"
+)
+
+// FuzzFuncsProvider may be implemented by resources to provide custom fuzz functions -- e.g. to fuzz fields whose
+// values must be consistent with each other.  The functions have the form func(*SomeType, c fuzz.Continue), and
+// are used in addition to the fuzz functions for the ObjectMeta and other types shared by all resources.
+type FuzzFuncsProvider interface {
+	FuzzFuncs(codecs runtimeserializer.CodecFactory) []interface{}
+}
+
+// Iterations is the number of fuzzed objects tested for each resource version.
+var Iterations = 20
+
+// RoundTripAll tests every resource version registered with the server.  For each version RoundTripAll fuzzes
+// objects and checks that they are unchanged by
+//
+// - serializing and deserializing them as json, yaml and protobuf.  protobuf is skipped for types which cannot be
+//   serialized to protobuf.
+//
+// - converting them to the storage version of the resource and back.
+func RoundTripAll(t *testing.T, server *builder.Server) {
+	// the resources are tested with the scheme they are served with, which may contain conversions registered
+	// directly with apiserver.Scheme rather than by the server
+	scheme, codecs := apiserver.Scheme, apiserver.Codecs
+	if err := server.AddToScheme(scheme); err != nil {
+		t.Fatalf("unable to build the scheme: %v", err)
+	}
+	ns := protobuf.NegotiatedSerializer(codecs, scheme, scheme)
+
+	seed := time.Now().UnixNano()
+	t.Logf("fuzzing with seed %d", seed)
+
+	resources := server.Resources()
+	var gvrs []schema.GroupVersionResource
+	for gvr := range resources {
+		// subresources share the object of their resource
+		if !strings.Contains(gvr.Resource, "/") {
+			gvrs = append(gvrs, gvr)
+		}
+	}
+	sort.Slice(gvrs, func(i, j int) bool { return gvrs[i].String() < gvrs[j].String() })
+
+	for _, gvr := range gvrs {
+		obj := resources[gvr]
+		t.Run(gvr.String(), func(t *testing.T) {
+			funcs := []fuzzer.FuzzerFuncs{metafuzzer.Funcs}
+			if p, ok := obj.(FuzzFuncsProvider); ok {
+				funcs = append(funcs, p.FuzzFuncs)
+			}
+			f := fuzzer.FuzzerFor(fuzzer.MergeFuzzerFuncs(funcs...), rand.NewSource(seed), codecs)
+
+			storageVersion := server.StorageVersion(gvr.GroupResource())
+			for i := 0; i < Iterations; i++ {
+				for _, info := range ns.SupportedMediaTypes() {
+					roundTripSerializer(t, ns, info, gvr.GroupVersion(), fuzzed(f, obj.New()))
+				}
+				if storageVersion != gvr.GroupVersion() {
+					roundTripConversion(t, scheme, gvr.GroupVersion(), storageVersion, fuzzed(f, obj.New()))
+				}
+			}
+		})
+	}
+}
+
+// fuzzed fuzzes the object.  The TypeMeta is left empty as it is for objects in memory.
+func fuzzed(f *fuzz.Fuzzer, obj runtime.Object) runtime.Object {
+	f.Fuzz(obj)
+	obj.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+	return obj
+}
+
+// roundTripSerializer serializes and deserializes the object using the serializer.
+func roundTripSerializer(t *testing.T, ns runtime.NegotiatedSerializer, info runtime.SerializerInfo,
+	gv schema.GroupVersion, obj runtime.Object) {
+	original := obj.DeepCopyObject()
+	data, err := runtime.Encode(ns.EncoderForVersion(info.Serializer, gv), obj)
+	if protobuf.IsNotMarshalable(err) {
+		return
+	}
+	if err != nil {
+		t.Errorf("%s: unable to encode %T: %v\n%s", info.MediaType, obj, err, diff.ObjectGoPrintSideBySide(original, obj))
+		return
+	}
+	decoded, err := runtime.Decode(ns.DecoderToVersion(info.Serializer, gv), data)
+	if err != nil {
+		t.Errorf("%s: unable to decode %T: %v\n%s", info.MediaType, obj, err, string(data))
+		return
+	}
+	decoded.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+	if !apiequality.Semantic.DeepEqual(original, decoded) {
+		t.Errorf("%s: %T changed by serialization:\n%s", info.MediaType, obj, diff.ObjectReflectDiff(original, decoded))
+	}
+}
+
+// roundTripConversion converts the object to the storage version and back.
+func roundTripConversion(t *testing.T, scheme *runtime.Scheme, gv, storageVersion schema.GroupVersion,
+	obj runtime.Object) {
+	original := obj.DeepCopyObject()
+	stored, err := scheme.ConvertToVersion(obj.DeepCopyObject(), storageVersion)
+	if err != nil {
+		t.Errorf("unable to convert %T to the storage version %v: %v", obj, storageVersion, err)
+		return
+	}
+	converted, err := scheme.ConvertToVersion(stored, gv)
+	if err != nil {
+		t.Errorf("unable to convert %T from the storage version %v: %v", obj, storageVersion, err)
+		return
+	}
+	converted.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+	if !apiequality.Semantic.DeepEqual(original, converted) {
+		t.Errorf("%T changed by conversion to %v:\n%s", obj, storageVersion, diff.ObjectReflectDiff(original, converted))
+	}
+}
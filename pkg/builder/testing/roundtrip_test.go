@@ -0,0 +1,120 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing_test
+
+import (
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	"github.com/pwittrock/apiserver-runtime/pkg/apiserver"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder"
+	buildertesting "github.com/pwittrock/apiserver-runtime/pkg/builder/testing"
+	"github.com/pwittrock/apiserver-runtime/pkg/example/v1alpha1"
+	"github.com/pwittrock/apiserver-runtime/pkg/example/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	runtimeserializer "k8s.io/apimachinery/pkg/runtime/serializer"
+)
+
+// Gadget is a resource with custom fuzz functions
+type Gadget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
+
+	Spec GadgetSpec `json:"spec,omitempty" protobuf:"bytes,2,opt,name=spec"`
+}
+
+type GadgetSpec struct {
+	Size  int64   `json:"size,omitempty" protobuf:"varint,1,opt,name=size"`
+	Parts []int32 `json:"parts,omitempty" protobuf:"varint,2,rep,name=parts"`
+}
+
+type GadgetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
+	Items           []Gadget `json:"items" protobuf:"bytes,2,rep,name=items"`
+}
+
+var fuzzed int
+
+func (g *Gadget) FuzzFuncs(codecs runtimeserializer.CodecFactory) []interface{} {
+	return []interface{}{
+		func(s *GadgetSpec, c fuzz.Continue) {
+			c.FuzzNoCustom(s)
+			s.Size = int64(len(s.Parts))
+			fuzzed++
+		},
+	}
+}
+
+func (g *Gadget) DeepCopyObject() runtime.Object {
+	c := *g
+	g.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	c.Spec.Parts = append([]int32(nil), g.Spec.Parts...)
+	return &c
+}
+func (g *Gadget) GetObjectMeta() *metav1.ObjectMeta { return &g.ObjectMeta }
+func (g *Gadget) NamespaceScoped() bool             { return true }
+func (g *Gadget) New() runtime.Object               { return &Gadget{} }
+func (g *Gadget) NewList() runtime.Object           { return &GadgetList{} }
+func (g *Gadget) IsInternalVersion() bool           { return true }
+func (g *Gadget) GetGroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: "example.com", Version: "v1alpha1", Resource: "gadgets"}
+}
+
+func (g *GadgetList) DeepCopyObject() runtime.Object {
+	c := *g
+	c.Items = nil
+	for i := range g.Items {
+		c.Items = append(c.Items, *g.Items[i].DeepCopyObject().(*Gadget))
+	}
+	return &c
+}
+
+// addExampleConversions registers the conversions between the example versions.
+func addExampleConversions(scheme *runtime.Scheme) error {
+	if err := scheme.AddConversionFunc((*v1beta1.ExampleResource)(nil), (*v1alpha1.ExampleResource)(nil),
+		func(in, out interface{}, _ conversion.Scope) error {
+			out.(*v1alpha1.ExampleResource).ObjectMeta = in.(*v1beta1.ExampleResource).ObjectMeta
+			return nil
+		}); err != nil {
+		return err
+	}
+	return scheme.AddConversionFunc((*v1alpha1.ExampleResource)(nil), (*v1beta1.ExampleResource)(nil),
+		func(in, out interface{}, _ conversion.Scope) error {
+			out.(*v1beta1.ExampleResource).ObjectMeta = in.(*v1alpha1.ExampleResource).ObjectMeta
+			return nil
+		})
+}
+
+func TestRoundTripAll(t *testing.T) {
+	server := builder.APIServer.
+		WithResource(&v1alpha1.ExampleResource{}).
+		WithResource(&v1beta1.ExampleResource{}).
+		WithResource(&Gadget{})
+	// the conversions are only registered with the served scheme
+	if err := addExampleConversions(apiserver.Scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	buildertesting.RoundTripAll(t, server)
+	if fuzzed == 0 {
+		t.Errorf("expected the Gadget fuzz functions to be used")
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/apiserver/pkg/admission"
+)
+
+// AdmissionDecorator traces the admission plugins.  AdmissionDecorator is added to the Decorators of the
+// admission options.
+var AdmissionDecorator admission.Decorator = admission.DecoratorFunc(func(
+	handler admission.Interface, name string) admission.Interface {
+	return &tracedPlugin{Interface: handler, name: name}
+})
+
+// tracedPlugin traces an admission plugin.  As for the admission metrics decorator, tracedPlugin implements
+// both the mutating and validating interfaces, and does nothing for the interfaces the plugin does not implement.
+type tracedPlugin struct {
+	admission.Interface
+	name string
+}
+
+var _ admission.MutationInterface = &tracedPlugin{}
+var _ admission.ValidationInterface = &tracedPlugin{}
+
+// Admit traces the mutating admission of the plugin.
+func (p *tracedPlugin) Admit(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	m, ok := p.Interface.(admission.MutationInterface)
+	if !ok {
+		return nil
+	}
+	ctx, span := Start(ctx, "admit "+p.name, p.attributes(a)...)
+	err := m.Admit(ctx, a, o)
+	End(span, err)
+	return err
+}
+
+// Validate traces the validating admission of the plugin.
+func (p *tracedPlugin) Validate(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	v, ok := p.Interface.(admission.ValidationInterface)
+	if !ok {
+		return nil
+	}
+	ctx, span := Start(ctx, "validate "+p.name, p.attributes(a)...)
+	err := v.Validate(ctx, a, o)
+	End(span, err)
+	return err
+}
+
+func (p *tracedPlugin) attributes(a admission.Attributes) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("admission.plugin", p.name),
+		attribute.String("admission.operation", string(a.GetOperation())),
+		attribute.String("k8s.group", a.GetResource().Group),
+		attribute.String("k8s.resource", a.GetResource().Resource),
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	"k8s.io/apiserver/pkg/storage/storagebackend/factory"
+	"k8s.io/client-go/tools/cache"
+)
+
+// RESTOptionsGetter traces the storage calls of the resources whose storage is created from the RESTOptions
+// of getter -- i.e. every resource stored in etcd.
+func RESTOptionsGetter(getter generic.RESTOptionsGetter) generic.RESTOptionsGetter {
+	return &restOptionsGetter{RESTOptionsGetter: getter}
+}
+
+type restOptionsGetter struct {
+	generic.RESTOptionsGetter
+}
+
+func (g *restOptionsGetter) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	opts, err := g.RESTOptionsGetter.GetRESTOptions(resource)
+	if err != nil {
+		return opts, err
+	}
+	decorator := opts.Decorator
+	opts.Decorator = func(config *storagebackend.Config, resourcePrefix string,
+		keyFunc func(obj runtime.Object) (string, error), newFunc func() runtime.Object,
+		newListFunc func() runtime.Object, getAttrsFunc storage.AttrFunc, trigger storage.IndexerFuncs,
+		indexers *cache.Indexers) (storage.Interface, factory.DestroyFunc, error) {
+		s, destroy, err := decorator(
+			config, resourcePrefix, keyFunc, newFunc, newListFunc, getAttrsFunc, trigger, indexers)
+		if err != nil {
+			return s, destroy, err
+		}
+		return &tracedStorage{Interface: s, resource: resource}, destroy, nil
+	}
+	return opts, nil
+}
+
+// tracedStorage traces the calls to the storage of a resource, which is the watch cache when caching is enabled.
+type tracedStorage struct {
+	storage.Interface
+	resource schema.GroupResource
+}
+
+func (s *tracedStorage) start(ctx context.Context, op, key string) (context.Context, func(error)) {
+	ctx, span := Start(ctx, "storage "+op,
+		attribute.String("k8s.group", s.resource.Group),
+		attribute.String("k8s.resource", s.resource.Resource),
+		attribute.String("storage.key", key))
+	return ctx, func(err error) { End(span, err) }
+}
+
+func (s *tracedStorage) Create(ctx context.Context, key string, obj, out runtime.Object, ttl uint64) (err error) {
+	ctx, end := s.start(ctx, "Create", key)
+	defer func() { end(err) }()
+	return s.Interface.Create(ctx, key, obj, out, ttl)
+}
+
+func (s *tracedStorage) Delete(ctx context.Context, key string, out runtime.Object,
+	preconditions *storage.Preconditions, validateDeletion storage.ValidateObjectFunc) (err error) {
+	ctx, end := s.start(ctx, "Delete", key)
+	defer func() { end(err) }()
+	return s.Interface.Delete(ctx, key, out, preconditions, validateDeletion)
+}
+
+// Watch traces starting the watch.  The events of the watch are not traced.
+func (s *tracedStorage) Watch(ctx context.Context, key string, opts storage.ListOptions) (w watch.Interface,
+	err error) {
+	ctx, end := s.start(ctx, "Watch", key)
+	defer func() { end(err) }()
+	return s.Interface.Watch(ctx, key, opts)
+}
+
+// WatchList traces starting the watch.  The events of the watch are not traced.
+func (s *tracedStorage) WatchList(ctx context.Context, key string, opts storage.ListOptions) (w watch.Interface,
+	err error) {
+	ctx, end := s.start(ctx, "WatchList", key)
+	defer func() { end(err) }()
+	return s.Interface.WatchList(ctx, key, opts)
+}
+
+func (s *tracedStorage) Get(ctx context.Context, key string, opts storage.GetOptions, objPtr runtime.Object) (
+	err error) {
+	ctx, end := s.start(ctx, "Get", key)
+	defer func() { end(err) }()
+	return s.Interface.Get(ctx, key, opts, objPtr)
+}
+
+func (s *tracedStorage) GetToList(ctx context.Context, key string, opts storage.ListOptions,
+	listObj runtime.Object) (err error) {
+	ctx, end := s.start(ctx, "GetToList", key)
+	defer func() { end(err) }()
+	return s.Interface.GetToList(ctx, key, opts, listObj)
+}
+
+func (s *tracedStorage) List(ctx context.Context, key string, opts storage.ListOptions,
+	listObj runtime.Object) (err error) {
+	ctx, end := s.start(ctx, "List", key)
+	defer func() { end(err) }()
+	return s.Interface.List(ctx, key, opts, listObj)
+}
+
+func (s *tracedStorage) GuaranteedUpdate(ctx context.Context, key string, ptrToType runtime.Object,
+	ignoreNotFound bool, preconditions *storage.Preconditions, tryUpdate storage.UpdateFunc,
+	suggestion ...runtime.Object) (err error) {
+	ctx, end := s.start(ctx, "GuaranteedUpdate", key)
+	defer func() { end(err) }()
+	return s.Interface.GuaranteedUpdate(ctx, key, ptrToType, ignoreNotFound, preconditions, tryUpdate, suggestion...)
+}
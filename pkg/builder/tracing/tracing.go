@@ -0,0 +1,180 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing records OpenTelemetry spans for the requests served by an apiserver.
+//
+// Each request is traced by a server span continuing the W3C trace context of the request, with child spans for
+// the admission plugins, the strategy hooks of the resource and the storage calls.  Storage spans wrap the watch
+// cache of the resource, so reads served from the cache are traced without an etcd call.  Objects are converted
+// to and from their storage version within the storage spans of the calls which reach etcd, and conversion is
+// not traced by a span of its own.  Spans are exported as JSON to a file or stdout, so traces may be inspected
+// without running a collector.
+//
+// Spans are written by the OpenTelemetry stdouttrace exporter, one JSON object per span in the format of
+// tracetest.SpanStub.  This is not the OTLP JSON encoding, so the files are not read by OTLP collectors.
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the tracer recording the spans.
+const TracerName = "github.com/pwittrock/apiserver-runtime"
+
+// Config configures the exporter of the spans.
+type Config struct {
+	// Path is the file the spans are appended to as JSON.  Spans are written to stdout if Path is empty or "-".
+	// The file is created when the apiserver is started, not when it is built.
+	Path string
+	// Writer receives the spans instead of Path if set -- e.g. in tests.
+	Writer io.Writer
+	// ServiceName is the service.name resource attribute of the spans.
+	ServiceName string
+	// Sampler samples the traces.  Defaults to sampling every trace unless the parent span of the request is
+	// not sampled.
+	Sampler sdktrace.Sampler
+}
+
+// NewTracerProvider returns a TracerProvider exporting the spans as configured.  The TracerProvider must be
+// shut down to flush the spans and close the file.
+func NewTracerProvider(c Config) (*sdktrace.TracerProvider, error) {
+	var file *os.File
+	w := c.Writer
+	if w == nil && c.Path != "" && c.Path != "-" {
+		f, err := os.OpenFile(c.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open the trace file: %v", err)
+		}
+		file, w = f, f
+	}
+	if w == nil {
+		w = os.Stdout
+	}
+	stdout, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return nil, err
+	}
+	exporter := &exporter{Exporter: stdout, file: file}
+	sampler := c.Sampler
+	if sampler == nil {
+		sampler = sdktrace.ParentBased(sdktrace.AlwaysSample())
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(c.ServiceName))),
+	), nil
+}
+
+// exporter closes the file the spans are written to when it is shut down.
+type exporter struct {
+	*stdouttrace.Exporter
+	file *os.File
+}
+
+// Shutdown shuts down the exporter and closes the file.
+func (e *exporter) Shutdown(ctx context.Context) error {
+	if err := e.Exporter.Shutdown(ctx); err != nil {
+		return err
+	}
+	if e.file != nil {
+		return e.file.Close()
+	}
+	return nil
+}
+
+// Start starts a span using the global TracerProvider.  Spans are not recorded unless the TracerProvider has
+// been set -- e.g. by Server.WithTracing.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on the span, if it is not nil, and ends the span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// WithTracing traces the requests to handler, continuing the W3C trace context of the request if it has one.
+// WithTracing should wrap the complete handler chain so the span includes authentication and authorization.
+func WithTracing(handler http.Handler) http.Handler {
+	propagator := propagation.TraceContext{}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := otel.Tracer(TracerName).Start(ctx, req.Method+" "+req.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", req.URL.Path, req)...))
+		defer span.End()
+
+		rw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(rw, req.WithContext(ctx))
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(rw.status)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(rw.status))
+	})
+}
+
+// statusRecorder records the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush implements http.Flusher, which is required to watch resources.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify implements http.CloseNotifier, which is checked by the audit filter.
+func (r *statusRecorder) CloseNotify() <-chan bool {
+	if c, ok := r.ResponseWriter.(http.CloseNotifier); ok {
+		return c.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// Hijack implements http.Hijacker, which is required to upgrade connections -- e.g. for Connecters.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := r.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("%T does not support hijacking connections", r.ResponseWriter)
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/storage"
+)
+
+const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+// record records the spans started with the global TracerProvider until the test ends.
+func record(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+// validating is a validating admission plugin which rejects every request.
+type validating struct{}
+
+func (validating) Handles(admission.Operation) bool { return true }
+func (validating) Validate(context.Context, admission.Attributes, admission.ObjectInterfaces) error {
+	return fmt.Errorf("denied")
+}
+
+// getter is a storage which fails to get objects.
+type getter struct {
+	storage.Interface
+}
+
+func (getter) Get(context.Context, string, storage.GetOptions, runtime.Object) error {
+	return fmt.Errorf("unavailable")
+}
+
+func TestWithTracing(t *testing.T) {
+	recorder := record(t)
+	plugin := AdmissionDecorator.Decorate(validating{}, "Deny")
+	s := &tracedStorage{Interface: getter{}, resource: schema.GroupResource{Group: "example.com", Resource: "flunders"}}
+
+	handler := WithTracing(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		a := admission.NewAttributesRecord(nil, nil, schema.GroupVersionKind{}, "default", "one",
+			schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "flunders"}, "",
+			admission.Create, nil, false, nil)
+		if err := plugin.(admission.MutationInterface).Admit(req.Context(), a, nil); err != nil {
+			t.Errorf("expected the validating plugin not to mutate, got %v", err)
+		}
+		_ = plugin.(admission.ValidationInterface).Validate(req.Context(), a, nil)
+		_ = s.Get(req.Context(), "/flunders/default/one", storage.GetOptions{}, nil)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/apis/example.com/v1/namespaces/default/flunders", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	if len(spans) != 3 {
+		t.Fatalf("expected the request, admission and storage spans, got %v", spans)
+	}
+	server := spans["POST /apis/example.com/v1/namespaces/default/flunders"]
+	if server == nil {
+		t.Fatalf("expected a server span, got %v", spans)
+	}
+	if id := server.SpanContext().TraceID().String(); id != traceID {
+		t.Errorf("expected the trace context of the request to be continued, got trace %s", id)
+	}
+	if server.Parent().SpanID().String() != "00f067aa0ba902b7" || !server.Parent().IsRemote() {
+		t.Errorf("expected the span of the request to be the remote parent, got %v", server.Parent())
+	}
+	if server.Status().Code != codes.Error {
+		t.Errorf("expected the forbidden request to be an error, got %v", server.Status())
+	}
+	for _, name := range []string{"validate Deny", "storage Get"} {
+		span := spans[name]
+		if span == nil {
+			t.Errorf("expected a %s span, got %v", name, spans)
+			continue
+		}
+		if span.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Errorf("expected %s to be a child of the request span", name)
+		}
+		if span.Status().Code != codes.Error {
+			t.Errorf("expected the error of %s to be recorded, got %v", name, span.Status())
+		}
+	}
+}
+
+func TestNewTracerProvider(t *testing.T) {
+	out := &bytes.Buffer{}
+	provider, err := NewTracerProvider(Config{Writer: out, ServiceName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := provider.Tracer(TracerName).Start(context.Background(), "storage Create")
+	span.End()
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{`"Name":"storage Create"`, `"Value":"example.com"`} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected the exported spans to contain %s, got %s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/dynamic"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcestrategy"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// registration records a resource or subresource version registered with the Server.
+type registration struct {
+	gvr  schema.GroupVersionResource
+	obj  resource.Object
+	kind StorageKind
+	// newStorage is true if the registration created storage for the GroupResource rather than
+	// reusing the storage of another version.
+	newStorage bool
+	// dynamic is set instead of obj for the unstructured resources registered with WithUnstructuredResource.
+	dynamic *dynamic.Resource
+}
+
+func (r registration) isSubResource() bool {
+	return strings.Contains(r.gvr.Resource, "/")
+}
+
+// lifecycle returns the deprecation and removal releases of the registered version.  Unstructured resources
+// are never deprecated.
+func (r registration) lifecycle() apiLifecycle {
+	if r.dynamic != nil {
+		return apiLifecycle{}
+	}
+	l, _ := lifecycleOf(r.obj)
+	return l
+}
+
+// validate returns errors for inconsistencies in the registered resources.  validate must be called after
+// the types have been added to the scheme.
+func (a *Server) validate(scheme *runtime.Scheme) []error {
+	if len(a.registrations) == 0 {
+		return []error{fmt.Errorf("no resources registered")}
+	}
+
+	var errs []error
+	registered := map[schema.GroupVersionResource]registration{}
+	storage := map[schema.GroupResource]registration{}
+	for _, r := range a.registrations {
+		if _, found := registered[r.gvr]; found {
+			errs = append(errs, fmt.Errorf("%v is registered multiple times", r.gvr))
+			continue
+		}
+		registered[r.gvr] = r
+
+		if !r.newStorage {
+			continue
+		}
+		if s, found := storage[r.gvr.GroupResource()]; found {
+			errs = append(errs, fmt.Errorf(
+				"%v registers new storage for %v which already has storage registered by %v",
+				r.gvr, r.gvr.GroupResource(), s.gvr))
+			continue
+		}
+		storage[r.gvr.GroupResource()] = r
+	}
+
+	for _, r := range a.registrations {
+		if r.dynamic != nil {
+			continue
+		}
+		if s := storage[r.gvr.GroupResource()]; s.dynamic != nil && s.gvr != r.gvr {
+			errs = append(errs, fmt.Errorf(
+				"%v uses the storage of the unstructured resource %v, which is only served as %v",
+				r.gvr, r.gvr.GroupResource(), s.gvr.GroupVersion()))
+		}
+		if r.isSubResource() {
+			parent := r.gvr
+			parent.Resource = strings.Split(r.gvr.Resource, "/")[0]
+			if _, found := registered[parent]; !found {
+				errs = append(errs, fmt.Errorf("subresource %v is registered without its parent %v", r.gvr, parent))
+			}
+		}
+		errs = append(errs, validateTypes(r)...)
+	}
+
+	// deprecation is keyed by version, so the resources of a version must agree on it
+	versions := map[schema.GroupVersion]registration{}
+	for _, r := range a.registrations {
+		if r.isSubResource() {
+			continue
+		}
+		v, found := versions[r.gvr.GroupVersion()]
+		if !found {
+			versions[r.gvr.GroupVersion()] = r
+			continue
+		}
+		if l, vl := r.lifecycle(), v.lifecycle(); l != vl {
+			errs = append(errs, fmt.Errorf(
+				"%v is %v, but %v is %v: every resource of a version must be deprecated in the same releases",
+				r.gvr, l, v.gvr, vl))
+		}
+	}
+
+	hubs := a.hubRegistrations()
+	for _, r := range a.registrations {
+		if r.isSubResource() || r.dynamic != nil {
+			continue
+		}
+		if err := a.validateConversion(scheme, r, hubs, registered); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// validateTypes validates the object's New and NewList functions return the object's type and its list type.
+func validateTypes(r registration) []error {
+	var errs []error
+	name := typeName(r.obj)
+	if n := typeName(r.obj.New()); n != name {
+		errs = append(errs, fmt.Errorf("%v New returns %s, expected %s", r.gvr, n, name))
+	}
+	list := r.obj.NewList()
+	if list == nil || !meta.IsListType(list) {
+		errs = append(errs, fmt.Errorf("%v NewList must return a list type with an Items field", r.gvr))
+	} else if n := typeName(list); n != name+"List" {
+		errs = append(errs, fmt.Errorf("%v NewList returns %s, expected %sList", r.gvr, n, name))
+	}
+	return errs
+}
+
+// validateConversion validates the resource version can be converted to the internal version used by its store,
+// and that its storage version is registered.
+func (a *Server) validateConversion(
+	scheme *runtime.Scheme, r registration, hubs map[schema.GroupResource]registration,
+	registered map[schema.GroupVersionResource]registration) error {
+	gr := r.gvr.GroupResource()
+	h := hubs[gr]
+	if h.gvr == r.gvr {
+		storageVersion := a.storageVersion(gr)
+		if _, found := registered[storageVersion.WithResource(gr.Resource)]; !found {
+			return fmt.Errorf("%v is not registered in the storage version %v", gr, storageVersion)
+		}
+		return nil
+	}
+	if !convertible(scheme, r.obj.New(), h.obj.New()) || !convertible(scheme, h.obj.New(), r.obj.New()) {
+		return fmt.Errorf("%v has no conversion to and from %v, which stores the resource", r.gvr, h.gvr.GroupVersion())
+	}
+	return nil
+}
+
+// storageVersion returns the version used to store the GroupResource.
+func (a *Server) storageVersion(gr schema.GroupResource) schema.GroupVersion {
+	if gv, found := a.storageVersions[gr]; found {
+		return gv
+	}
+	if len(a.orderedGroupVersions) > 0 {
+		return a.orderedGroupVersions[0]
+	}
+	return schema.GroupVersion{}
+}
+
+// convertible returns true if the scheme can convert from into to, either directly or through the internal version.
+func convertible(scheme *runtime.Scheme, from, to runtime.Object) bool {
+	if reflect.TypeOf(from) == reflect.TypeOf(to) {
+		return true
+	}
+	_, fromConverter := from.(resourcestrategy.Converter)
+	_, toConverter := to.(resourcestrategy.Converter)
+	if fromConverter && toConverter {
+		return true
+	}
+	if scheme.Convert(from, to, nil) == nil {
+		return true
+	}
+	kinds, _, err := scheme.ObjectKinds(from)
+	if err != nil || len(kinds) == 0 {
+		return false
+	}
+	internal, err := scheme.New(kinds[0].GroupKind().WithVersion(runtime.APIVersionInternal))
+	if err != nil {
+		return false
+	}
+	return scheme.Convert(from, internal, nil) == nil && scheme.Convert(internal, to, nil) == nil
+}
+
+func typeName(obj runtime.Object) string {
+	if obj == nil {
+		return "nil"
+	}
+	return reflect.Indirect(reflect.ValueOf(obj)).Type().Name()
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/rest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var testGroupVersion = schema.GroupVersion{Group: "test.example.com", Version: "v1"}
+
+type Widget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+}
+
+type WidgetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Widget `json:"items"`
+}
+
+func (w *Widget) DeepCopyObject() runtime.Object {
+	c := *w
+	w.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	return &c
+}
+func (w *Widget) GetObjectMeta() *metav1.ObjectMeta { return &w.ObjectMeta }
+func (w *Widget) NamespaceScoped() bool             { return true }
+func (w *Widget) New() runtime.Object               { return &Widget{} }
+func (w *Widget) NewList() runtime.Object           { return &WidgetList{} }
+func (w *Widget) IsInternalVersion() bool           { return true }
+func (w *Widget) GetGroupVersionResource() schema.GroupVersionResource {
+	return testGroupVersion.WithResource("widgets")
+}
+
+func (w *WidgetList) DeepCopyObject() runtime.Object {
+	c := *w
+	c.Items = append([]Widget{}, w.Items...)
+	return &c
+}
+
+// Gadget incorrectly returns a Widget from New and has no list type
+type Gadget struct {
+	Widget
+}
+
+func (g *Gadget) New() runtime.Object     { return &Widget{} }
+func (g *Gadget) NewList() runtime.Object { return nil }
+func (g *Gadget) GetGroupVersionResource() schema.GroupVersionResource {
+	return testGroupVersion.WithResource("gadgets")
+}
+
+func newTestServer() *Server {
+	return &Server{storage: map[schema.GroupResource]*singletonProvider{}}
+}
+
+func TestValidate(t *testing.T) {
+	widgets := testGroupVersion.WithResource("widgets")
+	tests := []struct {
+		name     string
+		register func(a *Server)
+		expected []string
+	}{
+		{
+			name:     "valid",
+			register: func(a *Server) { a.WithResource(&Widget{}) },
+		},
+		{
+			name:     "nothing registered",
+			register: func(a *Server) {},
+			expected: []string{"no resources registered"},
+		},
+		{
+			name: "duplicate",
+			register: func(a *Server) {
+				a.WithResource(&Widget{}).WithResource(&Widget{})
+			},
+			expected: []string{"test.example.com/v1, Resource=widgets is registered multiple times"},
+		},
+		{
+			name: "duplicate storage",
+			register: func(a *Server) {
+				a.WithResource(&Widget{})
+				a.forGroupVersionResource(schema.GroupVersionResource{
+					Group: widgets.Group, Version: "v2", Resource: widgets.Resource}, &Widget{}, EtcdStorage, rest.New(&Widget{}))
+			},
+			expected: []string{
+				"test.example.com/v2, Resource=widgets registers new storage for widgets.test.example.com which " +
+					"already has storage registered by test.example.com/v1, Resource=widgets",
+			},
+		},
+		{
+			name: "subresource without parent",
+			register: func(a *Server) {
+				a.WithResource(&Widget{})
+				a.forGroupVersionResource(
+					testGroupVersion.WithResource("things/scale"), &Widget{}, EtcdStorage, rest.New(&Widget{}))
+			},
+			expected: []string{"subresource test.example.com/v1, Resource=things/scale is registered without " +
+				"its parent test.example.com/v1, Resource=things"},
+		},
+		{
+			name:     "mismatched types",
+			register: func(a *Server) { a.WithResource(&Widget{}).WithResource(&Gadget{}) },
+			expected: []string{
+				"test.example.com/v1, Resource=gadgets New returns Widget, expected Gadget",
+				"test.example.com/v1, Resource=gadgets NewList must return a list type with an Items field",
+			},
+		},
+		{
+			name: "storage version not registered",
+			register: func(a *Server) {
+				a.WithResource(&Widget{}).WithStorageVersion(schema.GroupVersionResource{
+					Group: widgets.Group, Version: "v2", Resource: widgets.Resource})
+			},
+			expected: []string{"widgets.test.example.com is not registered in the storage version test.example.com/v2"},
+		},
+	}
+	for _, test := range tests {
+		a := newTestServer()
+		test.register(a)
+		s := runtime.NewScheme()
+		if err := resource.AddToScheme(&Widget{})(s); err != nil {
+			t.Fatal(err)
+		}
+
+		var msgs []string
+		for _, err := range a.validate(s) {
+			msgs = append(msgs, err.Error())
+		}
+		if strings.Join(msgs, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("%s: expected errors:\n%s\ngot:\n%s",
+				test.name, strings.Join(test.expected, "\n"), strings.Join(msgs, "\n"))
+		}
+	}
+}
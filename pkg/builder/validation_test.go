@@ -0,0 +1,62 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-openapi/spec"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/openapi"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/rest"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Dial is validated against the rules in its tags before its Validate function is called.
+type Dial struct {
+	Widget
+	Level int `json:"level,omitempty" maximum:"10"`
+}
+
+func (d *Dial) New() runtime.Object { return &Dial{} }
+func (d *Dial) Validate(context.Context) field.ErrorList {
+	return field.ErrorList{field.Forbidden(field.NewPath("metadata", "name"), "validated")}
+}
+func (d *Dial) DeepCopyObject() runtime.Object {
+	c := *d
+	d.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	return &c
+}
+func (d *Dial) GetGroupVersionResource() schema.GroupVersionResource {
+	return testGroupVersion.WithResource("dials")
+}
+
+func TestTagValidation(t *testing.T) {
+	a := newTestServer().WithResource(&Dial{})
+	errs := rest.DefaultStrategy{Object: &Dial{}}.Validate(context.Background(), &Dial{Level: 11})
+	if len(errs) != 2 || errs[0].Field != "level" || errs[1].Field != "metadata.name" {
+		t.Errorf("expected the tag rules to be validated before Validate, got %v", errs)
+	}
+
+	defs := a.allOpenAPIDefinitions()(func(path string) spec.Ref { return spec.MustCreateRef(path) })
+	p := defs[openapi.DefinitionName(&Dial{})].Schema.Properties["level"]
+	if p.Maximum == nil || *p.Maximum != 10 {
+		t.Errorf("expected the maximum to be published, got %v", p.Maximum)
+	}
+}
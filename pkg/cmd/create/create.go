@@ -0,0 +1,146 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package create contains the commands for generating the source of new apiserver resources.
+package create
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/scaffold"
+	"github.com/spf13/cobra"
+)
+
+// NewCommandCreate provides a CLI handler for the 'create' command.
+func NewCommandCreate(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Generate the source for new apiserver resources",
+	}
+	cmd.AddCommand(NewCommandCreateResource(out))
+	return cmd
+}
+
+// ResourceOptions contains the options for generating a resource.
+type ResourceOptions struct {
+	scaffold.Resource
+
+	// Dir is the directory the files are generated in.
+	Dir string
+}
+
+// NewCommandCreateResource provides a CLI handler for the 'create resource' command which generates the types
+// of a new resource and a main.go serving it with builder.APIServer.
+func NewCommandCreateResource(out io.Writer) *cobra.Command {
+	o := &ResourceOptions{Dir: "."}
+	cmd := &cobra.Command{
+		Use:   "resource",
+		Short: "Generate the types for a new resource",
+		Long: "Generate the types for a new resource under pkg/apis/GROUP/VERSION, including DeepCopy functions, " +
+			"a list type, stubs for the requested resourcestrategy interfaces and a round-trip test.  " +
+			"main.go is generated if it does not exist, otherwise the resource is added to its builder.APIServer chain.",
+		Example: "  apiserver-runtime create resource --group example.com --version v1alpha1 --kind Flunder " +
+			"--strategy Defaulter,Validater",
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(); err != nil {
+				return err
+			}
+			return o.Run(out)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&o.Group, "group", o.Group, "API group of the resource -- e.g. example.com")
+	flags.StringVar(&o.Version, "version", o.Version, "API version of the resource -- e.g. v1alpha1")
+	flags.StringVar(&o.Kind, "kind", o.Kind, "Kind of the resource -- e.g. Flunder")
+	flags.StringVar(&o.Resource.Resource, "resource", o.Resource.Resource,
+		"All lowercase plural name of the resource.  Defaults to the pluralized lowercase kind.")
+	flags.BoolVar(&o.ClusterScoped, "cluster-scoped", o.ClusterScoped,
+		"Generate a resource which is not namespace scoped.")
+	flags.StringSliceVar(&o.Strategies, "strategy", o.Strategies,
+		fmt.Sprintf("resourcestrategy interfaces to generate stubs for.  One of: %s",
+			strings.Join(scaffold.Strategies(), ", ")))
+	flags.StringVar(&o.Module, "module", o.Module,
+		"Go import path of --dir.  Defaults to the path read from the go.mod file.")
+	flags.StringVar(&o.Dir, "dir", o.Dir, "Directory to generate the files in.")
+	for _, f := range []string{"group", "version", "kind"} {
+		_ = cmd.MarkFlagRequired(f)
+	}
+
+	return cmd
+}
+
+// Complete defaults the module from the go.mod file of the directory.
+func (o *ResourceOptions) Complete() error {
+	if o.Module != "" {
+		return nil
+	}
+	module, err := scaffold.ModulePath(o.Dir)
+	if err != nil {
+		return err
+	}
+	o.Module = module
+	return nil
+}
+
+// Run generates the files for the resource, and registers it in main.go if main.go already exists.
+func (o *ResourceOptions) Run(out io.Writer) error {
+	files, err := o.Files()
+	if err != nil {
+		return err
+	}
+	mainPath := filepath.Join(o.Dir, "main.go")
+	src, err := ioutil.ReadFile(mainPath)
+	if os.IsNotExist(err) {
+		return scaffold.Write(o.Dir, files, out)
+	}
+	if err != nil {
+		return err
+	}
+	registered, err := o.Register(src)
+	if err != nil {
+		return fmt.Errorf("unable to register the resource in main.go: %v\n"+
+			"add .%s to the builder.APIServer chain and import %q", err, o.Registration(),
+			path.Join(o.Module, o.Package()))
+	}
+
+	var generated []scaffold.File
+	for _, f := range files {
+		if f.Path != "main.go" {
+			generated = append(generated, f)
+		}
+	}
+	if err := scaffold.Write(o.Dir, generated, out); err != nil {
+		return err
+	}
+	if bytes.Equal(src, registered) {
+		fmt.Fprintf(out, "%s is already registered in main.go\n", o.Kind)
+		return nil
+	}
+	if err := ioutil.WriteFile(mainPath, registered, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "registered %s in main.go\n", o.Kind)
+	return nil
+}
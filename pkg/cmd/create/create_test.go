@@ -0,0 +1,113 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package create
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newDir returns a directory with a go.mod file for the example.com/demo module.
+func newDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "create")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/demo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// create runs the 'create resource' command in dir.
+func create(dir string, args ...string) (string, error) {
+	out := &bytes.Buffer{}
+	cmd := NewCommandCreate(out)
+	cmd.SetOut(out)
+	cmd.SetErr(out)
+	cmd.SetArgs(append([]string{"resource", "--dir", dir}, args...))
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestCreateResource(t *testing.T) {
+	dir := newDir(t)
+	defer os.RemoveAll(dir)
+
+	out, err := create(dir, "--group", "demo.example.com", "--version", "v1alpha1", "--kind", "Flunder",
+		"--strategy", "Validater")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"main.go", "pkg/apis/demo/v1alpha1/flunder_types.go",
+		"pkg/apis/demo/v1alpha1/flunder_strategy.go"} {
+		if !strings.Contains(out, "wrote "+f+"\n") {
+			t.Errorf("expected %s to be written, got:\n%s", f, out)
+		}
+	}
+
+	// the resources created later are added to main.go
+	out, err = create(dir, "--group", "demo.example.com", "--version", "v1beta1", "--kind", "Flunder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "registered Flunder in main.go") {
+		t.Errorf("expected the Flunder to be registered, got:\n%s", out)
+	}
+	main, err := ioutil.ReadFile(filepath.Join(dir, "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`"example.com/demo/pkg/apis/demo/v1alpha1"`,
+		`"example.com/demo/pkg/apis/demo/v1beta1"`,
+		"WithResource(&v1alpha1.Flunder{}).\n\t\tWithResource(&v1beta1.Flunder{}).\n\t\tExecute()",
+	} {
+		if !strings.Contains(string(main), s) {
+			t.Errorf("expected main.go to contain %q:\n%s", s, main)
+		}
+	}
+
+	// the existing types are not replaced
+	if _, err := create(dir, "--group", "demo.example.com", "--version", "v1beta1", "--kind", "Flunder"); err == nil ||
+		!strings.Contains(err.Error(), "flunder_types.go already exists") {
+		t.Errorf("expected the existing types not to be replaced, got %v", err)
+	}
+}
+
+func TestCreateResourceUnregistered(t *testing.T) {
+	dir := newDir(t)
+	defer os.RemoveAll(dir)
+	main := []byte("package main\n\nfunc main() {}\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), main, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the files are not written when the resource cannot be registered in main.go
+	_, err := create(dir, "--group", "demo.example.com", "--version", "v1alpha1", "--kind", "Flunder")
+	if err == nil || !strings.Contains(err.Error(), "add .WithResource(&v1alpha1.Flunder{}) to the builder.APIServer "+
+		`chain and import "example.com/demo/pkg/apis/demo/v1alpha1"`) {
+		t.Errorf("expected the line to add to main.go, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pkg")); !os.IsNotExist(err) {
+		t.Errorf("expected no files to be written, got %v", err)
+	}
+}
@@ -19,6 +19,7 @@ package server
 import (
 	"github.com/pwittrock/apiserver-runtime/pkg/apiserver"
 	"k8s.io/apiserver/pkg/endpoints/openapi"
+	"k8s.io/apiserver/pkg/registry/generic"
 	pkgserver "k8s.io/apiserver/pkg/server"
 	openapicommon "k8s.io/kube-openapi/pkg/common"
 )
@@ -27,11 +28,24 @@ var (
 	EtcdPath              string
 	RecommendedConfigFns  []func(*pkgserver.RecommendedConfig) *pkgserver.RecommendedConfig
 	ServerOptionsFns      []func(server *ServerOptions) *ServerOptions
+	RESTOptionsGetterFns  []func(generic.RESTOptionsGetter) generic.RESTOptionsGetter
+	OptionsApplierFns     []func(OptionsApplier) OptionsApplier
 	NewCommandStartServer = NewCommandStartWardleServer
 )
 
 type ServerOptions = WardleServerOptions
 
+// OptionsApplier applies the RecommendedOptions of the ServerOptions to the RecommendedConfig.  It may be
+// decorated through OptionsApplierFns -- e.g. to wrap the audit backend created from the audit options.
+type OptionsApplier func(o *ServerOptions, config *pkgserver.RecommendedConfig) error
+
+func ApplyOptionsApplierFns(in OptionsApplier) OptionsApplier {
+	for i := range OptionsApplierFns {
+		in = OptionsApplierFns[i](in)
+	}
+	return in
+}
+
 func ApplyServerOptionsFns(in *ServerOptions) *ServerOptions {
 	for i := range ServerOptionsFns {
 		in = ServerOptionsFns[i](in)
@@ -39,6 +53,13 @@ func ApplyServerOptionsFns(in *ServerOptions) *ServerOptions {
 	return in
 }
 
+func ApplyRESTOptionsGetterFns(in generic.RESTOptionsGetter) generic.RESTOptionsGetter {
+	for i := range RESTOptionsGetterFns {
+		in = RESTOptionsGetterFns[i](in)
+	}
+	return in
+}
+
 func ApplyRecommendedConfigFns(in *pkgserver.RecommendedConfig) *pkgserver.RecommendedConfig {
 	for i := range RecommendedConfigFns {
 		in = RecommendedConfigFns[i](in)
@@ -0,0 +1,377 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/apiserver"
+	"github.com/pwittrock/apiserver-runtime/pkg/registry"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/generic"
+	genericregistry "k8s.io/apiserver/pkg/registry/generic/registry"
+	"k8s.io/apiserver/pkg/registry/rest"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/storage"
+)
+
+// MigrateStorageOptions contains the options for migrating stored objects to their current storage version.
+type MigrateStorageOptions struct {
+	*WardleServerOptions
+
+	// ProgressFile records the resources and objects which have been migrated so an interrupted migration
+	// can be resumed.
+	ProgressFile string
+	// ChunkSize is the number of objects read from storage at a time.
+	ChunkSize int64
+	// Resources are the resources which are migrated.  Every registered resource is migrated if empty.
+	Resources []schema.GroupResource
+
+	// name is the name of the command, which is recorded in the ProgressFile.
+	name string
+}
+
+// NewCommandMigrateStorage provides a CLI handler for the 'migrate-storage' command which rewrites every
+// object stored under GetEctdPath() using the current storage version of its resource.
+func NewCommandMigrateStorage(defaults *WardleServerOptions, stopCh <-chan struct{}) *cobra.Command {
+	o := &MigrateStorageOptions{WardleServerOptions: defaults, ChunkSize: 500, name: "migrate-storage"}
+	cmd := o.command(stopCh, nil)
+	cmd.Use = o.name
+	cmd.Short = "Rewrite stored objects using the current storage version"
+	cmd.Long = "Rewrite every stored object of every registered resource using the current storage version " +
+		"of the resource.  Progress is recorded in --progress-file so interrupted migrations may be resumed."
+	return cmd
+}
+
+// NewCommandRotateEncryptionKeys provides a CLI handler for the 'rotate-encryption-keys' command which rewrites
+// every stored object of the encrypted resources returned by resources, so that the objects are encrypted using
+// the first provider and key configured for their resource.
+func NewCommandRotateEncryptionKeys(defaults *WardleServerOptions, stopCh <-chan struct{},
+	resources func() ([]schema.GroupResource, error)) *cobra.Command {
+	o := &MigrateStorageOptions{WardleServerOptions: defaults, ChunkSize: 500, name: "rotate-encryption-keys"}
+	cmd := o.command(stopCh, resources)
+	cmd.Use = o.name
+	cmd.Short = "Rewrite encrypted objects using the current encryption key"
+	cmd.Long = "Rewrite every stored object of the encrypted resources using the first provider and key " +
+		"configured for the resource.  Run after adding a new key as the first key of the provider, and " +
+		"before removing the previous key.  Progress is recorded in --progress-file so interrupted rotations " +
+		"may be resumed."
+	return cmd
+}
+
+// command returns a command migrating the resources returned by resources, or every resource if nil.
+func (o *MigrateStorageOptions) command(stopCh <-chan struct{},
+	resources func() ([]schema.GroupResource, error)) *cobra.Command {
+	cmd := &cobra.Command{
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(); err != nil {
+				return err
+			}
+			if resources != nil {
+				r, err := resources()
+				if err != nil {
+					return err
+				}
+				o.Resources = r
+			}
+			if err := o.Validate(args); err != nil {
+				return err
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				<-stopCh
+				cancel()
+			}()
+			return o.RunMigrateStorage(ctx)
+		},
+	}
+
+	flags := cmd.Flags()
+	o.RecommendedOptions.Etcd.AddFlags(flags)
+	flags.StringVar(&o.ProgressFile, "progress-file", o.ProgressFile,
+		"File used to record migration progress.  If the file exists, resources and objects recorded "+
+			"in it are skipped.  The file may only be resumed by the command which created it.")
+	flags.Int64Var(&o.ChunkSize, "chunk-size", o.ChunkSize, "Number of objects to read from storage at a time.")
+
+	return cmd
+}
+
+// Validate validates MigrateStorageOptions
+func (o MigrateStorageOptions) Validate(args []string) error {
+	if o.ChunkSize <= 0 {
+		return fmt.Errorf("--chunk-size must be greater than 0")
+	}
+	return nil
+}
+
+// RESTOptionsGetter returns the RESTOptionsGetter used by the apiserver to access storage.
+func (o MigrateStorageOptions) RESTOptionsGetter() (generic.RESTOptionsGetter, error) {
+	c := &genericapiserver.Config{}
+	if err := o.RecommendedOptions.Etcd.ApplyTo(c); err != nil {
+		return nil, err
+	}
+	if len(StorageVersions) > 0 {
+		c.RESTOptionsGetter = &storageVersionRESTOptionsGetter{
+			RESTOptionsGetter: c.RESTOptionsGetter,
+			etcd:              o.RecommendedOptions.Etcd,
+			encodings:         NewResourceEncodingConfig(),
+		}
+	}
+	// objects must be read and written as by the apiserver -- e.g. decrypted and encrypted
+	return ApplyRESTOptionsGetterFns(c.RESTOptionsGetter), nil
+}
+
+// RunMigrateStorage migrates the objects of the Resources, or of every registered resource.
+func (o MigrateStorageOptions) RunMigrateStorage(ctx context.Context) error {
+	optsGetter, err := o.RESTOptionsGetter()
+	if err != nil {
+		return err
+	}
+	p, err := loadMigrationProgress(o.ProgressFile, o.name)
+	if err != nil {
+		return err
+	}
+	m := &storageMigrator{
+		out:       o.StdOut,
+		progress:  p,
+		chunkSize: o.ChunkSize,
+		save: func() error {
+			return p.save(o.ProgressFile)
+		},
+	}
+	migrated := map[schema.GroupResource]bool{}
+	for _, gr := range o.Resources {
+		migrated[gr] = true
+	}
+	for _, gvr := range migratableResources() {
+		if len(migrated) > 0 && !migrated[gvr.GroupResource()] {
+			continue
+		}
+		s, err := apiserver.APIs[gvr](apiserver.Scheme, optsGetter)
+		if err != nil {
+			return err
+		}
+		if err := m.migrate(ctx, gvr.GroupResource(), s); err != nil {
+			return fmt.Errorf("failed to migrate %v: %v", gvr.GroupResource(), err)
+		}
+	}
+	return nil
+}
+
+// migratableResources returns one GroupVersionResource for each registered GroupResource, ignoring subresources
+// which share their parent's storage.
+func migratableResources() []schema.GroupVersionResource {
+	found := map[schema.GroupResource]bool{}
+	var gvrs []schema.GroupVersionResource
+	for gvr := range apiserver.APIs {
+		if strings.Contains(gvr.Resource, "/") || found[gvr.GroupResource()] {
+			continue
+		}
+		found[gvr.GroupResource()] = true
+		gvrs = append(gvrs, gvr)
+	}
+	sort.Slice(gvrs, func(i, j int) bool {
+		return gvrs[i].String() < gvrs[j].String()
+	})
+	return gvrs
+}
+
+// migrationProgress records the migrated resources and the last migrated key of the resource being migrated.
+// Command is the command which recorded the progress, so that the objects migrated by migrate-storage are not
+// skipped by rotate-encryption-keys, or the other way around.
+type migrationProgress struct {
+	Command   string            `json:"command,omitempty"`
+	Completed map[string]bool   `json:"completed"`
+	LastKey   map[string]string `json:"lastKey"`
+}
+
+// loadMigrationProgress reads the progress of command from path.  An error is returned if the progress was
+// recorded by another command.
+func loadMigrationProgress(path, command string) (*migrationProgress, error) {
+	p := &migrationProgress{Command: command, Completed: map[string]bool{}, LastKey: map[string]string{}}
+	if path == "" {
+		return p, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("unable to read migration progress from %s: %v", path, err)
+	}
+	if p.Command != command {
+		return nil, fmt.Errorf("%s records the progress of %s, not %s", path, p.Command, command)
+	}
+	return p, nil
+}
+
+func (p *migrationProgress) save(path string) error {
+	if path == "" {
+		return nil
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+type storageMigrator struct {
+	out       io.Writer
+	progress  *migrationProgress
+	chunkSize int64
+	save      func() error
+}
+
+// migrate rewrites every object of the resource stored by s.  Objects are read in key order so that the
+// migration can be resumed by listing the objects after the last migrated key.
+func (m *storageMigrator) migrate(ctx context.Context, gr schema.GroupResource, s rest.Storage) error {
+	store, ok := etcdStore(s)
+	if !ok {
+		fmt.Fprintf(m.out, "skipping %v: not stored in etcd\n", gr)
+		return nil
+	}
+	if m.progress.Completed[gr.String()] {
+		fmt.Fprintf(m.out, "skipping %v: already migrated\n", gr)
+		return nil
+	}
+
+	// list across all namespaces
+	ctx = genericapirequest.WithNamespace(ctx, metav1.NamespaceAll)
+	root := store.KeyRootFunc(ctx)
+	lastKey := m.progress.LastKey[gr.String()]
+	migrated := 0
+	p := storage.Everything
+	p.Limit = m.chunkSize
+	if lastKey != "" {
+		c, err := continueAfter(root, lastKey)
+		if err != nil {
+			return err
+		}
+		p.Continue = c
+	}
+	for {
+		list := store.NewListFunc()
+		if err := store.Storage.List(ctx, root, storage.ListOptions{Predicate: p}, list); err != nil {
+			return err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+		for i := range items {
+			key, err := objectKey(ctx, store, items[i])
+			if err != nil {
+				return err
+			}
+			if key <= lastKey {
+				continue
+			}
+			if err := m.migrateObject(ctx, store, key, items[i]); err != nil {
+				return err
+			}
+			lastKey = key
+			migrated++
+		}
+		m.progress.LastKey[gr.String()] = lastKey
+		if err := m.save(); err != nil {
+			return err
+		}
+		fmt.Fprintf(m.out, "migrated %d %v\n", migrated, gr)
+
+		listMeta, err := meta.ListAccessor(list)
+		if err != nil {
+			return err
+		}
+		if listMeta.GetContinue() == "" {
+			break
+		}
+		p.Continue = listMeta.GetContinue()
+	}
+
+	m.progress.Completed[gr.String()] = true
+	delete(m.progress.LastKey, gr.String())
+	return m.save()
+}
+
+// migrateObject writes the object back to storage, which encodes it using the current storage version.
+// The write is conditional on the object not having changed since it was read.  Objects which have since been
+// updated or deleted are already stored in the current storage version, or no longer stored, and are skipped.
+func (m *storageMigrator) migrateObject(ctx context.Context, store *genericregistry.Store, key string, obj runtime.Object) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	uid := accessor.GetUID()
+	rv := accessor.GetResourceVersion()
+	err = store.Storage.GuaranteedUpdate(ctx, key, store.NewFunc(), false,
+		&storage.Preconditions{UID: &uid, ResourceVersion: &rv},
+		func(input runtime.Object, _ storage.ResponseMeta) (runtime.Object, *uint64, error) {
+			return input, nil, nil
+		}, false)
+	if storage.IsNotFound(err) || storage.IsInvalidObj(err) || storage.IsConflict(err) {
+		return nil
+	}
+	return err
+}
+
+// continueAfter returns a continue token which lists the keys under root after key at the latest resource
+// version, encoded as by the etcd3 storage, so that a resumed migration does not list the migrated objects.
+func continueAfter(root, key string) (string, error) {
+	start := strings.TrimPrefix(key, strings.TrimSuffix(root, "/")+"/")
+	b, err := json.Marshal(map[string]interface{}{"v": "meta.k8s.io/v1", "rv": -1, "start": start + "\x00"})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// etcdStore returns the etcd backed store for the storage if it has one.
+func etcdStore(s rest.Storage) (*genericregistry.Store, bool) {
+	switch s := s.(type) {
+	case *genericregistry.Store:
+		return s, true
+	case *registry.REST:
+		return s.Store, true
+	}
+	return nil, false
+}
+
+func objectKey(ctx context.Context, store *genericregistry.Store, obj runtime.Object) (string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return "", err
+	}
+	return store.KeyFunc(genericapirequest.WithNamespace(ctx, accessor.GetNamespace()), accessor.GetName())
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/apiserver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	genericregistry "k8s.io/apiserver/pkg/registry/generic/registry"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/etcd3"
+	etcd3testing "k8s.io/apiserver/pkg/storage/etcd3/testing"
+	"k8s.io/apiserver/pkg/storage/value"
+)
+
+func TestMigratableResources(t *testing.T) {
+	gv := schema.GroupVersion{Group: "example.com", Version: "v1"}
+	gv2 := schema.GroupVersion{Group: "example.com", Version: "v2"}
+	for _, gvr := range []schema.GroupVersionResource{
+		gv.WithResource("foos"), gv.WithResource("foos/status"), gv2.WithResource("foos"), gv.WithResource("bars"),
+	} {
+		apiserver.APIs[gvr] = nil
+		defer delete(apiserver.APIs, gvr)
+	}
+
+	var resources []schema.GroupResource
+	for _, gvr := range migratableResources() {
+		resources = append(resources, gvr.GroupResource())
+	}
+	expected := []schema.GroupResource{
+		{Group: "example.com", Resource: "bars"},
+		{Group: "example.com", Resource: "foos"},
+	}
+	if !reflect.DeepEqual(resources, expected) {
+		t.Errorf("expected %v, got %v", expected, resources)
+	}
+}
+
+func TestMigrationProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "progress.json")
+
+	p, err := loadMigrationProgress(path, "migrate-storage")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p.Completed["foos.example.com"] = true
+	p.LastKey["bars.example.com"] = "/registry/example.com/bars/default/bar"
+	if err := p.save(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	resumed, err := loadMigrationProgress(path, "migrate-storage")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(p, resumed) {
+		t.Errorf("expected %v, got %v", p, resumed)
+	}
+
+	// the progress of another command is not resumed
+	_, err = loadMigrationProgress(path, "rotate-encryption-keys")
+	if err == nil || !strings.Contains(err.Error(), "records the progress of migrate-storage") {
+		t.Errorf("expected the progress of migrate-storage to be rejected, got %v", err)
+	}
+}
+
+// Widget is the resource migrated by the tests.
+type Widget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Size              int `json:"size,omitempty"`
+}
+
+type WidgetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Widget `json:"items"`
+}
+
+func (w *Widget) DeepCopyObject() runtime.Object {
+	c := *w
+	w.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	return &c
+}
+
+func (l *WidgetList) DeepCopyObject() runtime.Object {
+	c := *l
+	l.ListMeta.DeepCopyInto(&c.ListMeta)
+	c.Items = nil
+	for i := range l.Items {
+		c.Items = append(c.Items, *l.Items[i].DeepCopyObject().(*Widget))
+	}
+	return &c
+}
+
+// widgetStorage returns etcd storage encoding widgets as example.com/version.  Widget is registered as every
+// version so that objects are only converted by changing their apiVersion.
+func widgetStorage(server *etcd3testing.EtcdTestServer, version string) (storage.Interface, runtime.Codec) {
+	scheme := runtime.NewScheme()
+	for _, v := range []string{"v1", "v2", runtime.APIVersionInternal} {
+		gv := schema.GroupVersion{Group: "example.com", Version: v}
+		scheme.AddKnownTypes(gv, &Widget{}, &WidgetList{})
+	}
+	codecs := serializer.NewCodecFactory(scheme)
+	info, _ := runtime.SerializerInfoForMediaType(codecs.SupportedMediaTypes(), runtime.ContentTypeJSON)
+	codec := codecs.CodecForVersions(info.Serializer, codecs.UniversalDeserializer(),
+		schema.GroupVersion{Group: "example.com", Version: version},
+		schema.GroupVersion{Group: "example.com", Version: runtime.APIVersionInternal})
+	return etcd3.New(server.V3Client, codec, "/registry", value.IdentityTransformer, true), codec
+}
+
+func TestMigrate(t *testing.T) {
+	server, _ := etcd3testing.NewUnsecuredEtcd3TestClientServer(t)
+	defer server.Terminate(t)
+	ctx := genericapirequest.WithNamespace(context.Background(), metav1.NamespaceDefault)
+	gr := schema.GroupResource{Group: "example.com", Resource: "widgets"}
+
+	// store the widgets as v1
+	v1, _ := widgetStorage(server, "v1")
+	names := []string{"a", "b", "c", "d", "e"}
+	for _, name := range names {
+		w := &Widget{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}, Size: 1}
+		if err := v1.Create(ctx, "/widgets/default/"+name, w, nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storedVersions := func() []string {
+		var versions []string
+		for _, name := range names {
+			r, err := server.V3Client.Get(ctx, "/registry/widgets/default/"+name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := &metav1.TypeMeta{}
+			if err := json.Unmarshal(r.Kvs[0].Value, w); err != nil {
+				t.Fatal(err)
+			}
+			versions = append(versions, w.APIVersion)
+		}
+		return versions
+	}
+
+	// migrate them to v2, resuming after b
+	v2, codec := widgetStorage(server, "v2")
+	store := &genericregistry.Store{
+		NewFunc:     func() runtime.Object { return &Widget{} },
+		NewListFunc: func() runtime.Object { return &WidgetList{} },
+		KeyRootFunc: func(ctx context.Context) string {
+			return genericregistry.NamespaceKeyRootFunc(ctx, "/widgets")
+		},
+		KeyFunc: func(ctx context.Context, name string) (string, error) {
+			return genericregistry.NamespaceKeyFunc(ctx, "/widgets", name)
+		},
+		Storage: genericregistry.DryRunnableStorage{Storage: v2, Codec: codec},
+	}
+	out := &bytes.Buffer{}
+	p := &migrationProgress{Completed: map[string]bool{}, LastKey: map[string]string{gr.String(): "/widgets/default/b"}}
+	var saved []string
+	m := &storageMigrator{out: out, progress: p, chunkSize: 2, save: func() error {
+		saved = append(saved, p.LastKey[gr.String()])
+		return nil
+	}}
+	if err := m.migrate(context.Background(), gr, store); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"example.com/v1", "example.com/v1", "example.com/v2", "example.com/v2", "example.com/v2"}
+	if versions := storedVersions(); !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expected the widgets after b to be rewritten as v2, got %v", versions)
+	}
+	// the objects are listed from the last key, progress is saved after each chunk, and the last key is cleared
+	// once the resource is migrated
+	expectedSaved := []string{"/widgets/default/d", "/widgets/default/e", ""}
+	if !reflect.DeepEqual(saved, expectedSaved) {
+		t.Errorf("expected the progress to be saved with %v, got %v", expectedSaved, saved)
+	}
+	if !p.Completed[gr.String()] {
+		t.Errorf("expected %v to be completed, got %v", gr, p)
+	}
+	if !strings.Contains(out.String(), "migrated 3 widgets.example.com\n") {
+		t.Errorf("expected 3 widgets to be migrated, got:\n%s", out)
+	}
+
+	// completed resources are not migrated again
+	p.Completed[gr.String()] = true
+	out.Reset()
+	if err := m.migrate(context.Background(), gr, store); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "skipping widgets.example.com: already migrated\n" {
+		t.Errorf("expected the widgets to be skipped, got:\n%s", out)
+	}
+}
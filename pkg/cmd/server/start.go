@@ -37,6 +37,7 @@ import (
 // WardleServerOptions contains state for master/api server
 type WardleServerOptions struct {
 	RecommendedOptions *genericoptions.RecommendedOptions
+	APIEnablement      *genericoptions.APIEnablementOptions
 
 	//SharedInformerFactory informers.SharedInformerFactory
 	StdOut io.Writer
@@ -50,6 +51,7 @@ func NewWardleServerOptions(out, errOut io.Writer, version schema.GroupVersion)
 			GetEctdPath(),
 			apiserver.Codecs.LegacyCodec(version),
 		),
+		APIEnablement: genericoptions.NewAPIEnablementOptions(),
 
 		StdOut: out,
 		StdErr: errOut,
@@ -81,6 +83,7 @@ func NewCommandStartWardleServer(defaults *WardleServerOptions, stopCh <-chan st
 
 	flags := cmd.Flags()
 	o.RecommendedOptions.AddFlags(flags)
+	o.APIEnablement.AddFlags(flags)
 	utilfeature.DefaultMutableFeatureGate.AddFlag(flags)
 
 	return cmd
@@ -90,6 +93,7 @@ func NewCommandStartWardleServer(defaults *WardleServerOptions, stopCh <-chan st
 func (o WardleServerOptions) Validate(args []string) error {
 	errors := []error{}
 	errors = append(errors, o.RecommendedOptions.Validate()...)
+	errors = append(errors, o.APIEnablement.Validate(apiserver.Scheme)...)
 	return utilerrors.NewAggregate(errors)
 }
 
@@ -140,10 +144,36 @@ func (o *WardleServerOptions) Config() (*apiserver.Config, error) {
 	// serverConfig.OpenAPIConfig.Info.Title = "Wardle"
 	// serverConfig.OpenAPIConfig.Info.Version = "0.1"
 
-	if err := o.RecommendedOptions.ApplyTo(serverConfig); err != nil {
+	// change: apiserver-runtime
+	// the config built from the options may be decorated -- e.g. to redact the events of the audit backend
+	applyOptions := ApplyOptionsApplierFns(func(o *ServerOptions, c *genericapiserver.RecommendedConfig) error {
+		return o.RecommendedOptions.ApplyTo(c)
+	})
+	if err := applyOptions(o, serverConfig); err != nil {
+		return nil, err
+	}
+
+	// change: apiserver-runtime
+	// versions may be disabled with --runtime-config
+	if err := o.APIEnablement.ApplyTo(
+		&serverConfig.Config, apiserver.DefaultAPIResourceConfigSource(), apiserver.Scheme); err != nil {
 		return nil, err
 	}
 
+	// change: apiserver-runtime
+	// resources with an explicit storage version get their own storage codec
+	if len(StorageVersions) > 0 {
+		serverConfig.RESTOptionsGetter = &storageVersionRESTOptionsGetter{
+			RESTOptionsGetter: serverConfig.RESTOptionsGetter,
+			etcd:              o.RecommendedOptions.Etcd,
+			encodings:         NewResourceEncodingConfig(),
+		}
+	}
+
+	// change: apiserver-runtime
+	// the storage of every resource may be decorated -- e.g. to trace storage calls
+	serverConfig.RESTOptionsGetter = ApplyRESTOptionsGetterFns(serverConfig.RESTOptionsGetter)
+
 	config := &apiserver.Config{
 		GenericConfig: serverConfig,
 		ExtraConfig:   apiserver.ExtraConfig{},
@@ -0,0 +1,212 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/apiserver"
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+// Gizmo is the internal and v2 version of gizmos.
+type Gizmo struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+}
+
+func (g *Gizmo) DeepCopyObject() runtime.Object {
+	c := *g
+	g.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	return &c
+}
+
+// DeprecatedGizmo is the v1 version of gizmos, which is deprecated.
+type DeprecatedGizmo struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+}
+
+func (g *DeprecatedGizmo) DeepCopyObject() runtime.Object {
+	c := *g
+	g.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	return &c
+}
+
+func (g *DeprecatedGizmo) APILifecycleDeprecated() (major, minor int) { return 1, 0 }
+
+// gizmoStorage gets gizmos of any name.
+type gizmoStorage struct{}
+
+func (gizmoStorage) New() runtime.Object   { return &Gizmo{} }
+func (gizmoStorage) NamespaceScoped() bool { return false }
+func (gizmoStorage) Get(_ context.Context, name string, _ *metav1.GetOptions) (runtime.Object, error) {
+	return &Gizmo{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil
+}
+
+var (
+	gizmosV1 = schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "gizmos"}
+	gizmosV2 = schema.GroupVersionResource{Group: "example.com", Version: "v2", Resource: "gizmos"}
+)
+
+// addGizmos serves gizmos in v1 and v2, preferring v2 as the builder does for deprecated versions.
+func addGizmos(t *testing.T) func() {
+	gizmo := func(gvr schema.GroupVersionResource) schema.GroupVersionKind {
+		return gvr.GroupVersion().WithKind("Gizmo")
+	}
+	internal := schema.GroupVersion{Group: "example.com", Version: runtime.APIVersionInternal}
+	apiserver.Scheme.AddKnownTypeWithName(internal.WithKind("Gizmo"), &Gizmo{})
+	apiserver.Scheme.AddKnownTypeWithName(gizmo(gizmosV2), &Gizmo{})
+	apiserver.Scheme.AddKnownTypeWithName(gizmo(gizmosV1), &DeprecatedGizmo{})
+	for _, gv := range []schema.GroupVersion{gizmosV2.GroupVersion(), gizmosV1.GroupVersion()} {
+		metav1.AddToGroupVersion(apiserver.Scheme, gv)
+	}
+	if err := apiserver.Scheme.SetVersionPriority(gizmosV2.GroupVersion(), gizmosV1.GroupVersion()); err != nil {
+		t.Fatal(err)
+	}
+	if err := apiserver.Scheme.AddConversionFunc((*Gizmo)(nil), (*DeprecatedGizmo)(nil),
+		func(in, out interface{}, _ conversion.Scope) error {
+			out.(*DeprecatedGizmo).ObjectMeta = in.(*Gizmo).ObjectMeta
+			return nil
+		}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, gvr := range []schema.GroupVersionResource{gizmosV1, gizmosV2} {
+		apiserver.APIs[gvr] = func(*runtime.Scheme, generic.RESTOptionsGetter) (rest.Storage, error) {
+			return gizmoStorage{}, nil
+		}
+	}
+	return func() {
+		delete(apiserver.APIs, gizmosV1)
+		delete(apiserver.APIs, gizmosV2)
+	}
+}
+
+// newHandler returns the handler of the apiserver configured by args.  Requests are not authenticated or
+// authorized.
+func newHandler(t *testing.T, args ...string) http.Handler {
+	o := NewWardleServerOptions(ioutil.Discard, ioutil.Discard, gizmosV2.GroupVersion())
+	o.RecommendedOptions.Authentication = nil
+	o.RecommendedOptions.Authorization = nil
+	o.RecommendedOptions.CoreAPI = nil
+	o.RecommendedOptions.Admission = nil
+	o.RecommendedOptions.Etcd.StorageConfig.Transport.ServerList = []string{"http://127.0.0.1:2379"}
+	o.RecommendedOptions.SecureServing.ServerCert.CertDirectory = ""
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	o.RecommendedOptions.SecureServing.Listener = listener
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	o.APIEnablement.AddFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if errs := o.APIEnablement.Validate(apiserver.Scheme); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	config, err := o.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := config.Complete().New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.GenericAPIServer.Handler
+}
+
+// get serves a GET request for path.
+func get(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+// servedVersions returns the versions of the API group in discovery, and its preferred version.
+func servedVersions(t *testing.T, h http.Handler) ([]string, string) {
+	w := get(h, "/apis/example.com")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the group to be discovered, got %d: %s", w.Code, w.Body)
+	}
+	group := &metav1.APIGroup{}
+	if err := json.Unmarshal(w.Body.Bytes(), group); err != nil {
+		t.Fatal(err)
+	}
+	var versions []string
+	for _, v := range group.Versions {
+		versions = append(versions, v.Version)
+	}
+	return versions, group.PreferredVersion.Version
+}
+
+func TestRuntimeConfig(t *testing.T) {
+	defer addGizmos(t)()
+
+	h := newHandler(t)
+	versions, preferred := servedVersions(t, h)
+	if expected := []string{"v2", "v1"}; !reflect.DeepEqual(versions, expected) || preferred != "v2" {
+		t.Errorf("expected %v preferring v2, got %v preferring %s", expected, versions, preferred)
+	}
+
+	// the deprecated version returns a warning header, the other version does not
+	w := get(h, "/apis/example.com/v1/gizmos/one")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the v1 gizmo, got %d: %s", w.Code, w.Body)
+	}
+	warning := w.Header().Get("Warning")
+	if !strings.Contains(warning, "example.com/v1 Gizmo is deprecated in v1.0+") {
+		t.Errorf("expected a deprecation warning, got %q", warning)
+	}
+	w = get(h, "/apis/example.com/v2/gizmos/one")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the v2 gizmo, got %d: %s", w.Code, w.Body)
+	}
+	if warning := w.Header().Get("Warning"); warning != "" {
+		t.Errorf("expected no warning, got %q", warning)
+	}
+
+	// the version disabled with --runtime-config is not served
+	h = newHandler(t, "--runtime-config=example.com/v1=false")
+	versions, preferred = servedVersions(t, h)
+	if expected := []string{"v2"}; !reflect.DeepEqual(versions, expected) || preferred != "v2" {
+		t.Errorf("expected %v preferring v2, got %v preferring %s", expected, versions, preferred)
+	}
+	if w := get(h, "/apis/example.com/v1/gizmos/one"); w.Code != http.StatusNotFound {
+		t.Errorf("expected v1 not to be served, got %d: %s", w.Code, w.Body)
+	}
+	if w := get(h, "/apis/example.com/v2/gizmos/one"); w.Code != http.StatusOK {
+		t.Errorf("expected v2 to be served, got %d: %s", w.Code, w.Body)
+	}
+}
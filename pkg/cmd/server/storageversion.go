@@ -0,0 +1,88 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"strings"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/apiserver"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/generic"
+	genericoptions "k8s.io/apiserver/pkg/server/options"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+)
+
+// StorageVersions contains the storage version for each GroupResource that should not be stored
+// using the default storage version of the group.
+var StorageVersions = map[schema.GroupResource]schema.GroupVersion{}
+
+// NewResourceEncodingConfig returns a ResourceEncodingConfig which encodes the resources in
+// StorageVersions using their storage version.
+func NewResourceEncodingConfig() *serverstorage.DefaultResourceEncodingConfig {
+	c := serverstorage.NewDefaultResourceEncodingConfig(apiserver.Scheme)
+	for gr, gv := range StorageVersions {
+		c.SetResourceEncoding(gr, gv, schema.GroupVersion{Group: gr.Group, Version: runtime.APIVersionInternal})
+	}
+	return c
+}
+
+// storageVersionRESTOptionsGetter overrides the storage codec of the RESTOptions for resources which have
+// an explicit storage version.  Resources without an explicit storage version use the delegate's codec.
+type storageVersionRESTOptionsGetter struct {
+	generic.RESTOptionsGetter
+	etcd      *genericoptions.EtcdOptions
+	encodings *serverstorage.DefaultResourceEncodingConfig
+}
+
+func (g *storageVersionRESTOptionsGetter) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	opts, err := g.RESTOptionsGetter.GetRESTOptions(resource)
+	if err != nil {
+		return opts, err
+	}
+
+	// subresources are stored using the storage version of their parent resource
+	parent := schema.GroupResource{Group: resource.Group, Resource: strings.Split(resource.Resource, "/")[0]}
+	if _, found := StorageVersions[parent]; !found {
+		return opts, nil
+	}
+
+	storageVersion, err := g.encodings.StorageEncodingFor(parent)
+	if err != nil {
+		return opts, err
+	}
+	memoryVersion, err := g.encodings.InMemoryEncodingFor(parent)
+	if err != nil {
+		return opts, err
+	}
+
+	// operate on a copy so the storage config shared by other resources is not modified
+	storageConfig := *opts.StorageConfig
+	storageConfig.Codec, storageConfig.EncodeVersioner, err = serverstorage.NewStorageCodec(
+		serverstorage.StorageCodecConfig{
+			StorageMediaType:  g.etcd.DefaultStorageMediaType,
+			StorageSerializer: apiserver.Codecs,
+			StorageVersion:    storageVersion,
+			MemoryVersion:     memoryVersion,
+			Config:            storageConfig,
+		})
+	if err != nil {
+		return opts, err
+	}
+	opts.StorageConfig = &storageConfig
+	return opts, nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pwittrock/apiserver-runtime/pkg/apis/wardle"
+	"github.com/pwittrock/apiserver-runtime/pkg/apis/wardle/install"
+	"github.com/pwittrock/apiserver-runtime/pkg/apis/wardle/v1alpha1"
+	"github.com/pwittrock/apiserver-runtime/pkg/apis/wardle/v1beta1"
+	"github.com/pwittrock/apiserver-runtime/pkg/apiserver"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/generic"
+	genericoptions "k8s.io/apiserver/pkg/server/options"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+)
+
+type fakeRESTOptionsGetter struct {
+	etcd *genericoptions.EtcdOptions
+}
+
+func (f fakeRESTOptionsGetter) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	return generic.RESTOptions{StorageConfig: &f.etcd.StorageConfig}, nil
+}
+
+func TestStorageVersionRESTOptionsGetter(t *testing.T) {
+	install.Install(apiserver.Scheme)
+
+	flunders := schema.GroupResource{Group: wardle.GroupName, Resource: "flunders"}
+	StorageVersions[flunders] = v1beta1.SchemeGroupVersion
+	defer delete(StorageVersions, flunders)
+
+	etcd := genericoptions.NewEtcdOptions(storagebackend.NewDefaultConfig(
+		GetEctdPath(), apiserver.Codecs.LegacyCodec(v1alpha1.SchemeGroupVersion)))
+	g := &storageVersionRESTOptionsGetter{
+		RESTOptionsGetter: fakeRESTOptionsGetter{etcd: etcd},
+		etcd:              etcd,
+		encodings:         NewResourceEncodingConfig(),
+	}
+
+	tests := []struct {
+		resource string
+		obj      runtime.Object
+		expected string
+	}{
+		{resource: "flunders", obj: &wardle.Flunder{}, expected: v1beta1.SchemeGroupVersion.String()},
+		{resource: "flunders/status", obj: &wardle.Flunder{}, expected: v1beta1.SchemeGroupVersion.String()},
+		{resource: "fischers", obj: &wardle.Fischer{}, expected: v1alpha1.SchemeGroupVersion.String()},
+	}
+	for _, test := range tests {
+		opts, err := g.GetRESTOptions(schema.GroupResource{Group: wardle.GroupName, Resource: test.resource})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.resource, err)
+		}
+		buf := &bytes.Buffer{}
+		if err := opts.StorageConfig.Codec.Encode(test.obj, buf); err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.resource, err)
+		}
+		if !strings.Contains(buf.String(), `"apiVersion":"`+test.expected+`"`) {
+			t.Errorf("%s: expected to be stored as %s, got %s", test.resource, test.expected, buf.String())
+		}
+	}
+}
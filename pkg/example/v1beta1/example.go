@@ -36,8 +36,10 @@ type ExampleResourceList struct {
 }
 
 func (e *ExampleResource) DeepCopyObject() runtime.Object {
-	// implemented by code generation
-	return e
+	// normally implemented by deepcopy-gen
+	c := *e
+	e.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	return &c
 }
 
 func (e *ExampleResource) GetObjectMeta() *v1.ObjectMeta {
@@ -65,6 +67,14 @@ func (e ExampleResource) IsInternalVersion() bool {
 }
 
 func (e *ExampleResourceList) DeepCopyObject() runtime.Object {
-	// implemented by code generation
-	return e
+	// normally implemented by deepcopy-gen
+	c := *e
+	e.ListMeta.DeepCopyInto(&c.ListMeta)
+	if e.Items != nil {
+		c.Items = make([]ExampleResource, len(e.Items))
+		for i := range e.Items {
+			c.Items[i] = *e.Items[i].DeepCopyObject().(*ExampleResource)
+		}
+	}
+	return &c
 }
@@ -0,0 +1,172 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scaffold
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// builderImport is the import path of the package declaring builder.APIServer.
+const builderImport = "github.com/pwittrock/apiserver-runtime/pkg/builder"
+
+// Registration returns the call registering the Resource with builder.APIServer -- e.g.
+// WithResource(&v1alpha1.Flunder{}).
+func (r Resource) Registration() string {
+	return fmt.Sprintf("WithResource(&%s.%s{})", r.Version, r.Kind)
+}
+
+// Register returns the source of main.go with the Resource added to its builder.APIServer chain, after the
+// resources already registered so the storage versions of their groups are unchanged.  The package of the
+// Resource is imported if it is not already.  Register returns src unchanged if the Resource is already
+// registered, and an error if src does not have exactly one builder.APIServer chain.
+func (r Resource) Register(src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "main.go", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	pkg := path.Join(r.Module, r.Package())
+	builderName, found := importName(f, builderImport)
+	if !found {
+		return nil, fmt.Errorf("main.go does not import %s", builderImport)
+	}
+	name, imported := importName(f, pkg)
+	if !imported {
+		// another version of the same name -- e.g. pkg/apis/other/v1alpha1
+		for _, i := range f.Imports {
+			p := importPath(i)
+			if i.Name != nil && i.Name.Name == r.Version ||
+				i.Name == nil && path.Base(p) == r.Version && strings.HasPrefix(p, r.Module+"/") {
+				return nil, fmt.Errorf("main.go already imports %s as %s", p, r.Version)
+			}
+		}
+		name = r.Version
+	}
+
+	// find the builder.APIServer chain, and the last resource registered by it
+	var chains []ast.Expr
+	var last ast.Expr
+	ast.Inspect(f, func(n ast.Node) bool {
+		if s, ok := n.(*ast.SelectorExpr); ok && s.Sel.Name == "APIServer" {
+			if x, ok := s.X.(*ast.Ident); ok && x.Name == builderName {
+				chains = append(chains, s)
+			}
+		}
+		return true
+	})
+	if len(chains) != 1 {
+		return nil, fmt.Errorf("main.go has %d builder.APIServer chains, expected 1", len(chains))
+	}
+	registered := false
+	ast.Inspect(f, func(n ast.Node) bool {
+		c, ok := n.(*ast.CallExpr)
+		if !ok || !chainedFrom(c, chains[0]) {
+			return true
+		}
+		s := c.Fun.(*ast.SelectorExpr)
+		if !strings.HasPrefix(s.Sel.Name, "WithResource") {
+			return true
+		}
+		if last == nil || c.End() > last.End() {
+			last = c
+		}
+		if s.Sel.Name == "WithResource" && len(c.Args) == 1 {
+			b := &bytes.Buffer{}
+			if err := format.Node(b, fset, c.Args[0]); err == nil && b.String() == "&"+name+"."+r.Kind+"{}" {
+				registered = true
+			}
+		}
+		return true
+	})
+	if registered {
+		return src, nil
+	}
+	if last == nil {
+		last = chains[0]
+	}
+
+	// insert the call, and then the import, so the offset of the call is unchanged by the import
+	call := fmt.Sprintf("WithResource(&%s.%s{})", name, r.Kind)
+	out := insert(src, fset.Position(last.End()).Offset, ".\n"+call)
+	if !imported {
+		offset, text := importOffset(f, fset)
+		out = insert(out, offset, fmt.Sprintf(text, strconv.Quote(pkg)))
+	}
+	formatted, err := format.Source(out)
+	if err != nil {
+		return nil, fmt.Errorf("unable to format main.go: %v", err)
+	}
+	return formatted, nil
+}
+
+// chainedFrom returns true if the call is a method call on expr or on the result of a call chained from expr.
+func chainedFrom(c *ast.CallExpr, expr ast.Expr) bool {
+	s, ok := c.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	if s.X == expr {
+		return true
+	}
+	if inner, ok := s.X.(*ast.CallExpr); ok {
+		return chainedFrom(inner, expr)
+	}
+	return false
+}
+
+// importName returns the name the package is imported as by the file.
+func importName(f *ast.File, pkg string) (string, bool) {
+	for _, i := range f.Imports {
+		if importPath(i) != pkg {
+			continue
+		}
+		if i.Name != nil {
+			return i.Name.Name, true
+		}
+		return path.Base(pkg), true
+	}
+	return "", false
+}
+
+func importPath(i *ast.ImportSpec) string {
+	p, _ := strconv.Unquote(i.Path.Value)
+	return p
+}
+
+// importOffset returns the offset to insert an import at, and the format of the text to insert.
+func importOffset(f *ast.File, fset *token.FileSet) (int, string) {
+	for _, d := range f.Decls {
+		if g, ok := d.(*ast.GenDecl); ok && g.Tok == token.IMPORT && g.Lparen.IsValid() {
+			return fset.Position(g.Rparen).Offset, "\t%s\n"
+		}
+	}
+	return fset.Position(f.Name.End()).Offset, "\n\nimport %s"
+}
+
+func insert(src []byte, offset int, text string) []byte {
+	out := append([]byte{}, src[:offset]...)
+	out = append(out, text...)
+	return append(out, src[offset:]...)
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scaffold
+
+import (
+	"strings"
+	"testing"
+)
+
+const registeredMain = `package main
+
+import (
+	"example.com/demo/pkg/apis/demo/v1alpha1"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder"
+	"k8s.io/klog/v2"
+)
+
+func main() {
+	// serve the demo resources
+	err := builder.APIServer.
+		WithResource(&v1alpha1.Flunder{}).
+		WithResourceAndHandler(&v1alpha1.Fischer{}, nil).
+		WithOpenAPIDefinitions("demo", "v0", nil).
+		Execute()
+	if err != nil {
+		klog.Fatal(err)
+	}
+}
+`
+
+func TestRegister(t *testing.T) {
+	r := Resource{Group: "demo.example.com", Version: "v1beta1", Kind: "Flunder", Module: "example.com/demo"}
+	src, err := r.Register([]byte(registeredMain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Replace(registeredMain, `WithResourceAndHandler(&v1alpha1.Fischer{}, nil).
+`, `WithResourceAndHandler(&v1alpha1.Fischer{}, nil).
+		WithResource(&v1beta1.Flunder{}).
+`, 1)
+	expected = strings.Replace(expected, `	"example.com/demo/pkg/apis/demo/v1alpha1"
+`, `	"example.com/demo/pkg/apis/demo/v1alpha1"
+	"example.com/demo/pkg/apis/demo/v1beta1"
+`, 1)
+	if string(src) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, src)
+	}
+
+	// registering the resource again leaves main.go unchanged
+	again, err := r.Register(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(src) {
+		t.Errorf("expected main.go to be unchanged, got:\n%s", again)
+	}
+
+	// the existing import of the package is used
+	r.Version, r.Kind = "v1alpha1", "Gadget"
+	src, err = r.Register([]byte(registeredMain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(src), "pkg/apis/demo/v1alpha1") != 1 ||
+		!strings.Contains(string(src), "WithResource(&v1alpha1.Gadget{}).\n\t\tWithOpenAPIDefinitions") {
+		t.Errorf("expected the Gadget to be registered:\n%s", src)
+	}
+}
+
+func TestRegisterErrors(t *testing.T) {
+	r := Resource{Group: "other.example.com", Version: "v1alpha1", Kind: "Gadget", Module: "example.com/demo"}
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{
+			src:      registeredMain,
+			expected: "main.go already imports example.com/demo/pkg/apis/demo/v1alpha1 as v1alpha1",
+		},
+		{
+			src:      "package main\n\nfunc main() {}\n",
+			expected: "main.go does not import " + builderImport,
+		},
+		{
+			src:      "package main\n\nimport \"" + builderImport + "\"\n\nfunc main() {}\n",
+			expected: "main.go has 0 builder.APIServer chains, expected 1",
+		},
+	}
+	for _, test := range tests {
+		if _, err := r.Register([]byte(test.src)); err == nil || err.Error() != test.expected {
+			t.Errorf("expected %q, got %v", test.expected, err)
+		}
+	}
+}
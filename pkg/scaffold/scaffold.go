@@ -0,0 +1,385 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package scaffold generates the source for new resources served by a builder.Server.
+//
+// The generated types implement resource.Object, including DeepCopy functions, and are registered with
+// builder.APIServer by a generated main.go, or are added to the builder.APIServer chain of an existing main.go
+// by Resource.Register.
+package scaffold
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Resource describes a resource to generate.
+type Resource struct {
+	// Group is the API group of the resource -- e.g. example.com
+	Group string
+	// Version is the API version of the resource -- e.g. v1alpha1
+	Version string
+	// Kind is the kind of the resource -- e.g. Flunder
+	Kind string
+	// Resource is the all lowercase and pluralized kind.  Defaults to the pluralized lowercase Kind.
+	Resource string
+	// ClusterScoped is true if the resource is not namespace scoped.
+	ClusterScoped bool
+	// Strategies are the names of the resourcestrategy interfaces to generate stubs for -- e.g. Validater.
+	Strategies []string
+	// Module is the go import path of the directory the files are generated in.
+	Module string
+}
+
+// strategy is the stub implementing a resourcestrategy interface.
+type strategy struct {
+	// imports are the packages used by the stub
+	imports []string
+	text    string
+}
+
+// strategies maps the names of the resourcestrategy interfaces to the stubs implementing them.
+var strategies = map[string]strategy{
+	"Canonicalizer": {text: `
+// Canonicalize formats the {{.Kind}} for storage.
+func ({{.Receiver}} *{{.Kind}}) Canonicalize() {
+}
+`},
+	"Defaulter": {text: `
+// Default defaults unset fields of the {{.Kind}}.
+func ({{.Receiver}} *{{.Kind}}) Default() {
+}
+`},
+	"PrepareForCreater": {imports: []string{"context"}, text: `
+// PrepareForCreate clears fields of the {{.Kind}} which may not be set on creation.
+func ({{.Receiver}} *{{.Kind}}) PrepareForCreate(ctx context.Context) {
+}
+`},
+	"PrepareForUpdater": {imports: []string{"context", "k8s.io/apimachinery/pkg/runtime"}, text: `
+// PrepareForUpdate clears fields of the {{.Kind}} which may not be set on update.
+func ({{.Receiver}} *{{.Kind}}) PrepareForUpdate(ctx context.Context, old runtime.Object) {
+}
+`},
+	"Validater": {imports: []string{"context", "k8s.io/apimachinery/pkg/util/validation/field"}, text: `
+// Validate validates the {{.Kind}} when it is created.
+func ({{.Receiver}} *{{.Kind}}) Validate(ctx context.Context) field.ErrorList {
+	return nil
+}
+`},
+	"ValidateUpdater": {
+		imports: []string{"context", "k8s.io/apimachinery/pkg/runtime", "k8s.io/apimachinery/pkg/util/validation/field"},
+		text: `
+// ValidateUpdate validates the {{.Kind}} when it is updated.
+func ({{.Receiver}} *{{.Kind}}) ValidateUpdate(ctx context.Context, old runtime.Object) field.ErrorList {
+	return nil
+}
+`},
+}
+
+// Strategies returns the names of the resourcestrategy interfaces stubs may be generated for.
+func Strategies() []string {
+	var names []string
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// File is a generated file.
+type File struct {
+	// Path is the path of the file relative to the directory it is generated in.
+	Path string
+	// Contents is the formatted go source of the file.
+	Contents []byte
+	// KeepExisting is true if an existing file should be kept rather than being replaced -- e.g. the main.go
+	// shared by all resources.
+	KeepExisting bool
+}
+
+// Default defaults the Resource name from the Kind.
+func (r *Resource) Default() {
+	if r.Resource == "" {
+		r.Resource = Pluralize(strings.ToLower(r.Kind))
+	}
+}
+
+// Validate returns an error if the Resource cannot be generated.
+func (r *Resource) Validate() error {
+	var errs []string
+	if msgs := validation.IsDNS1123Subdomain(r.Group); len(msgs) != 0 || !strings.Contains(r.Group, ".") {
+		errs = append(errs, fmt.Sprintf("group %q must be a fully qualified domain name -- e.g. example.com", r.Group))
+	}
+	if msgs := validation.IsDNS1035Label(r.Version); len(msgs) != 0 {
+		errs = append(errs, fmt.Sprintf("version %q must be a lowercase name -- e.g. v1alpha1", r.Version))
+	}
+	if r.Kind == "" || strings.ToUpper(r.Kind[:1]) != r.Kind[:1] || !isIdentifier(r.Kind) {
+		errs = append(errs, fmt.Sprintf("kind %q must be an exported go identifier -- e.g. Flunder", r.Kind))
+	}
+	if msgs := validation.IsDNS1035Label(r.Resource); len(msgs) != 0 {
+		errs = append(errs, fmt.Sprintf("resource %q must be a lowercase name -- e.g. flunders", r.Resource))
+	}
+	for _, s := range r.Strategies {
+		if _, found := strategies[s]; !found {
+			errs = append(errs, fmt.Sprintf("unknown strategy %q, must be one of %v", s, Strategies()))
+		}
+	}
+	if r.Module == "" {
+		errs = append(errs, "module must be set")
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// Package returns the path of the package containing the types relative to the module.  The package is named by
+// the first label of the group, so Write rejects the resources of another group with the same first label.
+func (r Resource) Package() string {
+	return path.Join("pkg", "apis", strings.Split(r.Group, ".")[0], r.Version)
+}
+
+// Files returns the files for the Resource.
+func (r Resource) Files() ([]File, error) {
+	r.Default()
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	d := templateData{
+		Resource: r,
+		Receiver: strings.ToLower(r.Kind[:1]),
+		Import:   path.Join(r.Module, r.Package()),
+	}
+	imports := map[string]bool{}
+	for _, s := range r.Strategies {
+		stub, err := execute(s, strategies[s].text, d)
+		if err != nil {
+			return nil, err
+		}
+		d.Stubs = append(d.Stubs, string(stub))
+		for _, i := range strategies[s].imports {
+			if !imports[i] {
+				imports[i] = true
+				if strings.Contains(i, ".") {
+					d.StubImports = append(d.StubImports, i)
+				} else {
+					d.StubStdImports = append(d.StubStdImports, i)
+				}
+			}
+		}
+	}
+	sort.Strings(d.StubStdImports)
+	sort.Strings(d.StubImports)
+
+	name := strings.ToLower(r.Kind)
+	files := []struct {
+		path         string
+		text         string
+		keepExisting bool
+		skip         bool
+	}{
+		{path: path.Join(r.Package(), "doc.go"), text: docTemplate, keepExisting: true},
+		{path: path.Join(r.Package(), name+"_types.go"), text: typesTemplate},
+		{path: path.Join(r.Package(), name+"_deepcopy.go"), text: deepCopyTemplate},
+		{path: path.Join(r.Package(), name+"_strategy.go"), text: strategyTemplate, skip: len(d.Stubs) == 0},
+		{path: path.Join(r.Package(), name+"_types_test.go"), text: testTemplate},
+		{path: "main.go", text: mainTemplate, keepExisting: true},
+	}
+	var out []File
+	for _, f := range files {
+		if f.skip {
+			continue
+		}
+		contents, err := execute(f.path, f.text, d)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, File{Path: f.path, Contents: contents, KeepExisting: f.keepExisting})
+	}
+	return out, nil
+}
+
+// Write writes the files to dir, reporting each file written to out.  Write returns an error without writing
+// any files if a file which would be replaced already exists, or if the package of the files contains the types
+// of another API group.
+func Write(dir string, files []File, out io.Writer) error {
+	for _, f := range files {
+		if f.KeepExisting {
+			if err := checkGroupName(dir, f); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f.Path))); err == nil {
+			return fmt.Errorf("%s already exists", f.Path)
+		}
+	}
+	for _, f := range files {
+		p := filepath.Join(dir, filepath.FromSlash(f.Path))
+		if _, err := os.Stat(p); err == nil && f.KeepExisting {
+			fmt.Fprintf(out, "skipping existing %s\n", f.Path)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(p, f.Contents, 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "wrote %s\n", f.Path)
+	}
+	return nil
+}
+
+// checkGroupName returns an error if f is the doc.go of a package, and the existing doc.go of the package
+// declares another API group -- e.g. demo.example.com and demo.example.org share pkg/apis/demo.
+func checkGroupName(dir string, f File) error {
+	if path.Base(f.Path) != "doc.go" {
+		return nil
+	}
+	existing, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(f.Path)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if g, expected := groupName(existing), groupName(f.Contents); g != "" && g != expected {
+		return fmt.Errorf("%s contains the %s API group, not %s", path.Dir(f.Path), g, expected)
+	}
+	return nil
+}
+
+// groupName returns the value of the +groupName tag in the go source.
+func groupName(src []byte) string {
+	for _, line := range strings.Split(string(src), "\n") {
+		if strings.HasPrefix(line, "// +groupName=") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "// +groupName="))
+		}
+	}
+	return ""
+}
+
+// ModulePath returns the go import path of dir by reading the go.mod file of dir or its parents.
+func ModulePath(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for rel := ""; ; {
+		if module, err := readModule(filepath.Join(dir, "go.mod")); err != nil {
+			return "", err
+		} else if module != "" {
+			return path.Join(module, rel), nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no go.mod found, the module must be specified")
+		}
+		rel = path.Join(filepath.Base(dir), rel)
+		dir = parent
+	}
+}
+
+// readModule returns the module declared by the go.mod file, or "" if the file does not exist.
+func readModule(goMod string) (string, error) {
+	f, err := os.Open(goMod)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if fields := strings.Fields(s.Text()); len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`), nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s does not declare a module", goMod)
+}
+
+// Pluralize returns the plural of the lowercase kind -- e.g. policy -> policies.
+func Pluralize(kind string) string {
+	switch {
+	case strings.HasSuffix(kind, "y") && !strings.HasSuffix(kind, "ay") && !strings.HasSuffix(kind, "ey") &&
+		!strings.HasSuffix(kind, "oy") && !strings.HasSuffix(kind, "uy"):
+		return strings.TrimSuffix(kind, "y") + "ies"
+	case strings.HasSuffix(kind, "s"), strings.HasSuffix(kind, "x"), strings.HasSuffix(kind, "z"),
+		strings.HasSuffix(kind, "ch"), strings.HasSuffix(kind, "sh"):
+		return kind + "es"
+	default:
+		return kind + "s"
+	}
+}
+
+func isIdentifier(s string) bool {
+	for i, c := range s {
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+type templateData struct {
+	Resource
+	// Receiver is the name of the method receivers
+	Receiver string
+	// Import is the import path of the package containing the types
+	Import string
+	// Stubs are the strategy stubs
+	Stubs []string
+	// StubStdImports and StubImports are the standard library and other packages used by the strategy stubs
+	StubStdImports []string
+	StubImports    []string
+}
+
+// execute executes the template and formats the result.
+func execute(name, text string, d templateData) ([]byte, error) {
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	b := &bytes.Buffer{}
+	if err := t.Execute(b, d); err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(name, ".go") {
+		return b.Bytes(), nil
+	}
+	formatted, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("unable to format %s: %v\n%s", name, err, b.String())
+	}
+	return formatted, nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scaffold
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFiles(t *testing.T) {
+	r := Resource{
+		Group:         "demo.example.com",
+		Version:       "v1alpha1",
+		Kind:          "Policy",
+		ClusterScoped: true,
+		Strategies:    []string{"Defaulter", "ValidateUpdater"},
+		Module:        "example.com/demo",
+	}
+	files, err := r.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := map[string]string{}
+	for _, f := range files {
+		contents[f.Path] = string(f.Contents)
+	}
+	expected := map[string][]string{
+		"pkg/apis/demo/v1alpha1/doc.go": {"+groupName=demo.example.com", "package v1alpha1"},
+		"pkg/apis/demo/v1alpha1/policy_types.go": {
+			"type PolicyList struct",
+			`Resource: "policies"`,
+			"func (p *Policy) NamespaceScoped() bool {\n\treturn false\n}",
+		},
+		"pkg/apis/demo/v1alpha1/policy_deepcopy.go": {
+			"in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)",
+			"(*in)[i].DeepCopyInto(&(*out)[i])",
+		},
+		"pkg/apis/demo/v1alpha1/policy_strategy.go": {
+			"_ resourcestrategy.Defaulter       = &Policy{}",
+			"func (p *Policy) ValidateUpdate(ctx context.Context, old runtime.Object) field.ErrorList {",
+			`"k8s.io/apimachinery/pkg/util/validation/field"`,
+		},
+		"pkg/apis/demo/v1alpha1/policy_types_test.go": {
+			"buildertesting.RoundTripAll(t, builder.APIServer.WithResource(&v1alpha1.Policy{}))",
+		},
+		"main.go": {`"example.com/demo/pkg/apis/demo/v1alpha1"`, "WithResource(&v1alpha1.Policy{})"},
+	}
+	if len(contents) != len(expected) {
+		t.Errorf("expected files %v, got %v", expected, contents)
+	}
+	for path, substrings := range expected {
+		for _, s := range substrings {
+			if !strings.Contains(contents[path], s) {
+				t.Errorf("expected %s to contain %q:\n%s", path, s, contents[path])
+			}
+		}
+	}
+}
+
+func TestFilesWithoutStrategies(t *testing.T) {
+	files, err := Resource{Group: "example.com", Version: "v1", Kind: "Box", Module: "example.com/demo"}.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f.Path, "_strategy.go") {
+			t.Errorf("expected no strategy file, got %s", f.Path)
+		}
+		if strings.HasSuffix(f.Path, "box_types.go") && !strings.Contains(string(f.Contents), `Resource: "boxes"`) {
+			t.Errorf("expected the resource boxes:\n%s", f.Contents)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	r := Resource{
+		Group: "example", Version: "V1", Kind: "flunder", Strategies: []string{"Unknown"}, Module: "example.com/demo"}
+	_, err := r.Files()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	for _, s := range []string{`group "example"`, `version "V1"`, `kind "flunder"`, `unknown strategy "Unknown"`} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected %q in %v", s, err)
+		}
+	}
+}
+
+func TestPluralize(t *testing.T) {
+	for kind, expected := range map[string]string{
+		"flunder": "flunders",
+		"policy":  "policies",
+		"gateway": "gateways",
+		"box":     "boxes",
+		"class":   "classes",
+		"patch":   "patches",
+	} {
+		if actual := Pluralize(kind); actual != expected {
+			t.Errorf("expected %s for %s, got %s", expected, kind, actual)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scaffold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/demo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := ModulePath(filepath.Join(dir, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if module != "example.com/demo/sub" {
+		t.Errorf("expected example.com/demo/sub, got %s", module)
+	}
+
+	files, err := Resource{Group: "example.com", Version: "v1", Kind: "Box", Module: "example.com/demo"}.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	if err := Write(dir, files, out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "skipping existing main.go") {
+		t.Errorf("expected main.go to be skipped, got %s", out.String())
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "main.go")); string(data) != "package main\n" {
+		t.Errorf("expected main.go to be kept, got %s", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pkg", "apis", "example", "v1", "box_types.go")); err != nil {
+		t.Errorf("expected the types to be written: %v", err)
+	}
+
+	if err := Write(dir, files, out); err == nil || !strings.Contains(err.Error(), "box_types.go already exists") {
+		t.Errorf("expected the existing types not to be replaced, got %v", err)
+	}
+
+	// the package of the example.com group is not shared with another group with the same first label
+	files, err = Resource{Group: "example.org", Version: "v1", Kind: "Crate", Module: "example.com/demo"}.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(dir, files, out); err == nil ||
+		!strings.Contains(err.Error(), "pkg/apis/example/v1 contains the example.com API group, not example.org") {
+		t.Errorf("expected the groups sharing a package to be rejected, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pkg", "apis", "example", "v1", "crate_types.go")); err == nil {
+		t.Errorf("expected the types of the other group not to be written")
+	}
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scaffold
+
+const docTemplate = `// Package {{.Version}} contains the {{.Version}} version of the {{.Group}} API group.
+// +groupName={{.Group}}
+package {{.Version}}
+`
+
+const typesTemplate = `package {{.Version}}
+
+import (
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// {{.Kind}} is the Schema for the {{.Resource.Resource}} API.
+type {{.Kind}} struct {
+	metav1.TypeMeta   ` + "`" + `json:",inline"` + "`" + `
+	metav1.ObjectMeta ` + "`" + `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"` + "`" + `
+
+	Spec   {{.Kind}}Spec   ` + "`" + `json:"spec,omitempty" protobuf:"bytes,2,opt,name=spec"` + "`" + `
+	Status {{.Kind}}Status ` + "`" + `json:"status,omitempty" protobuf:"bytes,3,opt,name=status"` + "`" + `
+}
+
+// {{.Kind}}List contains a list of {{.Kind}}.
+type {{.Kind}}List struct {
+	metav1.TypeMeta ` + "`" + `json:",inline"` + "`" + `
+	metav1.ListMeta ` + "`" + `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"` + "`" + `
+
+	Items []{{.Kind}} ` + "`" + `json:"items" protobuf:"bytes,2,rep,name=items"` + "`" + `
+}
+
+// {{.Kind}}Spec defines the desired state of {{.Kind}}.
+type {{.Kind}}Spec struct {
+}
+
+// {{.Kind}}Status defines the observed state of {{.Kind}}.
+type {{.Kind}}Status struct {
+}
+
+var _ resource.Object = &{{.Kind}}{}
+
+// GetObjectMeta implements resource.Object
+func ({{.Receiver}} *{{.Kind}}) GetObjectMeta() *metav1.ObjectMeta {
+	return &{{.Receiver}}.ObjectMeta
+}
+
+// NamespaceScoped implements resource.Object
+func ({{.Receiver}} *{{.Kind}}) NamespaceScoped() bool {
+	return {{not .ClusterScoped}}
+}
+
+// New implements resource.Object
+func ({{.Receiver}} *{{.Kind}}) New() runtime.Object {
+	return &{{.Kind}}{}
+}
+
+// NewList implements resource.Object
+func ({{.Receiver}} *{{.Kind}}) NewList() runtime.Object {
+	return &{{.Kind}}List{}
+}
+
+// GetGroupVersionResource implements resource.Object
+func ({{.Receiver}} *{{.Kind}}) GetGroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: "{{.Group}}", Version: "{{.Version}}", Resource: "{{.Resource.Resource}}"}
+}
+
+// IsInternalVersion implements resource.Object
+func ({{.Receiver}} *{{.Kind}}) IsInternalVersion() bool {
+	return true
+}
+`
+
+// deepCopyTemplate matches the output of deepcopy-gen.  Fields added to the Spec and Status must be copied by
+// their DeepCopyInto functions.
+const deepCopyTemplate = `package {{.Version}}
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// The DeepCopy functions must copy every field of the types.  Update the DeepCopyInto functions when adding
+// pointer, slice or map fields to the {{.Kind}}Spec or {{.Kind}}Status, or replace this file with the output of
+// deepcopy-gen.
+
+// DeepCopyInto copies the receiver into out.  in must be non-nil.
+func (in *{{.Kind}}) DeepCopyInto(out *{{.Kind}}) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy copies the receiver, creating a new {{.Kind}}.
+func (in *{{.Kind}}) DeepCopy() *{{.Kind}} {
+	if in == nil {
+		return nil
+	}
+	out := new({{.Kind}})
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject copies the receiver, creating a new runtime.Object.
+func (in *{{.Kind}}) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto copies the receiver into out.  in must be non-nil.
+func (in *{{.Kind}}List) DeepCopyInto(out *{{.Kind}}List) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]{{.Kind}}, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy copies the receiver, creating a new {{.Kind}}List.
+func (in *{{.Kind}}List) DeepCopy() *{{.Kind}}List {
+	if in == nil {
+		return nil
+	}
+	out := new({{.Kind}}List)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject copies the receiver, creating a new runtime.Object.
+func (in *{{.Kind}}List) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto copies the receiver into out.  in must be non-nil.
+func (in *{{.Kind}}Spec) DeepCopyInto(out *{{.Kind}}Spec) {
+	*out = *in
+}
+
+// DeepCopy copies the receiver, creating a new {{.Kind}}Spec.
+func (in *{{.Kind}}Spec) DeepCopy() *{{.Kind}}Spec {
+	if in == nil {
+		return nil
+	}
+	out := new({{.Kind}}Spec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto copies the receiver into out.  in must be non-nil.
+func (in *{{.Kind}}Status) DeepCopyInto(out *{{.Kind}}Status) {
+	*out = *in
+}
+
+// DeepCopy copies the receiver, creating a new {{.Kind}}Status.
+func (in *{{.Kind}}Status) DeepCopy() *{{.Kind}}Status {
+	if in == nil {
+		return nil
+	}
+	out := new({{.Kind}}Status)
+	in.DeepCopyInto(out)
+	return out
+}
+`
+
+const strategyTemplate = `package {{.Version}}
+
+import (
+{{- range .StubStdImports}}
+	"{{.}}"
+{{- end}}
+
+	"github.com/pwittrock/apiserver-runtime/pkg/builder/resource/resourcestrategy"
+{{- range .StubImports}}
+	"{{.}}"
+{{- end}}
+)
+
+var (
+{{- range .Strategies}}
+	_ resourcestrategy.{{.}} = &{{$.Kind}}{}
+{{- end}}
+)
+{{range .Stubs}}{{.}}{{end}}`
+
+const testTemplate = `package {{.Version}}_test
+
+import (
+	"testing"
+
+	"{{.Import}}"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder"
+	buildertesting "github.com/pwittrock/apiserver-runtime/pkg/builder/testing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test{{.Kind}}RoundTrip(t *testing.T) {
+	buildertesting.RoundTripAll(t, builder.APIServer.WithResource(&{{.Version}}.{{.Kind}}{}))
+}
+
+func Test{{.Kind}}DeepCopy(t *testing.T) {
+	obj := &{{.Version}}.{{.Kind}}{ObjectMeta: metav1.ObjectMeta{Name: "name", Labels: map[string]string{"a": "b"}}}
+	c := obj.DeepCopyObject().(*{{.Version}}.{{.Kind}})
+	c.Labels["a"] = "c"
+	if obj.Labels["a"] != "b" {
+		t.Errorf("expected the copy not to share the labels of the original")
+	}
+}
+`
+
+const mainTemplate = `package main
+
+import (
+	"{{.Import}}"
+	"github.com/pwittrock/apiserver-runtime/pkg/builder"
+	"k8s.io/klog/v2"
+)
+
+func main() {
+	err := builder.APIServer.
+		WithResource(&{{.Version}}.{{.Kind}}{}).
+		Execute()
+	if err != nil {
+		klog.Fatal(err)
+	}
+}
+`